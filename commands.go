@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+)
+
+const usage = `usage: chirpy-server [command]
+
+With no command the HTTP server is started.
+
+commands:
+  bootstrap-admin <email>   make an existing user the first admin
+  hash-refresh-tokens       hash refresh tokens stored before hashing was added`
+
+func runCommand(dbQueries *db.Queries, refreshTokenPepper string, args []string) error {
+	switch args[0] {
+	case "hash-refresh-tokens":
+		return hashRefreshTokens(context.Background(), dbQueries, refreshTokenPepper)
+	case "bootstrap-admin":
+		if len(args) != 2 {
+			return fmt.Errorf("%s", usage)
+		}
+		return bootstrapAdmin(context.Background(), dbQueries, args[1])
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+}
+
+// bootstrapAdmin promotes the user with email to admin. It refuses to run
+// once an admin exists; from then on admins manage roles through the API.
+func bootstrapAdmin(ctx context.Context, dbQueries *db.Queries, email string) error {
+	admins, err := dbQueries.CountUsersWithRole(ctx, auth.RoleAdmin)
+	if err != nil {
+		return fmt.Errorf("counting admins: %w", err)
+	}
+	if admins > 0 {
+		return fmt.Errorf("an admin already exists, use PUT /admin/users/{id}/role instead")
+	}
+	user, err := dbQueries.GetUser(ctx, email)
+	if err != nil {
+		return fmt.Errorf("getting user %s: %w", email, err)
+	}
+	if _, err := dbQueries.SetUserRole(ctx, db.SetUserRoleParams{
+		ID:   user.ID,
+		Role: auth.RoleAdmin,
+	}); err != nil {
+		return fmt.Errorf("promoting user %s: %w", email, err)
+	}
+	fmt.Printf("%s (%s) is now an admin\n", user.Email, user.ID)
+	return nil
+}
+
+// hashRefreshTokens replaces every raw refresh token left from before
+// tokens were hashed. The server also does this lazily on first use.
+func hashRefreshTokens(ctx context.Context, dbQueries *db.Queries, pepper string) error {
+	tokens, err := dbQueries.ListLegacyRefreshTokens(ctx)
+	if err != nil {
+		return fmt.Errorf("listing legacy refresh tokens: %w", err)
+	}
+	for _, token := range tokens {
+		err := dbQueries.HashLegacyRefreshToken(ctx, db.HashLegacyRefreshTokenParams{
+			NewHash:  auth.HashRefreshToken(token, pepper),
+			RawToken: token,
+		})
+		if err != nil {
+			return fmt.Errorf("hashing refresh token: %w", err)
+		}
+	}
+	fmt.Printf("hashed %d refresh tokens\n", len(tokens))
+	return nil
+}
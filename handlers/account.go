@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/mail"
+	"github.com/google/uuid"
+)
+
+const (
+	emailPurposeVerify = "verify_email"
+	emailPurposeReset  = "reset_password"
+
+	verifyEmailTTL   = 48 * time.Hour
+	resetPasswordTTL = time.Hour
+)
+
+var errWeakPassword = errors.New("password does not meet the policy")
+
+type EmailTokenIn struct {
+	Token string `json:"token"`
+}
+
+type ForgotPasswordIn struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordIn struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// PasswordPolicyErrorOut lists every rule a refused password breaks.
+type PasswordPolicyErrorOut struct {
+	Error      string                   `json:"error"`
+	Code       string                   `json:"code"`
+	Violations []auth.PasswordViolation `json:"violations"`
+}
+
+// HANDLERS
+
+func (cfg *APIConfig) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	reqToken := EmailTokenIn{}
+	if err := json.NewDecoder(r.Body).Decode(&reqToken); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	token, ok := cfg.consumeEmailToken(w, r, reqToken.Token, emailPurposeVerify)
+	if !ok {
+		return
+	}
+	if err := cfg.DBQueries.VerifyUserEmail(r.Context(), token.UserID); err != nil {
+		slog.Error("Error verifying email", "error", err)
+		respondWithError(w, 500, "Could not verify email")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *APIConfig) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	user, err := cfg.DBQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		slog.Error("Error getting user", "error", err)
+		respondWithError(w, 500, "Could not get user")
+		return
+	}
+	if user.VerifiedAt.Valid {
+		respondWithError(w, 409, "Email is already verified")
+		return
+	}
+	err = cfg.inTx(r.Context(), func(q *db.Queries) error {
+		return cfg.queueVerificationEmail(r.Context(), q, user)
+	})
+	if err != nil {
+		slog.Error("Error queueing verification email", "error", err)
+		respondWithError(w, 500, "Could not send verification email")
+		return
+	}
+	w.WriteHeader(202)
+}
+
+// ForgotPassword mails a reset link. It answers 202 whether or not the
+// email belongs to an account, so it cannot be used to find accounts.
+func (cfg *APIConfig) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	reqForgot := ForgotPasswordIn{}
+	if err := json.NewDecoder(r.Body).Decode(&reqForgot); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	user, err := cfg.DBQueries.GetUser(r.Context(), reqForgot.Email)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(202)
+		return
+	}
+	if err != nil {
+		slog.Error("Error getting user", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	if accountState(user, time.Now()) == userStatusBanned {
+		w.WriteHeader(202)
+		return
+	}
+	err = cfg.inTx(r.Context(), func(q *db.Queries) error {
+		token, err := issueEmailToken(r.Context(), q, cfg.RefreshTokenPepper, db.CreateEmailTokenParams{
+			UserID:    user.ID,
+			Purpose:   emailPurposeReset,
+			ExpiresAt: time.Now().Add(resetPasswordTTL),
+		})
+		if err != nil {
+			return err
+		}
+		return mail.Queue(r.Context(), q, mail.Message{
+			To:      user.Email,
+			Subject: "Reset your Chirpy password",
+			Body: fmt.Sprintf("Someone asked to reset the password for your Chirpy account.\n\n"+
+				"Reset it within the next hour: %s\n\n"+
+				"If it wasn't you, ignore this email; your password stays the same.\n",
+				cfg.appLink("/reset-password", token)),
+		})
+	})
+	if err != nil {
+		slog.Error("Error queueing password reset", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	w.WriteHeader(202)
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword and
+// logs the account out everywhere.
+func (cfg *APIConfig) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	reqReset := ResetPasswordIn{}
+	if err := json.NewDecoder(r.Body).Decode(&reqReset); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	var violations []auth.PasswordViolation
+	err := cfg.inTx(r.Context(), func(q *db.Queries) error {
+		// consumed in the transaction, so a refused password leaves the
+		// link usable
+		token, err := q.ConsumeEmailToken(r.Context(), db.ConsumeEmailTokenParams{
+			TokenHash: auth.HashRefreshToken(reqReset.Token, cfg.RefreshTokenPepper),
+			Purpose:   emailPurposeReset,
+		})
+		if err != nil {
+			return err
+		}
+		user, err := q.GetUserByID(r.Context(), token.UserID)
+		if err != nil {
+			return err
+		}
+		violations = cfg.passwordViolations(reqReset.Password, user.Email)
+		if len(violations) > 0 {
+			return errWeakPassword
+		}
+		hashedPassword, err := auth.HashPassword(reqReset.Password)
+		if err != nil {
+			return err
+		}
+		// tokens issued before now stop working, sessions and personal
+		// access tokens alike
+		err = q.ResetUserPassword(r.Context(), db.ResetUserPasswordParams{
+			ID:             user.ID,
+			HashedPassword: hashedPassword,
+		})
+		if err != nil {
+			return err
+		}
+		// the link reached the inbox, which proves the address
+		if err := q.VerifyUserEmail(r.Context(), user.ID); err != nil {
+			return err
+		}
+		if err := q.DeleteUserPersonalAccessTokens(r.Context(), user.ID); err != nil {
+			return err
+		}
+		return q.RevokeUserRefreshTokens(r.Context(), user.ID)
+	})
+	switch {
+	case err == sql.ErrNoRows:
+		respondWithErrorCode(w, 400, "invalid_token", "Link is invalid or expired")
+		return
+	case err == errWeakPassword:
+		respondWithPasswordViolations(w, violations)
+		return
+	case err != nil:
+		slog.Error("Error resetting password", "error", err)
+		respondWithError(w, 500, "Could not reset password")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// HELPERS
+
+// checkPassword responds 400 with code weak_password when password breaks
+// the policy for the user with email.
+func (cfg *APIConfig) checkPassword(w http.ResponseWriter, password string, email string) bool {
+	violations := cfg.passwordViolations(password, email)
+	if len(violations) > 0 {
+		respondWithPasswordViolations(w, violations)
+		return false
+	}
+	return true
+}
+
+// passwordViolations checks password against the policy. A breached list
+// that cannot be searched is skipped, so signups keep working.
+func (cfg *APIConfig) passwordViolations(password string, email string) []auth.PasswordViolation {
+	policy := auth.DefaultPasswordPolicy
+	if cfg.PasswordPolicy != nil {
+		policy = *cfg.PasswordPolicy
+	}
+	violations, err := policy.Check(password, email)
+	if err != nil {
+		slog.Error("Error checking breached passwords", "error", err)
+	}
+	return violations
+}
+
+func respondWithPasswordViolations(w http.ResponseWriter, violations []auth.PasswordViolation) {
+	respondWithJSON(w, 400, PasswordPolicyErrorOut{
+		Error:      "Password does not meet the requirements",
+		Code:       "weak_password",
+		Violations: violations,
+	})
+}
+
+// inTx runs fn with queries bound to a transaction, committing if fn
+// succeeds.
+func (cfg *APIConfig) inTx(ctx context.Context, fn func(q *db.Queries) error) error {
+	tx, err := cfg.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(cfg.DBQueries.WithTx(tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// issueEmailToken replaces the user's outstanding tokens for arg.Purpose
+// with a new one and returns it. Only its hash is stored.
+func issueEmailToken(ctx context.Context, q *db.Queries, pepper string, arg db.CreateEmailTokenParams) (string, error) {
+	err := q.InvalidateEmailTokens(ctx, db.InvalidateEmailTokensParams{
+		UserID:  arg.UserID,
+		Purpose: arg.Purpose,
+	})
+	if err != nil {
+		return "", err
+	}
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	arg.TokenHash = auth.HashRefreshToken(token, pepper)
+	return token, q.CreateEmailToken(ctx, arg)
+}
+
+// consumeEmailToken uses up a token, responding 400 if it is unknown,
+// used or expired.
+func (cfg *APIConfig) consumeEmailToken(w http.ResponseWriter, r *http.Request, raw string, purpose string) (db.EmailToken, bool) {
+	token, err := cfg.DBQueries.ConsumeEmailToken(r.Context(), db.ConsumeEmailTokenParams{
+		TokenHash: auth.HashRefreshToken(raw, cfg.RefreshTokenPepper),
+		Purpose:   purpose,
+	})
+	if err == sql.ErrNoRows {
+		respondWithErrorCode(w, 400, "invalid_token", "Link is invalid or expired")
+		return db.EmailToken{}, false
+	}
+	if err != nil {
+		slog.Error("Error consuming email token", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return db.EmailToken{}, false
+	}
+	return token, true
+}
+
+func (cfg *APIConfig) queueVerificationEmail(ctx context.Context, q *db.Queries, user db.User) error {
+	token, err := issueEmailToken(ctx, q, cfg.RefreshTokenPepper, db.CreateEmailTokenParams{
+		UserID:    user.ID,
+		Purpose:   emailPurposeVerify,
+		ExpiresAt: time.Now().Add(verifyEmailTTL),
+	})
+	if err != nil {
+		return err
+	}
+	return mail.Queue(ctx, q, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Welcome to Chirpy!\n\nConfirm this is your address: %s\n\n"+
+			"The link works for 48 hours.\n",
+			cfg.appLink("/verify-email", token)),
+	})
+}
+
+// appLink is a link into the web app carrying token.
+func (cfg *APIConfig) appLink(path string, token string) string {
+	return cfg.BaseURL + "/app" + path + "?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/billing"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/mail"
+	"github.com/google/uuid"
+)
+
+type BillingOut struct {
+	// Status is none for users who never subscribed.
+	Status             string     `json:"status"`
+	Plan               string     `json:"plan,omitempty"`
+	IsChirpyRed        bool       `json:"is_chirpy_red"`
+	CurrentPeriodStart *time.Time `json:"current_period_start,omitempty"`
+	CurrentPeriodEnd   *time.Time `json:"current_period_end,omitempty"`
+	GraceUntil         *time.Time `json:"grace_until,omitempty"`
+	CanceledAt         *time.Time `json:"canceled_at,omitempty"`
+}
+
+// HANDLERS
+
+// GetBilling returns the user's Chirpy Red subscription.
+func (cfg *APIConfig) GetBilling(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	sub, err := cfg.DBQueries.GetSubscriptionByUser(r.Context(), userID)
+	if err == sql.ErrNoRows {
+		respondWithJSON(w, 200, BillingOut{Status: "none"})
+		return
+	}
+	if err != nil {
+		slog.Error("Error getting subscription", "error", err, "userID", userID)
+		respondWithError(w, 500, "Could not get billing state")
+		return
+	}
+	respondWithJSON(w, 200, billingOut(sub))
+}
+
+// HELPERS
+
+// applyBillingEvent updates the user's subscription and Chirpy Red status
+// for event. It returns sql.ErrNoRows for an unknown user.
+func (cfg *APIConfig) applyBillingEvent(ctx context.Context, q *db.Queries, userID uuid.UUID, event billing.Event, now time.Time) (billing.Subscription, error) {
+	user, err := q.GetUserByID(ctx, userID)
+	if err != nil {
+		return billing.Subscription{}, err
+	}
+	var current *billing.Subscription
+	stored, err := q.GetSubscriptionByUserForUpdate(ctx, userID)
+	if err == nil {
+		sub := billing.FromDB(stored)
+		current = &sub
+	} else if err != sql.ErrNoRows {
+		return billing.Subscription{}, err
+	}
+	next, err := billing.Apply(current, event, now)
+	if err != nil {
+		return billing.Subscription{}, err
+	}
+	_, err = q.UpsertSubscription(ctx, db.UpsertSubscriptionParams{
+		UserID:             userID,
+		Plan:               next.Plan,
+		Status:             next.Status,
+		CurrentPeriodStart: next.PeriodStart,
+		CurrentPeriodEnd:   next.PeriodEnd,
+		GraceUntil:         nullTime(next.GraceUntil),
+		CanceledAt:         nullTime(next.CanceledAt),
+	})
+	if err != nil {
+		return billing.Subscription{}, err
+	}
+	err = q.SetChirpyRed(ctx, db.SetChirpyRedParams{ID: userID, IsChirpyRed: next.Red()})
+	if err != nil {
+		return billing.Subscription{}, err
+	}
+	if next.Status == billing.StatusPastDue && current != nil && current.Status != billing.StatusPastDue {
+		err = mail.Queue(ctx, q, mail.Message{
+			To:      user.Email,
+			Subject: "Your Chirpy Red payment failed",
+			Body: fmt.Sprintf("We couldn't take the payment for your Chirpy Red subscription.\n\n"+
+				"You keep Chirpy Red until %s. Update your payment details with Polka before then "+
+				"to keep it: %s\n",
+				next.GraceUntil.UTC().Format("January 2, 2006"), cfg.BaseURL+"/app/billing"),
+		})
+		if err != nil {
+			return billing.Subscription{}, err
+		}
+	}
+	return next, nil
+}
+
+func billingOut(sub db.Subscription) BillingOut {
+	out := BillingOut{
+		Status:             sub.Status,
+		Plan:               sub.Plan,
+		IsChirpyRed:        billing.FromDB(sub).Red(),
+		CurrentPeriodStart: &sub.CurrentPeriodStart,
+		CurrentPeriodEnd:   &sub.CurrentPeriodEnd,
+	}
+	if sub.GraceUntil.Valid {
+		out.GraceUntil = &sub.GraceUntil.Time
+	}
+	if sub.CanceledAt.Valid {
+		out.CanceledAt = &sub.CanceledAt.Time
+	}
+	return out
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -1,14 +1,21 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/entitlements"
+	"github.com/eliza-guseva/chirpy-server/internal/langdetect"
+	"github.com/eliza-guseva/chirpy-server/internal/spam"
 	"github.com/google/uuid"
 )
 
@@ -23,8 +30,15 @@ type ChirpOut struct {
 	UpdatedAt time.Time `json:"updated_at"`
 	Body      string    `json:"body"`
 	UserID    string    `json:"user_id"`
+	Lang      string    `json:"lang"`
+	Status    string    `json:"status"`
 }
 
+const (
+	chirpStatusPublished = "published"
+	chirpStatusHeld      = "held"
+)
+
 func (cfg *APIConfig) CreateChirp(w http.ResponseWriter, r *http.Request) {
 	
 	decoder := json.NewDecoder(r.Body)
@@ -35,30 +49,55 @@ func (cfg *APIConfig) CreateChirp(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, 500, "Something went wrong")
 		return
 	}
-	if len(reqChirp.Body) > 140 {
-		respondWithError(w, 400, "Chirp is too long")
+	user, _ := r.Context().Value("user").(db.User)
+	if !entitlements.Can(user, entitlements.ChirpLength(utf8.RuneCountInString(reqChirp.Body))) {
+		respondWithError(w, 400, fmt.Sprintf("Chirp is too long, the limit is %d characters", entitlements.For(user).MaxChirpLength))
 		return
 	}
-	_, fixed := checkForProfane(reqChirp.Body)
+	lang := langdetect.Undetermined
+	if cfg.LangDetector != nil {
+		lang = cfg.LangDetector.Detect(reqChirp.Body)
+	}
+	_, fixed := checkForProfane(reqChirp.Body, lang)
 	UserID, _ := r.Context().Value("userID").(uuid.UUID)
-	chirp, err := cfg.DBQueries.CreateChirp(r.Context(), 
-		db.CreateChirpParams{
-			Body: fixed,
-			UserID: UserID,
-		})
+	verdict, err := cfg.scoreChirp(r, UserID, fixed)
+	if err != nil {
+		slog.Error("Error scoring chirp", "error", err)
+		respondWithError(w, 500, "Could not create chirp")
+		return
+	}
+	status := chirpStatusPublished
+	if verdict.Held {
+		status = chirpStatusHeld
+	}
+	var chirp db.Chirp
+	err = cfg.inTx(r.Context(), func(q *db.Queries) error {
+		chirp, err = q.CreateChirp(r.Context(), 
+			db.CreateChirpParams{
+				Body: fixed,
+				UserID: UserID,
+				Lang: lang,
+				Status: status,
+			})
+		if err != nil { return err }
+		if verdict.Score > 0 {
+			if err := saveSpamScore(r.Context(), q, chirp, verdict); err != nil { return err }
+		}
+		// held chirps are announced when a moderator releases them
+		if chirp.Status != chirpStatusPublished { return nil }
+		return publishEvent(r.Context(), q, UserID, EventChirpCreated, toChirpOut(chirp))
+	})
 	if err != nil {
 		slog.Error("Error creating chirp", "error", err)
 		respondWithError(w, 500, "Could not create chirp")
 		return
 	}
-	chirpOut := ChirpOut{
-		ID:        chirp.ID.String(),
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body:      fixed,
-		UserID:    chirp.UserID.String(),
+	if chirp.Status == chirpStatusHeld {
+		slog.Info("Chirp held for moderation", "chirpID", chirp.ID, "score", verdict.Score)
+		respondWithJSON(w, 202, toChirpOut(chirp))
+		return
 	}
-	respondWithJSON(w, 201, chirpOut)
+	respondWithJSON(w, 201, toChirpOut(chirp))
 
 }
 
@@ -67,23 +106,23 @@ func (cfg *APIConfig) GetChirps(w http.ResponseWriter, r *http.Request) {
 	authorID := r.URL.Query().Get("author_id")
 	slog.Info("Author ID", "authorID", authorID)
 
-	sort := r.URL.Query().Get("sort")
-	if sort != "desc" {
-		sort = "asc"
+	params := db.ListChirpsParams{
+		SortDesc: r.URL.Query().Get("sort") == "desc",
 	}
-	
-	var chirps []db.Chirp
-	var err error
-	switch {
-		case authorID != "" && sort == "desc":
-			chirps, err = cfg.DBQueries.GetChirpsByUserIDDESC(r.Context(), uuid.MustParse(authorID))
-		case authorID != "" && sort == "asc":
-			chirps, err = cfg.DBQueries.GetChirpsByUserIDASC(r.Context(), uuid.MustParse(authorID))
-		case sort == "desc":
-			chirps, err = cfg.DBQueries.GetChirpsDESC(r.Context())
-		default: // sort == "asc"
-			chirps, err = cfg.DBQueries.GetChirpsASC(r.Context())
+	if authorID != "" {
+		userID, err := uuid.Parse(authorID)
+		if err != nil {
+			slog.Error("Invalid author ID", "error", err)
+			respondWithError(w, 400, "Invalid author ID")
+			return
+		}
+		params.UserID = uuid.NullUUID{UUID: userID, Valid: true}
+	}
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		params.Lang = sql.NullString{String: strings.ToLower(lang), Valid: true}
 	}
+
+	chirps, err := cfg.DBQueries.ListChirps(r.Context(), params)
 	if err != nil {
 		slog.Error("Error getting chirps", "error", err)
 		respondWithError(w, 500, "Something went wrong")
@@ -92,13 +131,7 @@ func (cfg *APIConfig) GetChirps(w http.ResponseWriter, r *http.Request) {
 
 	var chirpOut []ChirpOut	
 	for _, chirp := range chirps {
-		chirpOut = append(chirpOut, ChirpOut{
-			ID:        chirp.ID.String(),
-			CreatedAt: chirp.CreatedAt,
-			UpdatedAt: chirp.UpdatedAt,
-			Body:      chirp.Body,
-			UserID:    chirp.UserID.String(),
-		})
+		chirpOut = append(chirpOut, toChirpOut(chirp))
 	}
 	respondWithJSON(w, 200, chirpOut)
 }
@@ -112,7 +145,7 @@ func (cfg *APIConfig) GetChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	slog.Info("Getting chirp", "id", chID)
-	chirp, err := cfg.DBQueries.GetChirp(r.Context(), chID)
+	chirp, err := cfg.DBQueries.GetPublishedChirp(r.Context(), chID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			respondWithError(w, 404, "Chirp not found")
@@ -122,16 +155,8 @@ func (cfg *APIConfig) GetChirp(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, 500, "Something went wrong")
 		return
 	}
-	
 
-	chirpOut := ChirpOut{
-		ID:        chirp.ID.String(),
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body:      chirp.Body,
-		UserID:    chirp.UserID.String(),
-	}
-	respondWithJSON(w, 200, chirpOut)
+	respondWithJSON(w, 200, toChirpOut(chirp))
 }
 
 func (cfg *APIConfig) DeleteChirp(w http.ResponseWriter, r *http.Request) {
@@ -157,7 +182,12 @@ func (cfg *APIConfig) DeleteChirp(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, 403, "Unauthorized")
 		return
 	}
-	err = cfg.DBQueries.DeleteChirp(r.Context(), chID)
+	err = cfg.inTx(r.Context(), func(q *db.Queries) error {
+		if err := q.DeleteChirp(r.Context(), chID); err != nil { return err }
+		// held chirps were never announced, so their deletion isn't either
+		if chirp.Status != chirpStatusPublished { return nil }
+		return publishEvent(r.Context(), q, authUserID, EventChirpDeleted, toChirpOut(chirp))
+	})
 	if err != nil {
 		slog.Error("Error deleting chirp", "error", err)
 		respondWithError(w, 500, "Something went wrong")
@@ -168,23 +198,95 @@ func (cfg *APIConfig) DeleteChirp(w http.ResponseWriter, r *http.Request) {
 
 // Helpers 
 
-func checkForProfane(chirp string) (hasProfate bool, fixed string) {
+// profaneWords holds the moderation dictionary for each locale. The "en"
+// list is applied to every chirp, whatever language it was detected as.
+var profaneWords = map[string][]string{
+	"en": {"kerfuffle", "sharbert", "fornax"},
+	"es": {"mierda", "joder", "gilipollas", "cabrón"},
+	"fr": {"merde", "putain", "connard", "salaud"},
+	"de": {"scheiße", "scheisse", "arschloch", "mistkerl"},
+	"it": {"merda", "cazzo", "stronzo", "vaffanculo"},
+	"pt": {"merda", "porra", "caralho", "babaca"},
+}
+
+func toChirpOut(chirp db.Chirp) ChirpOut {
+	return ChirpOut{
+		ID:        chirp.ID.String(),
+		CreatedAt: chirp.CreatedAt,
+		UpdatedAt: chirp.UpdatedAt,
+		Body:      chirp.Body,
+		UserID:    chirp.UserID.String(),
+		Lang:      chirp.Lang,
+		Status:    chirp.Status,
+	}
+}
+
+// scoreChirp runs the spam pipeline over a new chirp from userID. Without a
+// pipeline every chirp is published.
+func (cfg *APIConfig) scoreChirp(r *http.Request, userID uuid.UUID, body string) (spam.Verdict, error) {
+	if cfg.SpamPipeline == nil {
+		return spam.Verdict{}, nil
+	}
+	user, err := cfg.DBQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		return spam.Verdict{}, err
+	}
+	now := time.Now()
+	recent, err := cfg.DBQueries.GetRecentChirpsByUser(r.Context(), db.GetRecentChirpsByUserParams{
+		UserID:    userID,
+		CreatedAt: now.Add(-spam.RecentWindow),
+	})
+	if err != nil {
+		return spam.Verdict{}, err
+	}
+	in := spam.Input{
+		Body:             body,
+		Now:              now,
+		AccountCreatedAt: user.CreatedAt,
+	}
+	for _, chirp := range recent {
+		in.Recent = append(in.Recent, spam.Chirp{Body: chirp.Body, CreatedAt: chirp.CreatedAt})
+	}
+	return cfg.SpamPipeline.Evaluate(in), nil
+}
+
+// saveSpamScore records the verdict for the moderators reviewing held
+// chirps.
+func saveSpamScore(ctx context.Context, q *db.Queries, chirp db.Chirp, verdict spam.Verdict) error {
+	reasons, err := json.Marshal(verdict.Reasons)
+	if err != nil {
+		return err
+	}
+	_, err = q.CreateChirpSpamScore(ctx, db.CreateChirpSpamScoreParams{
+		ChirpID: chirp.ID,
+		Score:   verdict.Score,
+		Reasons: reasons,
+	})
+	return err
+}
+
+func checkForProfane(chirp string, lang string) (hasProfate bool, fixed string) {
 	hasProfane := false
-	fixed = chirp
-	badWords := []string{"kerfuffle", "sharbert", "fornax"}
+	badWords := profaneWords["en"]
+	if lang != "en" {
+		badWords = append(badWords[:len(badWords):len(badWords)], profaneWords[lang]...)
+	}
+	// lowercase rune by rune so indexes line up with the original text
+	runes := []rune(chirp)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
 	for _, badWord := range badWords {
-		for {
-			lowerChirp := strings.ToLower(fixed)
-			if ! strings.Contains(lowerChirp, badWord) {
-				break
+		bw := []rune(badWord)
+		for i := 0; i+len(bw) <= len(lower); i++ {
+			if string(lower[i:i+len(bw)]) != badWord {
+				continue
 			}
-			bwStart := strings.Index(lowerChirp, badWord)
-			bwEnd := bwStart + len(badWord)
-			fixed = fixed[:bwStart] + "****" + fixed[bwEnd:]
+			runes = append(runes[:i:i], append([]rune("****"), runes[i+len(bw):]...)...)
+			lower = append(lower[:i:i], append([]rune("****"), lower[i+len(bw):]...)...)
 			hasProfane = true
 		}
 	}
-	return hasProfane, fixed
+	return hasProfane, string(runes)
 }
-
-
@@ -1,7 +1,16 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/spam"
+	"github.com/google/uuid"
 )
 
 func TestCheckForProfane(t *testing.T) {
@@ -16,7 +25,31 @@ func TestCheckForProfane(t *testing.T) {
 		{"ForNax, fornax, fornax", true, "****, ****, ****"}, // test multiple
 	}
 	for _, testCase := range testCases {
-		hasProfane, fixed := checkForProfane(testCase.chirp)
+		hasProfane, fixed := checkForProfane(testCase.chirp, "en")
+		if hasProfane != testCase.hasProfane {
+			t.Errorf("Expected %v, got %v", testCase.hasProfane, hasProfane)
+		}
+		if fixed != testCase.fixed {
+			t.Errorf("Expected %v, got %v", testCase.fixed, fixed)
+		}
+	}
+}
+
+func TestCheckForProfaneLocale(t *testing.T) {
+	testCases := []struct {
+		chirp string
+		lang string
+		hasProfane bool
+		fixed string
+	}{
+		{"Qué MIERDA de día", "es", true, "Qué **** de día"}, // locale dictionary
+		{"Das ist SCHEIẞE", "de", true, "Das ist ****"}, // non-ASCII case folding
+		{"mierda sharbert", "en", true, "mierda ****"}, // other locales not applied
+		{"fornax, merde", "fr", true, "****, ****"}, // english applied everywhere
+		{"sharbert", "und", true, "****"}, // unknown locale falls back to english
+	}
+	for _, testCase := range testCases {
+		hasProfane, fixed := checkForProfane(testCase.chirp, testCase.lang)
 		if hasProfane != testCase.hasProfane {
 			t.Errorf("Expected %v, got %v", testCase.hasProfane, hasProfane)
 		}
@@ -25,3 +58,81 @@ func TestCheckForProfane(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateChirpTooLong(t *testing.T) {
+	cfg := &APIConfig{}
+	body := `{"body":"` + strings.Repeat("a", 141) + `"}`
+	req := httptest.NewRequest("POST", "/api/chirps", strings.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), "user", db.User{}))
+	w := httptest.NewRecorder()
+	cfg.CreateChirp(w, req)
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "140") {
+		t.Errorf("Expected 400 naming the 140 character limit, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCreateChirpCountsCharacters(t *testing.T) {
+	user := db.User{ID: uuid.New(), Status: userStatusActive, Role: "user"}
+	body := strings.Repeat("é", 140)
+	fake := newFakeDB()
+	fake.on("CreateChirp", chirpRow(db.Chirp{ID: uuid.New(), UserID: user.ID, Body: body, Status: chirpStatusPublished}))
+	req := httptest.NewRequest("POST", "/api/chirps", strings.NewReader(`{"body":"`+body+`"}`))
+	ctx := context.WithValue(req.Context(), "userID", user.ID)
+	req = req.WithContext(context.WithValue(ctx, "user", user))
+	w := httptest.NewRecorder()
+	fake.config().CreateChirp(w, req)
+	// 280 bytes, but 140 characters
+	if w.Code != 201 {
+		t.Errorf("Expected 201, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+// holdEverything scores every chirp as spam.
+type holdEverything struct{}
+
+func (holdEverything) Name() string { return "hold_everything" }
+
+func (holdEverything) Score(in spam.Input) (float64, string) { return 2, "held by the test" }
+
+func TestCreateChirpSavesSpamScoreWithChirp(t *testing.T) {
+	user := db.User{ID: uuid.New(), CreatedAt: time.Now(), UpdatedAt: time.Now(), Status: userStatusActive, Role: "user"}
+	fake := newFakeDB()
+	fake.on("GetUserByID", userRow(user))
+	fake.on("CreateChirp", chirpRow(db.Chirp{ID: uuid.New(), UserID: user.ID, Body: "hello", Status: chirpStatusHeld}))
+	fake.fail("CreateChirpSpamScore", errors.New("connection reset"))
+	cfg := fake.config()
+	cfg.SpamPipeline = &spam.Pipeline{Checks: []spam.Check{holdEverything{}}, Threshold: 1}
+
+	req := httptest.NewRequest("POST", "/api/chirps", strings.NewReader(`{"body":"hello"}`))
+	ctx := context.WithValue(req.Context(), "userID", user.ID)
+	req = req.WithContext(context.WithValue(ctx, "user", user))
+	w := httptest.NewRecorder()
+	cfg.CreateChirp(w, req)
+	if w.Code != 500 {
+		t.Fatalf("Expected 500, got %d %s", w.Code, w.Body.String())
+	}
+	// a held chirp without its score would be stuck without an explanation
+	if trace := fake.trace(); !strings.HasSuffix(trace, "begin CreateChirp CreateChirpSpamScore rollback") {
+		t.Errorf("Expected the chirp to be rolled back, got %s", trace)
+	}
+}
+
+func TestDeleteChirpPublishesInTransaction(t *testing.T) {
+	user := db.User{ID: uuid.New(), Status: userStatusActive, Role: "user"}
+	chirp := db.Chirp{ID: uuid.New(), UserID: user.ID, Body: "hello", Status: chirpStatusPublished}
+	fake := newFakeDB()
+	fake.on("GetChirp", chirpRow(chirp))
+	fake.fail("EnqueueWebhookEvent", errors.New("connection reset"))
+	req := httptest.NewRequest("DELETE", "/api/chirps/x", nil)
+	req.SetPathValue("id", chirp.ID.String())
+	req = req.WithContext(context.WithValue(req.Context(), "userID", user.ID))
+	w := httptest.NewRecorder()
+	fake.config().DeleteChirp(w, req)
+	if w.Code != 500 {
+		t.Fatalf("Expected 500, got %d %s", w.Code, w.Body.String())
+	}
+	// subscribers must not miss a deletion that happened
+	if trace := fake.trace(); trace != "GetChirp begin DeleteChirp EnqueueWebhookEvent rollback" {
+		t.Errorf("Expected the deletion to roll back, got %s", trace)
+	}
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/google/uuid"
+)
+
+// fakeDB is a database/sql driver for handler tests. Queries are answered
+// by the name sqlc gives them, and every statement is recorded in order
+// along with where transactions begin, commit and roll back.
+type fakeDB struct {
+	mu      sync.Mutex
+	answers map[string]fakeAnswer
+	// log holds query names and "begin", "commit" and "rollback".
+	log  []string
+	args map[string][][]driver.Value
+}
+
+// fakeAnswer returns the rows for one call of a query. Exec statements
+// report as many affected rows as rows returned.
+type fakeAnswer func(args []driver.Value) ([][]driver.Value, error)
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{answers: map[string]fakeAnswer{}, args: map[string][][]driver.Value{}}
+}
+
+// config returns an APIConfig whose queries and transactions run against
+// the fake.
+func (f *fakeDB) config() *APIConfig {
+	conn := sql.OpenDB(f)
+	return &APIConfig{DB: conn, DBQueries: db.New(conn)}
+}
+
+// on answers every call of the named query with rows.
+func (f *fakeDB) on(name string, rows ...[]driver.Value) {
+	f.answers[name] = func(args []driver.Value) ([][]driver.Value, error) { return rows, nil }
+}
+
+// fail makes every call of the named query return err.
+func (f *fakeDB) fail(name string, err error) {
+	f.answers[name] = func(args []driver.Value) ([][]driver.Value, error) { return nil, err }
+}
+
+// called returns the arguments of each call of the named query.
+func (f *fakeDB) called(name string) [][]driver.Value {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.args[name]
+}
+
+// trace is the log joined with spaces, for comparing in tests.
+func (f *fakeDB) trace() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return strings.Join(f.log, " ")
+}
+
+// run answers one statement. Queries nobody answers return no rows, and
+// such Execs affect one row.
+func (f *fakeDB) run(query string, args []driver.NamedValue) ([][]driver.Value, bool, error) {
+	// sqlc starts every query with "-- name: <Name> :<kind>"
+	name := query
+	if fields := strings.Fields(query); len(fields) > 2 && fields[1] == "name:" {
+		name = fields[2]
+	}
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	f.mu.Lock()
+	f.log = append(f.log, name)
+	f.args[name] = append(f.args[name], values)
+	answer := f.answers[name]
+	f.mu.Unlock()
+	if answer == nil {
+		return nil, false, nil
+	}
+	rows, err := answer(values)
+	return rows, true, err
+}
+
+func (f *fakeDB) record(event string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.log = append(f.log, event)
+}
+
+func (f *fakeDB) Connect(ctx context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                             { return nil }
+
+type fakeConn struct{ f *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) {
+	c.f.record("begin")
+	return fakeTx{c.f}, nil
+}
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	rows, answered, err := c.f.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	if !answered {
+		return driver.RowsAffected(1), nil
+	}
+	return driver.RowsAffected(len(rows)), nil
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, _, err := c.f.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+type fakeTx struct{ f *fakeDB }
+
+func (t fakeTx) Commit() error   { t.f.record("commit"); return nil }
+func (t fakeTx) Rollback() error { t.f.record("rollback"); return nil }
+
+type fakeRows struct {
+	rows [][]driver.Value
+	next int
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
+
+// Rows in the column order of the tables, for answering queries.
+
+func chirpRow(chirp db.Chirp) []driver.Value {
+	return []driver.Value{chirp.ID.String(), chirp.CreatedAt, chirp.UpdatedAt, chirp.UserID.String(), chirp.Body, chirp.Lang, chirp.Status}
+}
+
+func userRow(user db.User) []driver.Value {
+	return []driver.Value{user.ID.String(), user.CreatedAt, user.UpdatedAt, user.Email, user.HashedPassword, user.IsChirpyRed,
+		user.Status, nullTimeValue(user.SuspendedUntil), user.Role, nullTimeValue(user.VerifiedAt), nullTimeValue(user.PasswordChangedAt)}
+}
+
+func refreshTokenRow(token db.RefreshToken) []driver.Value {
+	return []driver.Value{token.TokenHash, token.CreatedAt, token.UpdatedAt, token.UserID.String(), token.ExpiresAt, nullTimeValue(token.RevokedAt),
+		token.FamilyID.String(), nullStringValue(token.ParentTokenHash), token.RevokedReason, token.Hashed, token.UserAgent, token.Ip,
+		token.LastUsedAt, nullStringValue(token.ClientID), token.Scope}
+}
+
+func reportRow(report db.Report) []driver.Value {
+	return []driver.Value{report.ID.String(), report.CreatedAt, report.UpdatedAt, report.ReporterID.String(), nullUUIDValue(report.ChirpID),
+		report.TargetUserID.String(), report.Reason, report.Details, report.Status, nullUUIDValue(report.ClaimedBy),
+		nullTimeValue(report.ClaimedAt), nullTimeValue(report.ResolvedAt), nullStringValue(report.Resolution)}
+}
+
+func nullUUIDValue(id uuid.NullUUID) driver.Value {
+	if !id.Valid {
+		return nil
+	}
+	return id.UUID.String()
+}
+
+func nullStringValue(s sql.NullString) driver.Value {
+	if !s.Valid {
+		return nil
+	}
+	return s.String
+}
+
+func nullTimeValue(t sql.NullTime) driver.Value {
+	if !t.Valid {
+		return nil
+	}
+	return t.Time
+}
+
+// anyLogEntry answers CreateModerationLogEntry; handlers ignore the row.
+var anyLogEntry = []driver.Value{int64(1), time.Now(), nil, "", nil, nil, nil, ""}
+
+// anyMail answers EnqueueMail; handlers ignore the row.
+var anyMail = []driver.Value{int64(1), time.Now(), "", "", "", int64(0), time.Now(), nil, ""}
@@ -1,14 +1,25 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	"strings"
 	"sync/atomic"
+	"time"
 	"github.com/google/uuid"
 	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/oidc"
+	"github.com/eliza-guseva/chirpy-server/internal/ratelimit"
+	"github.com/eliza-guseva/chirpy-server/internal/secretbox"
 	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/langdetect"
+	"github.com/eliza-guseva/chirpy-server/internal/lockout"
+	"github.com/eliza-guseva/chirpy-server/internal/spam"
+	"github.com/eliza-guseva/chirpy-server/internal/webauthn"
 	"log/slog"
 	"context"
 )
@@ -16,8 +27,29 @@ import (
 type APIConfig struct {
 	fileserverHits atomic.Int32
 	DBQueries *db.Queries
-	JWTSecret string
-	PolkaKey string
+	DB *sql.DB
+	Keys *auth.Keyring
+	Validator *auth.Validator
+	RefreshTokenPepper string
+	// Secrets seals secrets stored in the database, like TOTP secrets.
+	Secrets *secretbox.Box
+	// PolkaSecrets verify Polka webhook signatures. Several can be active
+	// while a secret is rotated.
+	PolkaSecrets []string
+	LangDetector *langdetect.Detector
+	SpamPipeline *spam.Pipeline
+	WebAuthn *webauthn.RelyingParty
+	// BaseURL is where the web app is served, for links in emails.
+	BaseURL string
+	// PasswordPolicy decides which new passwords are accepted; nil means
+	// auth.DefaultPasswordPolicy.
+	PasswordPolicy *auth.PasswordPolicy
+	// Lockout throttles password guessing; nil turns it off.
+	Lockout *lockout.Guard
+	// OIDC holds the external identity providers by name.
+	OIDC map[string]*oidc.Client
+	// RateLimiter backs RateLimit; nil turns rate limiting off.
+	RateLimiter *ratelimit.Limiter
 }
 
 
@@ -35,6 +67,12 @@ func Health(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "OK\n")
 }
 
+// JWKS serves the public keys that verify access tokens.
+func (cfg *APIConfig) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, 200, cfg.Keys.JWKS())
+}
+
 func (cfg *APIConfig) FSHits(w http.ResponseWriter, r *http.Request) {
 	templ, err := template.ParseFiles("templates/admin_metrics.html")
 	if err != nil {
@@ -50,35 +88,188 @@ func (cfg *APIConfig) FSHits(w http.ResponseWriter, r *http.Request) {
 	templ.Execute(w, data)
 }
 
-func (cfg *APIConfig) RequireAuth(handler http.HandlerFunc) http.HandlerFunc {
+// RequireAuth lets through requests with a valid access token. Tokens
+// issued to OAuth clients are only accepted on routes that name scopes,
+// and must carry all of them.
+func (cfg *APIConfig) RequireAuth(handler http.HandlerFunc, scopes ...string) http.HandlerFunc {
       return func(w http.ResponseWriter, r *http.Request) {
-          userID := cfg.Authenticate(w, r)
-          if userID == uuid.Nil {
+          user, claims := cfg.Authenticate(w, r)
+          if user.ID == uuid.Nil {
           	return
 		  }
+          if !checkScopes(w, claims, scopes) {
+          	return
+          }
+          // personal access tokens carry no sid
+          sessionID, _ := uuid.Parse(claims.SessionID)
 
-          ctx := context.WithValue(r.Context(), "userID", userID)
+          ctx := context.WithValue(r.Context(), "userID", user.ID)
+          ctx = context.WithValue(ctx, "role", user.Role)
+          ctx = context.WithValue(ctx, "sessionID", sessionID)
+          ctx = context.WithValue(ctx, "claims", claims)
+          ctx = context.WithValue(ctx, "user", user)
           handler(w, r.WithContext(ctx))
       }
   }
 
 
-func (cfg *APIConfig) Authenticate(w http.ResponseWriter, r *http.Request) uuid.UUID {
+// RequireRole only lets through users holding role or a higher one. It
+// reads the role RequireAuth stored, so wrap it inside RequireAuth.
+func (cfg *APIConfig) RequireRole(role string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userRole, ok := r.Context().Value("role").(string)
+		if !ok {
+			slog.Error("RequireRole used without RequireAuth", "path", r.URL.Path)
+			respondWithError(w, 401, "Unauthorized")
+			return
+		}
+		if !auth.HasRole(userRole, role) {
+			slog.Info("Insufficient role", "role", userRole, "required", role, "path", r.URL.Path)
+			respondWithError(w, 403, "Forbidden")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+
+// Authenticate validates the bearer token, a JWT or a personal access
+// token, and returns the user it belongs to along with its claims. On
+// failure it responds itself and returns a user with a nil ID.
+func (cfg *APIConfig) Authenticate(w http.ResponseWriter, r *http.Request) (db.User, *auth.Claims) {
 	bearerToken, err := auth.GetBearerToken(r.Header)
-	slog.Info("Bearer token", "token", bearerToken)
 	if err != nil {
 		slog.Error("Error getting bearer token", "error", err)
 		respondWithError(w, 401, "Unauthorized")
-		return uuid.Nil
+		return db.User{}, nil
+	}
+	var claims *auth.Claims
+	if auth.IsPAT(bearerToken) {
+		claims, err = cfg.validatePersonalToken(r, bearerToken)
+	} else {
+		claims, err = cfg.Validator.Validate(r.Context(), bearerToken)
+	}
+	if err != nil {
+		slog.Info("Rejected access token", "error", err)
+		respondWithTokenError(w, err)
+		return db.User{}, nil
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		slog.Error("Error parsing JWT subject", "error", err)
+		respondWithError(w, 401, "Unauthorized")
+		return db.User{}, nil
 	}
-	userID, err := auth.ValidateJWT(bearerToken, cfg.JWTSecret)
-	slog.Info("UserID", "userID", userID)
+	user, err := cfg.DBQueries.GetUserByID(r.Context(), userID)
 	if err != nil {
-		slog.Error("Error validating JWT", "error", err)
+		slog.Error("Error getting user", "error", err, "userID", userID)
 		respondWithError(w, 401, "Unauthorized")
-		return uuid.Nil
+		return db.User{}, nil
+	}
+	if !checkAccountActive(w, user) {
+		return db.User{}, nil
+	}
+	if issuedBeforeReset(user, claims) {
+		slog.Info("Access token predates password reset", "userID", userID)
+		respondWithErrorCode(w, 401, "token_revoked", "Password was reset, please log in again")
+		return db.User{}, nil
+	}
+	// personal access tokens act with whatever role the user has now
+	if claims.PersonalTokenID != "" {
+		claims.Role = user.Role
+	} else if !cfg.checkSessionLive(w, r, user.ID, claims) {
+		return db.User{}, nil
+	}
+	// the role claim must match the current role, so promotions and
+	// demotions take effect as soon as the client refreshes
+	if claims.Role != user.Role {
+		slog.Info("Stale role claim", "userID", userID, "claim", claims.Role, "role", user.Role)
+		respondWithErrorCode(w, 401, "stale_role", "Role changed, refresh your token")
+		return db.User{}, nil
+	}
+	return user, claims
+}
+
+
+// issuedBeforeReset reports whether claims were issued before the user's
+// password was last reset. Token times are in whole seconds, so a token
+// from the second of the reset still counts as after it.
+func issuedBeforeReset(user db.User, claims *auth.Claims) bool {
+	if !user.PasswordChangedAt.Valid || claims.IssuedAt == nil {
+		return false
+	}
+	return claims.IssuedAt.Time.Before(user.PasswordChangedAt.Time.Truncate(time.Second))
+}
+
+// checkSessionLive responds 401 unless the session a JWT was minted from,
+// its refresh token family, still has a live refresh token. Ending a
+// session thus stops its access tokens too, not just future refreshes.
+func (cfg *APIConfig) checkSessionLive(w http.ResponseWriter, r *http.Request, userID uuid.UUID, claims *auth.Claims) bool {
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil || sessionID == uuid.Nil {
+		respondWithErrorCode(w, 401, "token_malformed", "Access token is malformed")
+		return false
+	}
+	live, err := cfg.DBQueries.RefreshTokenFamilyActive(r.Context(), db.RefreshTokenFamilyActiveParams{
+		FamilyID: sessionID,
+		UserID:   userID,
+	})
+	if err != nil {
+		slog.Error("Error checking session", "error", err, "sessionID", sessionID)
+		respondWithError(w, 500, "Could not validate token")
+		return false
+	}
+	if !live {
+		respondWithErrorCode(w, 401, "session_revoked", "Session has ended, please log in again")
+		return false
+	}
+	return true
+}
+
+// checkScopes responds 403 with code insufficient_scope when claims lack
+// one of scopes. Routes without scopes are for Chirpy's own apps only.
+func checkScopes(w http.ResponseWriter, claims *auth.Claims, scopes []string) bool {
+	if !claims.ThirdParty() {
+		return true
+	}
+	if len(scopes) == 0 {
+		slog.Info("Scoped token used on a first-party route", "clientID", claims.ClientID, "tokenID", claims.PersonalTokenID)
+		respondWithErrorCode(w, 403, "insufficient_scope", "This endpoint is not available to third-party apps")
+		return false
+	}
+	for _, scope := range scopes {
+		if !claims.HasScope(scope) {
+			slog.Info("Missing scope", "clientID", claims.ClientID, "tokenID", claims.PersonalTokenID, "scope", scope)
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+strings.Join(scopes, " ")+`"`)
+			respondWithErrorCode(w, 403, "insufficient_scope", "Token lacks the "+scope+" scope")
+			return false
+		}
+	}
+	return true
+}
+
+// respondWithTokenError answers 401 with a code telling the client why its
+// access token was rejected.
+func respondWithTokenError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrTokenIPNotAllowed):
+		respondWithErrorCode(w, 403, "token_ip_not_allowed", "Token may not be used from this address")
+	case errors.Is(err, auth.ErrTokenExpired):
+		respondWithErrorCode(w, 401, "token_expired", "Access token expired")
+	case errors.Is(err, auth.ErrTokenRevoked):
+		respondWithErrorCode(w, 401, "token_revoked", "Access token was revoked")
+	case errors.Is(err, auth.ErrTokenNotYetValid):
+		respondWithErrorCode(w, 401, "token_not_yet_valid", "Access token is not valid yet")
+	case errors.Is(err, auth.ErrTokenIssuer), errors.Is(err, auth.ErrTokenAudience):
+		respondWithErrorCode(w, 401, "token_wrong_audience", "Access token was not issued for this service")
+	case errors.Is(err, auth.ErrTokenSignature):
+		respondWithErrorCode(w, 401, "token_invalid_signature", "Access token signature is invalid")
+	case errors.Is(err, auth.ErrTokenMalformed), errors.Is(err, auth.ErrTokenClaimMissing):
+		respondWithErrorCode(w, 401, "token_malformed", "Access token is malformed")
+	default:
+		slog.Error("Error validating access token", "error", err)
+		respondWithError(w, 500, "Could not validate token")
 	}
-	return userID
 }
 
 
@@ -88,6 +279,12 @@ func respondWithError(w http.ResponseWriter, code int, msg string) {
     json.NewEncoder(w).Encode(map[string]string{"error": msg})
 }
 
+func respondWithErrorCode(w http.ResponseWriter, code int, errCode string, msg string) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(code)
+    json.NewEncoder(w).Encode(map[string]string{"error": msg, "code": errCode})
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(code)
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/mail"
+	"github.com/google/uuid"
+)
+
+var errLoginThrottled = errors.New("too many failed logins")
+
+// HANDLERS
+
+// UnlockUser clears a user's failed logins and lockout.
+func (cfg *APIConfig) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	adminID := r.Context().Value("userID").(uuid.UUID)
+	userID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid user ID")
+		return
+	}
+	user, err := cfg.DBQueries.GetUserByID(r.Context(), userID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Error getting user", "error", err, "userID", userID)
+		respondWithError(w, 500, "Could not unlock user")
+		return
+	}
+	// with counters in Postgres the unlock and its log entry commit together
+	err = cfg.inTx(r.Context(), func(q *db.Queries) error {
+		if cfg.Lockout != nil {
+			if err := cfg.Lockout.WithQueries(q).Unlock(r.Context(), user.Email); err != nil {
+				return err
+			}
+		}
+		_, err := q.CreateModerationLogEntry(r.Context(), db.CreateModerationLogEntryParams{
+			ActorID:      uuid.NullUUID{UUID: adminID, Valid: true},
+			Action:       actionUnlockLogin,
+			TargetUserID: uuid.NullUUID{UUID: user.ID, Valid: true},
+		})
+		return err
+	})
+	if err != nil {
+		slog.Error("Error unlocking user", "error", err, "userID", user.ID)
+		respondWithError(w, 500, "Could not unlock user")
+		return
+	}
+	slog.Info("Unlocked user", "userID", user.ID, "adminID", adminID)
+	w.WriteHeader(204)
+}
+
+// HELPERS
+
+// loginWait is how long logins to email from this client must wait. It is
+// zero when lockout is off or its store is down, so logins keep working.
+func (cfg *APIConfig) loginWait(r *http.Request, email string) time.Duration {
+	if cfg.Lockout == nil {
+		return 0
+	}
+	wait, err := cfg.Lockout.Check(r.Context(), email, clientIP(r), time.Now())
+	if err != nil {
+		slog.Error("Error checking login failures", "error", err)
+		return 0
+	}
+	return wait
+}
+
+// checkLoginAllowed responds 429 with code too_many_attempts when logins
+// to email from this client must wait.
+func (cfg *APIConfig) checkLoginAllowed(w http.ResponseWriter, r *http.Request, email string) bool {
+	wait := cfg.loginWait(r, email)
+	if wait <= 0 {
+		return true
+	}
+	slog.Info("Login throttled", "ip", clientIP(r), "wait", wait)
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+	respondWithErrorCode(w, 429, "too_many_attempts", "Too many failed logins, try again later")
+	return false
+}
+
+// recordLoginFailure counts a wrong password or second factor. When it locks the account,
+// the owner is emailed, whether or not it was them.
+func (cfg *APIConfig) recordLoginFailure(r *http.Request, email string) {
+	if cfg.Lockout == nil {
+		return
+	}
+	result, err := cfg.Lockout.Fail(r.Context(), email, clientIP(r), time.Now())
+	if err != nil {
+		slog.Error("Error recording login failure", "error", err)
+		return
+	}
+	if !result.AccountLocked {
+		return
+	}
+	user, err := cfg.DBQueries.GetUser(r.Context(), email)
+	if err != nil {
+		// nobody to tell about guesses at an address without an account
+		return
+	}
+	slog.Warn("Account locked after failed logins", "userID", user.ID, "ip", clientIP(r))
+	cfg.recordSecurityEvent(r, user.ID, securityEventAccountLocked, map[string]interface{}{
+		"locked_for": result.RetryAfter.String(),
+		"user_agent": r.UserAgent(),
+		"remote":     r.RemoteAddr,
+	})
+	err = mail.Queue(r.Context(), cfg.DBQueries, mail.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy account was locked",
+		Body: fmt.Sprintf("Someone entered a wrong password or two-factor code for your Chirpy account %d times, "+
+			"so we have blocked logins for %s.\n\n"+
+			"If it was you, wait and try again, or reset your password: %s\n"+
+			"If it wasn't you, consider changing your password.\n",
+			cfg.Lockout.Account.LockAfter, result.RetryAfter, cfg.BaseURL+"/app/forgot-password"),
+	})
+	if err != nil {
+		slog.Error("Error queueing lockout email", "error", err, "userID", user.ID)
+	}
+}
+
+// recordLoginSuccess clears the account's failures once the whole login,
+// second factor included, has succeeded.
+func (cfg *APIConfig) recordLoginSuccess(r *http.Request, email string) {
+	if cfg.Lockout == nil {
+		return
+	}
+	if err := cfg.Lockout.Succeed(r.Context(), email); err != nil {
+		slog.Error("Error clearing login failures", "error", err)
+	}
+}
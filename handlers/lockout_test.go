@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/lockout"
+	"github.com/google/uuid"
+)
+
+func TestLoginThrottled(t *testing.T) {
+	guard := lockout.NewGuard(lockout.NewMemoryStore())
+	cfg := &APIConfig{Lockout: guard}
+	for i := 0; i < guard.Account.LockAfter; i++ {
+		guard.Fail(context.Background(), "ada@example.com", "198.51.100.9", time.Now())
+	}
+	req := httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"email":"ADA@example.com","password":"anything"}`))
+	w := httptest.NewRecorder()
+	cfg.Login(w, req)
+	if w.Code != 429 || !strings.Contains(w.Body.String(), "too_many_attempts") {
+		t.Fatalf("Expected 429 too_many_attempts, got %d %s", w.Code, w.Body.String())
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "1800" {
+		t.Errorf("Expected Retry-After 1800, got %q", retryAfter)
+	}
+}
+
+func TestUnlockUserLogsInTransaction(t *testing.T) {
+	user := db.User{ID: uuid.New(), Email: "ada@example.com", Status: userStatusActive, Role: auth.RoleUser}
+	for _, logErr := range []error{nil, errors.New("disk full")} {
+		fake := newFakeDB()
+		fake.on("GetUserByID", userRow(user))
+		fake.on("CreateModerationLogEntry", anyLogEntry)
+		if logErr != nil {
+			fake.fail("CreateModerationLogEntry", logErr)
+		}
+		cfg := fake.config()
+		cfg.Lockout = lockout.NewGuard(lockout.PostgresStore{Queries: cfg.DBQueries})
+		w := httptest.NewRecorder()
+		cfg.UnlockUser(w, asModerator("POST", "/admin/users/x/unlock", "", uuid.New(), user.ID))
+
+		expected, code := "GetUserByID begin ResetLoginFailures CreateModerationLogEntry commit", 204
+		if logErr != nil {
+			expected, code = "GetUserByID begin ResetLoginFailures CreateModerationLogEntry rollback", 500
+		}
+		if w.Code != code {
+			t.Errorf("Log error %v: expected %d, got %d %s", logErr, code, w.Code, w.Body.String())
+		}
+		if trace := fake.trace(); trace != expected {
+			t.Errorf("Log error %v: expected %q, got %q", logErr, expected, trace)
+		}
+	}
+}
+
+func TestLoginWithMFAKeepsFailures(t *testing.T) {
+	hash, err := auth.HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+	user := db.User{ID: uuid.New(), Email: "ada@example.com", HashedPassword: hash, Status: userStatusActive, Role: auth.RoleUser}
+	guard := lockout.NewGuard(lockout.NewMemoryStore())
+	// earlier failures whose backoff has run out
+	for i := 0; i < guard.Account.LockAfter-1; i++ {
+		guard.Fail(context.Background(), user.Email, "198.51.100.9", time.Now().Add(-time.Hour))
+	}
+	fake := newFakeDB()
+	fake.on("GetUser", userRow(user))
+	fake.on("GetUserTOTP", []driver.Value{user.ID.String(), time.Now(), "sealed", time.Now(), int64(0)})
+	cfg := fake.config()
+	cfg.Lockout = guard
+	w := httptest.NewRecorder()
+	body := `{"email":"ada@example.com","password":"correct horse battery staple"}`
+	cfg.Login(w, httptest.NewRequest("POST", "/api/login", strings.NewReader(body)))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "mfa_token") {
+		t.Fatalf("Expected the second step, got %d %s", w.Code, w.Body.String())
+	}
+
+	// the password alone must not wipe the failures the second factor is
+	// still subject to
+	guard.Fail(context.Background(), user.Email, "198.51.100.9", time.Now())
+	if wait, _ := guard.Check(context.Background(), user.Email, "203.0.113.7", time.Now()); wait < 29*time.Minute {
+		t.Errorf("Expected the account to be locked, got a wait of %s", wait)
+	}
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/mail"
+)
+
+const (
+	emailPurposeMagicLink = "magic_link"
+	magicLinkTTL          = 15 * time.Minute
+	// magicLinkCookie holds the nonce that ties a link to the browser
+	// that asked for it.
+	magicLinkCookie = "chirpy_magic_nonce"
+
+	magicLinkEmailLimit  = 3
+	magicLinkEmailWindow = magicLinkTTL
+	magicLinkIPLimit     = 20
+	magicLinkIPWindow    = time.Hour
+)
+
+type MagicLinkIn struct {
+	Email string `json:"email"`
+}
+
+// HANDLERS
+
+// RequestMagicLink emails a one-time login link. Like ForgotPassword it
+// answers 202 whether or not the account exists.
+func (cfg *APIConfig) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	reqLink := MagicLinkIn{}
+	if err := json.NewDecoder(r.Body).Decode(&reqLink); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	if !strings.Contains(reqLink.Email, "@") {
+		respondWithError(w, 400, "Email must contain @")
+		return
+	}
+	if !cfg.allowMagicLinkRequest(w, r, reqLink.Email) { return }
+
+	nonce, err := auth.MakeRefreshToken()
+	if err != nil {
+		slog.Error("Error creating magic link nonce", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkCookie,
+		Value:    nonce,
+		Path:     "/api/login/magic",
+		MaxAge:   int(magicLinkTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.BaseURL, "https://"),
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	user, err := cfg.DBQueries.GetUser(r.Context(), reqLink.Email)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(202)
+		return
+	}
+	if err != nil {
+		slog.Error("Error getting user", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	if accountState(user, time.Now()) == userStatusBanned {
+		w.WriteHeader(202)
+		return
+	}
+	err = cfg.inTx(r.Context(), func(q *db.Queries) error {
+		token, err := issueEmailToken(r.Context(), q, cfg.RefreshTokenPepper, db.CreateEmailTokenParams{
+			UserID:    user.ID,
+			Purpose:   emailPurposeMagicLink,
+			ExpiresAt: time.Now().Add(magicLinkTTL),
+			NonceHash: auth.HashRefreshToken(nonce, cfg.RefreshTokenPepper),
+		})
+		if err != nil {
+			return err
+		}
+		return mail.Queue(r.Context(), q, mail.Message{
+			To:      user.Email,
+			Subject: "Your Chirpy login link",
+			Body: fmt.Sprintf("Log in to Chirpy: %s\n\n"+
+				"The link works once, for 15 minutes, in the browser where you asked for it.\n"+
+				"If it wasn't you, ignore this email.\n",
+				cfg.appLink("/magic-login", token)),
+		})
+	})
+	if err != nil {
+		slog.Error("Error queueing magic link", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	w.WriteHeader(202)
+}
+
+// FinishMagicLink exchanges a link's token for the same response as Login.
+// It only works with the nonce cookie set by RequestMagicLink.
+func (cfg *APIConfig) FinishMagicLink(w http.ResponseWriter, r *http.Request) {
+	reqToken := EmailTokenIn{}
+	if err := json.NewDecoder(r.Body).Decode(&reqToken); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	cookie, err := r.Cookie(magicLinkCookie)
+	if err != nil {
+		respondWithErrorCode(w, 400, "wrong_browser", "Open the link in the browser where you asked for it")
+		return
+	}
+	token, err := cfg.DBQueries.ConsumeMagicLinkToken(r.Context(), db.ConsumeMagicLinkTokenParams{
+		TokenHash: auth.HashRefreshToken(reqToken.Token, cfg.RefreshTokenPepper),
+		NonceHash: auth.HashRefreshToken(cookie.Value, cfg.RefreshTokenPepper),
+	})
+	if err == sql.ErrNoRows {
+		respondWithErrorCode(w, 400, "invalid_token", "Link is invalid or expired")
+		return
+	}
+	if err != nil {
+		slog.Error("Error consuming magic link", "error", err)
+		respondWithError(w, 500, "Could not log in")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   magicLinkCookie,
+		Path:   "/api/login/magic",
+		MaxAge: -1,
+	})
+
+	user, err := cfg.DBQueries.GetUserByID(r.Context(), token.UserID)
+	if err != nil {
+		slog.Error("Error getting user", "error", err)
+		respondWithError(w, 500, "Could not log in")
+		return
+	}
+	if !checkAccountActive(w, user) { return }
+	// the link reached the inbox, which proves the address
+	if !user.VerifiedAt.Valid {
+		if err := cfg.DBQueries.VerifyUserEmail(r.Context(), user.ID); err != nil {
+			slog.Error("Error verifying email", "error", err)
+			respondWithError(w, 500, "Could not log in")
+			return
+		}
+		user.VerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+
+	mfaEnabled, err := cfg.secondFactorEnabled(r, user.ID)
+	if err != nil {
+		slog.Error("Error checking two-factor authentication", "error", err)
+		respondWithError(w, 500, "Could not log in")
+		return
+	}
+	if mfaEnabled {
+		cfg.startMFAChallenge(w, r, user)
+		return
+	}
+	cfg.startSession(w, r, user, time.Time{})
+}
+
+// HELPERS
+
+// allowMagicLinkRequest records a link request and responds 429 if the
+// email or the client IP has asked for too many recently. Requests for
+// unknown emails count too, so the limit reveals nothing about accounts.
+func (cfg *APIConfig) allowMagicLinkRequest(w http.ResponseWriter, r *http.Request, email string) bool {
+	now := time.Now()
+	email = strings.ToLower(strings.TrimSpace(email))
+	ip := clientIP(r)
+	byEmail, err := cfg.DBQueries.CountMagicLinkRequestsByEmail(r.Context(), db.CountMagicLinkRequestsByEmailParams{
+		Email: email,
+		Since: now.Add(-magicLinkEmailWindow),
+	})
+	if err != nil {
+		slog.Error("Error counting magic link requests", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return false
+	}
+	byIP, err := cfg.DBQueries.CountMagicLinkRequestsByIP(r.Context(), db.CountMagicLinkRequestsByIPParams{
+		Ip:    ip,
+		Since: now.Add(-magicLinkIPWindow),
+	})
+	if err != nil {
+		slog.Error("Error counting magic link requests", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return false
+	}
+	if retryAfter, limited := magicLinkLimited(byEmail, byIP); limited {
+		slog.Info("Magic link request rate limited", "ip", ip)
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+		respondWithErrorCode(w, 429, "rate_limited", "Too many login link requests, try again later")
+		return false
+	}
+
+	err = cfg.DBQueries.RecordMagicLinkRequest(r.Context(), db.RecordMagicLinkRequestParams{
+		Email: email,
+		Ip:    ip,
+	})
+	if err == nil {
+		err = cfg.DBQueries.DeleteMagicLinkRequestsBefore(r.Context(), now.Add(-magicLinkIPWindow))
+	}
+	if err != nil {
+		slog.Error("Error recording magic link request", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return false
+	}
+	return true
+}
+
+// magicLinkLimited reports whether another request would go over a limit,
+// and how long the client should wait if so.
+func magicLinkLimited(byEmail int64, byIP int64) (time.Duration, bool) {
+	if byIP >= magicLinkIPLimit {
+		return magicLinkIPWindow, true
+	}
+	if byEmail >= magicLinkEmailLimit {
+		return magicLinkEmailWindow, true
+	}
+	return 0, false
+}
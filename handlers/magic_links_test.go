@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMagicLinkLimited(t *testing.T) {
+	testCases := []struct {
+		byEmail    int64
+		byIP       int64
+		limited    bool
+		retryAfter time.Duration
+	}{
+		{0, 0, false, 0},
+		{magicLinkEmailLimit - 1, magicLinkIPLimit - 1, false, 0},
+		{magicLinkEmailLimit, 0, true, magicLinkEmailWindow},
+		{0, magicLinkIPLimit, true, magicLinkIPWindow},
+		{magicLinkEmailLimit, magicLinkIPLimit, true, magicLinkIPWindow}, // the longer wait wins
+	}
+	for _, testCase := range testCases {
+		retryAfter, limited := magicLinkLimited(testCase.byEmail, testCase.byIP)
+		if limited != testCase.limited || retryAfter != testCase.retryAfter {
+			t.Errorf("magicLinkLimited(%d, %d) = %v, %v; expected %v, %v",
+				testCase.byEmail, testCase.byIP, retryAfter, limited, testCase.retryAfter, testCase.limited)
+		}
+	}
+}
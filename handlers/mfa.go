@@ -0,0 +1,464 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/secretbox"
+	"github.com/eliza-guseva/chirpy-server/internal/webauthn"
+	"github.com/google/uuid"
+)
+
+const (
+	totpIssuer = "Chirpy"
+	// mfaChallengeTTL is how long the second login step may take.
+	mfaChallengeTTL         = 5 * time.Minute
+	mfaChallengeMaxAttempts = 5
+	// mfaVerifyWindow is how long step-up attempts are counted against
+	// mfaChallengeMaxAttempts.
+	mfaVerifyWindow = 15 * time.Minute
+	// recentMFAWindow is how long a passed second factor unlocks
+	// sensitive operations.
+	recentMFAWindow = 15 * time.Minute
+)
+
+type TOTPEnrollOut struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// MFACodeIn carries one second factor: a TOTP code, a recovery code, or a
+// passkey assertion with the ceremony it answers.
+type MFACodeIn struct {
+	Code         string                      `json:"code"`
+	RecoveryCode string                      `json:"recovery_code"`
+	CeremonyID   string                      `json:"ceremony_id"`
+	Passkey      *webauthn.AssertionResponse `json:"passkey"`
+}
+
+type MFALoginIn struct {
+	MFAToken string `json:"mfa_token"`
+	MFACodeIn
+}
+
+type MFAChallengeOut struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+// HANDLERS
+
+// EnrollTOTP starts TOTP enrollment. The secret only takes effect once
+// ConfirmTOTP sees a code generated from it.
+func (cfg *APIConfig) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	user, err := cfg.DBQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		slog.Error("Error getting user", "error", err)
+		respondWithError(w, 500, "Could not get user")
+		return
+	}
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		slog.Error("Error generating TOTP secret", "error", err)
+		respondWithError(w, 500, "Could not start enrollment")
+		return
+	}
+	_, err = cfg.DBQueries.UpsertUserTOTP(r.Context(), db.UpsertUserTOTPParams{
+		UserID: userID,
+		Secret: cfg.Secrets.SealString(secret, totpLabel(userID)),
+	})
+	if err == sql.ErrNoRows {
+		// the upsert skips confirmed rows
+		respondWithError(w, 409, "Two-factor authentication is already enabled")
+		return
+	}
+	if err != nil {
+		slog.Error("Error saving TOTP secret", "error", err)
+		respondWithError(w, 500, "Could not start enrollment")
+		return
+	}
+	respondWithJSON(w, 200, TOTPEnrollOut{
+		Secret: secret,
+		URI:    auth.TOTPProvisioningURI(secret, totpIssuer, user.Email),
+	})
+}
+
+// ConfirmTOTP enables TOTP once the user proves their app has the secret,
+// and returns recovery codes. The codes are only ever shown here.
+func (cfg *APIConfig) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	reqCode := MFACodeIn{}
+	if err := json.NewDecoder(r.Body).Decode(&reqCode); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	totp, err := cfg.DBQueries.GetUserTOTP(r.Context(), userID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, 404, "Start enrollment first")
+		return
+	}
+	if err != nil {
+		slog.Error("Error getting TOTP secret", "error", err)
+		respondWithError(w, 500, "Could not confirm enrollment")
+		return
+	}
+	if totp.ConfirmedAt.Valid {
+		respondWithError(w, 409, "Two-factor authentication is already enabled")
+		return
+	}
+	ok, err := cfg.checkTOTP(r, totp, reqCode.Code)
+	if err != nil {
+		slog.Error("Error checking TOTP code", "error", err)
+		respondWithError(w, 500, "Could not confirm enrollment")
+		return
+	}
+	if !ok {
+		respondWithErrorCode(w, 401, "invalid_mfa_code", "Invalid code")
+		return
+	}
+	// TOTP is only enabled together with the codes that get the user back in
+	var codes []string
+	err = cfg.inTx(r.Context(), func(q *db.Queries) error {
+		if err := q.ConfirmUserTOTP(r.Context(), userID); err != nil {
+			return err
+		}
+		codes, err = resetRecoveryCodes(r.Context(), q, cfg.RefreshTokenPepper, userID)
+		return err
+	})
+	if err != nil {
+		slog.Error("Error confirming TOTP", "error", err)
+		respondWithError(w, 500, "Could not confirm enrollment")
+		return
+	}
+	respondWithJSON(w, 200, map[string][]string{"recovery_codes": codes})
+}
+
+// DisableTOTP turns TOTP off. It needs a recent second factor.
+func (cfg *APIConfig) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	if !cfg.requireRecentMFA(w, r) {
+		return
+	}
+	if err := cfg.DBQueries.DeleteUserTOTP(r.Context(), userID); err != nil {
+		slog.Error("Error deleting TOTP secret", "error", err)
+		respondWithError(w, 500, "Could not disable two-factor authentication")
+		return
+	}
+	if err := cfg.DBQueries.DeleteRecoveryCodes(r.Context(), userID); err != nil {
+		slog.Error("Error deleting recovery codes", "error", err)
+		respondWithError(w, 500, "Could not disable two-factor authentication")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// VerifyMFA is the step-up for a logged-in user: a valid code gets an
+// access token that unlocks sensitive operations for a while. Wrong codes
+// count as failed logins, and too many end the session, as they end the
+// login in LoginMFA.
+func (cfg *APIConfig) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	sessionID, _ := r.Context().Value("sessionID").(uuid.UUID)
+	reqCode := MFACodeIn{}
+	if err := json.NewDecoder(r.Body).Decode(&reqCode); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	user, err := cfg.DBQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		slog.Error("Error getting user", "error", err)
+		respondWithError(w, 500, "Could not get user")
+		return
+	}
+	if !cfg.checkLoginAllowed(w, r, user.Email) {
+		return
+	}
+	if !cfg.attemptMFAVerify(w, r, userID, sessionID) {
+		return
+	}
+	ok, err := cfg.verifySecondFactor(r, userID, reqCode)
+	if err != nil {
+		slog.Error("Error verifying second factor", "error", err)
+		respondWithError(w, 500, "Could not verify code")
+		return
+	}
+	if !ok {
+		cfg.recordLoginFailure(r, user.Email)
+		respondWithErrorCode(w, 401, "invalid_mfa_code", "Invalid code")
+		return
+	}
+	if err := cfg.DBQueries.ResetMFAVerifyAttempts(r.Context(), userID); err != nil {
+		slog.Error("Error resetting MFA attempts", "error", err, "userID", userID)
+	}
+	jwtToken, err := cfg.createTokenWithExp(user, sessionID, time.Now().UTC(), w)
+	if err != nil { return }
+	respondWithJSON(w, 200, map[string]string{"token": jwtToken})
+}
+
+// LoginMFA is the second login step for users with a second factor.
+// Like the password step, it is refused while the account is locked out
+// and every wrong code counts as a failed login.
+func (cfg *APIConfig) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	reqLogin := MFALoginIn{}
+	if err := json.NewDecoder(r.Body).Decode(&reqLogin); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	challenge, ok := cfg.attemptMFAChallenge(w, r, reqLogin.MFAToken)
+	if !ok {
+		return
+	}
+	user, err := cfg.DBQueries.GetUserByID(r.Context(), challenge.UserID)
+	if err != nil {
+		slog.Error("Error getting user", "error", err)
+		respondWithError(w, 500, "Could not get user")
+		return
+	}
+	if !checkAccountActive(w, user) { return }
+	if !cfg.checkLoginAllowed(w, r, user.Email) {
+		return
+	}
+
+	ok, err = cfg.verifySecondFactor(r, user.ID, reqLogin.MFACodeIn)
+	if err != nil {
+		slog.Error("Error verifying second factor", "error", err)
+		respondWithError(w, 500, "Could not verify code")
+		return
+	}
+	if !ok {
+		cfg.recordLoginFailure(r, user.Email)
+		respondWithErrorCode(w, 401, "invalid_mfa_code", "Invalid code")
+		return
+	}
+	consumed, err := cfg.DBQueries.ConsumeMFAChallenge(r.Context(), challenge.TokenHash)
+	if err != nil {
+		slog.Error("Error consuming MFA challenge", "error", err)
+		respondWithError(w, 500, "Could not verify code")
+		return
+	}
+	if consumed == 0 {
+		respondWithErrorCode(w, 401, "invalid_mfa_token", "MFA token is invalid or expired, please log in again")
+		return
+	}
+	cfg.recordLoginSuccess(r, user.Email)
+	cfg.startSession(w, r, user, time.Now().UTC())
+}
+
+// HELPERS
+
+// secondFactorEnabled reports whether the user has confirmed TOTP
+// enrollment or registered a passkey.
+func (cfg *APIConfig) secondFactorEnabled(r *http.Request, userID uuid.UUID) (bool, error) {
+	totp, err := cfg.DBQueries.GetUserTOTP(r.Context(), userID)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	if err == nil && totp.ConfirmedAt.Valid {
+		return true, nil
+	}
+	passkeys, err := cfg.DBQueries.CountWebAuthnCredentialsByUser(r.Context(), userID)
+	if err != nil {
+		return false, err
+	}
+	return passkeys > 0, nil
+}
+
+// attemptMFAChallenge finds the login waiting for a second factor and
+// counts the attempt, giving up on the login after too many.
+func (cfg *APIConfig) attemptMFAChallenge(w http.ResponseWriter, r *http.Request, mfaToken string) (db.MfaChallenge, bool) {
+	tokenHash := auth.HashRefreshToken(mfaToken, cfg.RefreshTokenPepper)
+	challenge, err := cfg.DBQueries.AttemptMFAChallenge(r.Context(), tokenHash)
+	if err == sql.ErrNoRows {
+		respondWithErrorCode(w, 401, "invalid_mfa_token", "MFA token is invalid or expired, please log in again")
+		return db.MfaChallenge{}, false
+	}
+	if err != nil {
+		slog.Error("Error getting MFA challenge", "error", err)
+		respondWithError(w, 500, "Could not verify code")
+		return db.MfaChallenge{}, false
+	}
+	if challenge.Attempts > mfaChallengeMaxAttempts {
+		cfg.DBQueries.ConsumeMFAChallenge(r.Context(), tokenHash)
+		respondWithErrorCode(w, 401, "invalid_mfa_token", "Too many attempts, please log in again")
+		return db.MfaChallenge{}, false
+	}
+	return challenge, true
+}
+
+// attemptMFAVerify counts a step-up attempt. Past mfaChallengeMaxAttempts
+// in mfaVerifyWindow it revokes the session, so whoever holds the access
+// token has to get through the login, with its own limits, again.
+func (cfg *APIConfig) attemptMFAVerify(w http.ResponseWriter, r *http.Request, userID uuid.UUID, sessionID uuid.UUID) bool {
+	attempts, err := cfg.DBQueries.AttemptMFAVerify(r.Context(), db.AttemptMFAVerifyParams{
+		UserID:        userID,
+		WindowSeconds: mfaVerifyWindow.Seconds(),
+	})
+	if err != nil {
+		slog.Error("Error counting MFA attempt", "error", err)
+		respondWithError(w, 500, "Could not verify code")
+		return false
+	}
+	if attempts <= mfaChallengeMaxAttempts {
+		return true
+	}
+	slog.Warn("Too many MFA step-up attempts", "userID", userID, "sessionID", sessionID)
+	if sessionID != uuid.Nil {
+		_, err := cfg.DBQueries.RevokeUserRefreshTokenFamily(r.Context(), db.RevokeUserRefreshTokenFamilyParams{
+			FamilyID: sessionID,
+			UserID:   userID,
+		})
+		if err != nil {
+			slog.Error("Error revoking session", "error", err)
+			respondWithError(w, 500, "Could not verify code")
+			return false
+		}
+	}
+	respondWithErrorCode(w, 401, "session_revoked", "Too many attempts, please log in again")
+	return false
+}
+
+// startMFAChallenge answers the first login step for a user with TOTP
+// enabled: instead of tokens they get a short-lived token for LoginMFA.
+func (cfg *APIConfig) startMFAChallenge(w http.ResponseWriter, r *http.Request, user db.User) {
+	mfaToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		slog.Error("Error creating MFA token", "error", err)
+		respondWithError(w, 500, "Could not log in")
+		return
+	}
+	err = cfg.DBQueries.CreateMFAChallenge(r.Context(), db.CreateMFAChallengeParams{
+		TokenHash: auth.HashRefreshToken(mfaToken, cfg.RefreshTokenPepper),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(mfaChallengeTTL),
+	})
+	if err != nil {
+		slog.Error("Error saving MFA challenge", "error", err)
+		respondWithError(w, 500, "Could not log in")
+		return
+	}
+	respondWithJSON(w, 200, MFAChallengeOut{
+		MFARequired: true,
+		MFAToken:    mfaToken,
+	})
+}
+
+// verifySecondFactor checks a passkey assertion, a recovery code or a TOTP
+// code, whichever was given. Each works once.
+func (cfg *APIConfig) verifySecondFactor(r *http.Request, userID uuid.UUID, reqCode MFACodeIn) (bool, error) {
+	if reqCode.Passkey != nil {
+		return cfg.verifyPasskeyMFA(r, userID, reqCode.CeremonyID, *reqCode.Passkey)
+	}
+	if reqCode.RecoveryCode != "" {
+		used, err := cfg.DBQueries.UseRecoveryCode(r.Context(), db.UseRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashRecoveryCode(reqCode.RecoveryCode, cfg.RefreshTokenPepper),
+		})
+		return used == 1, err
+	}
+	totp, err := cfg.DBQueries.GetUserTOTP(r.Context(), userID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !totp.ConfirmedAt.Valid {
+		return false, nil
+	}
+	return cfg.checkTOTP(r, totp, reqCode.Code)
+}
+
+// checkTOTP validates code and records its time step so it cannot be
+// used again.
+func (cfg *APIConfig) checkTOTP(r *http.Request, totp db.UserTotp, code string) (bool, error) {
+	secret, err := cfg.openTOTPSecret(r, totp)
+	if err != nil {
+		return false, err
+	}
+	step, ok := auth.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	used, err := cfg.DBQueries.UseTOTPStep(r.Context(), db.UseTOTPStepParams{
+		UserID:       totp.UserID,
+		LastUsedStep: step,
+	})
+	return used == 1, err
+}
+
+// openTOTPSecret returns the user's TOTP secret, sealing it first if it
+// was stored before secrets were encrypted.
+func (cfg *APIConfig) openTOTPSecret(r *http.Request, totp db.UserTotp) (string, error) {
+	secret, err := cfg.Secrets.OpenString(totp.Secret, totpLabel(totp.UserID))
+	if err == nil {
+		return secret, nil
+	}
+	if !errors.Is(err, secretbox.ErrNotSealed) {
+		return "", err
+	}
+	err = cfg.DBQueries.SealUserTOTPSecret(r.Context(), db.SealUserTOTPSecretParams{
+		UserID: totp.UserID,
+		Secret: cfg.Secrets.SealString(totp.Secret, totpLabel(totp.UserID)),
+	})
+	if err != nil {
+		return "", err
+	}
+	return totp.Secret, nil
+}
+
+// totpLabel binds a sealed TOTP secret to its user.
+func totpLabel(userID uuid.UUID) string {
+	return "totp:" + userID.String()
+}
+
+// resetRecoveryCodes replaces the user's recovery codes and returns the
+// new ones.
+func resetRecoveryCodes(ctx context.Context, q *db.Queries, pepper string, userID uuid.UUID) ([]string, error) {
+	if err := q.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		err := q.CreateRecoveryCode(ctx, db.CreateRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashRecoveryCode(code, pepper),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// requireRecentMFA lets the request through if the user has no second
+// factor or passed one within recentMFAWindow. Otherwise it responds 403
+// and the client should step up through VerifyMFA.
+func (cfg *APIConfig) requireRecentMFA(w http.ResponseWriter, r *http.Request) bool {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	enabled, err := cfg.secondFactorEnabled(r, userID)
+	if err != nil {
+		slog.Error("Error checking two-factor authentication", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return false
+	}
+	if !enabled {
+		return true
+	}
+	claims, _ := r.Context().Value("claims").(*auth.Claims)
+	if claims != nil && claims.MFASince(time.Now().Add(-recentMFAWindow)) {
+		return true
+	}
+	respondWithErrorCode(w, 403, "mfa_required", "Verify your second factor first")
+	return false
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/lockout"
+	"github.com/eliza-guseva/chirpy-server/internal/secretbox"
+	"github.com/google/uuid"
+)
+
+// stepUp builds a VerifyMFA request from userID in sessionID.
+func stepUp(body string, userID uuid.UUID, sessionID uuid.UUID) *http.Request {
+	req := httptest.NewRequest("POST", "/api/mfa/verify", strings.NewReader(body))
+	ctx := context.WithValue(req.Context(), "userID", userID)
+	return req.WithContext(context.WithValue(ctx, "sessionID", sessionID))
+}
+
+func TestVerifyMFAWrongCode(t *testing.T) {
+	user := db.User{ID: uuid.New(), Email: "user@example.com", Status: userStatusActive, Role: auth.RoleUser}
+	fake := newFakeDB()
+	fake.on("GetUserByID", userRow(user))
+	fake.on("AttemptMFAVerify", []driver.Value{int64(1)})
+	fake.on("UseRecoveryCode")
+	w := httptest.NewRecorder()
+	fake.config().VerifyMFA(w, stepUp(`{"recovery_code":"wrong"}`, user.ID, uuid.New()))
+	if w.Code != 401 || !strings.Contains(w.Body.String(), "invalid_mfa_code") {
+		t.Fatalf("Expected 401 invalid_mfa_code, got %d %s", w.Code, w.Body.String())
+	}
+	if trace := fake.trace(); trace != "GetUserByID AttemptMFAVerify UseRecoveryCode" {
+		t.Errorf("Expected the attempt to be counted, got %s", trace)
+	}
+}
+
+func TestVerifyMFATooManyAttempts(t *testing.T) {
+	user := db.User{ID: uuid.New(), Email: "user@example.com", Status: userStatusActive, Role: auth.RoleUser}
+	sessionID := uuid.New()
+	fake := newFakeDB()
+	fake.on("GetUserByID", userRow(user))
+	fake.on("AttemptMFAVerify", []driver.Value{int64(mfaChallengeMaxAttempts + 1)})
+	w := httptest.NewRecorder()
+	fake.config().VerifyMFA(w, stepUp(`{"recovery_code":"guess"}`, user.ID, sessionID))
+	if w.Code != 401 || !strings.Contains(w.Body.String(), "session_revoked") {
+		t.Fatalf("Expected 401 session_revoked, got %d %s", w.Code, w.Body.String())
+	}
+	if trace := fake.trace(); trace != "GetUserByID AttemptMFAVerify RevokeUserRefreshTokenFamily" {
+		t.Errorf("Expected the session to end without checking the code, got %s", trace)
+	}
+	if revoked := fake.called("RevokeUserRefreshTokenFamily")[0]; revoked[0] != sessionID.String() {
+		t.Errorf("Expected session %s to be revoked, got %v", sessionID, revoked)
+	}
+}
+
+func TestOpenTOTPSecretSealsPlaintext(t *testing.T) {
+	box, err := secretbox.New(make([]byte, secretbox.KeySize))
+	if err != nil {
+		t.Fatalf("Error creating box: %v", err)
+	}
+	fake := newFakeDB()
+	cfg := fake.config()
+	cfg.Secrets = box
+	totp := db.UserTotp{UserID: uuid.New(), Secret: "JBSWY3DPEHPK3PXP"}
+	req := httptest.NewRequest("POST", "/api/mfa/verify", nil)
+
+	secret, err := cfg.openTOTPSecret(req, totp)
+	if err != nil || secret != totp.Secret {
+		t.Fatalf("Expected the plaintext secret, got %q (%v)", secret, err)
+	}
+	sealed := fake.called("SealUserTOTPSecret")
+	if len(sealed) != 1 {
+		t.Fatalf("Expected the secret to be sealed, got %s", fake.trace())
+	}
+	totp.Secret = sealed[0][1].(string)
+	if secret, err := cfg.openTOTPSecret(req, totp); err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("Expected the sealed secret to open, got %q (%v)", secret, err)
+	}
+	if len(fake.called("SealUserTOTPSecret")) != 1 {
+		t.Errorf("Expected a sealed secret to be left alone")
+	}
+}
+
+func TestConfirmTOTPRollsBack(t *testing.T) {
+	box, err := secretbox.New(make([]byte, secretbox.KeySize))
+	if err != nil {
+		t.Fatalf("Error creating box: %v", err)
+	}
+	userID := uuid.New()
+	secret := "JBSWY3DPEHPK3PXP"
+	code, err := auth.TOTPCode(secret, auth.TOTPStep(time.Now()))
+	if err != nil {
+		t.Fatalf("Error creating code: %v", err)
+	}
+	fake := newFakeDB()
+	fake.on("GetUserTOTP", []driver.Value{userID.String(), time.Now(), box.SealString(secret, totpLabel(userID)), nil, int64(0)})
+	fake.fail("CreateRecoveryCode", errors.New("disk full"))
+	cfg := fake.config()
+	cfg.Secrets = box
+	req := httptest.NewRequest("POST", "/api/mfa/totp/confirm", strings.NewReader(`{"code":"`+code+`"}`))
+	w := httptest.NewRecorder()
+	cfg.ConfirmTOTP(w, req.WithContext(context.WithValue(req.Context(), "userID", userID)))
+	if w.Code != 500 || strings.Contains(w.Body.String(), "recovery_codes") {
+		t.Fatalf("Expected 500 without codes, got %d %s", w.Code, w.Body.String())
+	}
+	if trace := fake.trace(); trace != "GetUserTOTP UseTOTPStep begin ConfirmUserTOTP DeleteRecoveryCodes CreateRecoveryCode rollback" {
+		t.Errorf("Expected TOTP to stay off without its recovery codes, got %s", trace)
+	}
+}
+
+// mfaLogin builds a LoginMFA request answering challenge with body.
+func mfaLogin(fake *fakeDB, user db.User, body string) *http.Request {
+	fake.on("AttemptMFAChallenge", []driver.Value{"hash", user.ID.String(), time.Now(), time.Now().Add(time.Minute), int64(1), nil})
+	fake.on("GetUserByID", userRow(user))
+	fake.on("UseRecoveryCode")
+	return httptest.NewRequest("POST", "/api/login/mfa", strings.NewReader(body))
+}
+
+func TestLoginMFALockedOut(t *testing.T) {
+	user := db.User{ID: uuid.New(), Email: "ada@example.com", Status: userStatusActive, Role: auth.RoleUser}
+	guard := lockout.NewGuard(lockout.NewMemoryStore())
+	for i := 0; i < guard.Account.LockAfter; i++ {
+		guard.Fail(context.Background(), user.Email, "198.51.100.9", time.Now())
+	}
+	fake := newFakeDB()
+	cfg := fake.config()
+	cfg.Lockout = guard
+	w := httptest.NewRecorder()
+	cfg.LoginMFA(w, mfaLogin(fake, user, `{"mfa_token":"t","recovery_code":"guess"}`))
+	if w.Code != 429 || !strings.Contains(w.Body.String(), "too_many_attempts") {
+		t.Fatalf("Expected 429 too_many_attempts, got %d %s", w.Code, w.Body.String())
+	}
+	if trace := fake.trace(); trace != "AttemptMFAChallenge GetUserByID" {
+		t.Errorf("Expected the code not to be checked, got %s", trace)
+	}
+}
+
+func TestLoginMFAWrongCodeCountsAsFailure(t *testing.T) {
+	user := db.User{ID: uuid.New(), Email: "ada@example.com", Status: userStatusActive, Role: auth.RoleUser}
+	guard := lockout.NewGuard(lockout.NewMemoryStore())
+	fake := newFakeDB()
+	cfg := fake.config()
+	cfg.Lockout = guard
+	// the wrong codes add up until logins from here have to wait
+	for i := 0; i <= guard.Account.LockAfter; i++ {
+		w := httptest.NewRecorder()
+		cfg.LoginMFA(w, mfaLogin(fake, user, `{"mfa_token":"t","recovery_code":"wrong"}`))
+		if w.Code == 429 {
+			return
+		}
+		if w.Code != 401 {
+			t.Fatalf("Attempt %d: expected 401, got %d %s", i+1, w.Code, w.Body.String())
+		}
+	}
+	t.Errorf("Expected wrong codes to be throttled after %d attempts", guard.Account.LockAfter)
+}
@@ -0,0 +1,833 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/google/uuid"
+)
+
+const (
+	oauthCodeTTL        = 5 * time.Minute
+	oauthAccessTokenTTL = time.Hour
+	maxRedirectURIs     = 10
+)
+
+type OAuthClientIn struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	// Confidential clients get a secret. Public ones, like mobile and
+	// single-page apps, rely on PKCE alone.
+	Confidential bool `json:"confidential"`
+}
+
+type OAuthClientOut struct {
+	ClientID     string    `json:"client_id"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type OAuthTokenOut struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// IntrospectionOut is an RFC 7662 introspection response. Everything but
+// Active is left out for inactive tokens.
+type IntrospectionOut struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+}
+
+// authorizeRequest is a validated authorization request.
+type authorizeRequest struct {
+	Client        db.OauthClient
+	RedirectURI   string
+	Scopes        []string
+	State         string
+	CodeChallenge string
+}
+
+type consentScope struct {
+	Name        string
+	Description string
+}
+
+// consentPage is the data for templates/oauth_authorize.html. With an
+// empty ClientName it only shows Error.
+type consentPage struct {
+	ClientName string
+	Scopes     []consentScope
+	Fields     map[string]string
+	Email      string
+	Error      string
+}
+
+// HANDLERS
+
+func (cfg *APIConfig) CreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	reqClient := OAuthClientIn{}
+	if err := json.NewDecoder(r.Body).Decode(&reqClient); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	reqClient.Name = strings.TrimSpace(reqClient.Name)
+	if reqClient.Name == "" {
+		respondWithError(w, 400, "Name is required")
+		return
+	}
+	if len(reqClient.RedirectURIs) == 0 || len(reqClient.RedirectURIs) > maxRedirectURIs {
+		respondWithError(w, 400, "Between 1 and 10 redirect URIs are required")
+		return
+	}
+	for _, redirectURI := range reqClient.RedirectURIs {
+		if !validRedirectURI(redirectURI) {
+			respondWithError(w, 400, "Invalid redirect URI: "+redirectURI)
+			return
+		}
+	}
+	scopes, err := auth.ParseScopes(strings.Join(reqClient.Scopes, " "))
+	if err != nil || len(scopes) == 0 {
+		respondWithError(w, 400, "Scopes must be known and not empty")
+		return
+	}
+	redirectURIs, err := json.Marshal(reqClient.RedirectURIs)
+	if err != nil {
+		respondWithError(w, 500, "Could not create client")
+		return
+	}
+	clientID, err := auth.MakeRefreshToken()
+	if err != nil {
+		slog.Error("Error creating client ID", "error", err)
+		respondWithError(w, 500, "Could not create client")
+		return
+	}
+	clientID = clientID[:32]
+	secret, secretHash := "", ""
+	if reqClient.Confidential {
+		secret, err = auth.MakeRefreshToken()
+		if err != nil {
+			slog.Error("Error creating client secret", "error", err)
+			respondWithError(w, 500, "Could not create client")
+			return
+		}
+		secretHash = auth.HashRefreshToken(secret, cfg.RefreshTokenPepper)
+	}
+	client, err := cfg.DBQueries.CreateOAuthClient(r.Context(), db.CreateOAuthClientParams{
+		ID:           clientID,
+		OwnerID:      userID,
+		Name:         reqClient.Name,
+		SecretHash:   secretHash,
+		RedirectUris: redirectURIs,
+		Scopes:       strings.Join(scopes, " "),
+	})
+	if err != nil {
+		slog.Error("Error creating OAuth client", "error", err)
+		respondWithError(w, 500, "Could not create client")
+		return
+	}
+	out := oauthClientOut(client)
+	// the secret is only ever shown here
+	out.ClientSecret = secret
+	respondWithJSON(w, 201, out)
+}
+
+func (cfg *APIConfig) ListOAuthClients(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	clients, err := cfg.DBQueries.ListOAuthClientsByOwner(r.Context(), userID)
+	if err != nil {
+		slog.Error("Error listing OAuth clients", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	out := []OAuthClientOut{}
+	for _, client := range clients {
+		out = append(out, oauthClientOut(client))
+	}
+	respondWithJSON(w, 200, out)
+}
+
+// DeleteOAuthClient removes a client along with every token issued to it
+// and the webhook endpoints it registered.
+func (cfg *APIConfig) DeleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	deleted, err := cfg.DBQueries.DeleteOAuthClient(r.Context(), db.DeleteOAuthClientParams{
+		ID:      r.PathValue("id"),
+		OwnerID: userID,
+	})
+	if err != nil {
+		slog.Error("Error deleting OAuth client", "error", err)
+		respondWithError(w, 500, "Could not delete client")
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, 404, "Client not found")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// OAuthMetadata serves the RFC 8414 authorization server metadata.
+func (cfg *APIConfig) OAuthMetadata(w http.ResponseWriter, r *http.Request) {
+	scopes := []string{}
+	for scope := range auth.ScopeDescriptions {
+		scopes = append(scopes, scope)
+	}
+	respondWithJSON(w, 200, map[string]interface{}{
+		"issuer":                                cfg.BaseURL,
+		"authorization_endpoint":                cfg.BaseURL + "/oauth/authorize",
+		"token_endpoint":                        cfg.BaseURL + "/oauth/token",
+		"introspection_endpoint":                cfg.BaseURL + "/oauth/introspect",
+		"revocation_endpoint":                   cfg.BaseURL + "/oauth/revoke",
+		"jwks_uri":                              cfg.BaseURL + "/.well-known/jwks.json",
+		"scopes_supported":                      scopes,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"authorization_response_iss_parameter_supported": true,
+	})
+}
+
+// Authorize shows the consent screen for an authorization request.
+func (cfg *APIConfig) Authorize(w http.ResponseWriter, r *http.Request) {
+	req, ok := cfg.loadAuthorizeRequest(w, r, r.URL.Query())
+	if !ok { return }
+	renderConsent(w, 200, req, "", "")
+}
+
+// AuthorizeDecision handles the consent form. The user logs in on the form
+// itself, with a one-time code if they have a second factor.
+func (cfg *APIConfig) AuthorizeDecision(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderAuthorizeError(w, 400, "Invalid form")
+		return
+	}
+	req, ok := cfg.loadAuthorizeRequest(w, r, r.PostForm)
+	if !ok { return }
+	if r.PostForm.Get("action") != "approve" {
+		cfg.redirectAuthorizeError(w, r, req, "access_denied", "The user denied the request")
+		return
+	}
+	email := r.PostForm.Get("email")
+	user, problem, err := cfg.authenticateConsent(r, email, r.PostForm.Get("password"), r.PostForm.Get("code"))
+	if err != nil {
+		slog.Error("Error authenticating consent", "error", err)
+		renderAuthorizeError(w, 500, "Something went wrong")
+		return
+	}
+	if problem != "" {
+		renderConsent(w, 401, req, email, problem)
+		return
+	}
+
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		slog.Error("Error creating authorization code", "error", err)
+		renderAuthorizeError(w, 500, "Something went wrong")
+		return
+	}
+	err = cfg.DBQueries.CreateOAuthCode(r.Context(), db.CreateOAuthCodeParams{
+		CodeHash:      auth.HashRefreshToken(code, cfg.RefreshTokenPepper),
+		ClientID:      req.Client.ID,
+		UserID:        user.ID,
+		RedirectUri:   req.RedirectURI,
+		Scope:         strings.Join(req.Scopes, " "),
+		CodeChallenge: req.CodeChallenge,
+		FamilyID:      uuid.New(),
+		ExpiresAt:     time.Now().Add(oauthCodeTTL),
+	})
+	if err != nil {
+		slog.Error("Error saving authorization code", "error", err)
+		renderAuthorizeError(w, 500, "Something went wrong")
+		return
+	}
+	slog.Info("OAuth consent granted", "userID", user.ID, "clientID", req.Client.ID, "scope", req.Scopes)
+	cfg.redirectAuthorize(w, r, req, url.Values{"code": {code}})
+}
+
+// OAuthToken is the token endpoint for the authorization_code and
+// refresh_token grants.
+func (cfg *APIConfig) OAuthToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, 400, "invalid_request", "Invalid form")
+		return
+	}
+	client, ok := cfg.authenticateOAuthClient(w, r)
+	if !ok { return }
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		cfg.exchangeOAuthCode(w, r, client)
+	case "refresh_token":
+		cfg.refreshOAuthToken(w, r, client)
+	default:
+		respondWithOAuthError(w, 400, "unsupported_grant_type", "")
+	}
+}
+
+// IntrospectOAuthToken tells a client whether one of its tokens is
+// active (RFC 7662). Tokens of other clients are reported inactive.
+func (cfg *APIConfig) IntrospectOAuthToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, 400, "invalid_request", "Invalid form")
+		return
+	}
+	client, ok := cfg.authenticateOAuthClient(w, r)
+	if !ok { return }
+	token := r.PostForm.Get("token")
+
+	claims, err := cfg.Validator.Validate(r.Context(), token)
+	if err == nil {
+		if claims.ClientID != client.ID {
+			respondWithJSON(w, 200, IntrospectionOut{})
+			return
+		}
+		// the access token dies with its grant, as Authenticate sees it
+		sessionID, _ := uuid.Parse(claims.SessionID)
+		userID, _ := uuid.Parse(claims.Subject)
+		live, err := cfg.DBQueries.RefreshTokenFamilyActive(r.Context(), db.RefreshTokenFamilyActiveParams{
+			FamilyID: sessionID,
+			UserID:   userID,
+		})
+		if err != nil {
+			slog.Error("Error checking grant", "error", err)
+			respondWithOAuthError(w, 500, "server_error", "")
+			return
+		}
+		if !live {
+			respondWithJSON(w, 200, IntrospectionOut{})
+			return
+		}
+		respondWithJSON(w, 200, IntrospectionOut{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			Subject:   claims.Subject,
+			TokenType: "Bearer",
+			ExpiresAt: claims.ExpiresAt.Unix(),
+			IssuedAt:  claims.IssuedAt.Unix(),
+			Issuer:    claims.Issuer,
+		})
+		return
+	}
+	dbToken, err := cfg.lookupRefreshToken(r, token)
+	if err != nil && err != sql.ErrNoRows {
+		slog.Error("Error getting refresh token", "error", err)
+		respondWithOAuthError(w, 500, "server_error", "")
+		return
+	}
+	if err != nil || dbToken.ClientID.String != client.ID || dbToken.RevokedAt.Valid || time.Now().After(dbToken.ExpiresAt) {
+		respondWithJSON(w, 200, IntrospectionOut{})
+		return
+	}
+	respondWithJSON(w, 200, IntrospectionOut{
+		Active:    true,
+		Scope:     dbToken.Scope,
+		ClientID:  client.ID,
+		Subject:   dbToken.UserID.String(),
+		TokenType: "refresh_token",
+		ExpiresAt: dbToken.ExpiresAt.Unix(),
+		IssuedAt:  dbToken.CreatedAt.Unix(),
+		Issuer:    auth.Issuer,
+	})
+}
+
+// RevokeOAuthToken revokes an access token, or the whole grant for a
+// refresh token (RFC 7009). Unknown tokens are not an error.
+func (cfg *APIConfig) RevokeOAuthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, 400, "invalid_request", "Invalid form")
+		return
+	}
+	client, ok := cfg.authenticateOAuthClient(w, r)
+	if !ok { return }
+	token := r.PostForm.Get("token")
+
+	if claims, err := cfg.Validator.Validate(r.Context(), token); err == nil {
+		if claims.ClientID == client.ID {
+			if err := cfg.revokeAccessToken(r, claims); err != nil {
+				slog.Error("Error revoking access token", "error", err)
+				respondWithOAuthError(w, 503, "temporarily_unavailable", "")
+				return
+			}
+		}
+		w.WriteHeader(200)
+		return
+	}
+	dbToken, err := cfg.lookupRefreshToken(r, token)
+	if err != nil && err != sql.ErrNoRows {
+		slog.Error("Error getting refresh token", "error", err)
+		respondWithOAuthError(w, 503, "temporarily_unavailable", "")
+		return
+	}
+	if err == nil && dbToken.ClientID.String == client.ID {
+		err := cfg.inTx(r.Context(), func(q *db.Queries) error {
+			if err := q.RevokeRefreshTokenFamily(r.Context(), dbToken.FamilyID); err != nil {
+				return err
+			}
+			return deleteEndedGrantWebhooks(r.Context(), q, dbToken.UserID, dbToken.FamilyID)
+		})
+		if err != nil {
+			slog.Error("Error revoking refresh token family", "error", err)
+			respondWithOAuthError(w, 503, "temporarily_unavailable", "")
+			return
+		}
+	}
+	w.WriteHeader(200)
+}
+
+// HELPERS
+
+// loadAuthorizeRequest validates authorization request parameters. An
+// unknown client or redirect URI gets an error page, since redirecting
+// there could hand codes to an attacker; anything else is reported to the
+// client at its redirect URI.
+func (cfg *APIConfig) loadAuthorizeRequest(w http.ResponseWriter, r *http.Request, values url.Values) (authorizeRequest, bool) {
+	req := authorizeRequest{State: values.Get("state")}
+	client, err := cfg.DBQueries.GetOAuthClient(r.Context(), values.Get("client_id"))
+	if err == sql.ErrNoRows {
+		renderAuthorizeError(w, 400, "Unknown application")
+		return req, false
+	}
+	if err != nil {
+		slog.Error("Error getting OAuth client", "error", err)
+		renderAuthorizeError(w, 500, "Something went wrong")
+		return req, false
+	}
+	req.Client = client
+	registered := clientRedirectURIs(client)
+	req.RedirectURI = values.Get("redirect_uri")
+	if req.RedirectURI == "" && len(registered) == 1 {
+		req.RedirectURI = registered[0]
+	}
+	if !contains(registered, req.RedirectURI) {
+		renderAuthorizeError(w, 400, "The redirect URI is not registered for this application")
+		return req, false
+	}
+
+	if values.Get("response_type") != "code" {
+		cfg.redirectAuthorizeError(w, r, req, "unsupported_response_type", "Only the code response type is supported")
+		return req, false
+	}
+	req.CodeChallenge = values.Get("code_challenge")
+	if values.Get("code_challenge_method") != "S256" || !auth.ValidPKCEChallenge(req.CodeChallenge) {
+		cfg.redirectAuthorizeError(w, r, req, "invalid_request", "PKCE with the S256 method is required")
+		return req, false
+	}
+	req.Scopes, err = auth.ParseScopes(values.Get("scope"))
+	if err != nil || len(req.Scopes) == 0 || !auth.ScopesAllowed(req.Scopes, strings.Fields(client.Scopes)) {
+		cfg.redirectAuthorizeError(w, r, req, "invalid_scope", "Request scopes this application registered")
+		return req, false
+	}
+	return req, true
+}
+
+// authenticateConsent checks the credentials typed into the consent form.
+// It returns a message for the user when they are wrong.
+func (cfg *APIConfig) authenticateConsent(r *http.Request, email string, password string, code string) (db.User, string, error) {
+	const wrongCredentials = "Incorrect email or password"
+	if cfg.loginWait(r, email) > 0 {
+		return db.User{}, "Too many failed logins, try again later", nil
+	}
+	user, err := cfg.DBQueries.GetUser(r.Context(), email)
+	if err == sql.ErrNoRows {
+		cfg.recordLoginFailure(r, email)
+		return db.User{}, wrongCredentials, nil
+	}
+	if err != nil {
+		return db.User{}, "", err
+	}
+	if auth.CheckPasswordHash(password, user.HashedPassword) != nil {
+		cfg.recordLoginFailure(r, email)
+		return db.User{}, wrongCredentials, nil
+	}
+	cfg.upgradePasswordHash(r, &user, password)
+	if accountState(user, time.Now()) != userStatusActive {
+		return db.User{}, "This account cannot authorize applications", nil
+	}
+	mfaEnabled, err := cfg.secondFactorEnabled(r, user.ID)
+	if err != nil {
+		return db.User{}, "", err
+	}
+	if mfaEnabled {
+		if code == "" {
+			return db.User{}, "Enter the code from your authenticator app", nil
+		}
+		ok, err := cfg.verifySecondFactor(r, user.ID, MFACodeIn{Code: code})
+		if err != nil {
+			return db.User{}, "", err
+		}
+		if !ok {
+			cfg.recordLoginFailure(r, email)
+			return db.User{}, "Incorrect code", nil
+		}
+	}
+	cfg.recordLoginSuccess(r, email)
+	return user, "", nil
+}
+
+// authenticateOAuthClient identifies the client calling the token,
+// introspection or revocation endpoint, by HTTP Basic auth or form
+// fields. Public clients send only their ID.
+func (cfg *APIConfig) authenticateOAuthClient(w http.ResponseWriter, r *http.Request) (db.OauthClient, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 form-encodes both before Basic encoding
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+	invalid := func() (db.OauthClient, bool) {
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		}
+		respondWithOAuthError(w, 401, "invalid_client", "Client authentication failed")
+		return db.OauthClient{}, false
+	}
+	if clientID == "" {
+		return invalid()
+	}
+	client, err := cfg.DBQueries.GetOAuthClient(r.Context(), clientID)
+	if err == sql.ErrNoRows {
+		return invalid()
+	}
+	if err != nil {
+		slog.Error("Error getting OAuth client", "error", err)
+		respondWithOAuthError(w, 500, "server_error", "")
+		return db.OauthClient{}, false
+	}
+	if client.SecretHash == "" {
+		if secret != "" {
+			return invalid()
+		}
+		return client, true
+	}
+	if !auth.CheckRefreshTokenHash(secret, client.SecretHash, cfg.RefreshTokenPepper) {
+		return invalid()
+	}
+	return client, true
+}
+
+func (cfg *APIConfig) exchangeOAuthCode(w http.ResponseWriter, r *http.Request, client db.OauthClient) {
+	code, err := cfg.DBQueries.GetOAuthCode(r.Context(), auth.HashRefreshToken(r.PostForm.Get("code"), cfg.RefreshTokenPepper))
+	if err == sql.ErrNoRows {
+		respondWithOAuthError(w, 400, "invalid_grant", "Unknown authorization code")
+		return
+	}
+	if err != nil {
+		slog.Error("Error getting authorization code", "error", err)
+		respondWithOAuthError(w, 500, "server_error", "")
+		return
+	}
+	if code.ClientID != client.ID {
+		respondWithOAuthError(w, 400, "invalid_grant", "Code was issued to another client")
+		return
+	}
+	if code.UsedAt.Valid {
+		cfg.handleOAuthCodeReuse(w, r, code)
+		return
+	}
+	if time.Now().After(code.ExpiresAt) {
+		respondWithOAuthError(w, 400, "invalid_grant", "Authorization code expired")
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != code.RedirectUri {
+		respondWithOAuthError(w, 400, "invalid_grant", "Redirect URI does not match the authorization request")
+		return
+	}
+	if !auth.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		respondWithOAuthError(w, 400, "invalid_grant", "Code verifier does not match")
+		return
+	}
+	consumed, err := cfg.DBQueries.ConsumeOAuthCode(r.Context(), code.CodeHash)
+	if err != nil {
+		slog.Error("Error consuming authorization code", "error", err)
+		respondWithOAuthError(w, 500, "server_error", "")
+		return
+	}
+	if consumed == 0 {
+		// another request redeemed it between our read and write
+		cfg.handleOAuthCodeReuse(w, r, code)
+		return
+	}
+	user, err := cfg.DBQueries.GetUserByID(r.Context(), code.UserID)
+	if err != nil {
+		slog.Error("Error getting user", "error", err)
+		respondWithOAuthError(w, 500, "server_error", "")
+		return
+	}
+	if accountState(user, time.Now()) != userStatusActive {
+		respondWithOAuthError(w, 400, "invalid_grant", "Account is not active")
+		return
+	}
+	cfg.issueOAuthTokens(w, r, client, user, code.FamilyID, code.Scope, nil)
+}
+
+func (cfg *APIConfig) refreshOAuthToken(w http.ResponseWriter, r *http.Request, client db.OauthClient) {
+	dbToken, err := cfg.lookupRefreshToken(r, r.PostForm.Get("refresh_token"))
+	if err == nil && dbToken.ClientID.String != client.ID {
+		err = sql.ErrNoRows
+	}
+	if err == sql.ErrNoRows {
+		respondWithOAuthError(w, 400, "invalid_grant", "Unknown refresh token")
+		return
+	}
+	if err != nil {
+		slog.Error("Error getting refresh token", "error", err)
+		respondWithOAuthError(w, 500, "server_error", "")
+		return
+	}
+	if dbToken.RevokedAt.Valid && dbToken.RevokedReason != refreshTokenRotated {
+		respondWithOAuthError(w, 400, "invalid_grant", "Refresh token was revoked")
+		return
+	}
+	if dbToken.RevokedAt.Valid {
+		if err := cfg.revokeReusedRefreshToken(r, dbToken); err != nil {
+			slog.Error("Error revoking refresh token family", "error", err)
+			respondWithOAuthError(w, 500, "server_error", "")
+			return
+		}
+		respondWithOAuthError(w, 400, "invalid_grant", "Refresh token was already used")
+		return
+	}
+	if time.Now().After(dbToken.ExpiresAt) {
+		respondWithOAuthError(w, 400, "invalid_grant", "Refresh token expired")
+		return
+	}
+	user, err := cfg.DBQueries.GetUserByID(r.Context(), dbToken.UserID)
+	if err != nil {
+		slog.Error("Error getting user", "error", err)
+		respondWithOAuthError(w, 500, "server_error", "")
+		return
+	}
+	if accountState(user, time.Now()) != userStatusActive {
+		respondWithOAuthError(w, 400, "invalid_grant", "Account is not active")
+		return
+	}
+	cfg.issueOAuthTokens(w, r, client, user, dbToken.FamilyID, dbToken.Scope, &dbToken)
+}
+
+// issueOAuthTokens responds with a scoped access token and a refresh token
+// in the grant's family. When refreshing, parent is the presented refresh
+// token; it is rotated in the same transaction that creates its successor.
+func (cfg *APIConfig) issueOAuthTokens(
+	w http.ResponseWriter,
+	r *http.Request,
+	client db.OauthClient,
+	user db.User,
+	familyID uuid.UUID,
+	scope string,
+	parent *db.RefreshToken,
+) {
+	arg := db.CreateRefreshTokenParams{
+		UserID:   user.ID,
+		FamilyID: familyID,
+		ClientID: sql.NullString{String: client.ID, Valid: true},
+		Scope:    scope,
+	}
+	var refreshToken string
+	err := cfg.inTx(r.Context(), func(q *db.Queries) error {
+		var err error
+		if parent == nil {
+			refreshToken, err = createRefreshToken(r, q, cfg.RefreshTokenPepper, arg)
+		} else {
+			refreshToken, err = rotateRefreshToken(r, q, cfg.RefreshTokenPepper, *parent, arg)
+		}
+		return err
+	})
+	if err == errRefreshTokenRotated {
+		if err := cfg.revokeReusedRefreshToken(r, *parent); err != nil {
+			slog.Error("Error revoking refresh token family", "error", err)
+		}
+		respondWithOAuthError(w, 400, "invalid_grant", "Refresh token was already used")
+		return
+	}
+	if err != nil {
+		slog.Error("Error creating refresh token", "error", err)
+		respondWithOAuthError(w, 500, "server_error", "")
+		return
+	}
+	accessToken, err := auth.MakeOAuthJWT(cfg.Keys, user.ID, user.Role, familyID, client.ID, scope, oauthAccessTokenTTL)
+	if err != nil {
+		slog.Error("Error creating token", "error", err)
+		respondWithOAuthError(w, 500, "server_error", "")
+		return
+	}
+	respondWithJSON(w, 200, OAuthTokenOut{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        scope,
+	})
+}
+
+// handleOAuthCodeReuse revokes the tokens a replayed authorization code
+// was exchanged for, as the code may have been stolen.
+func (cfg *APIConfig) handleOAuthCodeReuse(w http.ResponseWriter, r *http.Request, code db.OauthAuthorizationCode) {
+	slog.Warn("Authorization code reuse detected", "userID", code.UserID, "clientID", code.ClientID)
+	if err := cfg.DBQueries.RevokeRefreshTokenFamily(r.Context(), code.FamilyID); err != nil {
+		slog.Error("Error revoking refresh token family", "error", err, "familyID", code.FamilyID)
+		respondWithOAuthError(w, 500, "server_error", "")
+		return
+	}
+	cfg.recordSecurityEvent(r, code.UserID, securityEventOAuthCodeReuse, map[string]interface{}{
+		"client_id":  code.ClientID,
+		"family_id":  code.FamilyID,
+		"user_agent": r.UserAgent(),
+		"remote":     r.RemoteAddr,
+	})
+	respondWithOAuthError(w, 400, "invalid_grant", "Authorization code was already used")
+}
+
+func (cfg *APIConfig) redirectAuthorize(w http.ResponseWriter, r *http.Request, req authorizeRequest, params url.Values) {
+	target, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		renderAuthorizeError(w, 500, "Invalid redirect URI")
+		return
+	}
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	// RFC 9207 lets clients check who answered
+	query.Set("iss", cfg.BaseURL)
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+}
+
+func (cfg *APIConfig) redirectAuthorizeError(w http.ResponseWriter, r *http.Request, req authorizeRequest, code string, description string) {
+	cfg.redirectAuthorize(w, r, req, url.Values{
+		"error":             {code},
+		"error_description": {description},
+	})
+}
+
+func renderConsent(w http.ResponseWriter, status int, req authorizeRequest, email string, problem string) {
+	scopes := []consentScope{}
+	for _, scope := range req.Scopes {
+		scopes = append(scopes, consentScope{Name: scope, Description: auth.ScopeDescriptions[scope]})
+	}
+	renderAuthorizePage(w, status, consentPage{
+		ClientName: req.Client.Name,
+		Scopes:     scopes,
+		Fields: map[string]string{
+			"response_type":         "code",
+			"client_id":             req.Client.ID,
+			"redirect_uri":          req.RedirectURI,
+			"scope":                 strings.Join(req.Scopes, " "),
+			"state":                 req.State,
+			"code_challenge":        req.CodeChallenge,
+			"code_challenge_method": "S256",
+		},
+		Email: email,
+		Error: problem,
+	})
+}
+
+func renderAuthorizeError(w http.ResponseWriter, status int, msg string) {
+	renderAuthorizePage(w, status, consentPage{Error: msg})
+}
+
+func renderAuthorizePage(w http.ResponseWriter, status int, page consentPage) {
+	templ, err := template.ParseFiles("templates/oauth_authorize.html")
+	if err != nil {
+		http.Error(w, "Template not found", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// the consent screen must not be framed, or it could be clickjacked
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(status)
+	if err := templ.Execute(w, page); err != nil {
+		slog.Error("Error rendering consent page", "error", err)
+	}
+}
+
+// respondWithOAuthError writes an RFC 6749 error response.
+func respondWithOAuthError(w http.ResponseWriter, status int, code string, description string) {
+	body := map[string]string{"error": code}
+	if description != "" {
+		body["error_description"] = description
+	}
+	respondWithJSON(w, status, body)
+}
+
+func oauthClientOut(client db.OauthClient) OAuthClientOut {
+	return OAuthClientOut{
+		ClientID:     client.ID,
+		Name:         client.Name,
+		RedirectURIs: clientRedirectURIs(client),
+		Scopes:       strings.Fields(client.Scopes),
+		Confidential: client.SecretHash != "",
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+func clientRedirectURIs(client db.OauthClient) []string {
+	uris := []string{}
+	if err := json.Unmarshal(client.RedirectUris, &uris); err != nil {
+		slog.Error("Error decoding redirect URIs", "error", err, "clientID", client.ID)
+	}
+	return uris
+}
+
+// validRedirectURI accepts https URIs, http on the loopback interface and
+// private-use schemes like com.example.app:/callback for native apps
+// (RFC 8252). Fragments are never allowed.
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Fragment != "" || strings.Contains(raw, "#") {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return strings.Contains(u.Scheme, ".")
+	}
+}
+
+func contains(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
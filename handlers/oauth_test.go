@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+)
+
+func TestValidRedirectURI(t *testing.T) {
+	testCases := []struct {
+		uri   string
+		valid bool
+	}{
+		{"https://app.example.com/callback", true},
+		{"http://localhost:3000/callback", true},
+		{"http://127.0.0.1/callback", true},
+		{"com.example.app:/callback", true},
+		{"http://app.example.com/callback", false}, // plain http off loopback
+		{"https://app.example.com/callback#frag", false},
+		{"https:///callback", false},
+		{"javascript:alert(1)", false},
+		{"/relative", false},
+	}
+	for _, testCase := range testCases {
+		if valid := validRedirectURI(testCase.uri); valid != testCase.valid {
+			t.Errorf("validRedirectURI(%q): expected %v, got %v", testCase.uri, testCase.valid, valid)
+		}
+	}
+}
+
+func TestCheckScopes(t *testing.T) {
+	firstParty := &auth.Claims{}
+	thirdParty := &auth.Claims{ClientID: "client-1", Scope: "profile chirps:read"}
+	personalToken := &auth.Claims{PersonalTokenID: "token-1", Scope: "chirps:write"}
+	testCases := []struct {
+		name    string
+		claims  *auth.Claims
+		scopes  []string
+		allowed bool
+	}{
+		{"first party, unscoped route", firstParty, nil, true},
+		{"first party, scoped route", firstParty, []string{auth.ScopeChirpsWrite}, true},
+		{"third party, unscoped route", thirdParty, nil, false},
+		{"third party, granted scope", thirdParty, []string{auth.ScopeProfile}, true},
+		{"third party, missing scope", thirdParty, []string{auth.ScopeChirpsWrite}, false},
+		{"third party, one of two scopes", thirdParty, []string{auth.ScopeProfile, auth.ScopeDM}, false},
+		{"personal token, unscoped route", personalToken, nil, false},
+		{"personal token, granted scope", personalToken, []string{auth.ScopeChirpsWrite}, true},
+		{"personal token, missing scope", personalToken, []string{auth.ScopeProfile}, false},
+	}
+	for _, testCase := range testCases {
+		w := httptest.NewRecorder()
+		allowed := checkScopes(w, testCase.claims, testCase.scopes)
+		if allowed != testCase.allowed {
+			t.Errorf("%s: expected %v, got %v", testCase.name, testCase.allowed, allowed)
+		}
+		if !allowed && w.Code != 403 {
+			t.Errorf("%s: expected 403, got %d", testCase.name, w.Code)
+		}
+	}
+}
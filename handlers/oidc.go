@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/oidc"
+)
+
+const (
+	oidcStateTTL = 10 * time.Minute
+	// oidcStateCookie holds the state so that only the browser that
+	// started a login can finish it.
+	oidcStateCookie = "chirpy_oidc_state"
+)
+
+var errOIDCEmailUnverified = errors.New("provider did not verify the email")
+
+type OIDCCallbackIn struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// HANDLERS
+
+// BeginOIDCLogin sends the browser to the provider's login page.
+func (cfg *APIConfig) BeginOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider := r.PathValue("provider")
+	client, ok := cfg.OIDC[provider]
+	if !ok {
+		respondWithError(w, 404, "Unknown identity provider")
+		return
+	}
+	state, err1 := auth.MakeRefreshToken()
+	nonce, err2 := auth.MakeRefreshToken()
+	verifier, err3 := auth.MakeRefreshToken()
+	if err := errors.Join(err1, err2, err3); err != nil {
+		slog.Error("Error creating OIDC state", "error", err)
+		respondWithError(w, 500, "Could not start login")
+		return
+	}
+	target, err := client.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		slog.Error("Error building OIDC auth URL", "error", err, "provider", provider)
+		respondWithError(w, 502, "Identity provider is unavailable")
+		return
+	}
+	err = cfg.DBQueries.CreateOIDCLoginState(r.Context(), db.CreateOIDCLoginStateParams{
+		StateHash:    auth.HashRefreshToken(state, cfg.RefreshTokenPepper),
+		Provider:     provider,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	})
+	if err == nil {
+		err = cfg.DBQueries.DeleteExpiredOIDCLoginStates(r.Context())
+	}
+	if err != nil {
+		slog.Error("Error saving OIDC state", "error", err)
+		respondWithError(w, 500, "Could not start login")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/login/oidc",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.BaseURL, "https://"),
+		// Lax, because the browser arrives back from the provider's site
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// FinishOIDCLogin takes the code and state the provider sent back to the
+// web app and returns the same response as Login. Users are found by
+// their provider identity, then by verified email, and created otherwise.
+func (cfg *APIConfig) FinishOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	reqCallback := OIDCCallbackIn{}
+	if err := json.NewDecoder(r.Body).Decode(&reqCallback); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(reqCallback.State)) != 1 {
+		respondWithErrorCode(w, 400, "wrong_browser", "Finish logging in in the browser where you started")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   oidcStateCookie,
+		Path:   "/api/login/oidc",
+		MaxAge: -1,
+	})
+	state, err := cfg.DBQueries.ConsumeOIDCLoginState(r.Context(), auth.HashRefreshToken(reqCallback.State, cfg.RefreshTokenPepper))
+	if err == sql.ErrNoRows {
+		respondWithErrorCode(w, 400, "invalid_state", "Login expired, please start again")
+		return
+	}
+	if err != nil {
+		slog.Error("Error consuming OIDC state", "error", err)
+		respondWithError(w, 500, "Could not log in")
+		return
+	}
+	client, ok := cfg.OIDC[state.Provider]
+	if !ok {
+		respondWithError(w, 404, "Unknown identity provider")
+		return
+	}
+	tokens, err := client.Exchange(r.Context(), reqCallback.Code, state.CodeVerifier)
+	if err != nil {
+		slog.Info("OIDC code exchange failed", "error", err, "provider", state.Provider)
+		respondWithErrorCode(w, 401, "oidc_failed", "The identity provider rejected the login")
+		return
+	}
+	idToken, err := client.Verify(r.Context(), tokens.IDToken, state.Nonce)
+	if err != nil {
+		slog.Warn("Rejected OIDC ID token", "error", err, "provider", state.Provider)
+		respondWithErrorCode(w, 401, "oidc_failed", "The identity provider's response was invalid")
+		return
+	}
+
+	user, err := cfg.oidcUser(r.Context(), state.Provider, idToken)
+	if err == errOIDCEmailUnverified {
+		respondWithErrorCode(w, 403, "email_not_verified", "Your identity provider has not verified your email")
+		return
+	}
+	if err != nil {
+		slog.Error("Error finding OIDC user", "error", err, "provider", state.Provider)
+		respondWithError(w, 500, "Could not log in")
+		return
+	}
+	if !checkAccountActive(w, user) { return }
+
+	mfaEnabled, err := cfg.secondFactorEnabled(r, user.ID)
+	if err != nil {
+		slog.Error("Error checking two-factor authentication", "error", err)
+		respondWithError(w, 500, "Could not log in")
+		return
+	}
+	if mfaEnabled {
+		cfg.startMFAChallenge(w, r, user)
+		return
+	}
+	cfg.startSession(w, r, user, time.Time{})
+}
+
+// HELPERS
+
+// oidcUser finds or creates the user for a provider identity. An unknown
+// identity is linked to the account with the same email, but only if the
+// provider verified that email.
+func (cfg *APIConfig) oidcUser(ctx context.Context, provider string, idToken *oidc.IDToken) (db.User, error) {
+	identity, err := cfg.DBQueries.GetOIDCIdentity(ctx, db.GetOIDCIdentityParams{
+		Provider: provider,
+		Subject:  idToken.Subject,
+	})
+	if err == nil {
+		err = cfg.DBQueries.TouchOIDCIdentity(ctx, db.TouchOIDCIdentityParams{
+			Provider: provider,
+			Subject:  idToken.Subject,
+			Email:    idToken.Email,
+		})
+		if err != nil {
+			return db.User{}, err
+		}
+		return cfg.DBQueries.GetUserByID(ctx, identity.UserID)
+	}
+	if err != sql.ErrNoRows {
+		return db.User{}, err
+	}
+	if !idToken.EmailVerified || !strings.Contains(idToken.Email, "@") {
+		return db.User{}, errOIDCEmailUnverified
+	}
+
+	var user db.User
+	err = cfg.inTx(ctx, func(q *db.Queries) error {
+		user, err = q.GetUser(ctx, idToken.Email)
+		if err == sql.ErrNoRows {
+			user, err = cfg.createOIDCUser(ctx, q, idToken.Email)
+		} else if err == nil && !user.VerifiedAt.Valid {
+			err = cfg.claimUnverifiedAccount(ctx, q, user)
+		}
+		if err != nil {
+			return err
+		}
+		slog.Info("Linking OIDC identity", "userID", user.ID, "provider", provider)
+		return q.CreateOIDCIdentity(ctx, db.CreateOIDCIdentityParams{
+			Provider: provider,
+			Subject:  idToken.Subject,
+			UserID:   user.ID,
+			Email:    idToken.Email,
+		})
+	})
+	if err != nil {
+		return db.User{}, err
+	}
+	user.VerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	return user, nil
+}
+
+// createOIDCUser creates a verified account with an unguessable password;
+// the user can set a real one through the password reset flow.
+func (cfg *APIConfig) createOIDCUser(ctx context.Context, q *db.Queries, email string) (db.User, error) {
+	password, err := auth.MakeRefreshToken()
+	if err != nil {
+		return db.User{}, err
+	}
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		return db.User{}, err
+	}
+	user, err := q.CreateUser(ctx, db.CreateUserParams{
+		Email:          email,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		return db.User{}, err
+	}
+	return user, q.VerifyUserEmail(ctx, user.ID)
+}
+
+// claimUnverifiedAccount hands an account nobody proved they own to the
+// provider's verified user. Whoever signed up with the address may have
+// been someone else, so their password and sessions stop working.
+func (cfg *APIConfig) claimUnverifiedAccount(ctx context.Context, q *db.Queries, user db.User) error {
+	password, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	err = q.ResetUserPassword(ctx, db.ResetUserPasswordParams{
+		ID:             user.ID,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		return err
+	}
+	if err := q.DeleteUserPersonalAccessTokens(ctx, user.ID); err != nil {
+		return err
+	}
+	if err := q.RevokeUserRefreshTokens(ctx, user.ID); err != nil {
+		return err
+	}
+	return q.VerifyUserEmail(ctx, user.ID)
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFinishOIDCLoginChecksBrowser(t *testing.T) {
+	testCases := []struct {
+		name   string
+		cookie string
+	}{
+		{"no cookie", ""},
+		{"other login's state", "state-b"},
+	}
+	cfg := &APIConfig{}
+	for _, testCase := range testCases {
+		req := httptest.NewRequest("POST", "/api/login/oidc/callback", strings.NewReader(`{"code":"c","state":"state-a"}`))
+		if testCase.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: testCase.cookie})
+		}
+		w := httptest.NewRecorder()
+		cfg.FinishOIDCLogin(w, req)
+		if w.Code != 400 || !strings.Contains(w.Body.String(), "wrong_browser") {
+			t.Errorf("%s: expected 400 wrong_browser, got %d %s", testCase.name, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestBeginOIDCLoginUnknownProvider(t *testing.T) {
+	cfg := &APIConfig{}
+	req := httptest.NewRequest("GET", "/api/login/oidc/nope", nil)
+	req.SetPathValue("provider", "nope")
+	w := httptest.NewRecorder()
+	cfg.BeginOIDCLogin(w, req)
+	if w.Code != 404 {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,458 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/webauthn"
+	"github.com/google/uuid"
+)
+
+const (
+	ceremonyRegister = "register"
+	ceremonyLogin    = "login"
+	ceremonyMFA      = "mfa"
+
+	webauthnChallengeTTL = 5 * time.Minute
+)
+
+type PasskeyOut struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// PasskeyBeginOut carries the options for the browser's WebAuthn call and
+// the ceremony ID to send back with its result.
+type PasskeyBeginOut struct {
+	CeremonyID string      `json:"ceremony_id"`
+	PublicKey  interface{} `json:"publicKey"`
+}
+
+type PasskeyRegisterIn struct {
+	CeremonyID string                        `json:"ceremony_id"`
+	Name       string                        `json:"name"`
+	Credential webauthn.RegistrationResponse `json:"credential"`
+}
+
+type PasskeyLoginBeginIn struct {
+	Email string `json:"email"`
+}
+
+type PasskeyLoginIn struct {
+	CeremonyID string                     `json:"ceremony_id"`
+	Credential webauthn.AssertionResponse `json:"credential"`
+}
+
+// HANDLERS
+
+func (cfg *APIConfig) BeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	// a new passkey is a new way in, so it needs the same proof as
+	// changing the password
+	if !cfg.requireRecentMFA(w, r) {
+		return
+	}
+	user, err := cfg.DBQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		slog.Error("Error getting user", "error", err)
+		respondWithError(w, 500, "Could not get user")
+		return
+	}
+	existing, err := cfg.passkeyIDs(r, userID)
+	if err != nil {
+		slog.Error("Error listing passkeys", "error", err)
+		respondWithError(w, 500, "Could not start registration")
+		return
+	}
+	challenge, ok := cfg.startCeremony(w, r, ceremonyRegister, userID)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, 200, PasskeyBeginOut{
+		CeremonyID: challenge.ID.String(),
+		PublicKey:  cfg.WebAuthn.BeginRegistration(challenge.Challenge, userID[:], user.Email, existing),
+	})
+}
+
+func (cfg *APIConfig) FinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	reqRegister := PasskeyRegisterIn{}
+	if err := json.NewDecoder(r.Body).Decode(&reqRegister); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	challenge, ok := cfg.finishCeremony(w, r, reqRegister.CeremonyID, ceremonyRegister)
+	if !ok {
+		return
+	}
+	if challenge.UserID.UUID != userID {
+		respondWithError(w, 400, "Invalid or expired ceremony")
+		return
+	}
+	cred, err := cfg.WebAuthn.FinishRegistration(challenge.Challenge, reqRegister.Credential)
+	if err != nil {
+		slog.Info("Rejected passkey registration", "error", err, "userID", userID)
+		respondWithErrorCode(w, 400, "invalid_passkey", "Passkey registration failed")
+		return
+	}
+	if _, err := cfg.DBQueries.GetWebAuthnCredential(r.Context(), cred.ID); err != sql.ErrNoRows {
+		if err != nil {
+			slog.Error("Error getting passkey", "error", err)
+			respondWithError(w, 500, "Could not save passkey")
+			return
+		}
+		respondWithError(w, 409, "Passkey is already registered")
+		return
+	}
+	name := reqRegister.Name
+	if name == "" {
+		name = "Passkey"
+	}
+	dbCred, err := cfg.DBQueries.CreateWebAuthnCredential(r.Context(), db.CreateWebAuthnCredentialParams{
+		CredentialID: cred.ID,
+		UserID:       userID,
+		Name:         name,
+		PublicKey:    cred.PublicKey,
+		Algorithm:    int32(cred.Algorithm),
+		SignCount:    int64(cred.SignCount),
+		Aaguid:       cred.AAGUID,
+	})
+	if err != nil {
+		slog.Error("Error saving passkey", "error", err)
+		respondWithError(w, 500, "Could not save passkey")
+		return
+	}
+	respondWithJSON(w, 201, toPasskeyOut(dbCred))
+}
+
+func (cfg *APIConfig) ListPasskeys(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	creds, err := cfg.DBQueries.ListWebAuthnCredentialsByUser(r.Context(), userID)
+	if err != nil {
+		slog.Error("Error listing passkeys", "error", err)
+		respondWithError(w, 500, "Could not list passkeys")
+		return
+	}
+	passkeys := []PasskeyOut{}
+	for _, cred := range creds {
+		passkeys = append(passkeys, toPasskeyOut(cred))
+	}
+	respondWithJSON(w, 200, passkeys)
+}
+
+func (cfg *APIConfig) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	credID, err := base64.RawURLEncoding.DecodeString(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid passkey ID")
+		return
+	}
+	if !cfg.requireRecentMFA(w, r) {
+		return
+	}
+	deleted, err := cfg.DBQueries.DeleteWebAuthnCredential(r.Context(), db.DeleteWebAuthnCredentialParams{
+		CredentialID: credID,
+		UserID:       userID,
+	})
+	if err != nil {
+		slog.Error("Error deleting passkey", "error", err)
+		respondWithError(w, 500, "Could not delete passkey")
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, 404, "Passkey not found")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// BeginPasskeyLogin starts a passwordless login. Without an email any
+// discoverable passkey may answer. An unknown email gets the same answer
+// as a known one, so the endpoint does not reveal who has an account.
+func (cfg *APIConfig) BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	reqBegin := PasskeyLoginBeginIn{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&reqBegin); err != nil {
+			respondWithError(w, 400, "Invalid request body")
+			return
+		}
+	}
+	var allow [][]byte
+	if reqBegin.Email != "" {
+		user, err := cfg.DBQueries.GetUser(r.Context(), reqBegin.Email)
+		if err != nil && err != sql.ErrNoRows {
+			slog.Error("Error getting user", "error", err)
+			respondWithError(w, 500, "Could not start login")
+			return
+		}
+		if err == nil {
+			allow, err = cfg.passkeyIDs(r, user.ID)
+			if err != nil {
+				slog.Error("Error listing passkeys", "error", err)
+				respondWithError(w, 500, "Could not start login")
+				return
+			}
+		}
+	}
+	challenge, ok := cfg.startCeremony(w, r, ceremonyLogin, uuid.Nil)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, 200, PasskeyBeginOut{
+		CeremonyID: challenge.ID.String(),
+		PublicKey:  cfg.WebAuthn.BeginLogin(challenge.Challenge, allow),
+	})
+}
+
+// FinishPasskeyLogin logs in with a passkey instead of a password. A
+// passkey that verified the user (PIN or biometric) is two factors on its
+// own; otherwise users with a second factor still go through LoginMFA.
+func (cfg *APIConfig) FinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	reqLogin := PasskeyLoginIn{}
+	if err := json.NewDecoder(r.Body).Decode(&reqLogin); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	challenge, ok := cfg.finishCeremony(w, r, reqLogin.CeremonyID, ceremonyLogin)
+	if !ok {
+		return
+	}
+	cred, assertion, ok, err := cfg.verifyPasskey(r, challenge, reqLogin.Credential)
+	if err != nil {
+		slog.Error("Error verifying passkey", "error", err)
+		respondWithError(w, 500, "Could not log in")
+		return
+	}
+	if !ok {
+		respondWithErrorCode(w, 401, "invalid_passkey", "Passkey login failed")
+		return
+	}
+	user, err := cfg.DBQueries.GetUserByID(r.Context(), cred.UserID)
+	if err != nil {
+		slog.Error("Error getting user", "error", err)
+		respondWithError(w, 500, "Could not get user")
+		return
+	}
+	if !checkAccountActive(w, user) { return }
+
+	if assertion.UserVerified {
+		cfg.startSession(w, r, user, time.Now().UTC())
+		return
+	}
+	mfaEnabled, err := cfg.secondFactorEnabled(r, user.ID)
+	if err != nil {
+		slog.Error("Error checking two-factor authentication", "error", err)
+		respondWithError(w, 500, "Could not log in")
+		return
+	}
+	if mfaEnabled {
+		cfg.startMFAChallenge(w, r, user)
+		return
+	}
+	cfg.startSession(w, r, user, time.Time{})
+}
+
+// BeginPasskeyMFA starts a passkey ceremony to step up a logged-in user.
+// The result goes to VerifyMFA.
+func (cfg *APIConfig) BeginPasskeyMFA(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	cfg.beginPasskeyMFA(w, r, userID)
+}
+
+// BeginPasskeyLoginMFA starts a passkey ceremony for the second login
+// step. The result goes to LoginMFA.
+func (cfg *APIConfig) BeginPasskeyLoginMFA(w http.ResponseWriter, r *http.Request) {
+	reqLogin := MFALoginIn{}
+	if err := json.NewDecoder(r.Body).Decode(&reqLogin); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	challenge, ok := cfg.attemptMFAChallenge(w, r, reqLogin.MFAToken)
+	if !ok {
+		return
+	}
+	cfg.beginPasskeyMFA(w, r, challenge.UserID)
+}
+
+// HELPERS
+
+func (cfg *APIConfig) beginPasskeyMFA(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	allow, err := cfg.passkeyIDs(r, userID)
+	if err != nil {
+		slog.Error("Error listing passkeys", "error", err)
+		respondWithError(w, 500, "Could not start verification")
+		return
+	}
+	if len(allow) == 0 {
+		respondWithError(w, 404, "No passkeys registered")
+		return
+	}
+	challenge, ok := cfg.startCeremony(w, r, ceremonyMFA, userID)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, 200, PasskeyBeginOut{
+		CeremonyID: challenge.ID.String(),
+		PublicKey:  cfg.WebAuthn.BeginLogin(challenge.Challenge, allow),
+	})
+}
+
+// verifyPasskeyMFA checks a passkey assertion made for userID in a
+// ceremonyMFA ceremony.
+func (cfg *APIConfig) verifyPasskeyMFA(r *http.Request, userID uuid.UUID, ceremonyID string, resp webauthn.AssertionResponse) (bool, error) {
+	id, err := uuid.Parse(ceremonyID)
+	if err != nil {
+		return false, nil
+	}
+	challenge, err := cfg.DBQueries.ConsumeWebAuthnChallenge(r.Context(), db.ConsumeWebAuthnChallengeParams{
+		ID:       id,
+		Ceremony: ceremonyMFA,
+	})
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if challenge.UserID.UUID != userID {
+		return false, nil
+	}
+	_, _, ok, err := cfg.verifyPasskey(r, challenge, resp)
+	return ok, err
+}
+
+// verifyPasskey checks an assertion against the stored credential and
+// records its new sign count. ok is false when the assertion is invalid.
+func (cfg *APIConfig) verifyPasskey(
+	r *http.Request,
+	challenge db.WebauthnChallenge,
+	resp webauthn.AssertionResponse,
+) (db.WebauthnCredential, webauthn.Assertion, bool, error) {
+	cred, err := cfg.DBQueries.GetWebAuthnCredential(r.Context(), resp.RawID)
+	if err == sql.ErrNoRows {
+		return db.WebauthnCredential{}, webauthn.Assertion{}, false, nil
+	}
+	if err != nil {
+		return db.WebauthnCredential{}, webauthn.Assertion{}, false, err
+	}
+	if challenge.UserID.Valid && challenge.UserID.UUID != cred.UserID {
+		return db.WebauthnCredential{}, webauthn.Assertion{}, false, nil
+	}
+	assertion, err := cfg.WebAuthn.FinishLogin(challenge.Challenge, webauthn.Credential{
+		ID:        cred.CredentialID,
+		PublicKey: cred.PublicKey,
+		Algorithm: int64(cred.Algorithm),
+		SignCount: uint32(cred.SignCount),
+	}, resp)
+	if errors.Is(err, webauthn.ErrSignCount) {
+		cfg.recordPasskeyClone(r, cred)
+	}
+	if err != nil {
+		slog.Info("Rejected passkey assertion", "error", err, "userID", cred.UserID)
+		return db.WebauthnCredential{}, webauthn.Assertion{}, false, nil
+	}
+	updated, err := cfg.DBQueries.UpdateWebAuthnSignCount(r.Context(), db.UpdateWebAuthnSignCountParams{
+		SignCount:    int64(assertion.SignCount),
+		CredentialID: cred.CredentialID,
+	})
+	if err != nil {
+		return db.WebauthnCredential{}, webauthn.Assertion{}, false, err
+	}
+	if updated == 0 {
+		// another login with a higher count landed first
+		cfg.recordPasskeyClone(r, cred)
+		return db.WebauthnCredential{}, webauthn.Assertion{}, false, nil
+	}
+	return cred, assertion, true, nil
+}
+
+// recordPasskeyClone notes a sign count that did not increase: two
+// authenticators may hold the same key.
+func (cfg *APIConfig) recordPasskeyClone(r *http.Request, cred db.WebauthnCredential) {
+	slog.Warn("Passkey sign count did not increase", "userID", cred.UserID)
+	cfg.recordSecurityEvent(r, cred.UserID, securityEventPasskeyClone, map[string]interface{}{
+		"credential_id": base64.RawURLEncoding.EncodeToString(cred.CredentialID),
+		"stored_count":  cred.SignCount,
+		"user_agent":    r.UserAgent(),
+		"remote":        r.RemoteAddr,
+	})
+}
+
+// startCeremony stores a fresh challenge for ceremony. userID is Nil for
+// logins where we do not know the user yet.
+func (cfg *APIConfig) startCeremony(w http.ResponseWriter, r *http.Request, ceremony string, userID uuid.UUID) (db.WebauthnChallenge, bool) {
+	raw, err := webauthn.NewChallenge()
+	if err != nil {
+		slog.Error("Error creating challenge", "error", err)
+		respondWithError(w, 500, "Could not start passkey ceremony")
+		return db.WebauthnChallenge{}, false
+	}
+	challenge, err := cfg.DBQueries.CreateWebAuthnChallenge(r.Context(), db.CreateWebAuthnChallengeParams{
+		ExpiresAt: time.Now().Add(webauthnChallengeTTL),
+		UserID:    uuid.NullUUID{UUID: userID, Valid: userID != uuid.Nil},
+		Ceremony:  ceremony,
+		Challenge: raw,
+	})
+	if err != nil {
+		slog.Error("Error saving challenge", "error", err)
+		respondWithError(w, 500, "Could not start passkey ceremony")
+		return db.WebauthnChallenge{}, false
+	}
+	return challenge, true
+}
+
+// finishCeremony takes the challenge of a ceremony out of storage, so it
+// can only be answered once.
+func (cfg *APIConfig) finishCeremony(w http.ResponseWriter, r *http.Request, ceremonyID string, ceremony string) (db.WebauthnChallenge, bool) {
+	id, err := uuid.Parse(ceremonyID)
+	if err != nil {
+		respondWithError(w, 400, "Invalid or expired ceremony")
+		return db.WebauthnChallenge{}, false
+	}
+	challenge, err := cfg.DBQueries.ConsumeWebAuthnChallenge(r.Context(), db.ConsumeWebAuthnChallengeParams{
+		ID:       id,
+		Ceremony: ceremony,
+	})
+	if err == sql.ErrNoRows {
+		respondWithError(w, 400, "Invalid or expired ceremony")
+		return db.WebauthnChallenge{}, false
+	}
+	if err != nil {
+		slog.Error("Error getting challenge", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return db.WebauthnChallenge{}, false
+	}
+	return challenge, true
+}
+
+func (cfg *APIConfig) passkeyIDs(r *http.Request, userID uuid.UUID) ([][]byte, error) {
+	creds, err := cfg.DBQueries.ListWebAuthnCredentialsByUser(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	ids := [][]byte{}
+	for _, cred := range creds {
+		ids = append(ids, cred.CredentialID)
+	}
+	return ids, nil
+}
+
+func toPasskeyOut(cred db.WebauthnCredential) PasskeyOut {
+	out := PasskeyOut{
+		ID:        base64.RawURLEncoding.EncodeToString(cred.CredentialID),
+		Name:      cred.Name,
+		CreatedAt: cred.CreatedAt,
+	}
+	if cred.LastUsedAt.Valid {
+		out.LastUsedAt = &cred.LastUsedAt.Time
+	}
+	return out
+}
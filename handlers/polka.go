@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/billing"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/webhook"
+	"github.com/google/uuid"
+)
+
+const (
+	polkaSignatureHeader = "Polka-Signature"
+	maxPolkaBodyBytes    = 64 << 10
+
+	polkaOutcomeProcessed    = "processed"
+	polkaOutcomeDuplicate    = "duplicate"
+	polkaOutcomeIgnored      = "ignored"
+	polkaOutcomeRejected     = "rejected"
+	polkaOutcomeInvalid      = "invalid"
+	polkaOutcomeUserNotFound = "user_not_found"
+	polkaOutcomeFailed       = "failed"
+)
+
+var errPolkaDuplicate = errors.New("polka event already processed")
+
+type PolkaIn struct {
+	ID    string `json:"id"`
+	Event string `json:"event"`
+	Data  struct {
+		UserID      string     `json:"user_id"`
+		Plan        string     `json:"plan"`
+		PeriodStart *time.Time `json:"period_start"`
+		PeriodEnd   *time.Time `json:"period_end"`
+	} `json:"data"`
+}
+
+// polkaDelivery is what gets recorded about one webhook request.
+type polkaDelivery struct {
+	eventID string
+	event   string
+	payload []byte
+	// verified is set once the signature checks out; only then is the
+	// payload worth keeping
+	verified bool
+	outcome  string
+	err     error
+}
+
+// HANDLERS
+
+// PolkaWebhook applies Polka's billing events to subscriptions. Deliveries must carry a
+// Polka-Signature made with one of PolkaSecrets no more than
+// webhook.DefaultTolerance ago. Polka retries until it gets a 2xx, so an
+// event ID that was already processed is acknowledged without acting
+// again. Every delivery is recorded in polka_events.
+func (cfg *APIConfig) PolkaWebhook(w http.ResponseWriter, r *http.Request) {
+	delivery := &polkaDelivery{}
+	defer cfg.recordPolkaDelivery(r, delivery)
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPolkaBodyBytes))
+	delivery.payload = body
+	if err != nil {
+		delivery.outcome, delivery.err = polkaOutcomeInvalid, err
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	err = webhook.Verify(r.Header.Get(polkaSignatureHeader), body, cfg.PolkaSecrets, time.Now(), webhook.DefaultTolerance)
+	if err != nil {
+		slog.Info("Rejected Polka webhook", "error", err, "ip", clientIP(r))
+		delivery.outcome, delivery.err = polkaOutcomeRejected, err
+		respondWithErrorCode(w, 401, "invalid_signature", "Invalid webhook signature")
+		return
+	}
+	delivery.verified = true
+
+	event := PolkaIn{}
+	if err := json.Unmarshal(body, &event); err != nil {
+		delivery.outcome, delivery.err = polkaOutcomeInvalid, err
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	delivery.eventID, delivery.event = event.ID, event.Event
+	if event.ID == "" {
+		delivery.outcome, delivery.err = polkaOutcomeInvalid, errors.New("missing event id")
+		respondWithError(w, 400, "Missing event id")
+		return
+	}
+	if !billing.KnownEvent(event.Event) {
+		delivery.outcome = polkaOutcomeIgnored
+		w.WriteHeader(204)
+		return
+	}
+	userID, err := uuid.Parse(event.Data.UserID)
+	if err != nil {
+		delivery.outcome, delivery.err = polkaOutcomeInvalid, err
+		respondWithError(w, 400, "Invalid user ID")
+		return
+	}
+	billingEvent := billing.Event{Type: event.Event, Plan: event.Data.Plan}
+	if event.Data.PeriodStart != nil {
+		billingEvent.PeriodStart = *event.Data.PeriodStart
+	}
+	if event.Data.PeriodEnd != nil {
+		billingEvent.PeriodEnd = *event.Data.PeriodEnd
+	}
+
+	sub := billing.Subscription{}
+	err = cfg.inTx(r.Context(), func(q *db.Queries) error {
+		// marking the event in the same transaction means a failed update
+		// is not remembered, and Polka's retry gets another go
+		marked, err := q.MarkPolkaEventProcessed(r.Context(), event.ID)
+		if err != nil {
+			return err
+		}
+		if marked == 0 {
+			return errPolkaDuplicate
+		}
+		sub, err = cfg.applyBillingEvent(r.Context(), q, userID, billingEvent, time.Now())
+		return err
+	})
+	switch {
+	case err == errPolkaDuplicate:
+		slog.Info("Duplicate Polka event", "eventID", event.ID)
+		delivery.outcome = polkaOutcomeDuplicate
+		w.WriteHeader(204)
+	case err == billing.ErrNoSubscription:
+		slog.Info("Polka event for a user without a subscription", "eventID", event.ID, "event", event.Event, "userID", userID)
+		delivery.outcome, delivery.err = polkaOutcomeIgnored, err
+		w.WriteHeader(204)
+	case err == billing.ErrUnknownPlan:
+		delivery.outcome, delivery.err = polkaOutcomeInvalid, err
+		respondWithError(w, 400, "Unknown plan")
+	case err == sql.ErrNoRows:
+		delivery.outcome, delivery.err = polkaOutcomeUserNotFound, err
+		respondWithError(w, 404, "User not found")
+	case err != nil:
+		slog.Error("Error applying billing event", "error", err, "eventID", event.ID)
+		delivery.outcome, delivery.err = polkaOutcomeFailed, err
+		respondWithError(w, 500, "Could not update subscription")
+	default:
+		slog.Info("Applied billing event", "userID", userID, "eventID", event.ID, "event", event.Event, "status", sub.Status)
+		delivery.outcome = polkaOutcomeProcessed
+		w.WriteHeader(204)
+	}
+}
+
+// HELPERS
+
+// recordPolkaDelivery writes a delivery to polka_events. Payloads are only
+// kept for deliveries Polka signed, so strangers cannot fill the table with
+// whatever they post. Losing the record is logged but does not change the
+// response.
+func (cfg *APIConfig) recordPolkaDelivery(r *http.Request, delivery *polkaDelivery) {
+	errText := ""
+	if delivery.err != nil {
+		errText = delivery.err.Error()
+	}
+	payload := ""
+	if delivery.verified {
+		payload = strings.ToValidUTF8(string(delivery.payload), "\uFFFD")
+	}
+	err := cfg.DBQueries.CreatePolkaEvent(r.Context(), db.CreatePolkaEventParams{
+		EventID:  delivery.eventID,
+		Event:    delivery.event,
+		Payload:  payload,
+		RemoteIp: clientIP(r),
+		Outcome:  delivery.outcome,
+		Error:    errText,
+	})
+	if err != nil {
+		slog.Error("Error recording Polka event", "error", err, "eventID", delivery.eventID, "outcome", delivery.outcome)
+	}
+}
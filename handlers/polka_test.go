@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/webhook"
+)
+
+// execRecorder is a db.DBTX that only supports Exec, remembering the
+// arguments of each call.
+type execRecorder struct {
+	db.DBTX
+	calls [][]interface{}
+}
+
+func (e *execRecorder) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	e.calls = append(e.calls, args)
+	return nil, nil
+}
+
+func TestPolkaWebhookRejected(t *testing.T) {
+	body := `{"id":"evt_1","event":"user.upgraded","data":{"user_id":"not-a-uuid"}}`
+	now := time.Now()
+	testCases := []struct {
+		name      string
+		body      string
+		signature string
+		code      int
+		outcome   string
+		// payloads are only kept once the signature checks out
+		payload string
+	}{
+		{"unsigned", body, "", 401, polkaOutcomeRejected, ""},
+		{"wrong secret", body, webhook.Sign([]byte(body), now, "other"), 401, polkaOutcomeRejected, ""},
+		{"replayed", body, webhook.Sign([]byte(body), now.Add(-time.Hour), "secret"), 401, polkaOutcomeRejected, ""},
+		{"malformed user ID", body, webhook.Sign([]byte(body), now, "secret"), 400, polkaOutcomeInvalid, body},
+		{"no event ID", `{"event":"user.upgraded"}`, webhook.Sign([]byte(`{"event":"user.upgraded"}`), now, "secret"), 400, polkaOutcomeInvalid, `{"event":"user.upgraded"}`},
+		{"other event", `{"id":"evt_2","event":"user.created"}`, webhook.Sign([]byte(`{"id":"evt_2","event":"user.created"}`), now, "old", "secret"), 204, polkaOutcomeIgnored, `{"id":"evt_2","event":"user.created"}`},
+	}
+	for _, testCase := range testCases {
+		recorder := &execRecorder{}
+		cfg := &APIConfig{DBQueries: db.New(recorder), PolkaSecrets: []string{"secret"}}
+		req := httptest.NewRequest("POST", "/api/polka/webhooks", strings.NewReader(testCase.body))
+		if testCase.signature != "" {
+			req.Header.Set("Polka-Signature", testCase.signature)
+		}
+		w := httptest.NewRecorder()
+		cfg.PolkaWebhook(w, req)
+		if w.Code != testCase.code {
+			t.Errorf("%s: expected %d, got %d %s", testCase.name, testCase.code, w.Code, w.Body.String())
+		}
+		// every delivery is recorded, accepted or not
+		if len(recorder.calls) != 1 {
+			t.Errorf("%s: expected the delivery to be recorded once, got %d", testCase.name, len(recorder.calls))
+			continue
+		}
+		args := recorder.calls[0]
+		if args[2] != testCase.payload || args[4] != testCase.outcome {
+			t.Errorf("%s: expected payload %q with outcome %s, got %v", testCase.name, testCase.payload, testCase.outcome, args)
+		}
+	}
+}
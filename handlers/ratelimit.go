@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/entitlements"
+	"github.com/eliza-guseva/chirpy-server/internal/ratelimit"
+	"github.com/google/uuid"
+)
+
+// RateLimit counts each request against the named policy before calling
+// handler. Inside RequireAuth the quota belongs to the personal access
+// token or user, and users entitled to higher rate limits get the
+// policy's Red limit; elsewhere it belongs to the client IP. Over the
+// limit it responds 429 with code rate_limited and Retry-After.
+func (cfg *APIConfig) RateLimit(policy string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.RateLimiter == nil {
+			handler(w, r)
+			return
+		}
+		key := rateLimitKey(r)
+		user, ok := r.Context().Value("user").(db.User)
+		red := ok && entitlements.Can(user, entitlements.HigherRateLimits)
+		result, err := cfg.RateLimiter.Take(r.Context(), policy, key, red, time.Now())
+		if err != nil {
+			// a broken store must not take the API down with it
+			slog.Error("Error checking rate limit", "error", err, "policy", policy)
+			handler(w, r)
+			return
+		}
+		setRateLimitHeaders(w, result)
+		if !result.Allowed {
+			slog.Info("Rate limited", "policy", policy, "key", key, "retryAfter", result.RetryAfter)
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			respondWithErrorCode(w, 429, "rate_limited", "Too many requests, try again later")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// rateLimitKey is who a request counts against.
+func rateLimitKey(r *http.Request) string {
+	if claims, ok := r.Context().Value("claims").(*auth.Claims); ok && claims.PersonalTokenID != "" {
+		return "pat:" + claims.PersonalTokenID
+	}
+	if userID, ok := r.Context().Value("userID").(uuid.UUID); ok {
+		return "user:" + userID.String()
+	}
+	return "ip:" + clientIP(r)
+}
+
+// setRateLimitHeaders sets the RateLimit-* headers of the IETF draft
+// "RateLimit header fields for HTTP".
+func setRateLimitHeaders(w http.ResponseWriter, result ratelimit.Result) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit.Requests))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", result.Limit.Requests, ceilSeconds(result.Limit.Per)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
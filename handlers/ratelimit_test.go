@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/ratelimit"
+	"github.com/google/uuid"
+)
+
+func TestRateLimit(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore())
+	limiter.Policies = map[string]ratelimit.Policy{
+		"test": {
+			Limit: ratelimit.Limit{Requests: 2, Per: time.Minute},
+			Red:   ratelimit.Limit{Requests: 4, Per: time.Minute},
+		},
+	}
+	cfg := &APIConfig{RateLimiter: limiter}
+	handler := cfg.RateLimit("test", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	})
+	withUser := func(r *http.Request, userID uuid.UUID, red bool) *http.Request {
+		ctx := context.WithValue(r.Context(), "userID", userID)
+		ctx = context.WithValue(ctx, "claims", &auth.Claims{})
+		ctx = context.WithValue(ctx, "user", db.User{ID: userID, IsChirpyRed: red})
+		return r.WithContext(ctx)
+	}
+
+	userID, redID := uuid.New(), uuid.New()
+	testCases := []struct {
+		name      string
+		request   func() *http.Request
+		code      int
+		remaining string
+	}{
+		{"first", func() *http.Request { return httptest.NewRequest("POST", "/", nil) }, 204, "1"},
+		{"second", func() *http.Request { return httptest.NewRequest("POST", "/", nil) }, 204, "0"},
+		{"over the limit", func() *http.Request { return httptest.NewRequest("POST", "/", nil) }, 429, "0"},
+		{"another IP", func() *http.Request {
+			r := httptest.NewRequest("POST", "/", nil)
+			r.RemoteAddr = "198.51.100.9:1234"
+			return r
+		}, 204, "1"},
+		// users have their own quota, wherever they come from
+		{"user", func() *http.Request { return withUser(httptest.NewRequest("POST", "/", nil), userID, false) }, 204, "1"},
+		{"user again", func() *http.Request { return withUser(httptest.NewRequest("POST", "/", nil), userID, false) }, 204, "0"},
+		{"user over the limit", func() *http.Request { return withUser(httptest.NewRequest("POST", "/", nil), userID, false) }, 429, "0"},
+		{"Chirpy Red user", func() *http.Request { return withUser(httptest.NewRequest("POST", "/", nil), redID, true) }, 204, "3"},
+	}
+	for _, testCase := range testCases {
+		w := httptest.NewRecorder()
+		handler(w, testCase.request())
+		if w.Code != testCase.code {
+			t.Errorf("%s: expected %d, got %d %s", testCase.name, testCase.code, w.Code, w.Body.String())
+		}
+		if remaining := w.Header().Get("RateLimit-Remaining"); remaining != testCase.remaining {
+			t.Errorf("%s: expected RateLimit-Remaining %s, got %q", testCase.name, testCase.remaining, remaining)
+		}
+		if w.Header().Get("RateLimit-Policy") == "" {
+			t.Errorf("%s: expected a RateLimit-Policy header", testCase.name)
+		}
+		if w.Code == 429 {
+			if !strings.Contains(w.Body.String(), "rate_limited") {
+				t.Errorf("%s: expected code rate_limited, got %s", testCase.name, w.Body.String())
+			}
+			if retryAfter := w.Header().Get("Retry-After"); retryAfter != "30" {
+				t.Errorf("%s: expected Retry-After 30, got %q", testCase.name, retryAfter)
+			}
+		}
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.1:1234"
+	if key := rateLimitKey(r); key != "ip:203.0.113.1" {
+		t.Errorf("Expected the IP key, got %q", key)
+	}
+	tokenID := uuid.New().String()
+	ctx := context.WithValue(r.Context(), "userID", uuid.New())
+	ctx = context.WithValue(ctx, "claims", &auth.Claims{PersonalTokenID: tokenID})
+	if key := rateLimitKey(r.WithContext(ctx)); key != "pat:"+tokenID {
+		t.Errorf("Expected the personal access token key, got %q", key)
+	}
+}
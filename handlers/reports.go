@@ -0,0 +1,619 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/mail"
+	"github.com/google/uuid"
+)
+
+type ReportIn struct {
+	ChirpID string `json:"chirp_id"`
+	UserID  string `json:"user_id"`
+	Reason  string `json:"reason"`
+	Details string `json:"details"`
+}
+
+type ReportOut struct {
+	ID           string     `json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	ReporterID   string     `json:"reporter_id"`
+	ChirpID      string     `json:"chirp_id,omitempty"`
+	TargetUserID string     `json:"target_user_id"`
+	Reason       string     `json:"reason"`
+	Details      string     `json:"details"`
+	Status       string     `json:"status"`
+	ClaimedBy    string     `json:"claimed_by,omitempty"`
+	ClaimedAt    *time.Time `json:"claimed_at,omitempty"`
+	ResolvedAt   *time.Time `json:"resolved_at,omitempty"`
+	Resolution   string     `json:"resolution,omitempty"`
+}
+
+type ResolveReportIn struct {
+	Action         string    `json:"action"`
+	Note           string    `json:"note"`
+	SuspendedUntil time.Time `json:"suspended_until"`
+}
+
+type UserStatusIn struct {
+	Status         string    `json:"status"`
+	SuspendedUntil time.Time `json:"suspended_until"`
+	Note           string    `json:"note"`
+}
+
+type UserStatusOut struct {
+	ID             string     `json:"id"`
+	Status         string     `json:"status"`
+	SuspendedUntil *time.Time `json:"suspended_until,omitempty"`
+}
+
+type ModerationLogOut struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	ActorID      string    `json:"actor_id,omitempty"`
+	Action       string    `json:"action"`
+	ReportID     string    `json:"report_id,omitempty"`
+	TargetUserID string    `json:"target_user_id,omitempty"`
+	ChirpID      string    `json:"chirp_id,omitempty"`
+	Note         string    `json:"note"`
+}
+
+// HeldChirpOut is a chirp waiting in the spam queue with why it was held.
+type HeldChirpOut struct {
+	ChirpOut
+	SpamScore   float64         `json:"spam_score"`
+	SpamReasons json.RawMessage `json:"spam_reasons"`
+}
+
+type ModerationNoteIn struct {
+	Note string `json:"note"`
+}
+
+var reportReasons = map[string]bool{
+	"spam":          true,
+	"harassment":    true,
+	"hate":          true,
+	"violence":      true,
+	"impersonation": true,
+	"other":         true,
+}
+
+var reportStatuses = map[string]bool{
+	"open":     true,
+	"claimed":  true,
+	"resolved": true,
+}
+
+const (
+	actionClaim       = "claim"
+	actionDismiss     = "dismiss"
+	actionDeleteChirp = "delete_chirp"
+	actionWarn        = "warn"
+	actionSuspend     = "suspend"
+	actionBan         = "ban"
+	actionSetStatus   = "set_status"
+	actionUnlockLogin = "unlock_login"
+	// held chirps are released to the public or rejected and deleted
+	actionReleaseChirp = "release_chirp"
+	actionRejectChirp  = "reject_chirp"
+)
+
+// HANDLERS
+
+func (cfg *APIConfig) CreateReport(w http.ResponseWriter, r *http.Request) {
+	reporterID := r.Context().Value("userID").(uuid.UUID)
+	decoder := json.NewDecoder(r.Body)
+	reqReport := ReportIn{}
+	if err := decoder.Decode(&reqReport); err != nil {
+		slog.Error("Error decoding request", "error", err)
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	if !reportReasons[reqReport.Reason] {
+		respondWithError(w, 400, "Unknown report reason")
+		return
+	}
+	if (reqReport.ChirpID == "") == (reqReport.UserID == "") {
+		respondWithError(w, 400, "Report exactly one of chirp_id or user_id")
+		return
+	}
+
+	params := db.CreateReportParams{
+		ReporterID: reporterID,
+		Reason:     reqReport.Reason,
+		Details:    reqReport.Details,
+	}
+	if reqReport.ChirpID != "" {
+		chID, err := uuid.Parse(reqReport.ChirpID)
+		if err != nil {
+			respondWithError(w, 400, "Invalid chirp ID")
+			return
+		}
+		chirp, err := cfg.DBQueries.GetChirp(r.Context(), chID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				respondWithError(w, 404, "Chirp not found")
+				return
+			}
+			slog.Error("Error getting chirp", "error", err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+		params.ChirpID = uuid.NullUUID{UUID: chirp.ID, Valid: true}
+		params.TargetUserID = chirp.UserID
+	} else {
+		userID, err := uuid.Parse(reqReport.UserID)
+		if err != nil {
+			respondWithError(w, 400, "Invalid user ID")
+			return
+		}
+		user, err := cfg.DBQueries.GetUserByID(r.Context(), userID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				respondWithError(w, 404, "User not found")
+				return
+			}
+			slog.Error("Error getting user", "error", err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+		params.TargetUserID = user.ID
+	}
+
+	report, err := cfg.DBQueries.CreateReport(r.Context(), params)
+	if err != nil {
+		slog.Error("Error creating report", "error", err)
+		respondWithError(w, 500, "Could not create report")
+		return
+	}
+	respondWithJSON(w, 201, toReportOut(report))
+}
+
+func (cfg *APIConfig) ListReports(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "open"
+	}
+	if !reportStatuses[status] {
+		respondWithError(w, 400, "Unknown report status")
+		return
+	}
+	reports, err := cfg.DBQueries.ListReports(r.Context(), status)
+	if err != nil {
+		slog.Error("Error listing reports", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	reportsOut := []ReportOut{}
+	for _, report := range reports {
+		reportsOut = append(reportsOut, toReportOut(report))
+	}
+	respondWithJSON(w, 200, reportsOut)
+}
+
+func (cfg *APIConfig) ClaimReport(w http.ResponseWriter, r *http.Request) {
+	moderatorID := r.Context().Value("userID").(uuid.UUID)
+	reportID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid report ID")
+		return
+	}
+	var report db.Report
+	err = cfg.inTx(r.Context(), func(q *db.Queries) error {
+		report, err = q.ClaimReport(r.Context(), db.ClaimReportParams{
+			ID:        reportID,
+			ClaimedBy: uuid.NullUUID{UUID: moderatorID, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		return logModeration(r.Context(), q, moderatorID, actionClaim, report, "")
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, 409, "Report is not open")
+			return
+		}
+		slog.Error("Error claiming report", "error", err)
+		respondWithError(w, 500, "Could not claim report")
+		return
+	}
+	respondWithJSON(w, 200, toReportOut(report))
+}
+
+func (cfg *APIConfig) ResolveReport(w http.ResponseWriter, r *http.Request) {
+	moderatorID := r.Context().Value("userID").(uuid.UUID)
+	reportID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid report ID")
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	reqResolve := ResolveReportIn{}
+	if err := decoder.Decode(&reqResolve); err != nil {
+		slog.Error("Error decoding request", "error", err)
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	report, err := cfg.DBQueries.GetReport(r.Context(), reportID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, 404, "Report not found")
+			return
+		}
+		slog.Error("Error getting report", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	if report.Status != "claimed" || report.ClaimedBy.UUID != moderatorID {
+		respondWithError(w, 409, "Report must be claimed by you before resolving")
+		return
+	}
+
+	switch reqResolve.Action {
+	case actionDismiss:
+		// nothing to change, the log entry is the record
+	case actionWarn:
+	case actionDeleteChirp:
+		if !report.ChirpID.Valid {
+			respondWithError(w, 400, "Report has no chirp to delete")
+			return
+		}
+	case actionSuspend:
+		if !reqResolve.SuspendedUntil.After(time.Now()) {
+			respondWithError(w, 400, "suspended_until must be in the future")
+			return
+		}
+	case actionBan:
+	default:
+		respondWithError(w, 400, "Unknown action")
+		return
+	}
+
+	// resolving first locks the report, so a second moderator racing us
+	// finds it resolved and nothing is applied twice
+	var resolved db.Report
+	err = cfg.inTx(r.Context(), func(q *db.Queries) error {
+		resolved, err = q.ResolveReport(r.Context(), db.ResolveReportParams{
+			ID:         report.ID,
+			ClaimedBy:  uuid.NullUUID{UUID: moderatorID, Valid: true},
+			Resolution: sql.NullString{String: reqResolve.Action, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		var target db.User
+		if reqResolve.Action != actionDismiss {
+			if target, err = checkOutranks(r, q, report.TargetUserID); err != nil {
+				return err
+			}
+		}
+		switch reqResolve.Action {
+		case actionDeleteChirp:
+			err = q.DeleteChirp(r.Context(), report.ChirpID.UUID)
+		case actionWarn:
+			err = queueWarning(r.Context(), q, target, report)
+		case actionSuspend:
+			_, err = setAccountStatus(r.Context(), q, report.TargetUserID, userStatusSuspended, reqResolve.SuspendedUntil)
+		case actionBan:
+			_, err = setAccountStatus(r.Context(), q, report.TargetUserID, userStatusBanned, time.Time{})
+		}
+		if err != nil {
+			return err
+		}
+		return logModeration(r.Context(), q, moderatorID, reqResolve.Action, report, reqResolve.Note)
+	})
+	if err == sql.ErrNoRows {
+		respondWithError(w, 409, "Report must be claimed by you before resolving")
+		return
+	}
+	if err == errOutranked {
+		respondWithError(w, 403, "You can only moderate users below your role")
+		return
+	}
+	if err != nil {
+		slog.Error("Error resolving report", "error", err, "action", reqResolve.Action, "reportID", report.ID)
+		respondWithError(w, 500, "Could not resolve report")
+		return
+	}
+	respondWithJSON(w, 200, toReportOut(resolved))
+}
+
+// SetUserStatus lets moderators suspend, ban or reinstate an account
+// outside of a report, e.g. after an appeal.
+func (cfg *APIConfig) SetUserStatus(w http.ResponseWriter, r *http.Request) {
+	moderatorID := r.Context().Value("userID").(uuid.UUID)
+	userID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid user ID")
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	reqStatus := UserStatusIn{}
+	if err := decoder.Decode(&reqStatus); err != nil {
+		slog.Error("Error decoding request", "error", err)
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	switch reqStatus.Status {
+	case userStatusActive, userStatusBanned:
+	case userStatusSuspended:
+		if !reqStatus.SuspendedUntil.After(time.Now()) {
+			respondWithError(w, 400, "suspended_until must be in the future")
+			return
+		}
+	default:
+		respondWithError(w, 400, "Unknown status")
+		return
+	}
+	// the status, the revoked tokens and the log entry land together
+	var user db.User
+	err = cfg.inTx(r.Context(), func(q *db.Queries) error {
+		if _, err := checkOutranks(r, q, userID); err != nil {
+			return err
+		}
+		user, err = setAccountStatus(r.Context(), q, userID, reqStatus.Status, reqStatus.SuspendedUntil)
+		if err != nil {
+			return err
+		}
+		_, err = q.CreateModerationLogEntry(r.Context(), db.CreateModerationLogEntryParams{
+			ActorID:      uuid.NullUUID{UUID: moderatorID, Valid: true},
+			Action:       actionSetStatus,
+			TargetUserID: uuid.NullUUID{UUID: user.ID, Valid: true},
+			Note:         reqStatus.Status + ": " + reqStatus.Note,
+		})
+		return err
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, 404, "User not found")
+			return
+		}
+		if err == errOutranked {
+			respondWithError(w, 403, "You can only moderate users below your role")
+			return
+		}
+		slog.Error("Error setting user status", "error", err, "userID", userID)
+		respondWithError(w, 500, "Could not set user status")
+		return
+	}
+	out := UserStatusOut{ID: user.ID.String(), Status: user.Status}
+	if user.SuspendedUntil.Valid {
+		out.SuspendedUntil = &user.SuspendedUntil.Time
+	}
+	respondWithJSON(w, 200, out)
+}
+
+func (cfg *APIConfig) GetModerationLog(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			respondWithError(w, 400, "Invalid limit")
+			return
+		}
+		limit = parsed
+	}
+	entries, err := cfg.DBQueries.ListModerationLog(r.Context(), int32(limit))
+	if err != nil {
+		slog.Error("Error listing moderation log", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	entriesOut := []ModerationLogOut{}
+	for _, entry := range entries {
+		entriesOut = append(entriesOut, ModerationLogOut{
+			ID:           entry.ID,
+			CreatedAt:    entry.CreatedAt,
+			ActorID:      nullUUIDString(entry.ActorID),
+			Action:       entry.Action,
+			ReportID:     nullUUIDString(entry.ReportID),
+			TargetUserID: nullUUIDString(entry.TargetUserID),
+			ChirpID:      nullUUIDString(entry.ChirpID),
+			Note:         entry.Note,
+		})
+	}
+	respondWithJSON(w, 200, entriesOut)
+}
+
+// ListHeldChirps is the queue of chirps the spam checks held, oldest
+// first.
+func (cfg *APIConfig) ListHeldChirps(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			respondWithError(w, 400, "Invalid limit")
+			return
+		}
+		limit = parsed
+	}
+	chirps, err := cfg.DBQueries.ListHeldChirps(r.Context(), int32(limit))
+	if err != nil {
+		slog.Error("Error listing held chirps", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	chirpsOut := []HeldChirpOut{}
+	for _, chirp := range chirps {
+		chirpsOut = append(chirpsOut, HeldChirpOut{
+			ChirpOut: toChirpOut(db.Chirp{
+				ID:        chirp.ID,
+				CreatedAt: chirp.CreatedAt,
+				UpdatedAt: chirp.UpdatedAt,
+				UserID:    chirp.UserID,
+				Body:      chirp.Body,
+				Lang:      chirp.Lang,
+				Status:    chirp.Status,
+			}),
+			SpamScore:   chirp.SpamScore,
+			SpamReasons: chirp.SpamReasons,
+		})
+	}
+	respondWithJSON(w, 200, chirpsOut)
+}
+
+// ReleaseChirp publishes a held chirp the spam checks got wrong.
+func (cfg *APIConfig) ReleaseChirp(w http.ResponseWriter, r *http.Request) {
+	chirp, ok := cfg.decideHeldChirp(w, r, actionReleaseChirp)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, 200, toChirpOut(chirp))
+}
+
+// RejectChirp deletes a held chirp.
+func (cfg *APIConfig) RejectChirp(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.decideHeldChirp(w, r, actionRejectChirp); !ok {
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// HELPERS
+
+// decideHeldChirp releases or rejects the held chirp named in the path and
+// logs the decision in the same transaction. It responds itself unless it
+// returns true.
+func (cfg *APIConfig) decideHeldChirp(w http.ResponseWriter, r *http.Request, action string) (db.Chirp, bool) {
+	moderatorID := r.Context().Value("userID").(uuid.UUID)
+	chirpID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp ID")
+		return db.Chirp{}, false
+	}
+	// the note is optional, so is the body
+	reqNote := ModerationNoteIn{}
+	if err := json.NewDecoder(r.Body).Decode(&reqNote); err != nil && err != io.EOF {
+		respondWithError(w, 400, "Invalid request body")
+		return db.Chirp{}, false
+	}
+	var chirp db.Chirp
+	err = cfg.inTx(r.Context(), func(q *db.Queries) error {
+		if action == actionReleaseChirp {
+			chirp, err = q.ReleaseHeldChirp(r.Context(), chirpID)
+		} else {
+			chirp, err = q.DeleteHeldChirp(r.Context(), chirpID)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = q.CreateModerationLogEntry(r.Context(), db.CreateModerationLogEntryParams{
+			ActorID:      uuid.NullUUID{UUID: moderatorID, Valid: true},
+			Action:       action,
+			TargetUserID: uuid.NullUUID{UUID: chirp.UserID, Valid: true},
+			ChirpID:      uuid.NullUUID{UUID: chirp.ID, Valid: true},
+			Note:         reqNote.Note,
+		})
+		if err != nil {
+			return err
+		}
+		// a released chirp is published only now, so it is announced now
+		if action == actionReleaseChirp {
+			return publishEvent(r.Context(), q, chirp.UserID, EventChirpCreated, toChirpOut(chirp))
+		}
+		return nil
+	})
+	if err == sql.ErrNoRows {
+		respondWithError(w, 404, "No held chirp with that ID")
+		return db.Chirp{}, false
+	}
+	if err != nil {
+		slog.Error("Error deciding held chirp", "error", err, "action", action, "chirpID", chirpID)
+		respondWithError(w, 500, "Could not update chirp")
+		return db.Chirp{}, false
+	}
+	slog.Info("Held chirp decided", "action", action, "chirpID", chirp.ID, "by", moderatorID)
+	return chirp, true
+}
+
+// errOutranked means the moderator does not outrank the user they tried
+// to act on.
+var errOutranked = errors.New("target's role is not below the actor's")
+
+// checkOutranks returns the user with userID, or errOutranked unless the
+// caller's role ranks above theirs, so moderators cannot ban each other
+// or their admins.
+func checkOutranks(r *http.Request, q *db.Queries, userID uuid.UUID) (db.User, error) {
+	actorRole, _ := r.Context().Value("role").(string)
+	target, err := q.GetUserByID(r.Context(), userID)
+	if err != nil {
+		return db.User{}, err
+	}
+	if !auth.Outranks(actorRole, target.Role) {
+		return db.User{}, errOutranked
+	}
+	return target, nil
+}
+
+// queueWarning queues the email telling target a report about them
+// ended in a warning. Run it in the transaction that resolves the report.
+func queueWarning(ctx context.Context, q *db.Queries, target db.User, report db.Report) error {
+	about := "your account"
+	if report.ChirpID.Valid {
+		about = "one of your chirps"
+	}
+	return mail.Queue(ctx, q, mail.Message{
+		To:      target.Email,
+		Subject: "A warning about your Chirpy account",
+		Body: fmt.Sprintf("A moderator reviewed a report about %s for %s and is warning you.\n\n"+
+			"Further reports like it can get your account suspended or banned.\n",
+			about, report.Reason),
+	})
+}
+
+// logModeration appends an entry about report to the moderation log. Run
+// it in the transaction that made the change, so no action goes unlogged.
+func logModeration(ctx context.Context, q *db.Queries, actorID uuid.UUID, action string, report db.Report, note string) error {
+	_, err := q.CreateModerationLogEntry(ctx, db.CreateModerationLogEntryParams{
+		ActorID:      uuid.NullUUID{UUID: actorID, Valid: true},
+		Action:       action,
+		ReportID:     uuid.NullUUID{UUID: report.ID, Valid: true},
+		TargetUserID: uuid.NullUUID{UUID: report.TargetUserID, Valid: true},
+		ChirpID:      report.ChirpID,
+		Note:         note,
+	})
+	return err
+}
+
+func toReportOut(report db.Report) ReportOut {
+	out := ReportOut{
+		ID:           report.ID.String(),
+		CreatedAt:    report.CreatedAt,
+		UpdatedAt:    report.UpdatedAt,
+		ReporterID:   report.ReporterID.String(),
+		ChirpID:      nullUUIDString(report.ChirpID),
+		TargetUserID: report.TargetUserID.String(),
+		Reason:       report.Reason,
+		Details:      report.Details,
+		Status:       report.Status,
+		ClaimedBy:    nullUUIDString(report.ClaimedBy),
+		Resolution:   report.Resolution.String,
+	}
+	if report.ClaimedAt.Valid {
+		out.ClaimedAt = &report.ClaimedAt.Time
+	}
+	if report.ResolvedAt.Valid {
+		out.ResolvedAt = &report.ResolvedAt.Time
+	}
+	return out
+}
+
+func nullUUIDString(id uuid.NullUUID) string {
+	if !id.Valid {
+		return ""
+	}
+	return id.UUID.String()
+}
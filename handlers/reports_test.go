@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/google/uuid"
+)
+
+// asModerator builds a request from moderatorID, a moderator, for a route
+// with an {id}.
+func asModerator(method string, target string, body string, moderatorID uuid.UUID, id uuid.UUID) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.SetPathValue("id", id.String())
+	ctx := context.WithValue(req.Context(), "userID", moderatorID)
+	return req.WithContext(context.WithValue(ctx, "role", auth.RoleModerator))
+}
+
+func TestDecideHeldChirp(t *testing.T) {
+	moderatorID := uuid.New()
+	chirp := db.Chirp{ID: uuid.New(), UserID: uuid.New(), Body: "buy now", Status: chirpStatusHeld, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	released := chirp
+	released.Status = chirpStatusPublished
+
+	testCases := []struct {
+		name    string
+		handler func(cfg *APIConfig) http.HandlerFunc
+		query   string
+		row     db.Chirp
+		action  string
+		code    int
+		trace   string
+	}{
+		// a released chirp is announced with its decision
+		{"release", func(cfg *APIConfig) http.HandlerFunc { return cfg.ReleaseChirp }, "ReleaseHeldChirp", released, actionReleaseChirp, 200,
+			"begin ReleaseHeldChirp CreateModerationLogEntry EnqueueWebhookEvent commit"},
+		{"reject", func(cfg *APIConfig) http.HandlerFunc { return cfg.RejectChirp }, "DeleteHeldChirp", chirp, actionRejectChirp, 204,
+			"begin DeleteHeldChirp CreateModerationLogEntry commit"},
+	}
+	for _, testCase := range testCases {
+		fake := newFakeDB()
+		fake.on(testCase.query, chirpRow(testCase.row))
+		fake.on("CreateModerationLogEntry", anyLogEntry)
+		cfg := fake.config()
+		w := httptest.NewRecorder()
+		testCase.handler(cfg)(w, asModerator("POST", "/admin/chirps/x", `{"note":"false positive"}`, moderatorID, chirp.ID))
+		if w.Code != testCase.code {
+			t.Errorf("%s: expected %d, got %d %s", testCase.name, testCase.code, w.Code, w.Body.String())
+			continue
+		}
+		if trace := fake.trace(); trace != testCase.trace {
+			t.Errorf("%s: expected the decision and its log entry in one transaction, got %s", testCase.name, trace)
+		}
+		entry := fake.called("CreateModerationLogEntry")[0]
+		if entry[1] != testCase.action || entry[4] != chirp.ID.String() || entry[5] != "false positive" {
+			t.Errorf("%s: unexpected log entry %v", testCase.name, entry)
+		}
+	}
+}
+
+func TestReleaseChirpNotHeld(t *testing.T) {
+	fake := newFakeDB()
+	// ReleaseHeldChirp finds nothing for published or missing chirps
+	cfg := fake.config()
+	w := httptest.NewRecorder()
+	cfg.ReleaseChirp(w, asModerator("POST", "/admin/chirps/x", "", uuid.New(), uuid.New()))
+	if w.Code != 404 {
+		t.Fatalf("Expected 404, got %d %s", w.Code, w.Body.String())
+	}
+	if trace := fake.trace(); trace != "begin ReleaseHeldChirp rollback" {
+		t.Errorf("Expected nothing to be logged, got %s", trace)
+	}
+}
+
+// claimedReport is a report about a chirp claimed by moderatorID.
+func claimedReport(moderatorID uuid.UUID) db.Report {
+	return db.Report{
+		ID:           uuid.New(),
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+		ReporterID:   uuid.New(),
+		ChirpID:      uuid.NullUUID{UUID: uuid.New(), Valid: true},
+		TargetUserID: uuid.New(),
+		Reason:       "spam",
+		Status:       "claimed",
+		ClaimedBy:    uuid.NullUUID{UUID: moderatorID, Valid: true},
+		ClaimedAt:    sql.NullTime{Time: time.Now(), Valid: true},
+	}
+}
+
+func TestResolveReport(t *testing.T) {
+	moderatorID := uuid.New()
+	report := claimedReport(moderatorID)
+	resolved := report
+	resolved.Status = "resolved"
+	target := db.User{ID: report.TargetUserID, Email: "target@example.com", Status: userStatusBanned, Role: auth.RoleUser}
+
+	testCases := []struct {
+		name  string
+		body  string
+		trace string
+	}{
+		{"dismiss", `{"action":"dismiss"}`, "GetReport begin ResolveReport CreateModerationLogEntry commit"},
+		{"delete chirp", `{"action":"delete_chirp"}`, "GetReport begin ResolveReport GetUserByID DeleteChirp CreateModerationLogEntry commit"},
+		{"warn", `{"action":"warn"}`, "GetReport begin ResolveReport GetUserByID EnqueueMail CreateModerationLogEntry commit"},
+		{"ban", `{"action":"ban","note":"repeat offender"}`, "GetReport begin ResolveReport GetUserByID SetUserStatus RevokeUserRefreshTokens CreateModerationLogEntry commit"},
+	}
+	for _, testCase := range testCases {
+		fake := newFakeDB()
+		fake.on("GetReport", reportRow(report))
+		fake.on("ResolveReport", reportRow(resolved))
+		fake.on("GetUserByID", userRow(db.User{ID: target.ID, Email: target.Email, Role: auth.RoleUser}))
+		fake.on("SetUserStatus", userRow(target))
+		fake.on("EnqueueMail", anyMail)
+		fake.on("CreateModerationLogEntry", anyLogEntry)
+		w := httptest.NewRecorder()
+		fake.config().ResolveReport(w, asModerator("POST", "/admin/reports/x/resolve", testCase.body, moderatorID, report.ID))
+		if w.Code != 200 {
+			t.Errorf("%s: expected 200, got %d %s", testCase.name, w.Code, w.Body.String())
+			continue
+		}
+		if trace := fake.trace(); trace != testCase.trace {
+			t.Errorf("%s: expected %q, got %q", testCase.name, testCase.trace, trace)
+		}
+		if testCase.name == "warn" {
+			if to := fake.called("EnqueueMail")[0][0]; to != target.Email {
+				t.Errorf("Expected the warning to go to %s, got %v", target.Email, to)
+			}
+		}
+	}
+}
+
+func TestResolveReportOutranked(t *testing.T) {
+	moderatorID := uuid.New()
+	report := claimedReport(moderatorID)
+	resolved := report
+	resolved.Status = "resolved"
+	for _, action := range []string{actionDeleteChirp, actionWarn} {
+		fake := newFakeDB()
+		fake.on("GetReport", reportRow(report))
+		fake.on("ResolveReport", reportRow(resolved))
+		fake.on("GetUserByID", userRow(db.User{ID: report.TargetUserID, Role: auth.RoleModerator}))
+		w := httptest.NewRecorder()
+		body := `{"action":"` + action + `"}`
+		fake.config().ResolveReport(w, asModerator("POST", "/admin/reports/x/resolve", body, moderatorID, report.ID))
+		if w.Code != 403 {
+			t.Errorf("%s: expected 403, got %d %s", action, w.Code, w.Body.String())
+		}
+		if trace := fake.trace(); trace != "GetReport begin ResolveReport GetUserByID rollback" {
+			t.Errorf("%s: expected nothing to be applied, got %s", action, trace)
+		}
+	}
+}
+
+func TestListReportsUnknownStatus(t *testing.T) {
+	fake := newFakeDB()
+	w := httptest.NewRecorder()
+	fake.config().ListReports(w, httptest.NewRequest("GET", "/admin/reports?status=closed", nil))
+	if w.Code != 400 {
+		t.Errorf("Expected 400, got %d %s", w.Code, w.Body.String())
+	}
+	if trace := fake.trace(); trace != "" {
+		t.Errorf("Expected no query, got %s", trace)
+	}
+}
+
+func TestResolveReportRollsBack(t *testing.T) {
+	moderatorID := uuid.New()
+	report := claimedReport(moderatorID)
+	resolved := report
+	resolved.Status = "resolved"
+
+	// the ban is undone when it cannot be logged
+	fake := newFakeDB()
+	fake.on("GetReport", reportRow(report))
+	fake.on("ResolveReport", reportRow(resolved))
+	fake.on("GetUserByID", userRow(db.User{ID: report.TargetUserID, Role: auth.RoleUser}))
+	fake.on("SetUserStatus", userRow(db.User{ID: report.TargetUserID, Status: userStatusBanned}))
+	fake.fail("CreateModerationLogEntry", errors.New("disk full"))
+	w := httptest.NewRecorder()
+	fake.config().ResolveReport(w, asModerator("POST", "/admin/reports/x/resolve", `{"action":"ban"}`, moderatorID, report.ID))
+	if w.Code != 500 {
+		t.Fatalf("Expected 500, got %d %s", w.Code, w.Body.String())
+	}
+	if trace := fake.trace(); !strings.HasSuffix(trace, "CreateModerationLogEntry rollback") {
+		t.Errorf("Expected the ban to roll back, got %s", trace)
+	}
+
+	// another moderator's resolve got there first
+	fake = newFakeDB()
+	fake.on("GetReport", reportRow(report))
+	w = httptest.NewRecorder()
+	fake.config().ResolveReport(w, asModerator("POST", "/admin/reports/x/resolve", `{"action":"ban"}`, moderatorID, report.ID))
+	if w.Code != 409 {
+		t.Fatalf("Expected 409, got %d %s", w.Code, w.Body.String())
+	}
+	if trace := fake.trace(); trace != "GetReport begin ResolveReport rollback" {
+		t.Errorf("Expected nothing to be applied, got %s", trace)
+	}
+}
+
+func TestResolveReportNotClaimedByCaller(t *testing.T) {
+	fake := newFakeDB()
+	report := claimedReport(uuid.New())
+	fake.on("GetReport", reportRow(report))
+	w := httptest.NewRecorder()
+	fake.config().ResolveReport(w, asModerator("POST", "/admin/reports/x/resolve", `{"action":"dismiss"}`, uuid.New(), report.ID))
+	if w.Code != 409 {
+		t.Fatalf("Expected 409, got %d %s", w.Code, w.Body.String())
+	}
+	if trace := fake.trace(); trace != "GetReport" {
+		t.Errorf("Expected no changes, got %s", trace)
+	}
+}
+
+func TestClaimReportLogsInTransaction(t *testing.T) {
+	moderatorID := uuid.New()
+	fake := newFakeDB()
+	report := claimedReport(moderatorID)
+	fake.on("ClaimReport", reportRow(report))
+	fake.fail("CreateModerationLogEntry", errors.New("disk full"))
+	w := httptest.NewRecorder()
+	fake.config().ClaimReport(w, asModerator("POST", "/admin/reports/x/claim", "", moderatorID, report.ID))
+	if w.Code != 500 {
+		t.Fatalf("Expected 500, got %d %s", w.Code, w.Body.String())
+	}
+	if trace := fake.trace(); trace != "begin ClaimReport CreateModerationLogEntry rollback" {
+		t.Errorf("Expected the claim to roll back, got %s", trace)
+	}
+}
+
+func TestSetUserStatus(t *testing.T) {
+	moderatorID := uuid.New()
+	target := db.User{ID: uuid.New(), Email: "target@example.com", Status: userStatusBanned, Role: auth.RoleUser}
+
+	fake := newFakeDB()
+	fake.on("GetUserByID", userRow(target))
+	fake.on("SetUserStatus", userRow(target))
+	fake.on("CreateModerationLogEntry", anyLogEntry)
+	w := httptest.NewRecorder()
+	fake.config().SetUserStatus(w, asModerator("PUT", "/admin/users/x/status", `{"status":"banned","note":"spam ring"}`, moderatorID, target.ID))
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	if trace := fake.trace(); trace != "begin GetUserByID SetUserStatus RevokeUserRefreshTokens CreateModerationLogEntry commit" {
+		t.Errorf("Expected the ban and its log entry in one transaction, got %s", trace)
+	}
+
+	fake = newFakeDB()
+	fake.on("GetUserByID", userRow(target))
+	fake.on("SetUserStatus", userRow(target))
+	fake.fail("CreateModerationLogEntry", errors.New("disk full"))
+	w = httptest.NewRecorder()
+	fake.config().SetUserStatus(w, asModerator("PUT", "/admin/users/x/status", `{"status":"banned"}`, moderatorID, target.ID))
+	if w.Code != 500 {
+		t.Fatalf("Expected 500, got %d %s", w.Code, w.Body.String())
+	}
+	if trace := fake.trace(); !strings.HasSuffix(trace, "rollback") {
+		t.Errorf("Expected an unlogged ban to roll back, got %s", trace)
+	}
+}
+
+func TestSetUserStatusOutranked(t *testing.T) {
+	for _, role := range []string{auth.RoleModerator, auth.RoleAdmin} {
+		target := db.User{ID: uuid.New(), Email: "staff@example.com", Status: userStatusActive, Role: role}
+		fake := newFakeDB()
+		fake.on("GetUserByID", userRow(target))
+		w := httptest.NewRecorder()
+		fake.config().SetUserStatus(w, asModerator("PUT", "/admin/users/x/status", `{"status":"banned"}`, uuid.New(), target.ID))
+		if w.Code != 403 {
+			t.Errorf("%s: expected 403, got %d %s", role, w.Code, w.Body.String())
+		}
+		if trace := fake.trace(); trace != "begin GetUserByID rollback" {
+			t.Errorf("%s: expected no changes, got %s", role, trace)
+		}
+	}
+}
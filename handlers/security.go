@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/google/uuid"
+)
+
+const (
+	securityEventRefreshTokenReuse = "refresh_token_reuse"
+	securityEventPasskeyClone      = "passkey_clone_suspected"
+	securityEventOAuthCodeReuse    = "oauth_code_reuse"
+	securityEventAccountLocked     = "account_locked"
+)
+
+// recordSecurityEvent stores an event for later investigation. Failing to
+// store it never fails the request that triggered it.
+func (cfg *APIConfig) recordSecurityEvent(r *http.Request, userID uuid.UUID, kind string, details map[string]interface{}) {
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		slog.Error("Error encoding security event", "error", err, "kind", kind)
+		return
+	}
+	_, err = cfg.DBQueries.CreateSecurityEvent(r.Context(), db.CreateSecurityEventParams{
+		UserID:  uuid.NullUUID{UUID: userID, Valid: userID != uuid.Nil},
+		Kind:    kind,
+		Details: encoded,
+	})
+	if err != nil {
+		slog.Error("Error recording security event", "error", err, "kind", kind, "userID", userID)
+	}
+}
+
+// handleRefreshTokenReuse revokes every token descended from the same
+// login as a refresh token that was presented after being revoked.
+func (cfg *APIConfig) handleRefreshTokenReuse(w http.ResponseWriter, r *http.Request, token db.RefreshToken) {
+	if err := cfg.revokeReusedRefreshToken(r, token); err != nil {
+		slog.Error("Error revoking refresh token family", "error", err, "familyID", token.FamilyID)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	respondWithErrorCode(w, 401, "token_reused", "Refresh token was already used, please log in again")
+}
+
+func (cfg *APIConfig) revokeReusedRefreshToken(r *http.Request, token db.RefreshToken) error {
+	slog.Warn("Refresh token reuse detected", "userID", token.UserID, "familyID", token.FamilyID)
+	if err := cfg.DBQueries.RevokeRefreshTokenFamily(r.Context(), token.FamilyID); err != nil {
+		return err
+	}
+	cfg.recordSecurityEvent(r, token.UserID, securityEventRefreshTokenReuse, map[string]interface{}{
+		"family_id":  token.FamilyID,
+		"revoked_at": token.RevokedAt.Time,
+		"client_id":  token.ClientID.String,
+		"user_agent": r.UserAgent(),
+		"remote":     r.RemoteAddr,
+	})
+	return nil
+}
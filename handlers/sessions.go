@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/google/uuid"
+)
+
+// SessionOut describes one login, i.e. one refresh token family.
+type SessionOut struct {
+	ID         string    `json:"id"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	Current    bool      `json:"current"`
+	// ClientID is set for sessions granted to OAuth clients.
+	ClientID   string    `json:"client_id,omitempty"`
+}
+
+// HANDLERS
+
+func (cfg *APIConfig) GetSessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	sessionID, _ := r.Context().Value("sessionID").(uuid.UUID)
+	// rotation keeps exactly one live token per family, so every live
+	// token is one session
+	tokens, err := cfg.DBQueries.ListActiveRefreshTokensByUser(r.Context(), userID)
+	if err != nil {
+		slog.Error("Error listing sessions", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	sessions := []SessionOut{}
+	for _, token := range tokens {
+		sessions = append(sessions, SessionOut{
+			ID:         token.FamilyID.String(),
+			LastUsedAt: token.LastUsedAt,
+			ExpiresAt:  token.ExpiresAt,
+			UserAgent:  token.UserAgent,
+			IP:         token.Ip,
+			Current:    token.FamilyID == sessionID,
+			ClientID:   token.ClientID.String,
+		})
+	}
+	respondWithJSON(w, 200, sessions)
+}
+
+func (cfg *APIConfig) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	familyID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid session ID")
+		return
+	}
+	var revoked int64
+	err = cfg.inTx(r.Context(), func(q *db.Queries) error {
+		revoked, err = q.RevokeUserRefreshTokenFamily(r.Context(), db.RevokeUserRefreshTokenFamilyParams{
+			FamilyID: familyID,
+			UserID:   userID,
+		})
+		if err != nil || revoked == 0 {
+			return err
+		}
+		return deleteEndedGrantWebhooks(r.Context(), q, userID, familyID)
+	})
+	if err != nil {
+		slog.Error("Error revoking session", "error", err)
+		respondWithError(w, 500, "Could not revoke session")
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, 404, "Session not found")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// DeleteOtherSessions logs the user out everywhere except the session the
+// request was made from.
+func (cfg *APIConfig) DeleteOtherSessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	sessionID, _ := r.Context().Value("sessionID").(uuid.UUID)
+	if sessionID == uuid.Nil {
+		// with no session to keep, every session would be revoked
+		respondWithError(w, 400, "Personal access tokens have no session to keep")
+		return
+	}
+	if err := cfg.revokeOtherSessions(r, userID, sessionID); err != nil {
+		slog.Error("Error revoking other sessions", "error", err)
+		respondWithError(w, 500, "Could not revoke sessions")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// Logout ends the current session: the access token used for the request
+// stops working right away and the session's refresh token is revoked.
+func (cfg *APIConfig) Logout(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	sessionID, _ := r.Context().Value("sessionID").(uuid.UUID)
+	claims := r.Context().Value("claims").(*auth.Claims)
+	if err := cfg.revokeAccessToken(r, claims); err != nil {
+		slog.Error("Error revoking access token", "error", err)
+		respondWithError(w, 500, "Could not log out")
+		return
+	}
+	if sessionID != uuid.Nil {
+		_, err := cfg.DBQueries.RevokeUserRefreshTokenFamily(r.Context(), db.RevokeUserRefreshTokenFamilyParams{
+			FamilyID: sessionID,
+			UserID:   userID,
+		})
+		if err != nil {
+			slog.Error("Error revoking session", "error", err)
+			respondWithError(w, 500, "Could not log out")
+			return
+		}
+	}
+	w.WriteHeader(204)
+}
+
+// HELPERS
+
+// revokeAccessToken records the token's jti until the validator would
+// reject the token as expired anyway, and drops records past that point.
+func (cfg *APIConfig) revokeAccessToken(r *http.Request, claims *auth.Claims) error {
+	jti, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return err
+	}
+	err = cfg.DBQueries.RevokeAccessToken(r.Context(), db.RevokeAccessTokenParams{
+		Jti:       jti,
+		ExpiresAt: claims.ExpiresAt.Time.Add(cfg.Validator.Leeway),
+	})
+	if err != nil {
+		return err
+	}
+	return cfg.DBQueries.DeleteExpiredRevokedAccessTokens(r.Context())
+}
+
+func (cfg *APIConfig) revokeOtherSessions(r *http.Request, userID uuid.UUID, keep uuid.UUID) error {
+	return cfg.DBQueries.RevokeOtherRefreshTokenFamilies(r.Context(), db.RevokeOtherRefreshTokenFamiliesParams{
+		UserID:   userID,
+		FamilyID: keep,
+	})
+}
+
+// clientIP is the address of the peer that sent the request.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
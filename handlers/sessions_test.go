@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestAuthenticateEndedSession(t *testing.T) {
+	key, err := auth.GenerateSigningKey(auth.AlgEdDSA)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	user := db.User{ID: uuid.New(), Email: "user@example.com", Status: userStatusActive, Role: auth.RoleUser}
+	sessionID := uuid.New()
+
+	for _, live := range []bool{true, false} {
+		fake := newFakeDB()
+		fake.on("GetUserByID", userRow(user))
+		fake.on("RefreshTokenFamilyActive", []driver.Value{live})
+		cfg := fake.config()
+		cfg.Keys = auth.NewKeyring("")
+		cfg.Keys.Set([]auth.SigningKey{key})
+		cfg.Validator = auth.NewValidator(cfg.Keys)
+		token, err := auth.MakeJWTWithMFA(cfg.Keys, user.ID, user.Role, sessionID, time.Time{}, time.Hour)
+		if err != nil {
+			t.Fatalf("Error making token: %v", err)
+		}
+		req := httptest.NewRequest("GET", "/api/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		authenticated, _ := cfg.Authenticate(w, req)
+		if live && authenticated.ID != user.ID {
+			t.Errorf("Expected a live session to authenticate, got %d %s", w.Code, w.Body.String())
+		}
+		if !live && (authenticated.ID != uuid.Nil || w.Code != 401 || !strings.Contains(w.Body.String(), "session_revoked")) {
+			t.Errorf("Expected 401 session_revoked for an ended session, got %d %s", w.Code, w.Body.String())
+		}
+		if args := fake.called("RefreshTokenFamilyActive"); len(args) != 1 || args[0][0] != sessionID.String() {
+			t.Errorf("Expected the token's session to be checked, got %v", args)
+		}
+	}
+}
+
+func TestDeleteOtherSessionsWithoutSession(t *testing.T) {
+	fake := newFakeDB()
+	req := httptest.NewRequest("DELETE", "/api/sessions", nil)
+	ctx := context.WithValue(req.Context(), "userID", uuid.New())
+	ctx = context.WithValue(ctx, "sessionID", uuid.Nil)
+	w := httptest.NewRecorder()
+	fake.config().DeleteOtherSessions(w, req.WithContext(ctx))
+	if w.Code != 400 {
+		t.Fatalf("Expected 400, got %d %s", w.Code, w.Body.String())
+	}
+	if trace := fake.trace(); trace != "" {
+		t.Errorf("Expected no sessions to be revoked, got %s", trace)
+	}
+}
+
+func TestIssuedBeforeReset(t *testing.T) {
+	reset := time.Date(2026, 3, 1, 12, 0, 0, 500_000_000, time.UTC)
+	testCases := []struct {
+		name     string
+		changed  sql.NullTime
+		issuedAt time.Time
+		expected bool
+	}{
+		{"never reset", sql.NullTime{}, reset.Add(-time.Hour), false},
+		{"issued before", sql.NullTime{Time: reset, Valid: true}, reset.Add(-time.Minute), true},
+		{"issued the same second", sql.NullTime{Time: reset, Valid: true}, reset.Truncate(time.Second), false},
+		{"issued after", sql.NullTime{Time: reset, Valid: true}, reset.Add(time.Minute), false},
+	}
+	for _, testCase := range testCases {
+		claims := &auth.Claims{}
+		claims.IssuedAt = jwt.NewNumericDate(testCase.issuedAt)
+		if got := issuedBeforeReset(db.User{PasswordChangedAt: testCase.changed}, claims); got != testCase.expected {
+			t.Errorf("%s: expected %v, got %v", testCase.name, testCase.expected, got)
+		}
+	}
+}
+
+func TestDeleteSessionDeletesAppWebhooks(t *testing.T) {
+	userID, familyID := uuid.New(), uuid.New()
+	fake := newFakeDB()
+	req := httptest.NewRequest("DELETE", "/api/sessions/x", nil)
+	req.SetPathValue("id", familyID.String())
+	req = req.WithContext(context.WithValue(req.Context(), "userID", userID))
+	w := httptest.NewRecorder()
+	fake.config().DeleteSession(w, req)
+	if w.Code != 204 {
+		t.Fatalf("Expected 204, got %d %s", w.Code, w.Body.String())
+	}
+	if trace := fake.trace(); trace != "begin RevokeUserRefreshTokenFamily DeleteEndedGrantWebhookEndpoints commit" {
+		t.Errorf("Expected the grant's webhooks to go with it, got %s", trace)
+	}
+	if args := fake.called("DeleteEndedGrantWebhookEndpoints")[0]; args[0] != userID.String() || args[1] != familyID.String() {
+		t.Errorf("Unexpected arguments %v", args)
+	}
+}
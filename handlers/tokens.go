@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	maxPersonalTokens        = 50
+	maxPersonalTokenDays     = 366
+	maxPersonalTokenIPRanges = 20
+)
+
+type PersonalTokenIn struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresInDays of 0 makes a token that never expires.
+	ExpiresInDays int      `json:"expires_in_days"`
+	AllowedIPs    []string `json:"allowed_ips"`
+}
+
+type PersonalTokenOut struct {
+	ID         string     `json:"id"`
+	Token      string     `json:"token,omitempty"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// HANDLERS
+
+// CreatePersonalToken makes a long-lived token for scripts. The token is
+// only ever shown in this response.
+func (cfg *APIConfig) CreatePersonalToken(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	reqToken := PersonalTokenIn{}
+	if err := json.NewDecoder(r.Body).Decode(&reqToken); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	reqToken.Name = strings.TrimSpace(reqToken.Name)
+	if reqToken.Name == "" || len(reqToken.Name) > 100 {
+		respondWithError(w, 400, "Name is required and at most 100 characters")
+		return
+	}
+	scopes, err := auth.ParseScopes(strings.Join(reqToken.Scopes, " "))
+	if err != nil || len(scopes) == 0 {
+		respondWithError(w, 400, "Scopes must be known and not empty")
+		return
+	}
+	if reqToken.ExpiresInDays < 0 || reqToken.ExpiresInDays > maxPersonalTokenDays {
+		respondWithError(w, 400, fmt.Sprintf("expires_in_days must be between 0 and %d", maxPersonalTokenDays))
+		return
+	}
+	if len(reqToken.AllowedIPs) > maxPersonalTokenIPRanges {
+		respondWithError(w, 400, fmt.Sprintf("At most %d allowed IPs", maxPersonalTokenIPRanges))
+		return
+	}
+	allowedIPs, err := auth.ParseIPAllowlist(reqToken.AllowedIPs)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if !cfg.requireRecentMFA(w, r) { return }
+
+	count, err := cfg.DBQueries.CountPersonalAccessTokens(r.Context(), userID)
+	if err != nil {
+		slog.Error("Error counting personal access tokens", "error", err)
+		respondWithError(w, 500, "Could not create token")
+		return
+	}
+	if count >= maxPersonalTokens {
+		respondWithError(w, 409, fmt.Sprintf("You already have %d tokens, delete one first", maxPersonalTokens))
+		return
+	}
+	allowedIPsJSON, err := json.Marshal(allowedIPs)
+	if err != nil {
+		respondWithError(w, 500, "Could not create token")
+		return
+	}
+	expiresAt := sql.NullTime{}
+	if reqToken.ExpiresInDays > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().AddDate(0, 0, reqToken.ExpiresInDays), Valid: true}
+	}
+	token, err := auth.MakePAT()
+	if err != nil {
+		slog.Error("Error making personal access token", "error", err)
+		respondWithError(w, 500, "Could not create token")
+		return
+	}
+	pat, err := cfg.DBQueries.CreatePersonalAccessToken(r.Context(), db.CreatePersonalAccessTokenParams{
+		UserID:     userID,
+		Name:       reqToken.Name,
+		TokenHash:  auth.HashRefreshToken(token, cfg.RefreshTokenPepper),
+		Scopes:     strings.Join(scopes, " "),
+		AllowedIps: allowedIPsJSON,
+		ExpiresAt:  expiresAt,
+	})
+	if err != nil {
+		slog.Error("Error creating personal access token", "error", err)
+		respondWithError(w, 500, "Could not create token")
+		return
+	}
+	slog.Info("Created personal access token", "userID", userID, "tokenID", pat.ID, "scopes", pat.Scopes)
+	out := personalTokenOut(pat)
+	out.Token = token
+	respondWithJSON(w, 201, out)
+}
+
+func (cfg *APIConfig) ListPersonalTokens(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	pats, err := cfg.DBQueries.ListPersonalAccessTokens(r.Context(), userID)
+	if err != nil {
+		slog.Error("Error listing personal access tokens", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	out := []PersonalTokenOut{}
+	for _, pat := range pats {
+		out = append(out, personalTokenOut(pat))
+	}
+	respondWithJSON(w, 200, out)
+}
+
+// DeletePersonalToken revokes a token. It stops working immediately.
+func (cfg *APIConfig) DeletePersonalToken(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	tokenID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, 404, "Token not found")
+		return
+	}
+	deleted, err := cfg.DBQueries.DeletePersonalAccessToken(r.Context(), db.DeletePersonalAccessTokenParams{
+		ID:     tokenID,
+		UserID: userID,
+	})
+	if err != nil {
+		slog.Error("Error deleting personal access token", "error", err)
+		respondWithError(w, 500, "Could not delete token")
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, 404, "Token not found")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// HELPERS
+
+// validatePersonalToken looks up a personal access token and returns
+// claims for it, limited to its scopes like an OAuth client's.
+func (cfg *APIConfig) validatePersonalToken(r *http.Request, token string) (*auth.Claims, error) {
+	pat, err := cfg.DBQueries.GetPersonalAccessTokenByHash(r.Context(), auth.HashRefreshToken(token, cfg.RefreshTokenPepper))
+	if err == sql.ErrNoRows {
+		// deleted tokens are gone from the table
+		return nil, auth.ErrTokenRevoked
+	}
+	if err != nil {
+		return nil, err
+	}
+	if pat.ExpiresAt.Valid && time.Now().After(pat.ExpiresAt.Time) {
+		return nil, auth.ErrTokenExpired
+	}
+	allowedIPs, err := personalTokenIPs(pat)
+	if err != nil {
+		return nil, err
+	}
+	ip := clientIP(r)
+	if !auth.IPAllowed(ip, allowedIPs) {
+		return nil, fmt.Errorf("%w: %s", auth.ErrTokenIPNotAllowed, ip)
+	}
+	err = cfg.DBQueries.TouchPersonalAccessToken(r.Context(), db.TouchPersonalAccessTokenParams{
+		ID:         pat.ID,
+		LastUsedIp: ip,
+	})
+	if err != nil {
+		// not worth failing the request over
+		slog.Error("Error recording personal access token use", "error", err, "tokenID", pat.ID)
+	}
+	claims := &auth.Claims{
+		Scope:           pat.Scopes,
+		PersonalTokenID: pat.ID.String(),
+	}
+	claims.Subject = pat.UserID.String()
+	claims.IssuedAt = jwt.NewNumericDate(pat.CreatedAt)
+	return claims, nil
+}
+
+func personalTokenOut(pat db.PersonalAccessToken) PersonalTokenOut {
+	allowedIPs, err := personalTokenIPs(pat)
+	if err != nil {
+		slog.Error("Error decoding allowed IPs", "error", err, "tokenID", pat.ID)
+	}
+	out := PersonalTokenOut{
+		ID:         pat.ID.String(),
+		Name:       pat.Name,
+		Scopes:     strings.Fields(pat.Scopes),
+		AllowedIPs: allowedIPs,
+		LastUsedIP: pat.LastUsedIp,
+		CreatedAt:  pat.CreatedAt,
+	}
+	if pat.ExpiresAt.Valid {
+		out.ExpiresAt = &pat.ExpiresAt.Time
+	}
+	if pat.LastUsedAt.Valid {
+		out.LastUsedAt = &pat.LastUsedAt.Time
+	}
+	return out
+}
+
+func personalTokenIPs(pat db.PersonalAccessToken) ([]string, error) {
+	prefixes := []string{}
+	err := json.Unmarshal(pat.AllowedIps, &prefixes)
+	return prefixes, err
+}
@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"os"
@@ -11,6 +13,7 @@ import (
 
 	"github.com/eliza-guseva/chirpy-server/internal/auth"
 	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/entitlements"
 	"github.com/google/uuid"
 )
 
@@ -27,16 +30,22 @@ type UserOut struct {
 	Token     string    `json:"token"`
 	RefreshToken string `json:"refresh_token"`
 	IsChirpyRed bool    `json:"is_chirpy_red"`
+	Role      string    `json:"role"`
+	EmailVerified bool  `json:"email_verified"`
+	Entitlements entitlements.Entitlements `json:"entitlements"`
 }
 
-
-type PolkaIn struct {
-	Event string `json:"event"`
-	Data struct {
-		UserID string `json:"user_id"`
-	} `json:"data"`
+type UserRoleIn struct {
+	Role string `json:"role"`
 }
 
+
+const (
+	userStatusActive    = "active"
+	userStatusSuspended = "suspended"
+	userStatusBanned    = "banned"
+)
+
 // HANDLERS
 
 func (cfg *APIConfig) CreateUser(w http.ResponseWriter, r *http.Request) {
@@ -52,6 +61,7 @@ func (cfg *APIConfig) CreateUser(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, 400, "Email must contain @")
 		return
 	}
+	if !cfg.checkPassword(w, reqUser.Password, reqUser.Email) { return }
 
 	hashedPassword, err := auth.HashPassword(reqUser.Password)
 	if err != nil {
@@ -60,13 +70,18 @@ func (cfg *APIConfig) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	user, err := cfg.DBQueries.CreateUser(
-		r.Context(), 
-		db.CreateUserParams{
-			Email: reqUser.Email, 
-			HashedPassword: hashedPassword,
-		},
-	)
+	var user db.User
+	err = cfg.inTx(r.Context(), func(q *db.Queries) error {
+		user, err = q.CreateUser(
+			r.Context(), 
+			db.CreateUserParams{
+				Email: reqUser.Email, 
+				HashedPassword: hashedPassword,
+			},
+		)
+		if err != nil { return err }
+		return cfg.queueVerificationEmail(r.Context(), q, user)
+	})
 	if err != nil {
 		slog.Error("Error creating user", "error", err, "email", reqUser.Email)
 		respondWithError(w, 500, "Could not create user")
@@ -78,6 +93,9 @@ func (cfg *APIConfig) CreateUser(w http.ResponseWriter, r *http.Request) {
 		UpdatedAt: user.UpdatedAt,
 		Email:     user.Email,
 		IsChirpyRed: user.IsChirpyRed,
+		Role: user.Role,
+		EmailVerified: user.VerifiedAt.Valid,
+		Entitlements: entitlements.For(user),
 	}
 	respondWithJSON(w, 201, userOut)
 }
@@ -98,35 +116,106 @@ func (cfg *APIConfig) ResetUsers(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, 200, map[string]string{"message": "Users reset, Hits reset"})
 }
 
-func (cfg *APIConfig) Login(w http.ResponseWriter, r *http.Request) {
-	reqUser, err := getRequestUser(w, r)
-	if err != nil { return }
+// refreshTokenRotated is the revoked_reason of refresh tokens exchanged
+// for a new one. Only those mean a leak when presented again; logging out
+// or revoking a session revokes with another reason.
+const refreshTokenRotated = "rotated"
 
-	user, err := cfg.getUser(w, r, reqUser)
-	if err != nil { return }
-	
-	jwtToken, err := createTokenWithExp(user.ID, w)
+// errRefreshTokenRotated means another request rotated the refresh token
+// first.
+var errRefreshTokenRotated = errors.New("refresh token already rotated")
+
+// errLastAdmin stops SetUserRole from leaving nobody able to manage roles.
+var errLastAdmin = errors.New("cannot demote the last admin")
+
+// SetUserRole lets admins promote or demote users.
+func (cfg *APIConfig) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("id"))
 	if err != nil {
-		slog.Error("Error creating token", "error", err)
-		respondWithError(w, 500, "Could not create token")
+		respondWithError(w, 400, "Invalid user ID")
 		return
 	}
-	refreshToken, err := cfg.setRefreshToken(w, r, user)
-	if err != nil { return }
-	
-
+	decoder := json.NewDecoder(r.Body)
+	reqRole := UserRoleIn{}
+	if err := decoder.Decode(&reqRole); err != nil {
+		slog.Error("Error decoding request", "error", err)
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	if !auth.ValidRole(reqRole.Role) {
+		respondWithError(w, 400, "Unknown role")
+		return
+	}
+	// locking the admins makes two admins demoting each other wait their
+	// turn, so one of them stays
+	var user db.User
+	err = cfg.inTx(r.Context(), func(q *db.Queries) error {
+		admins, err := q.LockUsersWithRole(r.Context(), auth.RoleAdmin)
+		if err != nil {
+			return err
+		}
+		if reqRole.Role != auth.RoleAdmin && len(admins) == 1 && admins[0] == userID {
+			return errLastAdmin
+		}
+		user, err = q.SetUserRole(r.Context(), db.SetUserRoleParams{
+			ID:   userID,
+			Role: reqRole.Role,
+		})
+		return err
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, 404, "User not found")
+			return
+		}
+		if err == errLastAdmin {
+			respondWithError(w, 409, "Cannot demote the last admin")
+			return
+		}
+		slog.Error("Error setting user role", "error", err)
+		respondWithError(w, 500, "Could not set user role")
+		return
+	}
+	slog.Info("User role changed", "userID", user.ID, "role", user.Role, "by", r.Context().Value("userID"))
 	respondWithJSON(w, 200, UserOut{
-		ID:        user.ID.String(),
+		ID: user.ID.String(),
 		CreatedAt: user.CreatedAt,
 		UpdatedAt: user.UpdatedAt,
-		Email:     user.Email,
-		Token:     jwtToken,
-		RefreshToken: refreshToken,
+		Email: user.Email,
 		IsChirpyRed: user.IsChirpyRed,
+		Role: user.Role,
+		EmailVerified: user.VerifiedAt.Valid,
+		Entitlements: entitlements.For(user),
 	})
 }
 
+func (cfg *APIConfig) Login(w http.ResponseWriter, r *http.Request) {
+	reqUser, err := getRequestUser(w, r)
+	if err != nil { return }
+
+	user, err := cfg.getUser(w, r, reqUser)
+	if err != nil { return }
+	if !checkAccountActive(w, user) { return }
+
+	mfaEnabled, err := cfg.secondFactorEnabled(r, user.ID)
+	if err != nil {
+		slog.Error("Error checking two-factor authentication", "error", err)
+		respondWithError(w, 500, "Could not log in")
+		return
+	}
+	if mfaEnabled {
+		cfg.startMFAChallenge(w, r, user)
+		return
+	}
+	cfg.recordLoginSuccess(r, user.Email)
+	cfg.startSession(w, r, user, time.Time{})
+}
+
 
+// RefreshJWT exchanges a refresh token for a new access token and a new
+// refresh token in the same family. The presented token is revoked as
+// rotated, so seeing it again means it leaked: the whole family is then
+// revoked.
 func (cfg *APIConfig) RefreshJWT(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -134,15 +223,61 @@ func (cfg *APIConfig) RefreshJWT(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, 401, "Could not get bearer token")
 		return
 	}
-	dbToken, err := cfg.DBQueries.GetRefreshToken(r.Context(), refreshToken)
+	dbToken, err := cfg.lookupRefreshToken(r, refreshToken)
+	if err == nil && dbToken.ClientID.Valid {
+		// OAuth clients refresh at /oauth/token and keep their scopes
+		err = sql.ErrNoRows
+	}
 	if err != nil {
 		slog.Error("Error getting refresh token", "error", err)
 		respondWithError(w, 401, "Could not get refresh token")
 		return
 	}
-	jwtToken, err := createTokenWithExp(dbToken.UserID, w)
+	if dbToken.RevokedAt.Valid {
+		if dbToken.RevokedReason == refreshTokenRotated {
+			cfg.handleRefreshTokenReuse(w, r, dbToken)
+			return
+		}
+		respondWithErrorCode(w, 401, "token_revoked", "Refresh token was revoked")
+		return
+	}
+	if time.Now().After(dbToken.ExpiresAt) {
+		respondWithErrorCode(w, 401, "token_expired", "Refresh token expired")
+		return
+	}
+	user, err := cfg.DBQueries.GetUserByID(r.Context(), dbToken.UserID)
+	if err != nil {
+		slog.Error("Error getting user", "error", err)
+		respondWithError(w, 401, "Could not get refresh token")
+		return
+	}
+	if !checkAccountActive(w, user) { return }
+
+	// the new token exists only if the old one was rotated
+	var newRefreshToken string
+	err = cfg.inTx(r.Context(), func(q *db.Queries) error {
+		newRefreshToken, err = rotateRefreshToken(r, q, cfg.RefreshTokenPepper, dbToken, db.CreateRefreshTokenParams{
+			UserID:   user.ID,
+			FamilyID: dbToken.FamilyID,
+		})
+		return err
+	})
+	if err == errRefreshTokenRotated {
+		// another request rotated it between our read and write
+		cfg.handleRefreshTokenReuse(w, r, dbToken)
+		return
+	}
+	if err != nil {
+		slog.Error("Error rotating refresh token", "error", err)
+		respondWithError(w, 500, "Could not rotate refresh token")
+		return
+	}
+	jwtToken, err := cfg.createTokenWithExp(user, dbToken.FamilyID, time.Time{}, w)
 	if err != nil { return }
-	respondWithJSON(w, 200, map[string]string{"token": jwtToken})
+	respondWithJSON(w, 200, map[string]string{
+		"token": jwtToken,
+		"refresh_token": newRefreshToken,
+	})
 }
 
 func (cfg *APIConfig) RevokeRT(w http.ResponseWriter, r *http.Request) {
@@ -152,17 +287,30 @@ func (cfg *APIConfig) RevokeRT(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, 401, "Could not get bearer token")
 		return
 	}
-	cfg.DBQueries.ExpireRefreshToken(r.Context(), refreshToken)
+	dbToken, err := cfg.lookupRefreshToken(r, refreshToken)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("Error getting refresh token", "error", err)
+			respondWithError(w, 500, "Could not revoke refresh token")
+			return
+		}
+		w.WriteHeader(204)
+		return
+	}
+	if err := cfg.DBQueries.ExpireRefreshToken(r.Context(), dbToken.TokenHash); err != nil {
+		slog.Error("Error revoking refresh token", "error", err)
+		respondWithError(w, 500, "Could not revoke refresh token")
+		return
+	}
 	w.WriteHeader(204)
 
 }
 
 func (cfg *APIConfig) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	authUserID := r.Context().Value("userID").(uuid.UUID)
-	slog.Info("Auth user ID", "authUserID", authUserID)
 	reqUser, err := getRequestUser(w, r)
-	slog.Info("Request user", "reqUser", reqUser)
 	if err != nil { return }
+	if !cfg.requireRecentMFA(w, r) { return }
 
 	dbUser, err := cfg.DBQueries.GetUserByID(r.Context(), authUserID)
 	if err != nil { 
@@ -175,6 +323,9 @@ func (cfg *APIConfig) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, 401, "Unauthorized")
 		return
 	}
+	passwordChanged := auth.CheckPasswordHash(reqUser.Password, dbUser.HashedPassword) != nil
+	// an unchanged password is not held to rules added after it was set
+	if passwordChanged && !cfg.checkPassword(w, reqUser.Password, reqUser.Email) { return }
 	hashedPassword, err := auth.HashPassword(reqUser.Password)
 	if err != nil {
 		slog.Error("Error hashing password", "error", err)
@@ -182,17 +333,36 @@ func (cfg *APIConfig) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	user, err := cfg.DBQueries.UpdateUser(r.Context(), db.UpdateUserParams{
-		ID: dbUser.ID,
-		Email: reqUser.Email,
-		HashedPassword: hashedPassword,
+	var user db.User
+	err = cfg.inTx(r.Context(), func(q *db.Queries) error {
+		user, err = q.UpdateUser(r.Context(), db.UpdateUserParams{
+			ID: dbUser.ID,
+			Email: reqUser.Email,
+			HashedPassword: hashedPassword,
+		})
+		if err != nil { return err }
+		// personal access tokens are not tied to a session, so a new
+		// password would not otherwise stop them
+		if passwordChanged {
+			if err := q.DeleteUserPersonalAccessTokens(r.Context(), user.ID); err != nil { return err }
+		}
+		// a new address has to be verified again
+		if user.Email == dbUser.Email { return nil }
+		return cfg.queueVerificationEmail(r.Context(), q, user)
 	})
-	
 	if err != nil {
 		slog.Error("Error updating user", "error", err)
 		respondWithError(w, 500, "Could not update user")
 		return
 	}
+	if passwordChanged {
+		sessionID, _ := r.Context().Value("sessionID").(uuid.UUID)
+		if err := cfg.revokeOtherSessions(r, user.ID, sessionID); err != nil {
+			slog.Error("Error revoking other sessions", "error", err)
+			respondWithError(w, 500, "Could not revoke other sessions")
+			return
+		}
+	}
 	
 	respondWithJSON(w, 200, UserOut{
 		ID: user.ID.String(),
@@ -200,54 +370,94 @@ func (cfg *APIConfig) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		UpdatedAt: user.UpdatedAt,
 		Email: user.Email,
 		IsChirpyRed: user.IsChirpyRed,
+		Role: user.Role,
+		EmailVerified: user.VerifiedAt.Valid,
+		Entitlements: entitlements.For(user),
 	})
 }
 
-func (cfg *APIConfig) UpradeUserPolka(w http.ResponseWriter, r *http.Request) {
-	apiKey, err := auth.GetAPIKey(r.Header)
+// GetMe returns the authenticated user's account.
+func (cfg *APIConfig) GetMe(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	user, err := cfg.DBQueries.GetUserByID(r.Context(), userID)
 	if err != nil {
-		slog.Error("Error getting API key", "error", err)
-		respondWithError(w, 401, "Unauthorized")
+		slog.Error("Error getting user", "error", err)
+		respondWithError(w, 500, "Could not get user")
 		return
 	}
-	if apiKey != cfg.PolkaKey {
-		slog.Error("API key mismatch", "apiKey", apiKey, "cfg.PolkaKey", cfg.PolkaKey)
-		respondWithError(w, 401, "Unauthorized")
-		return
+	respondWithJSON(w, 200, UserOut{
+		ID: user.ID.String(),
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+		Email: user.Email,
+		IsChirpyRed: user.IsChirpyRed,
+		Role: user.Role,
+		EmailVerified: user.VerifiedAt.Valid,
+		Entitlements: entitlements.For(user),
+	})
+}
+
+// HELPERS
+
+// accountState returns the user's status at now. A suspension that has
+// run out counts as active.
+func accountState(user db.User, now time.Time) string {
+	if user.Status == userStatusSuspended && user.SuspendedUntil.Valid && !user.SuspendedUntil.Time.After(now) {
+		return userStatusActive
 	}
+	return user.Status
+}
 
-	decoder := json.NewDecoder(r.Body)
-	event := PolkaIn{}
-	err = decoder.Decode(&event)
-	slog.Info("Event", "event", event)
-	if 	err != nil {
-		respondWithError(w, 400, "Invalid request body")
-		return
+// checkAccountActive responds with 403 and returns false unless the user
+// may use the API.
+func checkAccountActive(w http.ResponseWriter, user db.User) bool {
+	switch accountState(user, time.Now()) {
+	case userStatusActive:
+		return true
+	case userStatusSuspended:
+		slog.Info("Suspended user rejected", "userID", user.ID, "until", user.SuspendedUntil.Time)
+		respondWithErrorCode(w, 403, "account_suspended",
+			"Account suspended until "+user.SuspendedUntil.Time.UTC().Format(time.RFC3339))
+		return false
+	case userStatusBanned:
+		slog.Info("Banned user rejected", "userID", user.ID)
+		respondWithErrorCode(w, 403, "account_banned", "Account banned")
+		return false
+	default:
+		slog.Error("Unknown account status", "userID", user.ID, "status", user.Status)
+		respondWithError(w, 500, "Something went wrong")
+		return false
 	}
-	if event.Event != "user.upgraded" {
-		slog.Info("Event NOT user.upgraded", "event", event)
-		w.WriteHeader(204)
-		return
+}
+
+// setAccountStatus changes the user's status and, unless the account is
+// being reinstated, revokes every refresh token so no new access tokens
+// can be minted.
+func setAccountStatus(ctx context.Context, q *db.Queries, userID uuid.UUID, status string, until time.Time) (db.User, error) {
+	suspendedUntil := sql.NullTime{}
+	if status == userStatusSuspended {
+		suspendedUntil = sql.NullTime{Time: until, Valid: true}
 	}
-	slog.Info("Event IS user.upgraded", "event", event)
-	_, err = cfg.DBQueries.UpgradeUser(r.Context(),uuid.MustParse(event.Data.UserID))
+	user, err := q.SetUserStatus(ctx, db.SetUserStatusParams{
+		ID:             userID,
+		Status:         status,
+		SuspendedUntil: suspendedUntil,
+	})
 	if err != nil {
-		if err == sql.ErrNoRows {
-			respondWithError(w, 404, "User not found")
-			return
+		return db.User{}, err
+	}
+	if status != userStatusActive {
+		if err := q.RevokeUserRefreshTokens(ctx, userID); err != nil {
+			return db.User{}, err
 		}
-		slog.Error("Error upgrading user", "error", err)
-		respondWithError(w, 500, "Could not upgrade user")
-		return
 	}
-	w.WriteHeader(204)
-	return
+	return user, nil
 }
 
-// HELPERS
-
-func createTokenWithExp(userID uuid.UUID, w http.ResponseWriter) (string, error) {
-	jwtToken, err := auth.MakeJWT(userID, os.Getenv("JWT_SECRET"), time.Hour)
+// createTokenWithExp signs an hour-long access token. mfaAt is when the
+// user last passed a second factor, or zero.
+func (cfg *APIConfig) createTokenWithExp(user db.User, sessionID uuid.UUID, mfaAt time.Time, w http.ResponseWriter) (string, error) {
+	jwtToken, err := auth.MakeJWTWithMFA(cfg.Keys, user.ID, user.Role, sessionID, mfaAt, time.Hour)
 	if err != nil {
 		slog.Error("Error creating token", "error", err)
 		respondWithError(w, 500, "Could not create token")
@@ -261,7 +471,7 @@ func getRequestUser(w http.ResponseWriter, r *http.Request) (UserIn, error) {
 	reqUser := UserIn{}
 	err := decoder.Decode(&reqUser)
 	if err != nil {
-		slog.Error("Error decoding request: %s", "error", err)
+		slog.Error("Error decoding request", "error", err)
 		respondWithError(w, 400, "Could not decode request")
 		return UserIn{}, err	
 	 }
@@ -279,10 +489,13 @@ func (cfg *APIConfig) getUser(
 	r *http.Request, 
 	reqUser UserIn,
 ) (db.User, error) {
+	if !cfg.checkLoginAllowed(w, r, reqUser.Email) {
+		return db.User{}, errLoginThrottled
+	}
 	user, err := cfg.DBQueries.GetUser(r.Context(), reqUser.Email)
-	slog.Info("User", "email", reqUser.Email, "user", user)
 	if err != nil {
 		if err == sql.ErrNoRows {
+			cfg.recordLoginFailure(r, reqUser.Email)
 			respondWithError(w, 401, "Incorrect email or password")
 			return db.User{}, err
 		}
@@ -291,32 +504,146 @@ func (cfg *APIConfig) getUser(
 		return db.User{}, err
 	}
 	if err := auth.CheckPasswordHash(reqUser.Password, user.HashedPassword); err != nil { 
-		slog.Error("Error checking password", "error", err)
+		slog.Info("Wrong password", "userID", user.ID, "error", err)
+		cfg.recordLoginFailure(r, reqUser.Email)
 		respondWithError(w, 401, "Incorrect email or password")
 		return db.User{}, err
 	}
+	cfg.upgradePasswordHash(r, &user, reqUser.Password)
 	return user, nil
 }
 
+// upgradePasswordHash rehashes a password that just verified when its
+// stored hash uses an old algorithm or parameters. Failing to upgrade
+// does not fail the login.
+func (cfg *APIConfig) upgradePasswordHash(r *http.Request, user *db.User, password string) {
+	if !auth.PasswordNeedsRehash(user.HashedPassword) {
+		return
+	}
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		slog.Error("Error rehashing password", "error", err, "userID", user.ID)
+		return
+	}
+	// only replaces the hash we checked, so a password changed since is
+	// not put back
+	updated, err := cfg.DBQueries.SetUserPassword(r.Context(), db.SetUserPasswordParams{
+		ID:                user.ID,
+		HashedPassword:    hashedPassword,
+		OldHashedPassword: user.HashedPassword,
+	})
+	if err != nil {
+		slog.Error("Error saving rehashed password", "error", err, "userID", user.ID)
+		return
+	}
+	if updated == 0 {
+		slog.Info("Password changed before its hash was upgraded", "userID", user.ID)
+		return
+	}
+	slog.Info("Upgraded password hash", "userID", user.ID)
+	user.HashedPassword = hashedPassword
+}
 
-func (cfg *APIConfig) setRefreshToken(w http.ResponseWriter, r *http.Request, user db.User) (string, error) {
-	refreshToken, err := auth.MakeRefreshToken()
+
+// lookupRefreshToken finds a refresh token by its keyed hash. Tokens stored
+// before we hashed them are found by their raw value and hashed in place.
+func (cfg *APIConfig) lookupRefreshToken(r *http.Request, refreshToken string) (db.RefreshToken, error) {
+	hash := auth.HashRefreshToken(refreshToken, cfg.RefreshTokenPepper)
+	dbToken, err := cfg.DBQueries.GetRefreshToken(r.Context(), hash)
+	if err == sql.ErrNoRows {
+		dbToken, err = cfg.DBQueries.GetLegacyRefreshToken(r.Context(), refreshToken)
+		if err != nil {
+			return db.RefreshToken{}, err
+		}
+		err = cfg.DBQueries.HashLegacyRefreshToken(r.Context(), db.HashLegacyRefreshTokenParams{
+			NewHash:  hash,
+			RawToken: refreshToken,
+		})
+		if err != nil {
+			return db.RefreshToken{}, err
+		}
+		slog.Info("Hashed legacy refresh token", "userID", dbToken.UserID)
+		dbToken.TokenHash = hash
+		dbToken.Hashed = true
+	}
+	if err != nil {
+		return db.RefreshToken{}, err
+	}
+	if !auth.CheckRefreshTokenHash(refreshToken, dbToken.TokenHash, cfg.RefreshTokenPepper) {
+		return db.RefreshToken{}, sql.ErrNoRows
+	}
+	return dbToken, nil
+}
+
+// startSession logs the user in: it starts a new refresh token family and
+// responds with the user, an access token and a refresh token. mfaAt is
+// when the user passed a second factor for this login, or zero.
+func (cfg *APIConfig) startSession(w http.ResponseWriter, r *http.Request, user db.User, mfaAt time.Time) {
+	sessionID := uuid.New()
+	refreshToken, err := cfg.issueRefreshToken(w, r, user.ID, sessionID, sql.NullString{})
+	if err != nil { return }
+	jwtToken, err := cfg.createTokenWithExp(user, sessionID, mfaAt, w)
+	if err != nil { return }
+
+	respondWithJSON(w, 200, UserOut{
+		ID:        user.ID.String(),
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+		Email:     user.Email,
+		Token:     jwtToken,
+		RefreshToken: refreshToken,
+		IsChirpyRed: user.IsChirpyRed,
+		Role: user.Role,
+		EmailVerified: user.VerifiedAt.Valid,
+		Entitlements: entitlements.For(user),
+	})
+}
+
+func (cfg *APIConfig) issueRefreshToken(
+	w http.ResponseWriter,
+	r *http.Request,
+	userID uuid.UUID,
+	familyID uuid.UUID,
+	parentHash sql.NullString,
+) (string, error) {
+	refreshToken, err := createRefreshToken(r, cfg.DBQueries, cfg.RefreshTokenPepper, db.CreateRefreshTokenParams{
+		UserID: userID,
+		FamilyID: familyID,
+		ParentTokenHash: parentHash,
+	})
 	if err != nil {
 		slog.Error("Error creating refresh token", "error", err)
 		respondWithError(w, 500, "Could not create refresh token")
-		return "", err	
-	}
-	_, err = cfg.DBQueries.CreateRefreshToken(
-		r.Context(),
-		db.CreateRefreshTokenParams{
-			Token: refreshToken,
-			UserID: user.ID,
-			ExpiresAt: time.Now().Add(time.Hour * 24 * 60),
-		},
-	)
+		return "", err
+	}
+	return refreshToken, nil
+}
+
+// rotateRefreshToken revokes parent as rotated and creates its successor
+// from arg. Run it in a transaction so a failure leaves parent usable. It
+// returns errRefreshTokenRotated if parent was already rotated.
+func rotateRefreshToken(r *http.Request, q *db.Queries, pepper string, parent db.RefreshToken, arg db.CreateRefreshTokenParams) (string, error) {
+	rotated, err := q.RotateRefreshToken(r.Context(), parent.TokenHash)
 	if err != nil {
-		slog.Error("Error expiring refresh token", "error", err)
-		respondWithError(w, 500, "Could not expire refresh token")
+		return "", err
+	}
+	if rotated == 0 {
+		return "", errRefreshTokenRotated
+	}
+	arg.ParentTokenHash = sql.NullString{String: parent.TokenHash, Valid: true}
+	return createRefreshToken(r, q, pepper, arg)
+}
+
+// createRefreshToken stores a new refresh token for arg's user and family,
+// filling in its hash, expiry and the caller's user agent and IP.
+func createRefreshToken(r *http.Request, q *db.Queries, pepper string, arg db.CreateRefreshTokenParams) (string, error) {
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil { return "", err }
+	arg.TokenHash = auth.HashRefreshToken(refreshToken, pepper)
+	arg.ExpiresAt = time.Now().Add(time.Hour * 24 * 60)
+	arg.UserAgent = r.UserAgent()
+	arg.Ip = clientIP(r)
+	if _, err := q.CreateRefreshToken(r.Context(), arg); err != nil {
 		return "", err
 	}
 	return refreshToken, nil
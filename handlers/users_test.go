@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAccountState(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		user  db.User
+		state string
+	}{
+		{db.User{Status: userStatusActive}, userStatusActive},
+		{db.User{Status: userStatusBanned}, userStatusBanned},
+		{db.User{
+			Status:         userStatusSuspended,
+			SuspendedUntil: sql.NullTime{Time: now.Add(time.Hour), Valid: true},
+		}, userStatusSuspended},
+		{db.User{
+			Status:         userStatusSuspended,
+			SuspendedUntil: sql.NullTime{Time: now.Add(-time.Hour), Valid: true},
+		}, userStatusActive}, // suspension ran out
+	}
+	for _, testCase := range testCases {
+		if state := accountState(testCase.user, now); state != testCase.state {
+			t.Errorf("Expected %v, got %v", testCase.state, state)
+		}
+	}
+}
+
+func TestCreateUserRejectsWeakPassword(t *testing.T) {
+	cfg := &APIConfig{}
+	req := httptest.NewRequest("POST", "/api/users", strings.NewReader(`{"email":"ada@example.com","password":"ada"}`))
+	w := httptest.NewRecorder()
+	cfg.CreateUser(w, req)
+	if w.Code != 400 {
+		t.Fatalf("Expected 400, got %d", w.Code)
+	}
+	out := PasswordPolicyErrorOut{}
+	if err := json.NewDecoder(w.Body).Decode(&out); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	if out.Code != "weak_password" || len(out.Violations) != 2 ||
+		out.Violations[0].Code != "password_too_short" || out.Violations[1].Code != "password_contains_email" {
+		t.Errorf("Unexpected response %+v", out)
+	}
+}
+
+func TestSetUserRoleKeepsLastAdmin(t *testing.T) {
+	adminID := uuid.New()
+	fake := newFakeDB()
+	fake.on("LockUsersWithRole", []driver.Value{adminID.String()})
+	w := httptest.NewRecorder()
+	fake.config().SetUserRole(w, asModerator("PUT", "/admin/users/x/role", `{"role":"moderator"}`, adminID, adminID))
+	if w.Code != 409 {
+		t.Fatalf("Expected 409, got %d %s", w.Code, w.Body.String())
+	}
+	if trace := fake.trace(); trace != "begin LockUsersWithRole rollback" {
+		t.Errorf("Expected the role to be left alone, got %s", trace)
+	}
+
+	// with a second admin around, the demotion goes through
+	fake = newFakeDB()
+	fake.on("LockUsersWithRole", []driver.Value{adminID.String()}, []driver.Value{uuid.New().String()})
+	fake.on("SetUserRole", userRow(db.User{ID: adminID, Email: "admin@example.com", Status: userStatusActive, Role: auth.RoleModerator}))
+	w = httptest.NewRecorder()
+	fake.config().SetUserRole(w, asModerator("PUT", "/admin/users/x/role", `{"role":"moderator"}`, adminID, adminID))
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	if trace := fake.trace(); trace != "begin LockUsersWithRole SetUserRole commit" {
+		t.Errorf("Expected the demotion to commit, got %s", trace)
+	}
+}
+
+// refreshRequest presents refreshToken to RefreshJWT, answering its lookup
+// with a token in the state of stored.
+func refreshRequest(fake *fakeDB, refreshToken string, stored db.RefreshToken) (*APIConfig, *httptest.ResponseRecorder, *http.Request) {
+	cfg := fake.config()
+	cfg.RefreshTokenPepper = "pepper"
+	stored.TokenHash = auth.HashRefreshToken(refreshToken, cfg.RefreshTokenPepper)
+	stored.Hashed = true
+	fake.on("GetRefreshToken", refreshTokenRow(stored))
+	req := httptest.NewRequest("POST", "/api/refresh", nil)
+	req.Header.Set("Authorization", "Bearer "+refreshToken)
+	return cfg, httptest.NewRecorder(), req
+}
+
+func TestRefreshJWTRevokedTokens(t *testing.T) {
+	revokedAt := sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true}
+	testCases := []struct {
+		reason       string
+		code         string
+		revokeFamily bool
+	}{
+		// only a rotated token coming back means it was stolen
+		{refreshTokenRotated, "token_reused", true},
+		{"logout", "token_revoked", false},
+		{"revoked", "token_revoked", false},
+	}
+	for _, testCase := range testCases {
+		fake := newFakeDB()
+		cfg, w, req := refreshRequest(fake, "refresh-token", db.RefreshToken{
+			UserID:        uuid.New(),
+			FamilyID:      uuid.New(),
+			ExpiresAt:     time.Now().Add(time.Hour),
+			RevokedAt:     revokedAt,
+			RevokedReason: testCase.reason,
+		})
+		cfg.RefreshJWT(w, req)
+		if w.Code != 401 || !strings.Contains(w.Body.String(), testCase.code) {
+			t.Errorf("%s: expected 401 %s, got %d %s", testCase.reason, testCase.code, w.Code, w.Body.String())
+		}
+		if revoked := len(fake.called("RevokeRefreshTokenFamily")) > 0; revoked != testCase.revokeFamily {
+			t.Errorf("%s: expected family revoked %v, got %v", testCase.reason, testCase.revokeFamily, revoked)
+		}
+	}
+}
+
+func TestRefreshJWTRotatesInTransaction(t *testing.T) {
+	user := db.User{ID: uuid.New(), Email: "user@example.com", Status: userStatusActive, Role: auth.RoleUser}
+	stored := db.RefreshToken{UserID: user.ID, FamilyID: uuid.New(), ExpiresAt: time.Now().Add(time.Hour)}
+
+	// the old token stays usable when its successor cannot be stored
+	fake := newFakeDB()
+	fake.on("GetUserByID", userRow(user))
+	fake.fail("CreateRefreshToken", errors.New("disk full"))
+	cfg, w, req := refreshRequest(fake, "refresh-token", stored)
+	cfg.RefreshJWT(w, req)
+	if w.Code != 500 {
+		t.Fatalf("Expected 500, got %d %s", w.Code, w.Body.String())
+	}
+	if trace := fake.trace(); trace != "GetRefreshToken GetUserByID begin RotateRefreshToken CreateRefreshToken rollback" {
+		t.Errorf("Expected the rotation to roll back, got %s", trace)
+	}
+
+	// losing a race to rotate it is reuse
+	fake = newFakeDB()
+	fake.on("GetUserByID", userRow(user))
+	fake.on("RotateRefreshToken")
+	cfg, w, req = refreshRequest(fake, "refresh-token", stored)
+	cfg.RefreshJWT(w, req)
+	if w.Code != 401 || !strings.Contains(w.Body.String(), "token_reused") {
+		t.Fatalf("Expected 401 token_reused, got %d %s", w.Code, w.Body.String())
+	}
+	if trace := fake.trace(); !strings.HasPrefix(trace, "GetRefreshToken GetUserByID begin RotateRefreshToken rollback RevokeRefreshTokenFamily") {
+		t.Errorf("Expected no new token and the family revoked, got %s", trace)
+	}
+}
+
+func TestUpdateUserPasswordDeletesPersonalTokens(t *testing.T) {
+	hashedPassword, err := auth.HashPassword("correct horse battery")
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+	user := db.User{ID: uuid.New(), Email: "ada@example.com", HashedPassword: hashedPassword, Status: userStatusActive, Role: auth.RoleUser}
+
+	for _, password := range []string{"correct horse battery", "staple grid lantern"} {
+		fake := newFakeDB()
+		fake.on("CountWebAuthnCredentialsByUser", []driver.Value{int64(0)})
+		fake.on("GetUserByID", userRow(user))
+		fake.on("UpdateUser", userRow(user))
+		req := httptest.NewRequest("PUT", "/api/users", strings.NewReader(`{"email":"ada@example.com","password":"`+password+`"}`))
+		req = req.WithContext(context.WithValue(req.Context(), "userID", user.ID))
+		w := httptest.NewRecorder()
+		fake.config().UpdateUser(w, req)
+		if w.Code != 200 {
+			t.Fatalf("Expected 200, got %d %s", w.Code, w.Body.String())
+		}
+		changed := password != "correct horse battery"
+		if deleted := len(fake.called("DeleteUserPersonalAccessTokens")) == 1; deleted != changed {
+			t.Errorf("Password changed %v: expected tokens deleted %v, got %s", changed, changed, fake.trace())
+		}
+	}
+}
+
+func TestUpgradePasswordHashKeepsNewerPassword(t *testing.T) {
+	legacy, err := auth.BcryptHasher{Cost: bcrypt.MinCost}.Hash("correct horse battery")
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+	// the password changed after the login read the old hash
+	fake := newFakeDB()
+	fake.on("SetUserPassword")
+	user := db.User{ID: uuid.New(), HashedPassword: legacy}
+	fake.config().upgradePasswordHash(httptest.NewRequest("POST", "/api/login", nil), &user, "correct horse battery")
+
+	calls := fake.called("SetUserPassword")
+	if len(calls) != 1 || calls[0][2] != legacy {
+		t.Fatalf("Expected the update to be conditional on the old hash, got %v", calls)
+	}
+	if user.HashedPassword != legacy {
+		t.Errorf("Expected the user to keep the hash that was stored")
+	}
+}
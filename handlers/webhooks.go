@@ -0,0 +1,372 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/webhook"
+	"github.com/google/uuid"
+)
+
+// Events webhook endpoints can subscribe to.
+const (
+	EventChirpCreated = "chirp.created"
+	EventChirpDeleted = "chirp.deleted"
+)
+
+// webhookEvents are all the events there are: Chirpy has no likes,
+// mentions or follows to announce.
+var webhookEvents = []string{EventChirpCreated, EventChirpDeleted}
+
+const (
+	maxWebhookEndpoints   = 10
+	defaultDeliveriesPage = 50
+	maxDeliveriesPage     = 200
+)
+
+type WebhookEndpointIn struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Description string   `json:"description"`
+}
+
+type WebhookEndpointOut struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Secret signs deliveries. It is only shown when the endpoint is made.
+	Secret              string     `json:"secret,omitempty"`
+	Events              []string   `json:"events"`
+	Description         string     `json:"description"`
+	Status              string     `json:"status"`
+	ConsecutiveFailures int32      `json:"consecutive_failures"`
+	PausedAt            *time.Time `json:"paused_at"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+type WebhookDeliveryOut struct {
+	ID            string     `json:"id"`
+	EventID       string     `json:"event_id"`
+	EventType     string     `json:"event_type"`
+	Status        string     `json:"status"`
+	Attempts      int32      `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+	LastAttemptAt *time.Time `json:"last_attempt_at"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+	// ResponseStatus is the endpoint's last HTTP status, 0 if it never
+	// answered.
+	ResponseStatus int32     `json:"response_status"`
+	LastError      string    `json:"last_error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// WebhookEvent is the body of every delivery.
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// HANDLERS
+
+// CreateWebhookEndpoint registers a URL to be sent the user's events. The
+// signing secret is only ever shown in this response.
+func (cfg *APIConfig) CreateWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	claims, _ := r.Context().Value("claims").(*auth.Claims)
+	reqEndpoint := WebhookEndpointIn{}
+	if err := json.NewDecoder(r.Body).Decode(&reqEndpoint); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	if err := webhook.ValidURL(reqEndpoint.URL, os.Getenv("PLATFORM") == "dev"); err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	events, err := parseWebhookEvents(reqEndpoint.Events)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	reqEndpoint.Description = strings.TrimSpace(reqEndpoint.Description)
+	if len(reqEndpoint.Description) > 200 {
+		respondWithError(w, 400, "Description is at most 200 characters")
+		return
+	}
+
+	count, err := cfg.DBQueries.CountWebhookEndpoints(r.Context(), userID)
+	if err != nil {
+		slog.Error("Error counting webhook endpoints", "error", err)
+		respondWithError(w, 500, "Could not create webhook endpoint")
+		return
+	}
+	if count >= maxWebhookEndpoints {
+		respondWithError(w, 409, fmt.Sprintf("You already have %d webhook endpoints, delete one first", maxWebhookEndpoints))
+		return
+	}
+	secret, err := auth.MakeRefreshToken()
+	if err != nil {
+		slog.Error("Error making webhook secret", "error", err)
+		respondWithError(w, 500, "Could not create webhook endpoint")
+		return
+	}
+	clientID := sql.NullString{}
+	if claims != nil && claims.ClientID != "" {
+		clientID = sql.NullString{String: claims.ClientID, Valid: true}
+	}
+	endpoint, err := cfg.DBQueries.CreateWebhookEndpoint(r.Context(), db.CreateWebhookEndpointParams{
+		UserID:      userID,
+		ClientID:    clientID,
+		Url:         reqEndpoint.URL,
+		Secret:      "whsec_" + secret,
+		Events:      strings.Join(events, " "),
+		Description: reqEndpoint.Description,
+	})
+	if err != nil {
+		slog.Error("Error creating webhook endpoint", "error", err)
+		respondWithError(w, 500, "Could not create webhook endpoint")
+		return
+	}
+	slog.Info("Created webhook endpoint", "userID", userID, "endpointID", endpoint.ID, "events", endpoint.Events)
+	out := webhookEndpointOut(endpoint)
+	out.Secret = endpoint.Secret
+	respondWithJSON(w, 201, out)
+}
+
+// ListWebhookEndpoints lists the user's endpoints. An OAuth client only
+// sees the endpoints it registered.
+func (cfg *APIConfig) ListWebhookEndpoints(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	endpoints, err := cfg.DBQueries.ListWebhookEndpoints(r.Context(), userID)
+	if err != nil {
+		slog.Error("Error listing webhook endpoints", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	out := []WebhookEndpointOut{}
+	for _, endpoint := range endpoints {
+		if canManageWebhook(r, endpoint) {
+			out = append(out, webhookEndpointOut(endpoint))
+		}
+	}
+	respondWithJSON(w, 200, out)
+}
+
+// DeleteWebhookEndpoint removes an endpoint along with its delivery log.
+func (cfg *APIConfig) DeleteWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	endpoint, ok := cfg.getWebhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+	_, err := cfg.DBQueries.DeleteWebhookEndpoint(r.Context(), db.DeleteWebhookEndpointParams{
+		ID:     endpoint.ID,
+		UserID: userID,
+	})
+	if err != nil {
+		slog.Error("Error deleting webhook endpoint", "error", err)
+		respondWithError(w, 500, "Could not delete webhook endpoint")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// ResumeWebhookEndpoint reactivates an endpoint that was paused after
+// failing too often. Deliveries queued before or while it was paused
+// are sent from then on.
+func (cfg *APIConfig) ResumeWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	endpoint, ok := cfg.getWebhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+	endpoint, err := cfg.DBQueries.ResumeWebhookEndpoint(r.Context(), db.ResumeWebhookEndpointParams{
+		ID:     endpoint.ID,
+		UserID: userID,
+	})
+	if err != nil {
+		slog.Error("Error resuming webhook endpoint", "error", err)
+		respondWithError(w, 500, "Could not resume webhook endpoint")
+		return
+	}
+	slog.Info("Resumed webhook endpoint", "userID", userID, "endpointID", endpoint.ID)
+	respondWithJSON(w, 200, webhookEndpointOut(endpoint))
+}
+
+// ListWebhookDeliveries returns the endpoint's delivery log, newest first.
+func (cfg *APIConfig) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := cfg.getWebhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+	limit := defaultDeliveriesPage
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxDeliveriesPage {
+			respondWithError(w, 400, fmt.Sprintf("limit must be between 1 and %d", maxDeliveriesPage))
+			return
+		}
+		limit = n
+	}
+	deliveries, err := cfg.DBQueries.ListWebhookDeliveries(r.Context(), db.ListWebhookDeliveriesParams{
+		EndpointID: endpoint.ID,
+		Limit:      int32(limit),
+	})
+	if err != nil {
+		slog.Error("Error listing webhook deliveries", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	out := []WebhookDeliveryOut{}
+	for _, delivery := range deliveries {
+		out = append(out, webhookDeliveryOut(delivery))
+	}
+	respondWithJSON(w, 200, out)
+}
+
+// HELPERS
+
+// publishEvent queues eventType for every active endpoint of userID that
+// subscribed to it. Call it in the transaction that makes the change, so
+// the event is queued exactly when the change commits.
+func publishEvent(ctx context.Context, q *db.Queries, userID uuid.UUID, eventType string, data interface{}) error {
+	eventID := uuid.New()
+	event := WebhookEvent{
+		ID:        eventID.String(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	queued, err := q.EnqueueWebhookEvent(ctx, db.EnqueueWebhookEventParams{
+		EventID:   eventID,
+		EventType: eventType,
+		Payload:   payload,
+		UserID:    userID,
+	})
+	if err != nil {
+		return err
+	}
+	if queued > 0 {
+		slog.Info("Queued webhook event", "eventID", event.ID, "type", eventType, "endpoints", queued)
+	}
+	return nil
+}
+
+// deleteEndedGrantWebhooks deletes the webhook endpoints an OAuth app
+// registered for userID once familyID, one of the app's grants, has been
+// revoked and the app holds no other. First-party sessions have no app
+// and leave endpoints alone.
+func deleteEndedGrantWebhooks(ctx context.Context, q *db.Queries, userID uuid.UUID, familyID uuid.UUID) error {
+	return q.DeleteEndedGrantWebhookEndpoints(ctx, db.DeleteEndedGrantWebhookEndpointsParams{
+		UserID:   userID,
+		FamilyID: familyID,
+	})
+}
+
+// getWebhookEndpoint loads the endpoint named in the path. It responds 404
+// itself when the endpoint is missing or not the caller's to manage.
+func (cfg *APIConfig) getWebhookEndpoint(w http.ResponseWriter, r *http.Request) (db.WebhookEndpoint, bool) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	endpointID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, 404, "Webhook endpoint not found")
+		return db.WebhookEndpoint{}, false
+	}
+	endpoint, err := cfg.DBQueries.GetWebhookEndpoint(r.Context(), endpointID)
+	if err == sql.ErrNoRows || (err == nil && (endpoint.UserID != userID || !canManageWebhook(r, endpoint))) {
+		respondWithError(w, 404, "Webhook endpoint not found")
+		return db.WebhookEndpoint{}, false
+	}
+	if err != nil {
+		slog.Error("Error getting webhook endpoint", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return db.WebhookEndpoint{}, false
+	}
+	return endpoint, true
+}
+
+// canManageWebhook keeps OAuth clients to the endpoints they registered.
+// Chirpy's own apps and personal tokens manage all of the user's.
+func canManageWebhook(r *http.Request, endpoint db.WebhookEndpoint) bool {
+	claims, _ := r.Context().Value("claims").(*auth.Claims)
+	if claims == nil || claims.ClientID == "" {
+		return true
+	}
+	return endpoint.ClientID.Valid && endpoint.ClientID.String == claims.ClientID
+}
+
+// parseWebhookEvents checks events are known and removes duplicates.
+func parseWebhookEvents(events []string) ([]string, error) {
+	if len(events) == 0 {
+		return nil, fmt.Errorf("Subscribe to at least one of %s", strings.Join(webhookEvents, ", "))
+	}
+	seen := map[string]bool{}
+	parsed := []string{}
+	for _, event := range events {
+		known := false
+		for _, e := range webhookEvents {
+			known = known || e == event
+		}
+		if !known {
+			return nil, fmt.Errorf("Unknown event %q, expected one of %s", event, strings.Join(webhookEvents, ", "))
+		}
+		if !seen[event] {
+			seen[event] = true
+			parsed = append(parsed, event)
+		}
+	}
+	return parsed, nil
+}
+
+func webhookEndpointOut(endpoint db.WebhookEndpoint) WebhookEndpointOut {
+	out := WebhookEndpointOut{
+		ID:                  endpoint.ID.String(),
+		URL:                 endpoint.Url,
+		Events:              strings.Fields(endpoint.Events),
+		Description:         endpoint.Description,
+		Status:              endpoint.Status,
+		ConsecutiveFailures: endpoint.ConsecutiveFailures,
+		CreatedAt:           endpoint.CreatedAt,
+	}
+	if endpoint.PausedAt.Valid {
+		out.PausedAt = &endpoint.PausedAt.Time
+	}
+	return out
+}
+
+func webhookDeliveryOut(delivery db.WebhookDelivery) WebhookDeliveryOut {
+	out := WebhookDeliveryOut{
+		ID:             delivery.ID.String(),
+		EventID:        delivery.EventID.String(),
+		EventType:      delivery.EventType,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		ResponseStatus: delivery.ResponseStatus,
+		LastError:      delivery.LastError,
+		CreatedAt:      delivery.CreatedAt,
+	}
+	if delivery.Status == "pending" {
+		out.NextAttemptAt = &delivery.NextAttemptAt
+	}
+	if delivery.LastAttemptAt.Valid {
+		out.LastAttemptAt = &delivery.LastAttemptAt.Time
+	}
+	if delivery.DeliveredAt.Valid {
+		out.DeliveredAt = &delivery.DeliveredAt.Time
+	}
+	return out
+}
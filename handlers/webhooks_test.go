@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/google/uuid"
+)
+
+func TestCreateWebhookEndpointRejected(t *testing.T) {
+	testCases := []struct {
+		name string
+		body string
+	}{
+		{"plain http", `{"url":"http://example.com/hooks","events":["chirp.created"]}`},
+		{"not a URL", `{"url":"example.com","events":["chirp.created"]}`},
+		{"no events", `{"url":"https://example.com/hooks","events":[]}`},
+		{"unknown event", `{"url":"https://example.com/hooks","events":["chirp.liked"]}`},
+		{"long description", `{"url":"https://example.com/hooks","events":["chirp.created"],"description":"` + strings.Repeat("a", 201) + `"}`},
+	}
+	for _, testCase := range testCases {
+		cfg := &APIConfig{}
+		req := httptest.NewRequest("POST", "/api/webhooks", strings.NewReader(testCase.body))
+		req = req.WithContext(context.WithValue(req.Context(), "userID", uuid.New()))
+		w := httptest.NewRecorder()
+		cfg.CreateWebhookEndpoint(w, req)
+		if w.Code != 400 {
+			t.Errorf("%s: expected 400, got %d %s", testCase.name, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestParseWebhookEvents(t *testing.T) {
+	events, err := parseWebhookEvents([]string{EventChirpDeleted, EventChirpCreated, EventChirpDeleted})
+	if err != nil || strings.Join(events, " ") != "chirp.deleted chirp.created" {
+		t.Errorf("Expected duplicates to be dropped, got %v (%v)", events, err)
+	}
+}
+
+func TestCanManageWebhook(t *testing.T) {
+	endpoint := db.WebhookEndpoint{ClientID: sql.NullString{String: "client-1", Valid: true}}
+	testCases := []struct {
+		name     string
+		claims   *auth.Claims
+		expected bool
+	}{
+		{"first party", &auth.Claims{}, true},
+		{"personal token", &auth.Claims{PersonalTokenID: "token-1"}, true},
+		{"client that registered it", &auth.Claims{ClientID: "client-1"}, true},
+		{"other client", &auth.Claims{ClientID: "client-2"}, false},
+	}
+	for _, testCase := range testCases {
+		req := httptest.NewRequest("GET", "/api/webhooks", nil)
+		req = req.WithContext(context.WithValue(req.Context(), "claims", testCase.claims))
+		if canManageWebhook(req, endpoint) != testCase.expected {
+			t.Errorf("%s: expected %v", testCase.name, testCase.expected)
+		}
+	}
+}
+
+// rowsRecorder is an execRecorder for :execrows queries.
+type rowsRecorder struct {
+	execRecorder
+}
+
+func (e *rowsRecorder) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	e.execRecorder.ExecContext(ctx, query, args...)
+	return driver.RowsAffected(1), nil
+}
+
+func TestPublishEvent(t *testing.T) {
+	recorder := &rowsRecorder{}
+	userID := uuid.New()
+	chirp := ChirpOut{ID: uuid.New().String(), Body: "hello", UserID: userID.String()}
+	if err := publishEvent(context.Background(), db.New(recorder), userID, EventChirpCreated, chirp); err != nil {
+		t.Fatalf("Error publishing event: %v", err)
+	}
+
+	if len(recorder.calls) != 1 {
+		t.Fatalf("Expected the event to be queued once, got %d", len(recorder.calls))
+	}
+	args := recorder.calls[0]
+	event := struct {
+		ID   string   `json:"id"`
+		Type string   `json:"type"`
+		Data ChirpOut `json:"data"`
+	}{}
+	if err := json.Unmarshal(args[2].(json.RawMessage), &event); err != nil {
+		t.Fatalf("Error decoding payload: %v", err)
+	}
+	if event.ID != args[0].(uuid.UUID).String() || event.Type != EventChirpCreated || args[1] != EventChirpCreated || args[3] != userID {
+		t.Errorf("Expected a %s event for %s, got %v", EventChirpCreated, userID, args)
+	}
+	if event.Data != chirp {
+		t.Errorf("Expected the chirp as data, got %+v", event.Data)
+	}
+}
@@ -2,7 +2,10 @@
 package auth
 
 import (
+	"context"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"log/slog"
@@ -12,37 +15,36 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
 
 
 
-func HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), 10)
-	if err != nil {
-		slog.Error("Error hashing password", "error", err)
-		return "", err
-	}
-	return string(hash), nil
-}
-
-func CheckPasswordHash(password string, hash string) error {
-	slog.Info("Checking password", "password", password, "hash", hash)
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	if err != nil { return err }
-	return nil
-}
-
+// MakeJWT signs an access token for the user with the keyring's current
+// key.
 func MakeJWT(
+	keys *Keyring,
 	userID uuid.UUID, 
-	tokenSecret string, 
+	role string,
+	sessionID uuid.UUID,
 	expiresIn time.Duration) (string, error) {
-		token := jwt.NewWithClaims(
-			jwt.SigningMethodHS256,
-			createClaims(userID, expiresIn),
-		)
-		signedToken, err := token.SignedString([]byte(tokenSecret))
+		return MakeJWTWithMFA(keys, userID, role, sessionID, time.Time{}, expiresIn)
+}
+
+// MakeJWTWithMFA is MakeJWT for a user who passed a second factor at
+// mfaAt. A zero mfaAt leaves the claim out.
+func MakeJWTWithMFA(
+	keys *Keyring,
+	userID uuid.UUID,
+	role string,
+	sessionID uuid.UUID,
+	mfaAt time.Time,
+	expiresIn time.Duration) (string, error) {
+		claims := createClaims(userID, role, sessionID, expiresIn)
+		if !mfaAt.IsZero() {
+			claims.MFAAt = jwt.NewNumericDate(mfaAt)
+		}
+		signedToken, err := keys.Sign(claims)
 		if err != nil {
 			slog.Error("Error signing token", "error", err)
 			return "", err
@@ -50,29 +52,34 @@ func MakeJWT(
 		return signedToken, nil
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		&jwt.RegisteredClaims{},
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(tokenSecret), nil},			
-		)
-		if err != nil {
-			slog.Error("Error parsing token", "error", err)
-			return uuid.Nil, err
-		}
-		if claims, ok := token.Claims.(*jwt.RegisteredClaims); ok && token.Valid {
-			return uuid.Parse(claims.Subject)
-		}
-		return uuid.Nil, nil
+// ValidateJWT returns the user the token was issued to.
+func ValidateJWT(tokenString string, keys *Keyring) (uuid.UUID, error) {
+	claims, err := ParseJWT(tokenString, keys)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.Parse(claims.Subject)
+}
+
+// ParseJWT validates the token with the default validator for the keyring
+// and returns its claims, including the user's role. It does not check
+// revocation; use a Validator with a RevocationList for that.
+func ParseJWT(tokenString string, keys *Keyring) (*Claims, error) {
+	return NewValidator(keys).Validate(context.Background(), tokenString)
 }
 
-func createClaims(userID uuid.UUID, expiresIn time.Duration) jwt.RegisteredClaims {
-	return jwt.RegisteredClaims{
-		Issuer: "chirpy",
-		IssuedAt: jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject: userID.String(),
+func createClaims(userID uuid.UUID, role string, sessionID uuid.UUID, expiresIn time.Duration) Claims {
+	return Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: Issuer,
+			Audience: jwt.ClaimStrings{Audience},
+			ID: uuid.NewString(),
+			IssuedAt: jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject: userID.String(),
+		},
+		Role: role,
+		SessionID: sessionID.String(),
 	}}
 
 
@@ -95,13 +102,17 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(key), nil
 }
 
-func GetAPIKey(headers http.Header) (string, error) {
-	slog.Info("Getting API key", "headers", headers)
-	header := headers.Get("Authorization")
-	slog.Info("Authorization header", "header", header)
-	parts := strings.Split(header, " ")
-	if len(parts) !=2 || strings.ToLower(parts[0]) != "apikey" {
-		return "", fmt.Errorf("invalid Authorization header")
-	}
-	return parts[1], nil
+// HashRefreshToken returns the keyed hash we store instead of the raw
+// refresh token, so a database leak does not leak sessions.
+func HashRefreshToken(token, pepper string) string {
+	mac := hmac.New(sha256.New, []byte(pepper))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
 }
+
+// CheckRefreshTokenHash compares token against a stored hash in constant
+// time.
+func CheckRefreshTokenHash(token, hash, pepper string) bool {
+	return hmac.Equal([]byte(HashRefreshToken(token, pepper)), []byte(hash))
+}
+
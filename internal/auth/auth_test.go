@@ -4,6 +4,9 @@ import (
 	"testing"
 	"os"
 	"os/exec"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 func TestHashPassword_ValidOK(t *testing.T) {
@@ -23,3 +26,81 @@ func TestHashPassword_ValidOK(t *testing.T) {
 }
 
 	
+
+func TestHasRole(t *testing.T) {
+	testCases := []struct {
+		have string
+		want string
+		ok bool
+	}{
+		{RoleAdmin, RoleModerator, true},
+		{RoleModerator, RoleModerator, true},
+		{RoleUser, RoleModerator, false},
+		{RoleModerator, RoleAdmin, false},
+		{"", RoleUser, false},
+		{"root", RoleUser, false},
+	}
+	for _, testCase := range testCases {
+		if ok := HasRole(testCase.have, testCase.want); ok != testCase.ok {
+			t.Errorf("HasRole(%q, %q): expected %v, got %v", testCase.have, testCase.want, testCase.ok, ok)
+		}
+	}
+}
+
+func TestOutranks(t *testing.T) {
+	testCases := []struct {
+		have string
+		target string
+		ok bool
+	}{
+		{RoleAdmin, RoleModerator, true},
+		{RoleModerator, RoleUser, true},
+		{RoleModerator, RoleModerator, false},
+		{RoleModerator, RoleAdmin, false},
+		{RoleAdmin, RoleAdmin, false},
+		{"root", RoleUser, false},
+	}
+	for _, testCase := range testCases {
+		if ok := Outranks(testCase.have, testCase.target); ok != testCase.ok {
+			t.Errorf("Outranks(%q, %q): expected %v, got %v", testCase.have, testCase.target, testCase.ok, ok)
+		}
+	}
+}
+
+func TestMakeJWT_RoleClaim(t *testing.T) {
+	keys := NewKeyring("")
+	key, err := GenerateSigningKey(AlgEdDSA)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	keys.Set([]SigningKey{key})
+	userID := uuid.New()
+	token, err := MakeJWT(keys, userID, RoleModerator, uuid.New(), time.Minute)
+	if err != nil {
+		t.Fatalf("Error making token: %v", err)
+	}
+	claims, err := ParseJWT(token, keys)
+	if err != nil {
+		t.Fatalf("Error parsing token: %v", err)
+	}
+	if claims.Subject != userID.String() || claims.Role != RoleModerator {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+}
+
+func TestHashRefreshToken(t *testing.T) {
+	token, err := MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("Error making refresh token: %v", err)
+	}
+	hash := HashRefreshToken(token, "pepper")
+	if hash == token || len(hash) != 64 {
+		t.Errorf("Expected a hex SHA-256 hash, got %v", hash)
+	}
+	if !CheckRefreshTokenHash(token, hash, "pepper") {
+		t.Errorf("Expected token to match its hash")
+	}
+	if CheckRefreshTokenHash(token, hash, "other pepper") {
+		t.Errorf("Expected a different pepper not to match")
+	}
+}
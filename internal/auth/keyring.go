@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+)
+
+// SigningKey is one asymmetric key in a Keyring, identified by the kid
+// header of the tokens it signs.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	CreatedAt time.Time
+	// RetiresAt is when the key stops verifying tokens. Zero means the
+	// key has not been rotated out yet.
+	RetiresAt time.Time
+}
+
+// Keyring holds every key that may verify a token and signs with the
+// newest one. It is safe for concurrent use.
+type Keyring struct {
+	mu         sync.RWMutex
+	keys       []SigningKey
+	legacyHMAC []byte
+}
+
+// NewKeyring returns an empty keyring. legacySecret, when not empty, is
+// the HS256 secret that tokens without a kid were signed with before
+// asymmetric keys; it only verifies and never signs.
+func NewKeyring(legacySecret string) *Keyring {
+	k := &Keyring{}
+	if legacySecret != "" {
+		k.legacyHMAC = []byte(legacySecret)
+	}
+	return k
+}
+
+// GenerateSigningKey creates a new key for alg with a random kid.
+func GenerateSigningKey(alg string) (SigningKey, error) {
+	var signer crypto.Signer
+	var err error
+	switch alg {
+	case AlgEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if err != nil {
+		return SigningKey{}, err
+	}
+	kid, err := MakeRefreshToken()
+	if err != nil {
+		return SigningKey{}, err
+	}
+	return SigningKey{
+		ID:        kid[:16],
+		Algorithm: alg,
+		Private:   signer,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// MarshalPrivateKey encodes the key as PKCS #8 DER for storage.
+func MarshalPrivateKey(key SigningKey) ([]byte, error) {
+	return x509.MarshalPKCS8PrivateKey(key.Private)
+}
+
+// ParsePrivateKey decodes a PKCS #8 key stored by MarshalPrivateKey and
+// checks it matches alg.
+func ParsePrivateKey(der []byte, alg string) (crypto.Signer, error) {
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	switch key := parsed.(type) {
+	case ed25519.PrivateKey:
+		if alg == AlgEdDSA {
+			return key, nil
+		}
+	case *rsa.PrivateKey:
+		if alg == AlgRS256 {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("stored key does not match algorithm %q", alg)
+}
+
+// Set replaces the keys in the ring.
+func (k *Keyring) Set(keys []SigningKey) {
+	sorted := append([]SigningKey(nil), keys...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.After(sorted[j].CreatedAt)
+	})
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = sorted
+}
+
+// Current returns the key new tokens are signed with.
+func (k *Keyring) Current() (SigningKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.RetiresAt.IsZero() {
+			return key, true
+		}
+	}
+	return SigningKey{}, false
+}
+
+// Sign signs claims with the current key and sets the kid header.
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	key, ok := k.Current()
+	if !ok {
+		return "", fmt.Errorf("keyring has no signing key")
+	}
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc finds the verification key for a token by its kid. The token
+// must use the algorithm the key was created for.
+func (k *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if k.legacyHMAC != nil && token.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+			return k.legacyHMAC, nil
+		}
+		return nil, fmt.Errorf("token has no kid")
+	}
+	now := time.Now()
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.ID != kid {
+			continue
+		}
+		if !key.RetiresAt.IsZero() && now.After(key.RetiresAt) {
+			return nil, fmt.Errorf("key %s is retired", kid)
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("token algorithm %s does not match key %s", token.Method.Alg(), kid)
+		}
+		return key.Private.Public(), nil
+	}
+	return nil, fmt.Errorf("unknown kid %s", kid)
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// PublicKey decodes the key. It understands Ed25519, P-256 and RSA keys,
+// which covers what identity providers sign ID tokens with.
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch j.Kty {
+	case "OKP":
+		x, err := decode(j.X)
+		if err != nil || j.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid OKP key %s", j.Kid)
+		}
+		return ed25519.PublicKey(x), nil
+	case "EC":
+		x, errX := decode(j.X)
+		y, errY := decode(j.Y)
+		if errX != nil || errY != nil || j.Crv != "P-256" {
+			return nil, fmt.Errorf("invalid EC key %s", j.Kid)
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("EC key %s is not on the curve", j.Kid)
+		}
+		return pub, nil
+	case "RSA":
+		n, errN := decode(j.N)
+		e, errE := decode(j.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA key %s", j.Kid)
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key %s is too short", j.Kid)
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every key that still verifies tokens.
+func (k *Keyring) JWKS() JWKS {
+	now := time.Now()
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range k.keys {
+		if !key.RetiresAt.IsZero() && now.After(key.RetiresAt) {
+			continue
+		}
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Algorithm}
+		switch pub := key.Private.Public().(type) {
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
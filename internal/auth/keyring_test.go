@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestKeyring_SignAndVerify(t *testing.T) {
+	for _, alg := range []string{AlgEdDSA, AlgRS256} {
+		key, err := GenerateSigningKey(alg)
+		if err != nil {
+			t.Fatalf("Error generating %s key: %v", alg, err)
+		}
+		der, err := MarshalPrivateKey(key)
+		if err != nil {
+			t.Fatalf("Error marshalling %s key: %v", alg, err)
+		}
+		if key.Private, err = ParsePrivateKey(der, alg); err != nil {
+			t.Fatalf("Error parsing %s key: %v", alg, err)
+		}
+		keys := NewKeyring("")
+		keys.Set([]SigningKey{key})
+		userID := uuid.New()
+		token, err := MakeJWT(keys, userID, RoleUser, uuid.New(), time.Minute)
+		if err != nil {
+			t.Fatalf("Error making %s token: %v", alg, err)
+		}
+		got, err := ValidateJWT(token, keys)
+		if err != nil || got != userID {
+			t.Errorf("%s: expected %v, got %v (%v)", alg, userID, got, err)
+		}
+	}
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	oldKey, _ := GenerateSigningKey(AlgEdDSA)
+	keys := NewKeyring("")
+	keys.Set([]SigningKey{oldKey})
+	oldToken, err := MakeJWT(keys, uuid.New(), RoleUser, uuid.New(), time.Minute)
+	if err != nil {
+		t.Fatalf("Error making token: %v", err)
+	}
+
+	newKey, _ := GenerateSigningKey(AlgEdDSA)
+	newKey.CreatedAt = oldKey.CreatedAt.Add(time.Second)
+	oldKey.RetiresAt = time.Now().Add(time.Hour)
+	keys.Set([]SigningKey{oldKey, newKey})
+	if current, _ := keys.Current(); current.ID != newKey.ID {
+		t.Errorf("Expected new key to sign, got %v", current.ID)
+	}
+	if _, err := ValidateJWT(oldToken, keys); err != nil {
+		t.Errorf("Expected token from rotated key to verify during grace: %v", err)
+	}
+	if n := len(keys.JWKS().Keys); n != 2 {
+		t.Errorf("Expected both keys in JWKS, got %v", n)
+	}
+
+	oldKey.RetiresAt = time.Now().Add(-time.Second)
+	keys.Set([]SigningKey{oldKey, newKey})
+	if _, err := ValidateJWT(oldToken, keys); err == nil {
+		t.Errorf("Expected token from retired key to be rejected")
+	}
+	if n := len(keys.JWKS().Keys); n != 1 {
+		t.Errorf("Expected retired key out of JWKS, got %v keys", n)
+	}
+}
+
+func TestKeyring_RejectsForgedTokens(t *testing.T) {
+	key, _ := GenerateSigningKey(AlgEdDSA)
+	keys := NewKeyring("")
+	keys.Set([]SigningKey{key})
+
+	// HS256 with no legacy secret configured
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: uuid.NewString()})
+	signed, _ := hs.SignedString([]byte("guess"))
+	if _, err := ValidateJWT(signed, keys); err == nil {
+		t.Errorf("Expected HS256 token to be rejected")
+	}
+
+	// right kid, wrong key
+	other, _ := GenerateSigningKey(AlgEdDSA)
+	forged := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.RegisteredClaims{Subject: uuid.NewString()})
+	forged.Header["kid"] = key.ID
+	signed, _ = forged.SignedString(other.Private)
+	if _, err := ValidateJWT(signed, keys); err == nil {
+		t.Errorf("Expected token signed by another key to be rejected")
+	}
+}
+
+func TestKeyring_LegacyHMAC(t *testing.T) {
+	keys := NewKeyring("old-secret")
+	userID := uuid.New()
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, createClaims(userID, RoleUser, uuid.Nil, time.Minute))
+	signed, _ := legacy.SignedString([]byte("old-secret"))
+	got, err := ValidateJWT(signed, keys)
+	if err != nil || got != userID {
+		t.Errorf("Expected legacy token to verify, got %v (%v)", got, err)
+	}
+	if _, err := MakeJWT(keys, userID, RoleUser, uuid.Nil, time.Minute); err == nil {
+		t.Errorf("Expected legacy secret not to sign")
+	}
+}
+
+func TestKeyring_JWKS(t *testing.T) {
+	ed, _ := GenerateSigningKey(AlgEdDSA)
+	rs, _ := GenerateSigningKey(AlgRS256)
+	keys := NewKeyring("")
+	keys.Set([]SigningKey{ed, rs})
+	for _, jwk := range keys.JWKS().Keys {
+		switch jwk.Kid {
+		case ed.ID:
+			x, _ := base64.RawURLEncoding.DecodeString(jwk.X)
+			if jwk.Kty != "OKP" || !ed25519.PublicKey(x).Equal(ed.Private.Public()) {
+				t.Errorf("Unexpected Ed25519 JWK %+v", jwk)
+			}
+		case rs.ID:
+			n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+			pub := rs.Private.Public().(*rsa.PublicKey)
+			if jwk.Kty != "RSA" || new(big.Int).SetBytes(n).Cmp(pub.N) != 0 || jwk.E != "AQAB" {
+				t.Errorf("Unexpected RSA JWK %+v", jwk)
+			}
+		default:
+			t.Errorf("Unexpected kid %v", jwk.Kid)
+		}
+	}
+}
+
+func TestJWK_PublicKeyRoundTrip(t *testing.T) {
+	ed, _ := GenerateSigningKey(AlgEdDSA)
+	rs, _ := GenerateSigningKey(AlgRS256)
+	keys := NewKeyring("")
+	keys.Set([]SigningKey{ed, rs})
+	want := map[string]interface{ Equal(crypto.PublicKey) bool }{
+		ed.ID: ed.Private.Public().(ed25519.PublicKey),
+		rs.ID: rs.Private.Public().(*rsa.PublicKey),
+	}
+	for _, jwk := range keys.JWKS().Keys {
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatalf("Error decoding %s: %v", jwk.Kid, err)
+		}
+		if !want[jwk.Kid].Equal(pub) {
+			t.Errorf("Key %s did not round-trip", jwk.Kid)
+		}
+	}
+
+	ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk := JWK{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(ec.X.Bytes()),
+		Y:   base64.RawURLEncoding.EncodeToString(ec.Y.Bytes()),
+	}
+	pub, err := jwk.PublicKey()
+	if err != nil || !ec.PublicKey.Equal(pub) {
+		t.Errorf("EC key did not decode: %v", err)
+	}
+	jwk.Y = jwk.X
+	if _, err := jwk.PublicKey(); err == nil {
+		t.Errorf("Expected a point off the curve to be rejected")
+	}
+	if _, err := (JWK{Kty: "oct"}).PublicKey(); err == nil {
+		t.Errorf("Expected a symmetric key to be rejected")
+	}
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scopes third-party clients can ask for. First-party tokens carry no
+// scope and may do everything.
+const (
+	ScopeChirpsRead  = "chirps:read"
+	ScopeChirpsWrite = "chirps:write"
+	ScopeProfile     = "profile"
+	ScopeDM          = "dm"
+	ScopeWebhooks    = "webhooks"
+)
+
+// ScopeDescriptions explains each scope on the consent screen.
+var ScopeDescriptions = map[string]string{
+	ScopeChirpsRead:  "Read chirps on your behalf",
+	ScopeChirpsWrite: "Post and delete chirps as you",
+	ScopeProfile:     "See your email address and account details",
+	ScopeDM:          "Read and send your direct messages",
+	ScopeWebhooks:    "Get notified about activity on your account",
+}
+
+// ParseScopes splits a space-separated scope string, rejecting unknown and
+// repeated scopes.
+func ParseScopes(scope string) ([]string, error) {
+	scopes := strings.Fields(scope)
+	seen := map[string]bool{}
+	for _, s := range scopes {
+		if _, ok := ScopeDescriptions[s]; !ok {
+			return nil, fmt.Errorf("unknown scope %q", s)
+		}
+		if seen[s] {
+			return nil, fmt.Errorf("repeated scope %q", s)
+		}
+		seen[s] = true
+	}
+	return scopes, nil
+}
+
+// ScopesAllowed reports whether every scope in requested is in allowed.
+func ScopesAllowed(requested []string, allowed []string) bool {
+	for _, want := range requested {
+		found := false
+		for _, have := range allowed {
+			if have == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ThirdParty reports whether the token was issued to an OAuth client or
+// is a personal access token, rather than a session in Chirpy's own apps.
+// Such tokens are limited to their scopes.
+func (c *Claims) ThirdParty() bool {
+	return c.ClientID != "" || c.PersonalTokenID != ""
+}
+
+// HasScope reports whether the token may be used for scope.
+func (c *Claims) HasScope(scope string) bool {
+	if !c.ThirdParty() {
+		return true
+	}
+	for _, s := range strings.Fields(c.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// MakeOAuthJWT signs an access token for an OAuth client acting for the
+// user, limited to scope.
+func MakeOAuthJWT(
+	keys *Keyring,
+	userID uuid.UUID,
+	role string,
+	sessionID uuid.UUID,
+	clientID string,
+	scope string,
+	expiresIn time.Duration) (string, error) {
+		claims := createClaims(userID, role, sessionID, expiresIn)
+		claims.ClientID = clientID
+		claims.Scope = scope
+		return keys.Sign(claims)
+}
+
+// VerifyPKCE checks a code_verifier against the S256 code_challenge sent
+// with the authorization request (RFC 7636).
+func VerifyPKCE(verifier string, challenge string) bool {
+	if !ValidPKCEVerifier(verifier) {
+		return false
+	}
+	expected := PKCEChallenge(verifier)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// PKCEChallenge is the S256 code_challenge for verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ValidPKCEVerifier reports whether verifier is 43 to 128 unreserved
+// characters, as RFC 7636 requires.
+func ValidPKCEVerifier(verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, c := range verifier {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '-' || c == '.' || c == '_' || c == '~':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ValidPKCEChallenge reports whether challenge looks like a base64url
+// SHA-256 digest.
+func ValidPKCEChallenge(challenge string) bool {
+	raw, err := base64.RawURLEncoding.DecodeString(challenge)
+	return err == nil && len(raw) == sha256.Size
+}
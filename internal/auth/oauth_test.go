@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	// RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	testCases := []struct {
+		name      string
+		verifier  string
+		challenge string
+		ok        bool
+	}{
+		{"rfc vector", verifier, challenge, true},
+		{"wrong verifier", strings.Replace(verifier, "d", "e", 1), challenge, false},
+		{"plain challenge", verifier, verifier, false},
+		{"short verifier", verifier[:42], challenge, false},
+		{"bad characters", verifier[:42] + "+", challenge, false},
+	}
+	for _, testCase := range testCases {
+		if ok := VerifyPKCE(testCase.verifier, testCase.challenge); ok != testCase.ok {
+			t.Errorf("%s: expected %v, got %v", testCase.name, testCase.ok, ok)
+		}
+	}
+	if !ValidPKCEChallenge(challenge) || ValidPKCEChallenge("abc") {
+		t.Errorf("ValidPKCEChallenge accepted the wrong challenge")
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	testCases := []struct {
+		scope   string
+		scopes  []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"profile chirps:read", []string{ScopeProfile, ScopeChirpsRead}, false},
+		{"  chirps:write  ", []string{ScopeChirpsWrite}, false},
+		{"admin", nil, true},
+		{"profile profile", nil, true},
+	}
+	for _, testCase := range testCases {
+		scopes, err := ParseScopes(testCase.scope)
+		if (err != nil) != testCase.wantErr {
+			t.Errorf("ParseScopes(%q): unexpected error %v", testCase.scope, err)
+			continue
+		}
+		if strings.Join(scopes, " ") != strings.Join(testCase.scopes, " ") {
+			t.Errorf("ParseScopes(%q): expected %v, got %v", testCase.scope, testCase.scopes, scopes)
+		}
+	}
+	if !ScopesAllowed([]string{ScopeProfile}, []string{ScopeChirpsRead, ScopeProfile}) {
+		t.Errorf("Expected profile to be allowed")
+	}
+	if ScopesAllowed([]string{ScopeDM}, []string{ScopeProfile}) {
+		t.Errorf("Expected dm not to be allowed")
+	}
+}
+
+func TestOAuthJWTScopes(t *testing.T) {
+	keys := NewKeyring("")
+	key, err := GenerateSigningKey(AlgEdDSA)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	keys.Set([]SigningKey{key})
+	token, err := MakeOAuthJWT(keys, uuid.New(), RoleUser, uuid.New(), "client-1", "profile chirps:read", time.Hour)
+	if err != nil {
+		t.Fatalf("Error making token: %v", err)
+	}
+	claims, err := ParseJWT(token, keys)
+	if err != nil {
+		t.Fatalf("Error parsing token: %v", err)
+	}
+	if !claims.ThirdParty() || claims.ClientID != "client-1" {
+		t.Errorf("Expected a third-party token for client-1, got %q", claims.ClientID)
+	}
+	if !claims.HasScope(ScopeChirpsRead) || claims.HasScope(ScopeChirpsWrite) {
+		t.Errorf("Unexpected scopes %q", claims.Scope)
+	}
+
+	firstParty, err := MakeJWT(keys, uuid.New(), RoleUser, uuid.New(), time.Hour)
+	if err != nil {
+		t.Fatalf("Error making token: %v", err)
+	}
+	claims, err = ParseJWT(firstParty, keys)
+	if err != nil {
+		t.Fatalf("Error parsing token: %v", err)
+	}
+	if claims.ThirdParty() || !claims.HasScope(ScopeDM) {
+		t.Errorf("Expected a first-party token to have every scope")
+	}
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrPasswordMismatch  = errors.New("password does not match")
+	ErrUnknownHashFormat = errors.New("unknown password hash format")
+)
+
+// PasswordHasher hashes passwords and checks them against stored hashes.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify returns ErrPasswordMismatch for a wrong password and
+	// ErrUnknownHashFormat for a hash it did not make.
+	Verify(password string, hash string) error
+	// NeedsRehash reports whether hash should be replaced with a new Hash
+	// of the password, because it is in another format or was made with
+	// other parameters.
+	NeedsRehash(hash string) bool
+}
+
+// Passwords is the hasher behind HashPassword and CheckPasswordHash. It
+// makes Argon2id hashes and still accepts the bcrypt ones Chirpy used to
+// make.
+var Passwords PasswordHasher = MigratingHasher{
+	Current: Argon2idHasher{Params: DefaultArgon2idParams},
+	Legacy:  []PasswordHasher{BcryptHasher{Cost: bcrypt.DefaultCost}},
+}
+
+func HashPassword(password string) (string, error) {
+	return Passwords.Hash(password)
+}
+
+func CheckPasswordHash(password string, hash string) error {
+	return Passwords.Verify(password, hash)
+}
+
+// PasswordNeedsRehash reports whether a hash that just verified should be
+// upgraded to the current algorithm and parameters.
+func PasswordNeedsRehash(hash string) bool {
+	return Passwords.NeedsRehash(hash)
+}
+
+// MigratingHasher hashes with Current and also verifies hashes made by the
+// Legacy hashers. Legacy hashes always need rehashing.
+type MigratingHasher struct {
+	Current PasswordHasher
+	Legacy  []PasswordHasher
+}
+
+func (h MigratingHasher) Hash(password string) (string, error) {
+	return h.Current.Hash(password)
+}
+
+func (h MigratingHasher) Verify(password string, hash string) error {
+	err := h.Current.Verify(password, hash)
+	for _, legacy := range h.Legacy {
+		if err != ErrUnknownHashFormat {
+			break
+		}
+		err = legacy.Verify(password, hash)
+	}
+	return err
+}
+
+func (h MigratingHasher) NeedsRehash(hash string) bool {
+	return h.Current.NeedsRehash(hash)
+}
+
+// Argon2idParams tune Argon2id. Memory is in KiB.
+type Argon2idParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams follow the OWASP recommendation of 19 MiB, two
+// iterations and one thread.
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Argon2idHasher makes hashes in the PHC string format, e.g.
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>, so each hash carries the
+// parameters it was made with.
+type Argon2idHasher struct {
+	Params Argon2idParams
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	p := h.Params
+	if p.Memory < 8*uint32(p.Parallelism) || p.Iterations < 1 || p.Parallelism < 1 || p.SaltLength < 8 || p.KeyLength < 16 {
+		return "", fmt.Errorf("invalid Argon2id parameters %+v", p)
+	}
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h Argon2idHasher) Verify(password string, hash string) error {
+	p, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+	p, _, _, err := decodeArgon2id(hash)
+	return err != nil || p != h.Params
+}
+
+// decodeArgon2id parses a PHC string. Hashes that are not Argon2id at all
+// get ErrUnknownHashFormat; broken ones get another error.
+func decodeArgon2id(hash string) (Argon2idParams, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return Argon2idParams{}, nil, nil, ErrUnknownHashFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2idParams{}, nil, nil, fmt.Errorf("unsupported Argon2 version %q", parts[2])
+	}
+	p := Argon2idParams{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return Argon2idParams{}, nil, nil, fmt.Errorf("invalid Argon2id parameters %q", parts[3])
+	}
+	if p.Iterations < 1 || p.Parallelism < 1 {
+		return Argon2idParams{}, nil, nil, fmt.Errorf("invalid Argon2id parameters %q", parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2idParams{}, nil, nil, fmt.Errorf("invalid Argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2idParams{}, nil, nil, fmt.Errorf("invalid Argon2id key")
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
+}
+
+// BcryptHasher is what Chirpy hashed passwords with before Argon2id. Note
+// that bcrypt only looks at the first 72 bytes of a password.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h BcryptHasher) Verify(password string, hash string) error {
+	if !isBcryptHash(hash) {
+		return ErrUnknownHashFormat
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+	if !isBcryptHash(hash) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fastArgon2id keeps the tests quick.
+var fastArgon2id = Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	hasher := Argon2idHasher{Params: fastArgon2id}
+	// longer than bcrypt's 72 byte limit, differing only at the end
+	long := strings.Repeat("a", 100)
+	hash, err := hasher.Hash(long + "1")
+	if err != nil {
+		t.Fatalf("Error hashing: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Unexpected hash format %q", hash)
+	}
+	if err := hasher.Verify(long+"1", hash); err != nil {
+		t.Errorf("Expected the password to verify, got %v", err)
+	}
+	if err := hasher.Verify(long+"2", hash); err != ErrPasswordMismatch {
+		t.Errorf("Expected ErrPasswordMismatch, got %v", err)
+	}
+	other, _ := hasher.Hash(long + "1")
+	if other == hash {
+		t.Error("Expected a fresh salt for every hash")
+	}
+}
+
+func TestArgon2idNeedsRehash(t *testing.T) {
+	hasher := Argon2idHasher{Params: fastArgon2id}
+	hash, _ := hasher.Hash("password")
+	if hasher.NeedsRehash(hash) {
+		t.Error("Expected a hash with current parameters to be kept")
+	}
+	stronger := fastArgon2id
+	stronger.Iterations = 2
+	if !(Argon2idHasher{Params: stronger}).NeedsRehash(hash) {
+		t.Error("Expected a hash with old parameters to need rehashing")
+	}
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if !hasher.NeedsRehash(string(bcryptHash)) {
+		t.Error("Expected a bcrypt hash to need rehashing")
+	}
+}
+
+func TestArgon2idRejectsBrokenHashes(t *testing.T) {
+	hasher := Argon2idHasher{Params: fastArgon2id}
+	testCases := []struct {
+		hash    string
+		unknown bool
+	}{
+		{"", true},
+		{"$2a$10$abcdefghijklmnopqrstuv", true},
+		{"$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5", true},
+		{"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5", false},
+		{"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$a2V5", false},
+		{"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$", false},
+		{"$argon2id$v=19$m=64,t=1,p=1$!!$a2V5", false},
+	}
+	for _, testCase := range testCases {
+		err := hasher.Verify("password", testCase.hash)
+		if err == nil || err == ErrPasswordMismatch {
+			t.Errorf("%q: expected a format error, got %v", testCase.hash, err)
+		}
+		if (err == ErrUnknownHashFormat) != testCase.unknown {
+			t.Errorf("%q: unexpected error %v", testCase.hash, err)
+		}
+	}
+}
+
+func TestMigratingHasher(t *testing.T) {
+	hasher := MigratingHasher{
+		Current: Argon2idHasher{Params: fastArgon2id},
+		Legacy:  []PasswordHasher{BcryptHasher{Cost: bcrypt.MinCost}},
+	}
+	legacy, _ := BcryptHasher{Cost: bcrypt.MinCost}.Hash("hunter2")
+	if err := hasher.Verify("hunter2", legacy); err != nil {
+		t.Errorf("Expected a legacy bcrypt hash to verify, got %v", err)
+	}
+	if err := hasher.Verify("hunter3", legacy); err != ErrPasswordMismatch {
+		t.Errorf("Expected ErrPasswordMismatch, got %v", err)
+	}
+	if !hasher.NeedsRehash(legacy) {
+		t.Error("Expected a legacy hash to need rehashing")
+	}
+
+	current, err := hasher.Hash("hunter2")
+	if err != nil {
+		t.Fatalf("Error hashing: %v", err)
+	}
+	if err := hasher.Verify("hunter2", current); err != nil {
+		t.Errorf("Expected the new hash to verify, got %v", err)
+	}
+	if hasher.NeedsRehash(current) {
+		t.Error("Expected the new hash to be kept")
+	}
+	if err := hasher.Verify("hunter2", "plaintext"); !errors.Is(err, ErrUnknownHashFormat) {
+		t.Errorf("Expected ErrUnknownHashFormat, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// PATPrefix starts every personal access token, so they can be told apart
+// from JWTs and found by secret scanners.
+const PATPrefix = "chirpy_pat_"
+
+// ErrTokenIPNotAllowed is returned for a personal access token used from
+// an address outside its allowlist.
+var ErrTokenIPNotAllowed = errors.New("token may not be used from this address")
+
+// MakePAT returns a new random personal access token.
+func MakePAT() (string, error) {
+	secret, err := MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	return PATPrefix + secret, nil
+}
+
+// IsPAT reports whether a bearer token is a personal access token rather
+// than a JWT.
+func IsPAT(token string) bool {
+	return strings.HasPrefix(token, PATPrefix)
+}
+
+// ParseIPAllowlist normalizes addresses and CIDR ranges into prefixes. A
+// bare address allows just itself.
+func ParseIPAllowlist(entries []string) ([]string, error) {
+	prefixes := []string{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()).String())
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address or CIDR range %q", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked().String())
+	}
+	return prefixes, nil
+}
+
+// IPAllowed reports whether ip falls in one of the prefixes. An empty
+// allowlist allows every address.
+func IPAllowed(ip string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		prefix, err := netip.ParsePrefix(p)
+		if err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
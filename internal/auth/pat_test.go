@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMakePAT(t *testing.T) {
+	token, err := MakePAT()
+	if err != nil {
+		t.Fatalf("Error making token: %v", err)
+	}
+	if !IsPAT(token) || len(token) != len(PATPrefix)+64 {
+		t.Errorf("Unexpected token %q", token)
+	}
+	other, _ := MakePAT()
+	if other == token {
+		t.Error("Expected tokens to differ")
+	}
+	if IsPAT("eyJhbGciOiJFZERTQSJ9.e30.sig") {
+		t.Error("Expected a JWT not to be a personal access token")
+	}
+}
+
+func TestParseIPAllowlist(t *testing.T) {
+	prefixes, err := ParseIPAllowlist([]string{"203.0.113.7", " 10.1.2.3/8", "2001:db8::/32", "::ffff:192.0.2.1"})
+	if err != nil {
+		t.Fatalf("Error parsing: %v", err)
+	}
+	expected := []string{"203.0.113.7/32", "10.0.0.0/8", "2001:db8::/32", "192.0.2.1/32"}
+	if !reflect.DeepEqual(prefixes, expected) {
+		t.Errorf("Expected %v, got %v", expected, prefixes)
+	}
+	for _, bad := range []string{"", "example.com", "10.0.0.0/33"} {
+		if _, err := ParseIPAllowlist([]string{bad}); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	allowlist := []string{"10.0.0.0/8", "2001:db8::/32", "203.0.113.7/32"}
+	testCases := []struct {
+		ip       string
+		prefixes []string
+		allowed  bool
+	}{
+		{"198.51.100.1", nil, true},
+		{"10.20.30.40", allowlist, true},
+		{"::ffff:10.0.0.1", allowlist, true},
+		{"2001:db8::1", allowlist, true},
+		{"203.0.113.7", allowlist, true},
+		{"203.0.113.8", allowlist, false},
+		{"2001:db9::1", allowlist, false},
+		{"not-an-ip", allowlist, false},
+	}
+	for _, testCase := range testCases {
+		if got := IPAllowed(testCase.ip, testCase.prefixes); got != testCase.allowed {
+			t.Errorf("IPAllowed(%q) = %v, expected %v", testCase.ip, got, testCase.allowed)
+		}
+	}
+}
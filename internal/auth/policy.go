@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// PasswordViolation is one way a password fails the policy.
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// BreachedPasswords knows passwords that have leaked in data breaches.
+type BreachedPasswords interface {
+	Contains(password string) (bool, error)
+}
+
+// PasswordPolicy decides which passwords users may choose. Lengths count
+// characters, not bytes.
+type PasswordPolicy struct {
+	MinLength int
+	// MaxLength bounds the work of hashing a password. Argon2id takes any
+	// length, unlike bcrypt which ignored everything past 72 bytes.
+	MaxLength int
+	// Breached, when set, rejects known leaked passwords.
+	Breached BreachedPasswords
+}
+
+// DefaultPasswordPolicy follows NIST SP 800-63B: at least 8 characters
+// and room for long passphrases.
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength: 8,
+	MaxLength: 128,
+}
+
+// Check returns every rule password breaks for the user with email. An
+// error means the breached list could not be searched; the other rules
+// are still checked.
+func (p PasswordPolicy) Check(password string, email string) ([]PasswordViolation, error) {
+	violations := []PasswordViolation{}
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, PasswordViolation{
+			Code:    "password_too_short",
+			Message: fmt.Sprintf("Password must be at least %d characters", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, PasswordViolation{
+			Code:    "password_too_long",
+			Message: fmt.Sprintf("Password must be at most %d characters", p.MaxLength),
+		})
+	}
+	if containsEmail(password, email) {
+		violations = append(violations, PasswordViolation{
+			Code:    "password_contains_email",
+			Message: "Password must not contain your email address",
+		})
+	}
+	if p.Breached == nil || password == "" {
+		return violations, nil
+	}
+	breached, err := p.Breached.Contains(password)
+	if err != nil {
+		return violations, err
+	}
+	if breached {
+		violations = append(violations, PasswordViolation{
+			Code:    "password_breached",
+			Message: "Password has appeared in a data breach, choose another one",
+		})
+	}
+	return violations, nil
+}
+
+// containsEmail reports whether password contains the email address or
+// is just its local part.
+func containsEmail(password string, email string) bool {
+	password = strings.ToLower(password)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" || password == "" {
+		return false
+	}
+	local, _, _ := strings.Cut(email, "@")
+	return strings.Contains(password, email) || password == local
+}
+
+// maxBreachedLineLength is longer than any "HASH:COUNT" line.
+const maxBreachedLineLength = 128
+
+// BreachedPasswordFile searches a Pwned Passwords SHA-1 file on disk: one
+// upper-case hex SHA-1 per line, optionally followed by ":count", sorted
+// by hash. This is what the haveibeenpwned downloader builds from the
+// k-anonymity range API, and it is searched in place with a binary search
+// so the file never has to fit in memory.
+type BreachedPasswordFile struct {
+	file io.ReaderAt
+	size int64
+}
+
+func OpenBreachedPasswordFile(path string) (*BreachedPasswordFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &BreachedPasswordFile{file: file, size: info.Size()}, nil
+}
+
+func (f *BreachedPasswordFile) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	target := []byte(strings.ToUpper(hex.EncodeToString(sum[:])))
+	// lo is always the start of a line
+	lo, hi := int64(0), f.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, line, err := f.lineAt(mid)
+		if err != nil {
+			return false, err
+		}
+		if start >= hi || line == nil {
+			hi = mid
+			continue
+		}
+		hash, _, _ := bytes.Cut(bytes.TrimSpace(line), []byte(":"))
+		switch bytes.Compare(bytes.ToUpper(hash), target) {
+		case 0:
+			return true, nil
+		case -1:
+			lo = start + int64(len(line)) + 1
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+// lineAt returns the first line starting at or after off, without its
+// newline. line is nil past the last line.
+func (f *BreachedPasswordFile) lineAt(off int64) (int64, []byte, error) {
+	start := off
+	if off > 0 {
+		// back up one byte so a line starting exactly at off is found
+		start = off - 1
+	}
+	buf := make([]byte, 2*maxBreachedLineLength)
+	n, err := f.file.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return 0, nil, err
+	}
+	buf = buf[:n]
+	if off > 0 {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			return f.size, nil, nil
+		}
+		start += int64(i) + 1
+		buf = buf[i+1:]
+	}
+	if len(buf) == 0 {
+		return f.size, nil, nil
+	}
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		buf = buf[:i]
+	} else if start+int64(len(buf)) < f.size {
+		return 0, nil, fmt.Errorf("line at offset %d is longer than %d bytes", start, maxBreachedLineLength)
+	}
+	return start, buf, nil
+}
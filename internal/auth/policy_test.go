@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeBreachedFile writes a Pwned Passwords style file with the given
+// passwords and some filler hashes.
+func writeBreachedFile(t *testing.T, passwords []string, lineEnd string) string {
+	t.Helper()
+	lines := []string{}
+	for i, password := range passwords {
+		sum := sha1.Sum([]byte(password))
+		lines = append(lines, fmt.Sprintf("%s:%d", strings.ToUpper(hex.EncodeToString(sum[:])), i+1))
+	}
+	for i := 0; i < 500; i++ {
+		sum := sha1.Sum([]byte(fmt.Sprintf("filler-%d", i)))
+		lines = append(lines, strings.ToUpper(hex.EncodeToString(sum[:]))+":1")
+	}
+	sort.Strings(lines)
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, lineEnd)), 0o600); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	return path
+}
+
+func TestBreachedPasswordFile(t *testing.T) {
+	breached := []string{"password", "123456", "hunter2", "correct horse battery staple"}
+	for _, lineEnd := range []string{"\n", "\r\n"} {
+		list, err := OpenBreachedPasswordFile(writeBreachedFile(t, breached, lineEnd))
+		if err != nil {
+			t.Fatalf("Error opening file: %v", err)
+		}
+		for _, password := range breached {
+			if found, err := list.Contains(password); err != nil || !found {
+				t.Errorf("Expected %q to be found, got %v, %v", password, found, err)
+			}
+		}
+		for i := 0; i < 500; i += 37 {
+			if found, _ := list.Contains(fmt.Sprintf("filler-%d", i)); !found {
+				t.Errorf("Expected filler-%d to be found", i)
+			}
+		}
+		for _, password := range []string{"", "not in the list", "Password"} {
+			if found, err := list.Contains(password); err != nil || found {
+				t.Errorf("Expected %q not to be found, got %v, %v", password, found, err)
+			}
+		}
+	}
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	list, err := OpenBreachedPasswordFile(writeBreachedFile(t, []string{"password123"}, "\n"))
+	if err != nil {
+		t.Fatalf("Error opening file: %v", err)
+	}
+	policy := PasswordPolicy{MinLength: 8, MaxLength: 20, Breached: list}
+	testCases := []struct {
+		password string
+		email    string
+		codes    []string
+	}{
+		{"a long passphrase", "ada@example.com", nil},
+		{"", "ada@example.com", []string{"password_too_short"}},
+		{"short", "ada@example.com", []string{"password_too_short"}},
+		{"ünïcödé", "ada@example.com", []string{"password_too_short"}},
+		{"ünïcödé!", "ada@example.com", nil},
+		{strings.Repeat("x", 21), "ada@example.com", []string{"password_too_long"}},
+		{"my ADA@example.com!", "ada@example.com", []string{"password_contains_email"}},
+		{"lovelace", "Lovelace@example.com", []string{"password_contains_email"}},
+		{"password123", "ada@example.com", []string{"password_breached"}},
+		{"ada", "ada@example.com", []string{"password_too_short", "password_contains_email"}},
+	}
+	for _, testCase := range testCases {
+		violations, err := policy.Check(testCase.password, testCase.email)
+		if err != nil {
+			t.Fatalf("%q: error checking: %v", testCase.password, err)
+		}
+		codes := []string{}
+		for _, violation := range violations {
+			codes = append(codes, violation.Code)
+		}
+		if strings.Join(codes, ",") != strings.Join(testCase.codes, ",") {
+			t.Errorf("%q: expected %v, got %v", testCase.password, testCase.codes, codes)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// roleRanks orders roles so that every role includes the permissions of
+// the roles ranked below it.
+var roleRanks = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// Claims are the JWT claims Chirpy issues. SessionID is the refresh token
+// family the access token was minted from. MFAAt is when the user last
+// passed a second factor, if they did for this token. ClientID and Scope
+// are set on tokens issued to OAuth clients. PersonalTokenID is never in
+// a JWT; it is set on the claims built for a personal access token.
+type Claims struct {
+	jwt.RegisteredClaims
+	Role            string           `json:"role"`
+	SessionID       string           `json:"sid,omitempty"`
+	MFAAt           *jwt.NumericDate `json:"mfa_at,omitempty"`
+	ClientID        string           `json:"client_id,omitempty"`
+	Scope           string           `json:"scope,omitempty"`
+	PersonalTokenID string           `json:"-"`
+}
+
+// MFASince reports whether the user passed a second factor at or after t.
+func (c *Claims) MFASince(t time.Time) bool {
+	return c.MFAAt != nil && !c.MFAAt.Time.Before(t)
+}
+
+// ValidRole reports whether role is one we know about.
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// HasRole reports whether a user with role have may do what requires
+// role want. Unknown roles have no permissions.
+func HasRole(have, want string) bool {
+	haveRank, ok := roleRanks[have]
+	if !ok {
+		return false
+	}
+	return haveRank >= roleRanks[want]
+}
+
+// Outranks reports whether a user with role have ranks strictly above
+// one with role target, as moderating another user requires.
+func Outranks(have, target string) bool {
+	haveRank, ok := roleRanks[have]
+	if !ok {
+		return false
+	}
+	return haveRank > roleRanks[target]
+}
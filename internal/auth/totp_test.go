@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 test key from RFC 6238 appendix B.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode_RFC6238(t *testing.T) {
+	testCases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, testCase := range testCases {
+		code, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(testCase.unix, 0)))
+		if err != nil {
+			t.Fatalf("Error generating code: %v", err)
+		}
+		if code != testCase.code {
+			t.Errorf("At %d: expected %v, got %v", testCase.unix, testCase.code, code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("Error generating secret: %v", err)
+	}
+	now := time.Now()
+	step := TOTPStep(now)
+	testCases := []struct {
+		name string
+		step int64
+		ok   bool
+	}{
+		{"current", step, true},
+		{"previous", step - 1, true},
+		{"next", step + 1, true},
+		{"too old", step - 2, false},
+	}
+	for _, testCase := range testCases {
+		code, _ := TOTPCode(secret, testCase.step)
+		got, ok := ValidateTOTP(secret, code, now)
+		if ok != testCase.ok || (ok && got != testCase.step) {
+			t.Errorf("%s: expected (%v, %v), got (%v, %v)", testCase.name, testCase.step, testCase.ok, got, ok)
+		}
+	}
+	if _, ok := ValidateTOTP(secret, "12345", now); ok {
+		t.Errorf("Expected a short code to be rejected")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("ABC", "Chirpy", "a@b.c")
+	if !strings.HasPrefix(uri, "otpauth://totp/Chirpy:a@b.c?") || !strings.Contains(uri, "secret=ABC") {
+		t.Errorf("Unexpected URI %v", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("Error generating recovery codes: %v", err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("Expected %d codes, got %d", RecoveryCodeCount, len(codes))
+	}
+	code := codes[0]
+	loose := strings.ToUpper(strings.ReplaceAll(code, "-", ""))
+	if HashRecoveryCode(code, "pepper") != HashRecoveryCode(loose, "pepper") {
+		t.Errorf("Expected %v and %v to hash the same", code, loose)
+	}
+	if HashRecoveryCode(codes[0], "pepper") == HashRecoveryCode(codes[1], "pepper") {
+		t.Errorf("Expected different codes to hash differently")
+	}
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// Issuer and Audience are the iss and aud claims of every access
+	// token Chirpy issues.
+	Issuer   = "chirpy"
+	Audience = "chirpy-api"
+
+	DefaultLeeway = 30 * time.Second
+)
+
+// Errors returned by Validator.Validate. Handlers map them to the code of
+// a 401 response.
+var (
+	ErrTokenMalformed    = errors.New("token is malformed")
+	ErrTokenSignature    = errors.New("token signature is invalid")
+	ErrTokenExpired      = errors.New("token is expired")
+	ErrTokenNotYetValid  = errors.New("token is not valid yet")
+	ErrTokenIssuer       = errors.New("token has the wrong issuer")
+	ErrTokenAudience     = errors.New("token has the wrong audience")
+	ErrTokenClaimMissing = errors.New("token is missing a required claim")
+	ErrTokenRevoked      = errors.New("token is revoked")
+)
+
+// RevocationList reports whether an access token was revoked before it
+// expired.
+type RevocationList interface {
+	IsAccessTokenRevoked(ctx context.Context, jti uuid.UUID) (bool, error)
+}
+
+// Validator checks access tokens. The zero values of Issuer, Audience,
+// Algorithms and Leeway are not usable; build one with NewValidator.
+type Validator struct {
+	Keys *Keyring
+	// Algorithms is the allow-list of alg headers. The keyring also checks
+	// that the alg matches the key named by kid.
+	Algorithms []string
+	Issuer     string
+	Audience   string
+	// Leeway is the clock skew tolerated on exp, nbf and iat.
+	Leeway time.Duration
+	// Revoked, when set, is consulted for every token's jti.
+	Revoked RevocationList
+}
+
+// NewValidator returns a validator for tokens issued by MakeJWT. HS256 is
+// only allowed while the keyring has a legacy secret.
+func NewValidator(keys *Keyring) *Validator {
+	algs := []string{AlgEdDSA, AlgRS256}
+	if keys.legacyHMAC != nil {
+		algs = append(algs, jwt.SigningMethodHS256.Alg())
+	}
+	return &Validator{
+		Keys:       keys,
+		Algorithms: algs,
+		Issuer:     Issuer,
+		Audience:   Audience,
+		Leeway:     DefaultLeeway,
+	}
+}
+
+// Validate verifies the token and returns its claims. Every error wraps
+// one of the ErrToken values.
+func (v *Validator) Validate(ctx context.Context, tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		claims,
+		v.Keys.Keyfunc,
+		jwt.WithValidMethods(v.Algorithms),
+		jwt.WithIssuer(v.Issuer),
+		jwt.WithAudience(v.Audience),
+		jwt.WithLeeway(v.Leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, classifyJWTError(err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: sub", ErrTokenClaimMissing)
+	}
+	jti, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: jti", ErrTokenClaimMissing)
+	}
+	if v.Revoked != nil {
+		revoked, err := v.Revoked.IsAccessTokenRevoked(ctx, jti)
+		if err != nil {
+			return nil, fmt.Errorf("checking token revocation: %w", err)
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+	return claims, nil
+}
+
+// classifyJWTError maps jwt parse errors to our typed errors.
+func classifyJWTError(err error) error {
+	var kind error
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		kind = ErrTokenExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		kind = ErrTokenNotYetValid
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		kind = ErrTokenIssuer
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		kind = ErrTokenAudience
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		kind = ErrTokenClaimMissing
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		kind = ErrTokenSignature
+	default:
+		kind = ErrTokenMalformed
+	}
+	slog.Debug("Rejected token", "error", err)
+	return fmt.Errorf("%w: %v", kind, err)
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+type revokedSet map[uuid.UUID]bool
+
+func (s revokedSet) IsAccessTokenRevoked(ctx context.Context, jti uuid.UUID) (bool, error) {
+	return s[jti], nil
+}
+
+func TestValidator_Validate(t *testing.T) {
+	key, err := GenerateSigningKey(AlgEdDSA)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	keys := NewKeyring("")
+	keys.Set([]SigningKey{key})
+	revokedID := uuid.New()
+	validator := NewValidator(keys)
+	validator.Revoked = revokedSet{revokedID: true}
+
+	sign := func(edit func(*Claims)) string {
+		claims := createClaims(uuid.New(), RoleUser, uuid.New(), time.Minute)
+		edit(&claims)
+		signed, err := keys.Sign(claims)
+		if err != nil {
+			t.Fatalf("Error signing token: %v", err)
+		}
+		return signed
+	}
+	past := func(d time.Duration) *jwt.NumericDate {
+		return jwt.NewNumericDate(time.Now().Add(-d))
+	}
+
+	testCases := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"valid", sign(func(c *Claims) {}), nil},
+		{"expired within leeway", sign(func(c *Claims) { c.ExpiresAt = past(10 * time.Second) }), nil},
+		{"expired", sign(func(c *Claims) { c.ExpiresAt = past(time.Minute) }), ErrTokenExpired},
+		{"no exp", sign(func(c *Claims) { c.ExpiresAt = nil }), ErrTokenClaimMissing},
+		{"issued in the future", sign(func(c *Claims) { c.IssuedAt = past(-time.Hour) }), ErrTokenNotYetValid},
+		{"wrong issuer", sign(func(c *Claims) { c.Issuer = "someone-else" }), ErrTokenIssuer},
+		{"wrong audience", sign(func(c *Claims) { c.Audience = jwt.ClaimStrings{"billing"} }), ErrTokenAudience},
+		{"no jti", sign(func(c *Claims) { c.ID = "" }), ErrTokenClaimMissing},
+		{"revoked", sign(func(c *Claims) { c.ID = revokedID.String() }), ErrTokenRevoked},
+		{"garbage", "not.a.token", ErrTokenMalformed},
+	}
+	for _, testCase := range testCases {
+		_, err := validator.Validate(context.Background(), testCase.token)
+		if testCase.err == nil && err != nil {
+			t.Errorf("%s: unexpected error %v", testCase.name, err)
+		}
+		if testCase.err != nil && !errors.Is(err, testCase.err) {
+			t.Errorf("%s: expected %v, got %v", testCase.name, testCase.err, err)
+		}
+	}
+}
+
+func TestValidator_AlgorithmAllowList(t *testing.T) {
+	key, _ := GenerateSigningKey(AlgRS256)
+	keys := NewKeyring("")
+	keys.Set([]SigningKey{key})
+	token, err := MakeJWT(keys, uuid.New(), RoleUser, uuid.New(), time.Minute)
+	if err != nil {
+		t.Fatalf("Error making token: %v", err)
+	}
+	validator := NewValidator(keys)
+	validator.Algorithms = []string{AlgEdDSA}
+	if _, err := validator.Validate(context.Background(), token); !errors.Is(err, ErrTokenSignature) {
+		t.Errorf("Expected RS256 to be rejected by an EdDSA-only validator, got %v", err)
+	}
+}
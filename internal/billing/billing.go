@@ -0,0 +1,223 @@
+// Package billing tracks Chirpy Red subscriptions. Polka, the payment
+// provider, sends billing events; Apply turns each one into the new state
+// of the user's subscription, and Expirer ends subscriptions that lapsed
+// without a renewal.
+package billing
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/google/uuid"
+)
+
+const (
+	StatusActive = "active"
+	// StatusPastDue means a payment failed. The subscriber keeps Red until
+	// the grace period ends.
+	StatusPastDue  = "past_due"
+	StatusCanceled = "canceled"
+	StatusExpired  = "expired"
+)
+
+const (
+	PlanRedMonthly = "red_monthly"
+	PlanRedYearly  = "red_yearly"
+)
+
+// planMonths is the billing period of each plan.
+var planMonths = map[string]int{
+	PlanRedMonthly: 1,
+	PlanRedYearly:  12,
+}
+
+// Events Polka sends about subscriptions.
+const (
+	EventUpgraded      = "user.upgraded"
+	EventRenewed       = "subscription.renewed"
+	EventPaymentFailed = "payment.failed"
+	EventDowngraded    = "user.downgraded"
+	EventExpired       = "subscription.expired"
+)
+
+var (
+	// GracePeriod is how long a past-due subscriber keeps Red after the
+	// later of the failed payment and the end of the paid period.
+	GracePeriod = 7 * 24 * time.Hour
+	// RenewalLeeway is how long after its period ends an active
+	// subscription waits for a late renewal before it expires.
+	RenewalLeeway = 24 * time.Hour
+)
+
+var (
+	ErrUnknownPlan  = errors.New("unknown plan")
+	ErrUnknownEvent = errors.New("unknown billing event")
+	// ErrNoSubscription is returned for events that only make sense for
+	// an existing subscription.
+	ErrNoSubscription = errors.New("no subscription")
+)
+
+// Subscription is the billing state of one user.
+type Subscription struct {
+	Plan        string
+	Status      string
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+	// GraceUntil is set while the subscription is past due.
+	GraceUntil time.Time
+	CanceledAt time.Time
+}
+
+// Red reports whether the subscription gives Chirpy Red.
+func (s Subscription) Red() bool {
+	return s.Status == StatusActive || s.Status == StatusPastDue
+}
+
+// Event is a billing event. Plan and the period are optional; without
+// them the current plan, or the monthly one, and a period starting now
+// are assumed.
+type Event struct {
+	Type        string
+	Plan        string
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+}
+
+// KnownEvent reports whether Apply handles events of type t.
+func KnownEvent(t string) bool {
+	switch t {
+	case EventUpgraded, EventRenewed, EventPaymentFailed, EventDowngraded, EventExpired:
+		return true
+	}
+	return false
+}
+
+// Apply returns the subscription after event. sub is nil for a user who
+// never subscribed.
+func Apply(sub *Subscription, event Event, now time.Time) (Subscription, error) {
+	switch event.Type {
+	case EventUpgraded, EventRenewed:
+		return subscribe(sub, event, now)
+	}
+	if sub == nil {
+		return Subscription{}, ErrNoSubscription
+	}
+	next := *sub
+	switch event.Type {
+	case EventPaymentFailed:
+		// repeated failures do not stretch the grace period
+		if next.Status != StatusActive {
+			return next, nil
+		}
+		next.Status = StatusPastDue
+		graceFrom := next.PeriodEnd
+		if now.After(graceFrom) {
+			graceFrom = now
+		}
+		next.GraceUntil = graceFrom.Add(GracePeriod)
+	case EventDowngraded:
+		if !next.Red() {
+			return next, nil
+		}
+		next.Status = StatusCanceled
+		next.GraceUntil = time.Time{}
+		next.CanceledAt = now
+	case EventExpired:
+		next.Status = StatusExpired
+		next.GraceUntil = time.Time{}
+	default:
+		return Subscription{}, ErrUnknownEvent
+	}
+	return next, nil
+}
+
+// subscribe starts a new period. A renewal that arrives early starts where
+// the paid period ends, and a stale one never shortens it.
+func subscribe(sub *Subscription, event Event, now time.Time) (Subscription, error) {
+	plan := event.Plan
+	if plan == "" && sub != nil {
+		plan = sub.Plan
+	}
+	if plan == "" {
+		plan = PlanRedMonthly
+	}
+	months, ok := planMonths[plan]
+	if !ok {
+		return Subscription{}, ErrUnknownPlan
+	}
+	start := event.PeriodStart
+	if start.IsZero() {
+		start = now
+		if sub != nil && event.Type == EventRenewed && sub.Red() && sub.PeriodEnd.After(now) {
+			start = sub.PeriodEnd
+		}
+	}
+	end := event.PeriodEnd
+	if end.IsZero() {
+		end = start.AddDate(0, months, 0)
+	}
+	if sub != nil && sub.Red() && end.Before(sub.PeriodEnd) {
+		start, end = sub.PeriodStart, sub.PeriodEnd
+	}
+	return Subscription{
+		Plan:        plan,
+		Status:      StatusActive,
+		PeriodStart: start,
+		PeriodEnd:   end,
+	}, nil
+}
+
+// FromDB converts a stored subscription.
+func FromDB(sub db.Subscription) Subscription {
+	return Subscription{
+		Plan:        sub.Plan,
+		Status:      sub.Status,
+		PeriodStart: sub.CurrentPeriodStart,
+		PeriodEnd:   sub.CurrentPeriodEnd,
+		GraceUntil:  sub.GraceUntil.Time,
+		CanceledAt:  sub.CanceledAt.Time,
+	}
+}
+
+// Queries is the subset of db.Queries Expirer needs.
+type Queries interface {
+	ExpireLapsedSubscriptions(ctx context.Context, arg db.ExpireLapsedSubscriptionsParams) ([]uuid.UUID, error)
+}
+
+// Expirer ends subscriptions whose period or grace period is over, and
+// takes Red away from their users.
+type Expirer struct {
+	Queries Queries
+}
+
+// Expire ends lapsed subscriptions and returns their users.
+func (e Expirer) Expire(ctx context.Context, now time.Time) ([]uuid.UUID, error) {
+	return e.Queries.ExpireLapsedSubscriptions(ctx, db.ExpireLapsedSubscriptionsParams{
+		ActiveBefore: now.Add(-RenewalLeeway),
+		GraceBefore:  now,
+	})
+}
+
+// Run expires lapsed subscriptions every interval until ctx is done.
+func (e Expirer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			userIDs, err := e.Expire(ctx, time.Now())
+			if err != nil {
+				slog.Error("Error expiring subscriptions", "error", err)
+				continue
+			}
+			for _, userID := range userIDs {
+				slog.Info("Subscription expired", "userID", userID)
+			}
+		}
+	}
+}
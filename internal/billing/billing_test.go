@@ -0,0 +1,170 @@
+package billing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/google/uuid"
+)
+
+func TestApply(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	active := &Subscription{
+		Plan:        PlanRedMonthly,
+		Status:      StatusActive,
+		PeriodStart: now.AddDate(0, 0, -20),
+		PeriodEnd:   now.AddDate(0, 0, 10),
+	}
+	pastDue := &Subscription{
+		Plan:        PlanRedMonthly,
+		Status:      StatusPastDue,
+		PeriodStart: now.AddDate(0, -1, -5),
+		PeriodEnd:   now.AddDate(0, 0, -5),
+		GraceUntil:  now.AddDate(0, 0, 2),
+	}
+	canceled := &Subscription{
+		Plan:       PlanRedYearly,
+		Status:     StatusCanceled,
+		PeriodEnd:  now.AddDate(0, 0, -1),
+		CanceledAt: now.AddDate(0, 0, -1),
+	}
+
+	testCases := []struct {
+		name     string
+		sub      *Subscription
+		event    Event
+		expected Subscription
+		err      error
+	}{
+		{
+			name:     "first upgrade",
+			event:    Event{Type: EventUpgraded},
+			expected: Subscription{Plan: PlanRedMonthly, Status: StatusActive, PeriodStart: now, PeriodEnd: now.AddDate(0, 1, 0)},
+		},
+		{
+			name:  "upgrade with a period",
+			event: Event{Type: EventUpgraded, Plan: PlanRedYearly, PeriodStart: now.Add(-time.Hour), PeriodEnd: now.AddDate(1, 0, 0)},
+			expected: Subscription{Plan: PlanRedYearly, Status: StatusActive,
+				PeriodStart: now.Add(-time.Hour), PeriodEnd: now.AddDate(1, 0, 0)},
+		},
+		{
+			name:  "unknown plan",
+			event: Event{Type: EventUpgraded, Plan: "gold"},
+			err:   ErrUnknownPlan,
+		},
+		{
+			name:  "early renewal starts at the end of the paid period",
+			sub:   active,
+			event: Event{Type: EventRenewed},
+			expected: Subscription{Plan: PlanRedMonthly, Status: StatusActive,
+				PeriodStart: active.PeriodEnd, PeriodEnd: active.PeriodEnd.AddDate(0, 1, 0)},
+		},
+		{
+			name:     "stale renewal does not shorten the period",
+			sub:      active,
+			event:    Event{Type: EventRenewed, PeriodStart: now.AddDate(0, -2, 0), PeriodEnd: now.AddDate(0, -1, 0)},
+			expected: *active,
+		},
+		{
+			name:     "renewal recovers a past-due subscription",
+			sub:      pastDue,
+			event:    Event{Type: EventRenewed},
+			expected: Subscription{Plan: PlanRedMonthly, Status: StatusActive, PeriodStart: now, PeriodEnd: now.AddDate(0, 1, 0)},
+		},
+		{
+			name:     "resubscribing after canceling",
+			sub:      canceled,
+			event:    Event{Type: EventUpgraded},
+			expected: Subscription{Plan: PlanRedYearly, Status: StatusActive, PeriodStart: now, PeriodEnd: now.AddDate(1, 0, 0)},
+		},
+		{
+			name:  "payment failure starts the grace period at the end of the paid period",
+			sub:   active,
+			event: Event{Type: EventPaymentFailed},
+			expected: Subscription{Plan: PlanRedMonthly, Status: StatusPastDue,
+				PeriodStart: active.PeriodStart, PeriodEnd: active.PeriodEnd, GraceUntil: active.PeriodEnd.Add(GracePeriod)},
+		},
+		{
+			name:     "repeated payment failure keeps the grace period",
+			sub:      pastDue,
+			event:    Event{Type: EventPaymentFailed},
+			expected: *pastDue,
+		},
+		{
+			name:  "downgrade",
+			sub:   pastDue,
+			event: Event{Type: EventDowngraded},
+			expected: Subscription{Plan: PlanRedMonthly, Status: StatusCanceled,
+				PeriodStart: pastDue.PeriodStart, PeriodEnd: pastDue.PeriodEnd, CanceledAt: now},
+		},
+		{
+			name:     "downgrade of a canceled subscription",
+			sub:      canceled,
+			event:    Event{Type: EventDowngraded},
+			expected: *canceled,
+		},
+		{
+			name:  "expiry",
+			sub:   active,
+			event: Event{Type: EventExpired},
+			expected: Subscription{Plan: PlanRedMonthly, Status: StatusExpired,
+				PeriodStart: active.PeriodStart, PeriodEnd: active.PeriodEnd},
+		},
+		{
+			name:  "payment failure without a subscription",
+			event: Event{Type: EventPaymentFailed},
+			err:   ErrNoSubscription,
+		},
+		{
+			name:  "unknown event",
+			sub:   active,
+			event: Event{Type: "user.deleted"},
+			err:   ErrUnknownEvent,
+		},
+	}
+	for _, testCase := range testCases {
+		sub, err := Apply(testCase.sub, testCase.event, now)
+		if err != testCase.err {
+			t.Errorf("%s: expected error %v, got %v", testCase.name, testCase.err, err)
+			continue
+		}
+		if err == nil && sub != testCase.expected {
+			t.Errorf("%s: expected %+v, got %+v", testCase.name, testCase.expected, sub)
+		}
+	}
+}
+
+func TestRed(t *testing.T) {
+	for status, red := range map[string]bool{
+		StatusActive:   true,
+		StatusPastDue:  true,
+		StatusCanceled: false,
+		StatusExpired:  false,
+	} {
+		if (Subscription{Status: status}).Red() != red {
+			t.Errorf("Expected Red() of %s to be %v", status, red)
+		}
+	}
+}
+
+type fakeQueries struct {
+	arg db.ExpireLapsedSubscriptionsParams
+}
+
+func (q *fakeQueries) ExpireLapsedSubscriptions(ctx context.Context, arg db.ExpireLapsedSubscriptionsParams) ([]uuid.UUID, error) {
+	q.arg = arg
+	return nil, nil
+}
+
+func TestExpirerWaitsForLateRenewals(t *testing.T) {
+	queries := &fakeQueries{}
+	now := time.Now()
+	if _, err := (Expirer{Queries: queries}).Expire(context.Background(), now); err != nil {
+		t.Fatalf("Error expiring: %v", err)
+	}
+	if !queries.arg.ActiveBefore.Equal(now.Add(-RenewalLeeway)) || !queries.arg.GraceBefore.Equal(now) {
+		t.Errorf("Expected active subscriptions to get %v of leeway, got %+v", RenewalLeeway, queries.arg)
+	}
+}
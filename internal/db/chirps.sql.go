@@ -7,21 +7,31 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (user_id, body) VALUES ($1, $2) RETURNING id, created_at, updated_at, user_id, body
+INSERT INTO chirps (user_id, body, lang, status) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at, user_id, body, lang, status
 `
 
 type CreateChirpParams struct {
 	UserID uuid.UUID
 	Body   string
+	Lang   string
+	Status string
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.UserID, arg.Body)
+	row := q.db.QueryRowContext(ctx, createChirp,
+		arg.UserID,
+		arg.Body,
+		arg.Lang,
+		arg.Status,
+	)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -29,6 +39,8 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.Body,
+		&i.Lang,
+		&i.Status,
 	)
 	return i, err
 }
@@ -42,8 +54,29 @@ func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
+const deleteHeldChirp = `-- name: DeleteHeldChirp :one
+DELETE FROM chirps
+WHERE id = $1 AND status = 'held'
+RETURNING id, created_at, updated_at, user_id, body, lang, status
+`
+
+func (q *Queries) DeleteHeldChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, deleteHeldChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Body,
+		&i.Lang,
+		&i.Status,
+	)
+	return i, err
+}
+
 const getChirp = `-- name: GetChirp :one
-SELECT id, created_at, updated_at, user_id, body FROM chirps
+SELECT id, created_at, updated_at, user_id, body, lang, status FROM chirps
 WHERE chirps.id = $1
 `
 
@@ -56,54 +89,52 @@ func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.Body,
+		&i.Lang,
+		&i.Status,
 	)
 	return i, err
 }
 
-const getChirpsASC = `-- name: GetChirpsASC :many
-SELECT 
-    id, created_at, updated_at, user_id, body
-FROM chirps
-ORDER BY chirps.created_at ASC
+const getPublishedChirp = `-- name: GetPublishedChirp :one
+SELECT id, created_at, updated_at, user_id, body, lang, status FROM chirps
+WHERE chirps.id = $1
+  AND chirps.status = 'published'
+  AND NOT EXISTS (
+    SELECT 1 FROM users
+    WHERE users.id = chirps.user_id
+      AND (users.status = 'banned' OR (users.status = 'suspended' AND users.suspended_until > NOW()))
+  )
 `
 
-func (q *Queries) GetChirpsASC(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirpsASC)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []Chirp
-	for rows.Next() {
-		var i Chirp
-		if err := rows.Scan(
-			&i.ID,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.UserID,
-			&i.Body,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Close(); err != nil {
-		return nil, err
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+func (q *Queries) GetPublishedChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getPublishedChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Body,
+		&i.Lang,
+		&i.Status,
+	)
+	return i, err
 }
 
-const getChirpsByUserIDASC = `-- name: GetChirpsByUserIDASC :many
-SELECT id, created_at, updated_at, user_id, body FROM chirps
-WHERE chirps.user_id = $1
-ORDER BY chirps.created_at ASC
+const getRecentChirpsByUser = `-- name: GetRecentChirpsByUser :many
+SELECT id, created_at, updated_at, user_id, body, lang, status FROM chirps
+WHERE chirps.user_id = $1 AND chirps.created_at > $2
+ORDER BY chirps.created_at DESC
+LIMIT 50
 `
 
-func (q *Queries) GetChirpsByUserIDASC(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirpsByUserIDASC, userID)
+type GetRecentChirpsByUserParams struct {
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+func (q *Queries) GetRecentChirpsByUser(ctx context.Context, arg GetRecentChirpsByUserParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getRecentChirpsByUser, arg.UserID, arg.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -117,6 +148,8 @@ func (q *Queries) GetChirpsByUserIDASC(ctx context.Context, userID uuid.UUID) ([
 			&i.UpdatedAt,
 			&i.UserID,
 			&i.Body,
+			&i.Lang,
+			&i.Status,
 		); err != nil {
 			return nil, err
 		}
@@ -131,14 +164,29 @@ func (q *Queries) GetChirpsByUserIDASC(ctx context.Context, userID uuid.UUID) ([
 	return items, nil
 }
 
-const getChirpsByUserIDDESC = `-- name: GetChirpsByUserIDDESC :many
-SELECT id, created_at, updated_at, user_id, body FROM chirps
-WHERE chirps.user_id = $1                
-ORDER BY chirps.created_at DESC
+const listChirps = `-- name: ListChirps :many
+SELECT id, created_at, updated_at, user_id, body, lang, status FROM chirps
+WHERE chirps.status = 'published'
+  AND NOT EXISTS (
+    SELECT 1 FROM users
+    WHERE users.id = chirps.user_id
+      AND (users.status = 'banned' OR (users.status = 'suspended' AND users.suspended_until > NOW()))
+  )
+  AND ($1::uuid IS NULL OR chirps.user_id = $1)
+  AND ($2::text IS NULL OR chirps.lang = $2)
+ORDER BY
+    CASE WHEN $3::bool THEN chirps.created_at END DESC,
+    chirps.created_at ASC
 `
 
-func (q *Queries) GetChirpsByUserIDDESC(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirpsByUserIDDESC, userID)
+type ListChirpsParams struct {
+	UserID   uuid.NullUUID
+	Lang     sql.NullString
+	SortDesc bool
+}
+
+func (q *Queries) ListChirps(ctx context.Context, arg ListChirpsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirps, arg.UserID, arg.Lang, arg.SortDesc)
 	if err != nil {
 		return nil, err
 	}
@@ -152,6 +200,8 @@ func (q *Queries) GetChirpsByUserIDDESC(ctx context.Context, userID uuid.UUID) (
 			&i.UpdatedAt,
 			&i.UserID,
 			&i.Body,
+			&i.Lang,
+			&i.Status,
 		); err != nil {
 			return nil, err
 		}
@@ -166,28 +216,48 @@ func (q *Queries) GetChirpsByUserIDDESC(ctx context.Context, userID uuid.UUID) (
 	return items, nil
 }
 
-const getChirpsDESC = `-- name: GetChirpsDESC :many
-SELECT 
-    id, created_at, updated_at, user_id, body
+const listHeldChirps = `-- name: ListHeldChirps :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.user_id, chirps.body, chirps.lang, chirps.status,
+    COALESCE(chirp_spam_scores.score, 0)::float8 AS spam_score,
+    COALESCE(chirp_spam_scores.reasons, '[]')::jsonb AS spam_reasons
 FROM chirps
-ORDER BY chirps.created_at DESC
+LEFT JOIN chirp_spam_scores ON chirp_spam_scores.chirp_id = chirps.id
+WHERE chirps.status = 'held'
+ORDER BY chirps.created_at ASC
+LIMIT $1
 `
 
-func (q *Queries) GetChirpsDESC(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirpsDESC)
+type ListHeldChirpsRow struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	UserID      uuid.UUID
+	Body        string
+	Lang        string
+	Status      string
+	SpamScore   float64
+	SpamReasons json.RawMessage
+}
+
+func (q *Queries) ListHeldChirps(ctx context.Context, limit int32) ([]ListHeldChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listHeldChirps, limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Chirp
+	var items []ListHeldChirpsRow
 	for rows.Next() {
-		var i Chirp
+		var i ListHeldChirpsRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.UserID,
 			&i.Body,
+			&i.Lang,
+			&i.Status,
+			&i.SpamScore,
+			&i.SpamReasons,
 		); err != nil {
 			return nil, err
 		}
@@ -202,6 +272,27 @@ func (q *Queries) GetChirpsDESC(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
+const releaseHeldChirp = `-- name: ReleaseHeldChirp :one
+UPDATE chirps SET status = 'published', updated_at = NOW()
+WHERE id = $1 AND status = 'held'
+RETURNING id, created_at, updated_at, user_id, body, lang, status
+`
+
+func (q *Queries) ReleaseHeldChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, releaseHeldChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Body,
+		&i.Lang,
+		&i.Status,
+	)
+	return i, err
+}
+
 const resetChirps = `-- name: ResetChirps :exec
 DELETE FROM chirps
 `
@@ -6,6 +6,7 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,22 +18,300 @@ type Chirp struct {
 	UpdatedAt time.Time
 	UserID    uuid.UUID
 	Body      string
+	Lang      string
+	Status    string
 }
 
-type RefreshToken struct {
-	Token     string
+type ChirpSpamScore struct {
+	ChirpID   uuid.UUID
 	CreatedAt time.Time
-	UpdatedAt time.Time
+	Score     float64
+	Reasons   json.RawMessage
+}
+
+type EmailToken struct {
+	TokenHash string
+	UserID    uuid.UUID
+	Purpose   string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+	NonceHash string
+}
+
+type LoginFailure struct {
+	Key          string
+	Failures     int32
+	BlockedUntil sql.NullTime
+	UpdatedAt    time.Time
+}
+
+type MagicLinkRequest struct {
+	ID        int64
+	CreatedAt time.Time
+	Email     string
+	Ip        string
+}
+
+type MailOutbox struct {
+	ID            int64
+	CreatedAt     time.Time
+	ToAddress     string
+	Subject       string
+	Body          string
+	Attempts      int32
+	NextAttemptAt time.Time
+	SentAt        sql.NullTime
+	LastError     string
+}
+
+type MfaChallenge struct {
+	TokenHash string
 	UserID    uuid.UUID
+	CreatedAt time.Time
 	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	Attempts  int32
+	UsedAt    sql.NullTime
+}
+
+type MfaVerifyAttempt struct {
+	UserID      uuid.UUID
+	Attempts    int32
+	WindowStart time.Time
+}
+
+type ModerationLog struct {
+	ID           int64
+	CreatedAt    time.Time
+	ActorID      uuid.NullUUID
+	Action       string
+	ReportID     uuid.NullUUID
+	TargetUserID uuid.NullUUID
+	ChirpID      uuid.NullUUID
+	Note         string
+}
+
+type OauthAuthorizationCode struct {
+	CodeHash      string
+	ClientID      string
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scope         string
+	CodeChallenge string
+	FamilyID      uuid.UUID
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+}
+
+type OauthClient struct {
+	ID           string
+	CreatedAt    time.Time
+	OwnerID      uuid.UUID
+	Name         string
+	SecretHash   string
+	RedirectUris json.RawMessage
+	Scopes       string
+}
+
+type OidcIdentity struct {
+	Provider    string
+	Subject     string
+	UserID      uuid.UUID
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt time.Time
+}
+
+type OidcLoginState struct {
+	StateHash    string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UserID     uuid.UUID
+	Name       string
+	TokenHash  string
+	Scopes     string
+	AllowedIps json.RawMessage
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	LastUsedIp string
+}
+
+type PolkaEvent struct {
+	ID         uuid.UUID
+	ReceivedAt time.Time
+	EventID    string
+	Event      string
+	Payload    string
+	RemoteIp   string
+	Outcome    string
+	Error      string
+}
+
+type PolkaProcessedEvent struct {
+	EventID     string
+	ProcessedAt time.Time
+}
+
+type RateLimit struct {
+	Key string
+	Tat time.Time
+}
+
+type RecoveryCode struct {
+	UserID    uuid.UUID
+	CodeHash  string
+	CreatedAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type RefreshToken struct {
+	TokenHash       string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	UserID          uuid.UUID
+	ExpiresAt       time.Time
+	RevokedAt       sql.NullTime
+	FamilyID        uuid.UUID
+	ParentTokenHash sql.NullString
+	RevokedReason   string
+	Hashed          bool
+	UserAgent       string
+	Ip              string
+	LastUsedAt      time.Time
+	ClientID        sql.NullString
+	Scope           string
+}
+
+type Report struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	ReporterID   uuid.UUID
+	ChirpID      uuid.NullUUID
+	TargetUserID uuid.UUID
+	Reason       string
+	Details      string
+	Status       string
+	ClaimedBy    uuid.NullUUID
+	ClaimedAt    sql.NullTime
+	ResolvedAt   sql.NullTime
+	Resolution   sql.NullString
+}
+
+type RevokedAccessToken struct {
+	Jti       uuid.UUID
+	RevokedAt time.Time
+	ExpiresAt time.Time
+}
+
+type SecurityEvent struct {
+	ID        int64
+	CreatedAt time.Time
+	UserID    uuid.NullUUID
+	Kind      string
+	Details   json.RawMessage
+}
+
+type SigningKey struct {
+	Kid        string
+	CreatedAt  time.Time
+	Algorithm  string
+	PrivateKey []byte
+	RetiresAt  sql.NullTime
+}
+
+type Subscription struct {
+	ID                 uuid.UUID
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	UserID             uuid.UUID
+	Plan               string
+	Status             string
+	CurrentPeriodStart time.Time
+	CurrentPeriodEnd   time.Time
+	GraceUntil         sql.NullTime
+	CanceledAt         sql.NullTime
 }
 
 type User struct {
+	ID                uuid.UUID
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	Email             string
+	HashedPassword    string
+	IsChirpyRed       bool
+	Status            string
+	SuspendedUntil    sql.NullTime
+	Role              string
+	VerifiedAt        sql.NullTime
+	PasswordChangedAt sql.NullTime
+}
+
+type UserTotp struct {
+	UserID       uuid.UUID
+	CreatedAt    time.Time
+	Secret       string
+	ConfirmedAt  sql.NullTime
+	LastUsedStep int64
+}
+
+type WebauthnChallenge struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UserID    uuid.NullUUID
+	Ceremony  string
+	Challenge []byte
+}
+
+type WebauthnCredential struct {
+	CredentialID []byte
+	UserID       uuid.UUID
+	CreatedAt    time.Time
+	Name         string
+	PublicKey    []byte
+	Algorithm    int32
+	SignCount    int64
+	Aaguid       []byte
+	LastUsedAt   sql.NullTime
+}
+
+type WebhookDelivery struct {
 	ID             uuid.UUID
 	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
-	IsChirpyRed    bool
+	EndpointID     uuid.UUID
+	EventID        uuid.UUID
+	EventType      string
+	Payload        json.RawMessage
+	Status         string
+	Attempts       int32
+	NextAttemptAt  time.Time
+	LastAttemptAt  sql.NullTime
+	DeliveredAt    sql.NullTime
+	ResponseStatus int32
+	LastError      string
+}
+
+type WebhookEndpoint struct {
+	ID                  uuid.UUID
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	UserID              uuid.UUID
+	ClientID            sql.NullString
+	Url                 string
+	Secret              string
+	Events              string
+	Description         string
+	Status              string
+	ConsecutiveFailures int32
+	PausedAt            sql.NullTime
 }
@@ -7,68 +7,133 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 const createRefreshToken = `-- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, user_id, expires_at) VALUES ($1, $2, $3) RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+INSERT INTO refresh_tokens (token_hash, user_id, expires_at, family_id, parent_token_hash, user_agent, ip, client_id, scope)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, parent_token_hash, revoked_reason, hashed, user_agent, ip, last_used_at, client_id, scope
 `
 
 type CreateRefreshTokenParams struct {
-	Token     string
-	UserID    uuid.UUID
-	ExpiresAt time.Time
+	TokenHash       string
+	UserID          uuid.UUID
+	ExpiresAt       time.Time
+	FamilyID        uuid.UUID
+	ParentTokenHash sql.NullString
+	UserAgent       string
+	Ip              string
+	ClientID        sql.NullString
+	Scope           string
 }
 
 func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, createRefreshToken, arg.Token, arg.UserID, arg.ExpiresAt)
+	row := q.db.QueryRowContext(ctx, createRefreshToken,
+		arg.TokenHash,
+		arg.UserID,
+		arg.ExpiresAt,
+		arg.FamilyID,
+		arg.ParentTokenHash,
+		arg.UserAgent,
+		arg.Ip,
+		arg.ClientID,
+		arg.Scope,
+	)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
+		&i.TokenHash,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ParentTokenHash,
+		&i.RevokedReason,
+		&i.Hashed,
+		&i.UserAgent,
+		&i.Ip,
+		&i.LastUsedAt,
+		&i.ClientID,
+		&i.Scope,
 	)
 	return i, err
 }
 
 const expireRefreshToken = `-- name: ExpireRefreshToken :exec
-UPDATE refresh_tokens SET revoked_at = NOW() WHERE token = $1
+UPDATE refresh_tokens SET revoked_at = NOW(), revoked_reason = 'logout'
+WHERE token_hash = $1 AND revoked_at IS NULL
 `
 
-func (q *Queries) ExpireRefreshToken(ctx context.Context, token string) error {
-	_, err := q.db.ExecContext(ctx, expireRefreshToken, token)
+func (q *Queries) ExpireRefreshToken(ctx context.Context, tokenHash string) error {
+	_, err := q.db.ExecContext(ctx, expireRefreshToken, tokenHash)
 	return err
 }
 
+const getLegacyRefreshToken = `-- name: GetLegacyRefreshToken :one
+SELECT token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, parent_token_hash, revoked_reason, hashed, user_agent, ip, last_used_at, client_id, scope FROM refresh_tokens WHERE token_hash = $1 AND NOT hashed
+`
+
+func (q *Queries) GetLegacyRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getLegacyRefreshToken, tokenHash)
+	var i RefreshToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ParentTokenHash,
+		&i.RevokedReason,
+		&i.Hashed,
+		&i.UserAgent,
+		&i.Ip,
+		&i.LastUsedAt,
+		&i.ClientID,
+		&i.Scope,
+	)
+	return i, err
+}
+
 const getRefreshToken = `-- name: GetRefreshToken :one
-SELECT token, created_at, updated_at, user_id, expires_at, revoked_at FROM refresh_tokens WHERE token = $1 AND revoked_at is NULL
+SELECT token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, parent_token_hash, revoked_reason, hashed, user_agent, ip, last_used_at, client_id, scope FROM refresh_tokens WHERE token_hash = $1 AND hashed
 `
 
-func (q *Queries) GetRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, getRefreshToken, token)
+func (q *Queries) GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshToken, tokenHash)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
+		&i.TokenHash,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ParentTokenHash,
+		&i.RevokedReason,
+		&i.Hashed,
+		&i.UserAgent,
+		&i.Ip,
+		&i.LastUsedAt,
+		&i.ClientID,
+		&i.Scope,
 	)
 	return i, err
 }
 
 const getUserByRefreshToken = `-- name: GetUserByRefreshToken :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users WHERE id = (SELECT user_id FROM refresh_tokens WHERE token = $1)
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, status, suspended_until, role, verified_at, password_changed_at FROM users WHERE id = (SELECT user_id FROM refresh_tokens WHERE token_hash = $1)
 `
 
-func (q *Queries) GetUserByRefreshToken(ctx context.Context, token string) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUserByRefreshToken, token)
+func (q *Queries) GetUserByRefreshToken(ctx context.Context, tokenHash string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByRefreshToken, tokenHash)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -77,6 +142,183 @@ func (q *Queries) GetUserByRefreshToken(ctx context.Context, token string) (User
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Status,
+		&i.SuspendedUntil,
+		&i.Role,
+		&i.VerifiedAt,
+		&i.PasswordChangedAt,
 	)
 	return i, err
 }
+
+const hashLegacyRefreshToken = `-- name: HashLegacyRefreshToken :exec
+UPDATE refresh_tokens SET token_hash = $1, hashed = true
+WHERE token_hash = $2 AND NOT hashed
+`
+
+type HashLegacyRefreshTokenParams struct {
+	NewHash  string
+	RawToken string
+}
+
+func (q *Queries) HashLegacyRefreshToken(ctx context.Context, arg HashLegacyRefreshTokenParams) error {
+	_, err := q.db.ExecContext(ctx, hashLegacyRefreshToken, arg.NewHash, arg.RawToken)
+	return err
+}
+
+const listActiveRefreshTokensByUser = `-- name: ListActiveRefreshTokensByUser :many
+SELECT token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, parent_token_hash, revoked_reason, hashed, user_agent, ip, last_used_at, client_id, scope FROM refresh_tokens
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY last_used_at DESC
+`
+
+func (q *Queries) ListActiveRefreshTokensByUser(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error) {
+	rows, err := q.db.QueryContext(ctx, listActiveRefreshTokensByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RefreshToken
+	for rows.Next() {
+		var i RefreshToken
+		if err := rows.Scan(
+			&i.TokenHash,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.FamilyID,
+			&i.ParentTokenHash,
+			&i.RevokedReason,
+			&i.Hashed,
+			&i.UserAgent,
+			&i.Ip,
+			&i.LastUsedAt,
+			&i.ClientID,
+			&i.Scope,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listLegacyRefreshTokens = `-- name: ListLegacyRefreshTokens :many
+SELECT token_hash FROM refresh_tokens WHERE NOT hashed
+`
+
+func (q *Queries) ListLegacyRefreshTokens(ctx context.Context) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listLegacyRefreshTokens)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var token_hash string
+		if err := rows.Scan(&token_hash); err != nil {
+			return nil, err
+		}
+		items = append(items, token_hash)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const refreshTokenFamilyActive = `-- name: RefreshTokenFamilyActive :one
+SELECT EXISTS (
+    SELECT 1 FROM refresh_tokens
+    WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+)
+`
+
+type RefreshTokenFamilyActiveParams struct {
+	FamilyID uuid.UUID
+	UserID   uuid.UUID
+}
+
+func (q *Queries) RefreshTokenFamilyActive(ctx context.Context, arg RefreshTokenFamilyActiveParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, refreshTokenFamilyActive, arg.FamilyID, arg.UserID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const revokeOtherRefreshTokenFamilies = `-- name: RevokeOtherRefreshTokenFamilies :exec
+UPDATE refresh_tokens SET revoked_at = NOW(), revoked_reason = 'revoked', updated_at = NOW()
+WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL
+`
+
+type RevokeOtherRefreshTokenFamiliesParams struct {
+	UserID   uuid.UUID
+	FamilyID uuid.UUID
+}
+
+func (q *Queries) RevokeOtherRefreshTokenFamilies(ctx context.Context, arg RevokeOtherRefreshTokenFamiliesParams) error {
+	_, err := q.db.ExecContext(ctx, revokeOtherRefreshTokenFamilies, arg.UserID, arg.FamilyID)
+	return err
+}
+
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens SET revoked_at = NOW(), revoked_reason = 'revoked', updated_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshTokenFamily, familyID)
+	return err
+}
+
+const revokeUserRefreshTokenFamily = `-- name: RevokeUserRefreshTokenFamily :execrows
+UPDATE refresh_tokens SET revoked_at = NOW(), revoked_reason = 'revoked', updated_at = NOW()
+WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL
+`
+
+type RevokeUserRefreshTokenFamilyParams struct {
+	FamilyID uuid.UUID
+	UserID   uuid.UUID
+}
+
+func (q *Queries) RevokeUserRefreshTokenFamily(ctx context.Context, arg RevokeUserRefreshTokenFamilyParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeUserRefreshTokenFamily, arg.FamilyID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeUserRefreshTokens = `-- name: RevokeUserRefreshTokens :exec
+UPDATE refresh_tokens SET revoked_at = NOW(), revoked_reason = 'revoked'
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeUserRefreshTokens(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeUserRefreshTokens, userID)
+	return err
+}
+
+const rotateRefreshToken = `-- name: RotateRefreshToken :execrows
+UPDATE refresh_tokens SET revoked_at = NOW(), revoked_reason = 'rotated', updated_at = NOW(), last_used_at = NOW()
+WHERE token_hash = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RotateRefreshToken(ctx context.Context, tokenHash string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rotateRefreshToken, tokenHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -7,12 +7,24 @@ package db
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
 )
 
+const countUsersWithRole = `-- name: CountUsersWithRole :one
+SELECT COUNT(*) FROM users WHERE role = $1
+`
+
+func (q *Queries) CountUsersWithRole(ctx context.Context, role string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUsersWithRole, role)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (email, hashed_password) VALUES ($1, $2) RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+INSERT INTO users (email, hashed_password) VALUES ($1, $2) RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, status, suspended_until, role, verified_at, password_changed_at
 `
 
 type CreateUserParams struct {
@@ -30,6 +42,11 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Status,
+		&i.SuspendedUntil,
+		&i.Role,
+		&i.VerifiedAt,
+		&i.PasswordChangedAt,
 	)
 	return i, err
 }
@@ -46,7 +63,7 @@ func (q *Queries) GetPassowordHash(ctx context.Context, email string) (string, e
 }
 
 const getUser = `-- name: GetUser :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users WHERE email = $1
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, status, suspended_until, role, verified_at, password_changed_at FROM users WHERE email = $1
 `
 
 func (q *Queries) GetUser(ctx context.Context, email string) (User, error) {
@@ -59,12 +76,17 @@ func (q *Queries) GetUser(ctx context.Context, email string) (User, error) {
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Status,
+		&i.SuspendedUntil,
+		&i.Role,
+		&i.VerifiedAt,
+		&i.PasswordChangedAt,
 	)
 	return i, err
 }
 
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users WHERE id = $1
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, status, suspended_until, role, verified_at, password_changed_at FROM users WHERE id = $1
 `
 
 func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
@@ -77,10 +99,60 @@ func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Status,
+		&i.SuspendedUntil,
+		&i.Role,
+		&i.VerifiedAt,
+		&i.PasswordChangedAt,
 	)
 	return i, err
 }
 
+const lockUsersWithRole = `-- name: LockUsersWithRole :many
+SELECT id FROM users WHERE role = $1 FOR UPDATE
+`
+
+func (q *Queries) LockUsersWithRole(ctx context.Context, role string) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, lockUsersWithRole, role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const resetUserPassword = `-- name: ResetUserPassword :exec
+UPDATE users SET
+    hashed_password = $2,
+    password_changed_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+`
+
+type ResetUserPasswordParams struct {
+	ID             uuid.UUID
+	HashedPassword string
+}
+
+func (q *Queries) ResetUserPassword(ctx context.Context, arg ResetUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, resetUserPassword, arg.ID, arg.HashedPassword)
+	return err
+}
+
 const resetUsers = `-- name: ResetUsers :exec
 DELETE FROM users
 `
@@ -90,23 +162,57 @@ func (q *Queries) ResetUsers(ctx context.Context) error {
 	return err
 }
 
-const updateUser = `-- name: UpdateUser :one
-UPDATE users SET 
-    email = $1,
-    hashed_password = $2,
+const setChirpyRed = `-- name: SetChirpyRed :exec
+UPDATE users SET is_chirpy_red = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type SetChirpyRedParams struct {
+	ID          uuid.UUID
+	IsChirpyRed bool
+}
+
+func (q *Queries) SetChirpyRed(ctx context.Context, arg SetChirpyRedParams) error {
+	_, err := q.db.ExecContext(ctx, setChirpyRed, arg.ID, arg.IsChirpyRed)
+	return err
+}
+
+const setUserPassword = `-- name: SetUserPassword :execrows
+UPDATE users SET
+    hashed_password = $1,
     updated_at = NOW()
-WHERE id = $3
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+WHERE id = $2 AND hashed_password = $3
 `
 
-type UpdateUserParams struct {
-	Email          string
-	HashedPassword string
-	ID             uuid.UUID
+type SetUserPasswordParams struct {
+	HashedPassword    string
+	ID                uuid.UUID
+	OldHashedPassword string
 }
 
-func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, updateUser, arg.Email, arg.HashedPassword, arg.ID)
+func (q *Queries) SetUserPassword(ctx context.Context, arg SetUserPasswordParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserPassword, arg.HashedPassword, arg.ID, arg.OldHashedPassword)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setUserRole = `-- name: SetUserRole :one
+UPDATE users SET
+    role = $2,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, status, suspended_until, role, verified_at, password_changed_at
+`
+
+type SetUserRoleParams struct {
+	ID   uuid.UUID
+	Role string
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserRole, arg.ID, arg.Role)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -115,19 +221,32 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Status,
+		&i.SuspendedUntil,
+		&i.Role,
+		&i.VerifiedAt,
+		&i.PasswordChangedAt,
 	)
 	return i, err
 }
 
-const upgradeUser = `-- name: UpgradeUser :one
+const setUserStatus = `-- name: SetUserStatus :one
 UPDATE users SET
-    is_chirpy_red = true
+    status = $2,
+    suspended_until = $3,
+    updated_at = NOW()
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, status, suspended_until, role, verified_at, password_changed_at
 `
 
-func (q *Queries) UpgradeUser(ctx context.Context, id uuid.UUID) (User, error) {
-	row := q.db.QueryRowContext(ctx, upgradeUser, id)
+type SetUserStatusParams struct {
+	ID             uuid.UUID
+	Status         string
+	SuspendedUntil sql.NullTime
+}
+
+func (q *Queries) SetUserStatus(ctx context.Context, arg SetUserStatusParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserStatus, arg.ID, arg.Status, arg.SuspendedUntil)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -136,6 +255,56 @@ func (q *Queries) UpgradeUser(ctx context.Context, id uuid.UUID) (User, error) {
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Status,
+		&i.SuspendedUntil,
+		&i.Role,
+		&i.VerifiedAt,
+		&i.PasswordChangedAt,
 	)
 	return i, err
 }
+
+const updateUser = `-- name: UpdateUser :one
+UPDATE users SET 
+    email = $1,
+    hashed_password = $2,
+    verified_at = CASE WHEN email = $1 THEN verified_at END,
+    updated_at = NOW()
+WHERE id = $3
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, status, suspended_until, role, verified_at, password_changed_at
+`
+
+type UpdateUserParams struct {
+	Email          string
+	HashedPassword string
+	ID             uuid.UUID
+}
+
+func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUser, arg.Email, arg.HashedPassword, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Status,
+		&i.SuspendedUntil,
+		&i.Role,
+		&i.VerifiedAt,
+		&i.PasswordChangedAt,
+	)
+	return i, err
+}
+
+const verifyUserEmail = `-- name: VerifyUserEmail :exec
+UPDATE users SET verified_at = NOW()
+WHERE id = $1 AND verified_at IS NULL
+`
+
+func (q *Queries) VerifyUserEmail(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, verifyUserEmail, id)
+	return err
+}
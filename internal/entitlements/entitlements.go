@@ -0,0 +1,72 @@
+// Package entitlements says what each plan lets a user do. Handlers ask
+// through Can instead of reading is_chirpy_red, so what Chirpy Red
+// includes is decided here in one place.
+package entitlements
+
+import (
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+)
+
+const (
+	PlanFree = "free"
+	// PlanRed is what every Chirpy Red subscription grants, monthly or
+	// yearly.
+	PlanRed = "red"
+)
+
+// Entitlements are the capabilities of one plan. They are sent to clients
+// as they are, so apps can show the limits before a request fails.
+type Entitlements struct {
+	Plan             string `json:"plan"`
+	MaxChirpLength   int    `json:"max_chirp_length"`
+	HigherRateLimits bool   `json:"higher_rate_limits"`
+}
+
+// Plans maps plan names to what they allow.
+var Plans = map[string]Entitlements{
+	PlanFree: {
+		Plan:           PlanFree,
+		MaxChirpLength: 140,
+	},
+	PlanRed: {
+		Plan:             PlanRed,
+		MaxChirpLength:   500,
+		HigherRateLimits: true,
+	},
+}
+
+// PlanFor is the plan user is on.
+func PlanFor(user db.User) string {
+	if user.IsChirpyRed {
+		return PlanRed
+	}
+	return PlanFree
+}
+
+// For returns the entitlements of user's plan.
+func For(user db.User) Entitlements {
+	return Plans[PlanFor(user)]
+}
+
+// A Feature is something a plan may allow, possibly up to a limit.
+type Feature interface {
+	allowedBy(e Entitlements) bool
+}
+
+type featureFunc func(e Entitlements) bool
+
+func (f featureFunc) allowedBy(e Entitlements) bool {
+	return f(e)
+}
+
+var HigherRateLimits Feature = featureFunc(func(e Entitlements) bool { return e.HigherRateLimits })
+
+// ChirpLength is posting a chirp of length characters.
+func ChirpLength(length int) Feature {
+	return featureFunc(func(e Entitlements) bool { return length <= e.MaxChirpLength })
+}
+
+// Can reports whether user's plan allows feature.
+func Can(user db.User, feature Feature) bool {
+	return feature.allowedBy(For(user))
+}
@@ -0,0 +1,40 @@
+package entitlements
+
+import (
+	"testing"
+
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+)
+
+func TestCan(t *testing.T) {
+	free := db.User{}
+	red := db.User{IsChirpyRed: true}
+	testCases := []struct {
+		name    string
+		feature Feature
+		free    bool
+		red     bool
+	}{
+		{"short chirp", ChirpLength(140), true, true},
+		{"long chirp", ChirpLength(141), false, true},
+		{"too long for anyone", ChirpLength(501), false, false},
+		{"higher rate limits", HigherRateLimits, false, true},
+	}
+	for _, testCase := range testCases {
+		if Can(free, testCase.feature) != testCase.free {
+			t.Errorf("%s: expected free users to get %v", testCase.name, testCase.free)
+		}
+		if Can(red, testCase.feature) != testCase.red {
+			t.Errorf("%s: expected Chirpy Red users to get %v", testCase.name, testCase.red)
+		}
+	}
+}
+
+func TestFor(t *testing.T) {
+	if e := For(db.User{}); e.Plan != PlanFree || e.MaxChirpLength != 140 {
+		t.Errorf("Expected the free plan with 140 character chirps, got %+v", e)
+	}
+	if e := For(db.User{IsChirpyRed: true}); e.Plan != PlanRed {
+		t.Errorf("Expected the red plan, got %+v", e)
+	}
+}
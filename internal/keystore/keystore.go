@@ -0,0 +1,207 @@
+// Package keystore persists JWT signing keys in the database and rotates
+// them on a schedule, so every replica signs and verifies with the same
+// keyring. Private keys are stored sealed with a secretbox.Box.
+package keystore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/secretbox"
+)
+
+// Store is the subset of db.Queries the rotator needs.
+type Store interface {
+	CreateSigningKey(ctx context.Context, arg db.CreateSigningKeyParams) (db.SigningKey, error)
+	ListActiveSigningKeys(ctx context.Context) ([]db.SigningKey, error)
+	RetireSigningKeys(ctx context.Context, arg db.RetireSigningKeysParams) error
+	SealSigningKey(ctx context.Context, arg db.SealSigningKeyParams) error
+	LockSigningKeys(ctx context.Context) error
+}
+
+// Rotator keeps Keys in sync with the Store.
+type Rotator struct {
+	Store Store
+	// InTx runs fn in a transaction with a Store bound to it. Rotations
+	// take a lock in it, so replicas racing to rotate create one key.
+	InTx func(ctx context.Context, fn func(Store) error) error
+	// Box seals private keys before they are stored.
+	Box       *secretbox.Box
+	Keys      *auth.Keyring
+	Algorithm string
+	// Interval is how long a key signs before it is rotated out.
+	Interval time.Duration
+	// Grace is how long a rotated key keeps verifying tokens. It must be
+	// longer than the access token lifetime.
+	Grace time.Duration
+}
+
+// Load replaces the keyring with the active keys from the store. Keys
+// stored before they were sealed are sealed on the way.
+func (r *Rotator) Load(ctx context.Context) error {
+	keys, err := r.load(ctx, r.Store)
+	if err != nil {
+		return err
+	}
+	r.Keys.Set(keys)
+	return nil
+}
+
+// RotateIfDue loads the keys and rotates when there is no signing key, the
+// signing key is older than Interval or uses another algorithm.
+func (r *Rotator) RotateIfDue(ctx context.Context, now time.Time) error {
+	if err := r.Load(ctx); err != nil {
+		return err
+	}
+	if current, ok := r.Keys.Current(); ok && !r.due(current, now) {
+		return nil
+	}
+	return r.rotate(ctx, now, false)
+}
+
+// Rotate creates a new signing key and retires the keys it replaces after
+// the grace period.
+func (r *Rotator) Rotate(ctx context.Context, now time.Time) error {
+	return r.rotate(ctx, now, true)
+}
+
+// Run checks for due rotations every tick until ctx is done. Reloading on
+// every tick also picks up keys rotated by other replicas.
+func (r *Rotator) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := r.RotateIfDue(ctx, now); err != nil {
+				slog.Error("Error rotating signing keys", "error", err)
+			}
+		}
+	}
+}
+
+// rotate creates a key under the rotation lock. Unless forced, it first
+// checks again whether a rotation is due, as another replica may have
+// rotated while we waited for the lock. Only keys as old as the one that
+// was signing are retired, never one another replica just created.
+func (r *Rotator) rotate(ctx context.Context, now time.Time, force bool) error {
+	var created auth.SigningKey
+	err := r.InTx(ctx, func(store Store) error {
+		if err := store.LockSigningKeys(ctx); err != nil {
+			return fmt.Errorf("locking signing keys: %w", err)
+		}
+		keys, err := r.load(ctx, store)
+		if err != nil {
+			return err
+		}
+		var previous *auth.SigningKey
+		if len(keys) > 0 {
+			// listed newest first
+			previous = &keys[0]
+			if !force && !r.due(*previous, now) {
+				return nil
+			}
+		}
+		created, err = auth.GenerateSigningKey(r.Algorithm)
+		if err != nil {
+			return fmt.Errorf("generating signing key: %w", err)
+		}
+		der, err := auth.MarshalPrivateKey(created)
+		if err != nil {
+			return fmt.Errorf("encoding signing key: %w", err)
+		}
+		_, err = store.CreateSigningKey(ctx, db.CreateSigningKeyParams{
+			Kid:        created.ID,
+			CreatedAt:  now,
+			Algorithm:  created.Algorithm,
+			PrivateKey: r.Box.Seal(der, label(created.ID)),
+		})
+		if err != nil {
+			return fmt.Errorf("storing signing key: %w", err)
+		}
+		if previous == nil {
+			return nil
+		}
+		err = store.RetireSigningKeys(ctx, db.RetireSigningKeysParams{
+			RetiresAt: sql.NullTime{Time: now.Add(r.Grace), Valid: true},
+			CreatedAt: previous.CreatedAt,
+		})
+		if err != nil {
+			return fmt.Errorf("retiring signing keys: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if created.ID != "" {
+		slog.Info("Rotated JWT signing key", "kid", created.ID, "alg", created.Algorithm)
+	}
+	return r.Load(ctx)
+}
+
+// due reports whether current should no longer sign at now.
+func (r *Rotator) due(current auth.SigningKey, now time.Time) bool {
+	return current.Algorithm != r.Algorithm || !now.Before(current.CreatedAt.Add(r.Interval))
+}
+
+// load returns the active keys in store, newest first.
+func (r *Rotator) load(ctx context.Context, store Store) ([]auth.SigningKey, error) {
+	rows, err := store.ListActiveSigningKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing signing keys: %w", err)
+	}
+	keys := make([]auth.SigningKey, 0, len(rows))
+	for _, row := range rows {
+		der, err := r.open(ctx, store, row)
+		if err != nil {
+			return nil, err
+		}
+		private, err := auth.ParsePrivateKey(der, row.Algorithm)
+		if err != nil {
+			return nil, fmt.Errorf("parsing signing key %s: %w", row.Kid, err)
+		}
+		keys = append(keys, auth.SigningKey{
+			ID:        row.Kid,
+			Algorithm: row.Algorithm,
+			Private:   private,
+			CreatedAt: row.CreatedAt,
+			RetiresAt: row.RetiresAt.Time,
+		})
+	}
+	return keys, nil
+}
+
+// open returns the DER of a stored key, sealing it first if it was stored
+// in the clear.
+func (r *Rotator) open(ctx context.Context, store Store, row db.SigningKey) ([]byte, error) {
+	der, err := r.Box.Open(row.PrivateKey, label(row.Kid))
+	if err == nil {
+		return der, nil
+	}
+	if !errors.Is(err, secretbox.ErrNotSealed) {
+		return nil, fmt.Errorf("opening signing key %s: %w", row.Kid, err)
+	}
+	err = store.SealSigningKey(ctx, db.SealSigningKeyParams{
+		Kid:        row.Kid,
+		PrivateKey: r.Box.Seal(row.PrivateKey, label(row.Kid)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sealing signing key %s: %w", row.Kid, err)
+	}
+	slog.Info("Sealed JWT signing key", "kid", row.Kid)
+	return row.PrivateKey, nil
+}
+
+// label binds a sealed key to its row.
+func label(kid string) string {
+	return "signing_key:" + kid
+}
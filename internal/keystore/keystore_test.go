@@ -0,0 +1,195 @@
+package keystore
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/secretbox"
+)
+
+type memStore struct {
+	keys []db.SigningKey
+	now  time.Time
+	// locks counts LockSigningKeys calls
+	locks int
+}
+
+func (s *memStore) CreateSigningKey(ctx context.Context, arg db.CreateSigningKeyParams) (db.SigningKey, error) {
+	key := db.SigningKey{
+		Kid:        arg.Kid,
+		CreatedAt:  arg.CreatedAt,
+		Algorithm:  arg.Algorithm,
+		PrivateKey: arg.PrivateKey,
+	}
+	s.keys = append(s.keys, key)
+	return key, nil
+}
+
+func (s *memStore) ListActiveSigningKeys(ctx context.Context) ([]db.SigningKey, error) {
+	// newest first, like the query
+	var active []db.SigningKey
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		key := s.keys[i]
+		if !key.RetiresAt.Valid || key.RetiresAt.Time.After(s.now) {
+			active = append(active, key)
+		}
+	}
+	return active, nil
+}
+
+func (s *memStore) RetireSigningKeys(ctx context.Context, arg db.RetireSigningKeysParams) error {
+	for i := range s.keys {
+		if !s.keys[i].RetiresAt.Valid && !s.keys[i].CreatedAt.After(arg.CreatedAt) {
+			s.keys[i].RetiresAt = arg.RetiresAt
+		}
+	}
+	return nil
+}
+
+func (s *memStore) SealSigningKey(ctx context.Context, arg db.SealSigningKeyParams) error {
+	for i := range s.keys {
+		if s.keys[i].Kid == arg.Kid {
+			s.keys[i].PrivateKey = arg.PrivateKey
+		}
+	}
+	return nil
+}
+
+func (s *memStore) LockSigningKeys(ctx context.Context) error {
+	s.locks++
+	return nil
+}
+
+func newRotator(t *testing.T, store *memStore) *Rotator {
+	key := make([]byte, secretbox.KeySize)
+	rand.Read(key)
+	box, err := secretbox.New(key)
+	if err != nil {
+		t.Fatalf("Error creating box: %v", err)
+	}
+	return &Rotator{
+		Store:     store,
+		InTx:      func(ctx context.Context, fn func(Store) error) error { return fn(store) },
+		Box:       box,
+		Keys:      auth.NewKeyring(""),
+		Algorithm: auth.AlgEdDSA,
+		Interval:  24 * time.Hour,
+		Grace:     2 * time.Hour,
+	}
+}
+
+func TestRotateIfDue(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now()
+	store := &memStore{now: start}
+	r := newRotator(t, store)
+
+	if err := r.RotateIfDue(ctx, start); err != nil {
+		t.Fatalf("Error creating first key: %v", err)
+	}
+	first, ok := r.Keys.Current()
+	if !ok || len(store.keys) != 1 {
+		t.Fatalf("Expected one signing key, got %v", len(store.keys))
+	}
+
+	if err := r.RotateIfDue(ctx, start.Add(time.Hour)); err != nil {
+		t.Fatalf("Error checking rotation: %v", err)
+	}
+	if len(store.keys) != 1 {
+		t.Errorf("Expected no rotation before the interval, got %v keys", len(store.keys))
+	}
+
+	later := start.Add(25 * time.Hour)
+	store.now = later
+	if err := r.RotateIfDue(ctx, later); err != nil {
+		t.Fatalf("Error rotating: %v", err)
+	}
+	second, _ := r.Keys.Current()
+	if second.ID == first.ID {
+		t.Errorf("Expected a new signing key after the interval")
+	}
+	if n := len(r.Keys.JWKS().Keys); n != 2 {
+		t.Errorf("Expected the old key to keep verifying during grace, got %v keys", n)
+	}
+
+	store.now = later.Add(3 * time.Hour)
+	if err := r.Load(ctx); err != nil {
+		t.Fatalf("Error loading keys: %v", err)
+	}
+	if n := len(r.Keys.JWKS().Keys); n != 1 {
+		t.Errorf("Expected the old key gone after grace, got %v keys", n)
+	}
+}
+
+func TestRotateStoresSealedKeys(t *testing.T) {
+	ctx := context.Background()
+	store := &memStore{now: time.Now()}
+	r := newRotator(t, store)
+	if err := r.RotateIfDue(ctx, store.now); err != nil {
+		t.Fatalf("Error creating first key: %v", err)
+	}
+	if !secretbox.IsSealed(store.keys[0].PrivateKey) {
+		t.Errorf("Expected the private key to be stored sealed")
+	}
+
+	// a key stored before sealing still loads, and is sealed on the way
+	legacy, err := auth.GenerateSigningKey(auth.AlgEdDSA)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	der, _ := auth.MarshalPrivateKey(legacy)
+	store.keys = []db.SigningKey{{Kid: legacy.ID, CreatedAt: store.now, Algorithm: legacy.Algorithm, PrivateKey: der}}
+	if err := r.Load(ctx); err != nil {
+		t.Fatalf("Error loading legacy key: %v", err)
+	}
+	if current, ok := r.Keys.Current(); !ok || current.ID != legacy.ID {
+		t.Errorf("Expected the legacy key to sign")
+	}
+	if bytes.Equal(store.keys[0].PrivateKey, der) || !secretbox.IsSealed(store.keys[0].PrivateKey) {
+		t.Errorf("Expected the legacy key to be sealed")
+	}
+
+	// a key sealed under another KEK refuses to load
+	other := newRotator(t, store)
+	if err := other.Load(ctx); err == nil {
+		t.Errorf("Expected a key sealed with another KEK to fail")
+	}
+}
+
+func TestRotateRacingReplicas(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now()
+	store := &memStore{now: start}
+	first := newRotator(t, store)
+	if err := first.RotateIfDue(ctx, start); err != nil {
+		t.Fatalf("Error creating first key: %v", err)
+	}
+	second := newRotator(t, store)
+	second.Box = first.Box
+	second.Load(ctx)
+
+	// both replicas decide a rotation is due, the first one wins the lock
+	later := start.Add(25 * time.Hour)
+	store.now = later
+	if err := first.RotateIfDue(ctx, later); err != nil {
+		t.Fatalf("Error rotating: %v", err)
+	}
+	if err := second.rotate(ctx, later, false); err != nil {
+		t.Fatalf("Error rotating: %v", err)
+	}
+	if len(store.keys) != 2 || store.locks != 3 {
+		t.Fatalf("Expected the second replica to find the key rotated, got %d keys", len(store.keys))
+	}
+	if store.keys[1].RetiresAt.Valid {
+		t.Errorf("Expected the new key to stay active")
+	}
+	current, _ := second.Keys.Current()
+	if current.ID != store.keys[1].Kid {
+		t.Errorf("Expected the second replica to sign with the new key")
+	}
+}
@@ -0,0 +1,118 @@
+// Package langdetect identifies the language of short texts using
+// character n-gram models trained on the embedded corpora.
+package langdetect
+
+import (
+	"embed"
+	"math"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// Undetermined is returned when a text has too few letters to classify.
+const Undetermined = "und"
+
+const maxN = 3
+
+// minLetters is the smallest number of letters we try to classify.
+const minLetters = 3
+
+//go:embed corpus/*.txt
+var corpusFS embed.FS
+
+type model struct {
+	counts map[string]int
+	total  int
+}
+
+// Detector scores texts against one n-gram model per language.
+type Detector struct {
+	models map[string]model
+	vocab  int
+}
+
+// New builds a Detector from the embedded corpora. The language code of
+// each model is the corpus file name without extension.
+func New() (*Detector, error) {
+	entries, err := corpusFS.ReadDir("corpus")
+	if err != nil {
+		return nil, err
+	}
+	d := &Detector{models: make(map[string]model)}
+	vocab := make(map[string]struct{})
+	for _, entry := range entries {
+		data, err := corpusFS.ReadFile(path.Join("corpus", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		lang := strings.TrimSuffix(entry.Name(), path.Ext(entry.Name()))
+		m := model{counts: make(map[string]int)}
+		for _, gram := range ngrams(string(data)) {
+			m.counts[gram]++
+			m.total++
+			vocab[gram] = struct{}{}
+		}
+		d.models[lang] = m
+	}
+	d.vocab = len(vocab)
+	return d, nil
+}
+
+// Languages returns the language codes the Detector knows about.
+func (d *Detector) Languages() []string {
+	langs := make([]string, 0, len(d.models))
+	for lang := range d.models {
+		langs = append(langs, lang)
+	}
+	return langs
+}
+
+// Detect returns the most likely language code for text, or Undetermined
+// when the text does not contain enough letters.
+func (d *Detector) Detect(text string) string {
+	letters := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+	if letters < minLetters {
+		return Undetermined
+	}
+	grams := ngrams(text)
+	best, bestScore := Undetermined, math.Inf(-1)
+	for lang, m := range d.models {
+		score := 0.0
+		for _, gram := range grams {
+			// add-one smoothing keeps unseen n-grams from zeroing the score
+			score += math.Log(float64(m.counts[gram]+1) / float64(m.total+d.vocab))
+		}
+		if score > bestScore || (score == bestScore && lang < best) {
+			best, bestScore = lang, score
+		}
+	}
+	return best
+}
+
+// ngrams splits text into lowercase words and returns every 1..maxN
+// character n-gram of each word padded with spaces.
+func ngrams(text string) []string {
+	var grams []string
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, word := range words {
+		runes := []rune(" " + word + " ")
+		for n := 1; n <= maxN; n++ {
+			for i := 0; i+n <= len(runes); i++ {
+				gram := string(runes[i : i+n])
+				if gram == " " {
+					continue
+				}
+				grams = append(grams, gram)
+			}
+		}
+	}
+	return grams
+}
@@ -0,0 +1,30 @@
+package langdetect
+
+import (
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	d, err := New()
+	if err != nil {
+		t.Fatalf("Error building detector: %v", err)
+	}
+	testCases := []struct {
+		text string
+		lang string
+	}{
+		{"I am going to the store with my friends tonight", "en"},
+		{"Hoy vamos a la playa con mis amigos", "es"},
+		{"Je ne sais pas pourquoi il fait si froid aujourd'hui", "fr"},
+		{"Ich habe heute keine Zeit für das Training", "de"},
+		{"Stasera andiamo a mangiare una pizza con gli amici", "it"},
+		{"Não sei por que o ônibus está atrasado de novo", "pt"},
+		{"!!", Undetermined},
+		{"", Undetermined},
+	}
+	for _, testCase := range testCases {
+		if lang := d.Detect(testCase.text); lang != testCase.lang {
+			t.Errorf("Detect(%q): expected %v, got %v", testCase.text, testCase.lang, lang)
+		}
+	}
+}
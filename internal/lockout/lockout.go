@@ -0,0 +1,265 @@
+// Package lockout slows down password guessing. It counts failed logins
+// per account and per client IP, makes each key wait exponentially longer
+// after a few failures, and locks it out for a while after many.
+package lockout
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is the state of one key.
+type Entry struct {
+	Failures     int
+	BlockedUntil time.Time
+}
+
+// Store keeps failure counters. Use MemoryStore for a single replica and
+// PostgresStore to share counters between replicas.
+type Store interface {
+	// Get returns the zero Entry for an unknown key.
+	Get(ctx context.Context, key string) (Entry, error)
+	// Increment records a failure and returns the new count. A key whose
+	// last failure was before since starts over at one.
+	Increment(ctx context.Context, key string, since time.Time) (int, error)
+	// Block keeps key blocked until at least until.
+	Block(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+	// Prune forgets keys whose last failure was before before and which
+	// are no longer blocked.
+	Prune(ctx context.Context, before time.Time) error
+}
+
+// Policy says how one kind of key is throttled.
+type Policy struct {
+	// FreeAttempts failures are allowed without delay.
+	FreeAttempts int
+	// BaseDelay is the wait after the first failure past FreeAttempts. It
+	// doubles with each further failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockAfter failures lock the key for LockFor.
+	LockAfter int
+	LockFor   time.Duration
+	// Failures are forgotten ResetAfter the last one.
+	ResetAfter time.Duration
+}
+
+// Delay is how long a key with failures must wait before its next attempt.
+func (p Policy) Delay(failures int) time.Duration {
+	if failures >= p.LockAfter && p.LockAfter > 0 {
+		return p.LockFor
+	}
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+var (
+	DefaultAccountPolicy = Policy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Minute,
+		LockAfter:    10,
+		LockFor:      30 * time.Minute,
+		ResetAfter:   24 * time.Hour,
+	}
+	// DefaultIPPolicy is looser, since many users can share an address.
+	DefaultIPPolicy = Policy{
+		FreeAttempts: 20,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Minute,
+		LockAfter:    100,
+		LockFor:      time.Hour,
+		ResetAfter:   time.Hour,
+	}
+)
+
+// Result describes a failure just recorded.
+type Result struct {
+	// RetryAfter is how long the client must wait before trying again.
+	RetryAfter time.Duration
+	// AccountLocked is true only for the failure that locked the account,
+	// so the owner is told once.
+	AccountLocked bool
+}
+
+// Guard applies the account and IP policies to logins.
+type Guard struct {
+	Store   Store
+	Account Policy
+	IP      Policy
+}
+
+func NewGuard(store Store) *Guard {
+	return &Guard{Store: store, Account: DefaultAccountPolicy, IP: DefaultIPPolicy}
+}
+
+func AccountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns how long the client must wait before it may try to log
+// in to email from ip, or zero. Check before verifying the password, so a
+// blocked key learns nothing.
+func (g *Guard) Check(ctx context.Context, email string, ip string, now time.Time) (time.Duration, error) {
+	wait := time.Duration(0)
+	for _, key := range []string{AccountKey(email), IPKey(ip)} {
+		entry, err := g.Store.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if d := entry.BlockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait.Round(time.Second), nil
+}
+
+// Fail records a failed login to email from ip.
+func (g *Guard) Fail(ctx context.Context, email string, ip string, now time.Time) (Result, error) {
+	result := Result{}
+	for _, k := range []struct {
+		key     string
+		policy  Policy
+		account bool
+	}{
+		{AccountKey(email), g.Account, true},
+		{IPKey(ip), g.IP, false},
+	} {
+		failures, err := g.Store.Increment(ctx, k.key, now.Add(-k.policy.ResetAfter))
+		if err != nil {
+			return Result{}, err
+		}
+		delay := k.policy.Delay(failures)
+		if delay == 0 {
+			continue
+		}
+		if err := g.Store.Block(ctx, k.key, now.Add(delay)); err != nil {
+			return Result{}, err
+		}
+		if delay > result.RetryAfter {
+			result.RetryAfter = delay
+		}
+		if k.account && failures == k.policy.LockAfter {
+			result.AccountLocked = true
+		}
+	}
+	return result, nil
+}
+
+// Succeed clears the account's failures after a successful login. The
+// IP's failures stay, or an attacker could clear them by logging in to
+// their own account between guesses.
+func (g *Guard) Succeed(ctx context.Context, email string) error {
+	return g.Store.Reset(ctx, AccountKey(email))
+}
+
+// Unlock clears an account's failures and lockout.
+func (g *Guard) Unlock(ctx context.Context, email string) error {
+	return g.Store.Reset(ctx, AccountKey(email))
+}
+
+// Run prunes forgotten keys every interval until ctx is done.
+func (g *Guard) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			resetAfter := g.Account.ResetAfter
+			if g.IP.ResetAfter > resetAfter {
+				resetAfter = g.IP.ResetAfter
+			}
+			if err := g.Store.Prune(ctx, time.Now().Add(-resetAfter)); err != nil {
+				slog.Error("Error pruning login failures", "error", err)
+			}
+		}
+	}
+}
+
+// MemoryStore keeps counters in this process. Counters are lost on
+// restart and not shared between replicas.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+type memoryEntry struct {
+	Entry
+	updatedAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]*memoryEntry{}}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.entries[key]; ok {
+		return entry.Entry, nil
+	}
+	return Entry{}, nil
+}
+
+func (s *MemoryStore) Increment(ctx context.Context, key string, since time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &memoryEntry{}
+		s.entries[key] = entry
+	}
+	if entry.updatedAt.Before(since) {
+		entry.Failures = 0
+	}
+	entry.Failures++
+	entry.updatedAt = time.Now()
+	return entry.Failures, nil
+}
+
+func (s *MemoryStore) Block(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.entries[key]; ok && until.After(entry.BlockedUntil) {
+		entry.BlockedUntil = until
+	}
+	return nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryStore) Prune(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, entry := range s.entries {
+		if entry.updatedAt.Before(before) && !entry.BlockedUntil.After(now) {
+			delete(s.entries, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package lockout
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	policy := Policy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Second,
+		MaxDelay:     10 * time.Second,
+		LockAfter:    8,
+		LockFor:      time.Hour,
+	}
+	testCases := []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{8, time.Hour},
+		{50, time.Hour},
+	}
+	for _, testCase := range testCases {
+		if delay := policy.Delay(testCase.failures); delay != testCase.delay {
+			t.Errorf("Delay(%d) = %v, expected %v", testCase.failures, delay, testCase.delay)
+		}
+	}
+	policy.LockAfter = 0
+	if delay := policy.Delay(50); delay != policy.MaxDelay {
+		t.Errorf("Expected the delay to be capped at %v, got %v", policy.MaxDelay, delay)
+	}
+}
+
+func TestGuardLocksAccount(t *testing.T) {
+	ctx := context.Background()
+	guard := NewGuard(NewMemoryStore())
+	now := time.Now()
+	locked := 0
+	for i := 1; i <= guard.Account.LockAfter; i++ {
+		result, err := guard.Fail(ctx, "Ada@Example.com", "203.0.113.1", now)
+		if err != nil {
+			t.Fatalf("Error recording failure: %v", err)
+		}
+		if result.RetryAfter != guard.Account.Delay(i) {
+			t.Errorf("Failure %d: expected to wait %v, got %v", i, guard.Account.Delay(i), result.RetryAfter)
+		}
+		if result.AccountLocked {
+			locked++
+		}
+	}
+	if locked != 1 {
+		t.Errorf("Expected the lock to be reported once, got %d", locked)
+	}
+
+	// the lock follows the account to other addresses, whatever the case
+	wait, err := guard.Check(ctx, "ada@example.com", "198.51.100.9", now)
+	if err != nil || wait != guard.Account.LockFor {
+		t.Errorf("Expected to wait %v, got %v, %v", guard.Account.LockFor, wait, err)
+	}
+	if wait, _ := guard.Check(ctx, "ada@example.com", "198.51.100.9", now.Add(guard.Account.LockFor)); wait != 0 {
+		t.Errorf("Expected the lock to expire, still waiting %v", wait)
+	}
+
+	if err := guard.Unlock(ctx, "ada@example.com"); err != nil {
+		t.Fatalf("Error unlocking: %v", err)
+	}
+	if wait, _ := guard.Check(ctx, "ada@example.com", "198.51.100.9", now); wait != 0 {
+		t.Errorf("Expected no wait after unlocking, got %v", wait)
+	}
+}
+
+func TestGuardThrottlesIP(t *testing.T) {
+	ctx := context.Background()
+	guard := NewGuard(NewMemoryStore())
+	guard.IP.FreeAttempts = 2
+	now := time.Now()
+	// one guess at each of many accounts
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if _, err := guard.Fail(ctx, email, "203.0.113.1", now); err != nil {
+			t.Fatalf("Error recording failure: %v", err)
+		}
+	}
+	if wait, _ := guard.Check(ctx, "d@example.com", "203.0.113.1", now); wait != guard.IP.BaseDelay {
+		t.Errorf("Expected the IP to wait %v, got %v", guard.IP.BaseDelay, wait)
+	}
+	if wait, _ := guard.Check(ctx, "d@example.com", "198.51.100.9", now); wait != 0 {
+		t.Errorf("Expected another IP not to wait, got %v", wait)
+	}
+	// a correct password for an account the attacker owns does not help
+	guard.Succeed(ctx, "c@example.com")
+	if wait, _ := guard.Check(ctx, "d@example.com", "203.0.113.1", now); wait == 0 {
+		t.Error("Expected the IP to keep waiting after a successful login")
+	}
+}
+
+func TestGuardForgetsOldFailures(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	guard := NewGuard(store)
+	now := time.Now()
+	for i := 0; i < guard.Account.FreeAttempts; i++ {
+		guard.Fail(ctx, "ada@example.com", "203.0.113.1", now)
+	}
+	later := now.Add(guard.Account.ResetAfter + time.Minute)
+	result, err := guard.Fail(ctx, "ada@example.com", "203.0.113.2", later)
+	if err != nil || result.RetryAfter != 0 {
+		t.Errorf("Expected old failures to be forgotten, got %v, %v", result, err)
+	}
+
+	if err := store.Prune(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("Error pruning: %v", err)
+	}
+	if len(store.entries) != 0 {
+		t.Errorf("Expected every entry to be pruned, %d left", len(store.entries))
+	}
+}
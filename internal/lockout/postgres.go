@@ -0,0 +1,70 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+)
+
+// Queries is the subset of db.Queries PostgresStore needs.
+type Queries interface {
+	GetLoginFailures(ctx context.Context, key string) (db.LoginFailure, error)
+	IncrementLoginFailures(ctx context.Context, arg db.IncrementLoginFailuresParams) (int32, error)
+	BlockLoginFailures(ctx context.Context, arg db.BlockLoginFailuresParams) error
+	ResetLoginFailures(ctx context.Context, key string) error
+	DeleteStaleLoginFailures(ctx context.Context, updatedAt time.Time) error
+}
+
+// PostgresStore keeps counters in the login_failures table, so every
+// replica sees the same failures.
+type PostgresStore struct {
+	Queries Queries
+}
+
+func (s PostgresStore) Get(ctx context.Context, key string) (Entry, error) {
+	row, err := s.Queries.GetLoginFailures(ctx, key)
+	if err == sql.ErrNoRows {
+		return Entry{}, nil
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{Failures: int(row.Failures), BlockedUntil: row.BlockedUntil.Time}, nil
+}
+
+func (s PostgresStore) Increment(ctx context.Context, key string, since time.Time) (int, error) {
+	failures, err := s.Queries.IncrementLoginFailures(ctx, db.IncrementLoginFailuresParams{
+		Key:       key,
+		UpdatedAt: since,
+	})
+	return int(failures), err
+}
+
+func (s PostgresStore) Block(ctx context.Context, key string, until time.Time) error {
+	return s.Queries.BlockLoginFailures(ctx, db.BlockLoginFailuresParams{
+		Key:          key,
+		BlockedUntil: sql.NullTime{Time: until, Valid: true},
+	})
+}
+
+func (s PostgresStore) Reset(ctx context.Context, key string) error {
+	return s.Queries.ResetLoginFailures(ctx, key)
+}
+
+func (s PostgresStore) Prune(ctx context.Context, before time.Time) error {
+	return s.Queries.DeleteStaleLoginFailures(ctx, before)
+}
+
+// WithQueries returns a copy of g whose PostgresStore uses q, such as
+// queries bound to a transaction. Guards with another store are returned
+// as they are.
+func (g *Guard) WithQueries(q Queries) *Guard {
+	if _, ok := g.Store.(PostgresStore); !ok {
+		return g
+	}
+	guard := *g
+	guard.Store = PostgresStore{Queries: q}
+	return &guard
+}
@@ -0,0 +1,248 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+)
+
+// fakeSMTP accepts one message and records the envelope and data.
+type fakeSMTP struct {
+	addr string
+	from string
+	to   []string
+	data string
+	done chan struct{}
+}
+
+func startFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	server := &fakeSMTP{addr: ln.Addr().String(), done: make(chan struct{})}
+	go func() {
+		defer close(server.done)
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		text := textproto.NewConn(conn)
+		text.PrintfLine("220 fake ESMTP")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch verb {
+			case "EHLO", "HELO":
+				text.PrintfLine("250 fake")
+			case "MAIL":
+				server.from = line
+				text.PrintfLine("250 OK")
+			case "RCPT":
+				server.to = append(server.to, line)
+				text.PrintfLine("250 OK")
+			case "DATA":
+				text.PrintfLine("354 go ahead")
+				lines, err := text.ReadDotLines()
+				if err != nil {
+					return
+				}
+				server.data = strings.Join(lines, "\n")
+				text.PrintfLine("250 queued")
+			case "QUIT":
+				text.PrintfLine("221 bye")
+				return
+			default:
+				text.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+	return server
+}
+
+func TestSMTPMailer(t *testing.T) {
+	server := startFakeSMTP(t)
+	mailer := &SMTPMailer{Addr: server.addr}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := mailer.Send(ctx, "chirpy@example.com", Message{
+		To:      "user@example.com",
+		Subject: "Reset your password",
+		Body:    "Use this link: http://localhost/reset?token=abc",
+	})
+	if err != nil {
+		t.Fatalf("Error sending: %v", err)
+	}
+	<-server.done
+	if server.from != "MAIL FROM:<chirpy@example.com>" {
+		t.Errorf("Unexpected MAIL command %q", server.from)
+	}
+	if len(server.to) != 1 || server.to[0] != "RCPT TO:<user@example.com>" {
+		t.Errorf("Unexpected RCPT commands %q", server.to)
+	}
+	if !strings.Contains(server.data, "Subject: Reset your password") || !strings.Contains(server.data, "token=3Dabc") {
+		t.Errorf("Unexpected message:\n%s", server.data)
+	}
+}
+
+func TestMessageValidate(t *testing.T) {
+	testCases := []struct {
+		msg Message
+		ok  bool
+	}{
+		{Message{To: "a@b.c", Subject: "hi"}, true},
+		{Message{To: "not an address", Subject: "hi"}, false},
+		{Message{To: "a@b.c", Subject: "hi\r\nBcc: victim@b.c"}, false},
+	}
+	for _, testCase := range testCases {
+		if err := testCase.msg.Validate(); (err == nil) != testCase.ok {
+			t.Errorf("%+v: expected ok=%v, got %v", testCase.msg, testCase.ok, err)
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.txt")
+	mailer := &FileMailer{Path: path}
+	for _, subject := range []string{"first", "second"} {
+		if err := mailer.Send(context.Background(), "chirpy@example.com", Message{To: "a@b.c", Subject: subject, Body: "hi"}); err != nil {
+			t.Fatalf("Error sending: %v", err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading sink: %v", err)
+	}
+	if !strings.Contains(string(data), "Subject: first") || !strings.Contains(string(data), "Subject: second") {
+		t.Errorf("Expected both messages in the sink, got:\n%s", data)
+	}
+}
+
+type memOutbox struct {
+	rows []db.MailOutbox
+}
+
+func (s *memOutbox) ClaimPendingMail(ctx context.Context, arg db.ClaimPendingMailParams) ([]db.MailOutbox, error) {
+	var claimed []db.MailOutbox
+	for i := range s.rows {
+		row := &s.rows[i]
+		if row.SentAt.Valid || row.NextAttemptAt.After(time.Now()) || row.Attempts >= arg.MaxAttempts {
+			continue
+		}
+		row.Attempts++
+		row.NextAttemptAt = arg.LeaseUntil
+		claimed = append(claimed, *row)
+	}
+	return claimed, nil
+}
+
+func (s *memOutbox) MarkMailSent(ctx context.Context, id int64) error {
+	for i := range s.rows {
+		if s.rows[i].ID == id {
+			s.rows[i].SentAt.Valid = true
+		}
+	}
+	return nil
+}
+
+func (s *memOutbox) MarkMailFailed(ctx context.Context, arg db.MarkMailFailedParams) error {
+	for i := range s.rows {
+		if s.rows[i].ID == arg.ID {
+			s.rows[i].LastError = arg.LastError
+			s.rows[i].NextAttemptAt = arg.NextAttemptAt
+		}
+	}
+	return nil
+}
+
+type flakyMailer struct {
+	failures int
+	sent     []Message
+}
+
+func (m *flakyMailer) Send(ctx context.Context, from string, msg Message) error {
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("connection refused")
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestOutboxFlush(t *testing.T) {
+	store := &memOutbox{rows: []db.MailOutbox{{ID: 1, ToAddress: "a@b.c", Subject: "hi"}}}
+	mailer := &flakyMailer{failures: 1}
+	outbox := &Outbox{Store: store, Mailer: mailer, BatchSize: 10, MaxAttempts: 3, Lease: time.Minute}
+
+	sent, err := outbox.Flush(context.Background())
+	if err != nil || sent != 0 {
+		t.Fatalf("Expected the first attempt to fail, got %v (%v)", sent, err)
+	}
+	if store.rows[0].LastError == "" || !store.rows[0].NextAttemptAt.After(time.Now()) {
+		t.Errorf("Expected the failure to be recorded with a backoff: %+v", store.rows[0])
+	}
+	if sent, _ := outbox.Flush(context.Background()); sent != 0 {
+		t.Errorf("Expected nothing to send during backoff")
+	}
+
+	store.rows[0].NextAttemptAt = time.Now()
+	sent, err = outbox.Flush(context.Background())
+	if err != nil || sent != 1 || len(mailer.sent) != 1 {
+		t.Fatalf("Expected the retry to send, got %v (%v)", sent, err)
+	}
+	if !store.rows[0].SentAt.Valid {
+		t.Errorf("Expected the message to be marked sent")
+	}
+}
+
+// slowMailer takes delay per message, or until the deadline.
+type slowMailer struct {
+	delay time.Duration
+	sent  int
+}
+
+func (m *slowMailer) Send(ctx context.Context, from string, msg Message) error {
+	select {
+	case <-time.After(m.delay):
+		m.sent++
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestOutboxFlushStopsAtLease(t *testing.T) {
+	store := &memOutbox{rows: []db.MailOutbox{
+		{ID: 1, ToAddress: "a@b.c", Subject: "one"},
+		{ID: 2, ToAddress: "a@b.c", Subject: "two"},
+		{ID: 3, ToAddress: "a@b.c", Subject: "three"},
+	}}
+	mailer := &slowMailer{delay: 30 * time.Millisecond}
+	outbox := &Outbox{Store: store, Mailer: mailer, BatchSize: 10, MaxAttempts: 3, Lease: 50 * time.Millisecond}
+
+	start := time.Now()
+	sent, err := outbox.Flush(context.Background())
+	if err != nil || sent != 1 {
+		t.Fatalf("Expected one message within the lease, got %v (%v)", sent, err)
+	}
+	if elapsed := time.Since(start); elapsed > 80*time.Millisecond {
+		t.Errorf("Expected sends to stop when the lease ran out, took %v", elapsed)
+	}
+	if store.rows[2].SentAt.Valid || store.rows[2].LastError != "" {
+		t.Errorf("Expected the message after the lease to be left for the next claim: %+v", store.rows[2])
+	}
+}
@@ -0,0 +1,119 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+)
+
+// Queuer is the query that adds a message to the outbox. Pass
+// db.Queries.WithTx to queue inside a transaction.
+type Queuer interface {
+	EnqueueMail(ctx context.Context, arg db.EnqueueMailParams) (db.MailOutbox, error)
+}
+
+// Queue adds msg to the outbox. It is sent once the surrounding
+// transaction, if any, commits.
+func Queue(ctx context.Context, q Queuer, msg Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	_, err := q.EnqueueMail(ctx, db.EnqueueMailParams{
+		ToAddress: msg.To,
+		Subject:   msg.Subject,
+		Body:      msg.Body,
+	})
+	return err
+}
+
+// Store is the subset of db.Queries the dispatcher needs.
+type Store interface {
+	ClaimPendingMail(ctx context.Context, arg db.ClaimPendingMailParams) ([]db.MailOutbox, error)
+	MarkMailSent(ctx context.Context, id int64) error
+	MarkMailFailed(ctx context.Context, arg db.MarkMailFailedParams) error
+}
+
+// Outbox hands queued messages to a Mailer, retrying failures with
+// backoff until MaxAttempts.
+type Outbox struct {
+	Store       Store
+	Mailer      Mailer
+	From        string
+	BatchSize   int32
+	MaxAttempts int32
+	// Lease is how long a claimed message is hidden from other
+	// dispatchers. Sends are cut off when it runs out, so another
+	// dispatcher never picks up a message still being sent.
+	Lease time.Duration
+}
+
+// Flush sends one batch of due messages and returns how many were sent.
+func (o *Outbox) Flush(ctx context.Context) (int, error) {
+	now := time.Now()
+	leaseUntil := now.Add(o.Lease)
+	pending, err := o.Store.ClaimPendingMail(ctx, db.ClaimPendingMailParams{
+		LeaseUntil:  leaseUntil,
+		MaxAttempts: o.MaxAttempts,
+		BatchSize:   o.BatchSize,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("claiming mail: %w", err)
+	}
+	sent := 0
+	for _, row := range pending {
+		// the whole batch shares one lease; what is left once it runs out
+		// is claimed again later
+		if !time.Now().Before(leaseUntil) {
+			break
+		}
+		sendCtx, cancel := context.WithDeadline(ctx, leaseUntil)
+		err := o.Mailer.Send(sendCtx, o.From, Message{
+			To:      row.ToAddress,
+			Subject: row.Subject,
+			Body:    row.Body,
+		})
+		cancel()
+		if err != nil {
+			slog.Error("Error sending mail", "error", err, "id", row.ID, "attempt", row.Attempts)
+			err = o.Store.MarkMailFailed(ctx, db.MarkMailFailedParams{
+				ID:            row.ID,
+				LastError:     err.Error(),
+				NextAttemptAt: now.Add(backoff(row.Attempts)),
+			})
+			if err != nil {
+				return sent, fmt.Errorf("marking mail %d failed: %w", row.ID, err)
+			}
+			continue
+		}
+		if err := o.Store.MarkMailSent(ctx, row.ID); err != nil {
+			return sent, fmt.Errorf("marking mail %d sent: %w", row.ID, err)
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// Run flushes the outbox every tick until ctx is done.
+func (o *Outbox) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := o.Flush(ctx); err != nil {
+				slog.Error("Error flushing mail outbox", "error", err)
+			}
+		}
+	}
+}
+
+// backoff is the wait before retrying after the given attempt: 1, 4, 9...
+// minutes.
+func backoff(attempt int32) time.Duration {
+	return time.Duration(attempt*attempt) * time.Minute
+}
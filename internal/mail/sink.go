@@ -0,0 +1,41 @@
+package mail
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileMailer appends every message to a file, for development. An empty
+// Path logs messages instead.
+type FileMailer struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (m *FileMailer) Send(ctx context.Context, from string, msg Message) error {
+	if m.Path == "" {
+		if err := msg.Validate(); err != nil {
+			return err
+		}
+		slog.Info("Email", "from", from, "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+		return nil
+	}
+	data, err := Format(from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := os.OpenFile(m.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, "\r\n\r\n"...)); err != nil {
+		return err
+	}
+	return f.Close()
+}
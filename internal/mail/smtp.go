@@ -0,0 +1,77 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTPMailer delivers through an SMTP relay. It upgrades to TLS when the
+// server offers STARTTLS and authenticates when Username is set; net/smtp
+// refuses to send credentials over plain text except to localhost.
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	// TLSConfig overrides the STARTTLS configuration, mainly for tests.
+	TLSConfig *tls.Config
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, from string, msg Message) error {
+	data, err := Format(from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		config := m.TLSConfig
+		if config == nil {
+			config = &tls.Config{ServerName: host}
+		}
+		if err := client.StartTLS(config); err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
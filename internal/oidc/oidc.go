@@ -0,0 +1,328 @@
+// Package oidc signs users in with an external OpenID Connect provider as a
+// relying party: discovery, the authorization code flow with PKCE, and ID
+// token validation against the provider's JWKS.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrDiscovery = errors.New("oidc: discovery failed")
+	ErrExchange  = errors.New("oidc: code exchange failed")
+	ErrIDToken   = errors.New("oidc: invalid ID token")
+	ErrNonce     = errors.New("oidc: nonce mismatch")
+)
+
+// jwksRefreshInterval limits how often an unknown kid makes us refetch
+// the provider's keys, so junk tokens cannot hammer the provider.
+const jwksRefreshInterval = time.Minute
+
+// Metadata is the part of the provider configuration document we use.
+type Metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgorithms     []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// Config describes our registration with the provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes are requested on top of openid.
+	Scopes []string
+}
+
+// Tokens is a token endpoint response.
+type Tokens struct {
+	IDToken     string `json:"id_token"`
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+}
+
+// IDToken is a validated ID token.
+type IDToken struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	IssuedAt      time.Time
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce           string      `json:"nonce"`
+	AuthorizedParty string      `json:"azp"`
+	Email           string      `json:"email"`
+	EmailVerified   interface{} `json:"email_verified"`
+	Name            string      `json:"name"`
+}
+
+// Client is a relying party for one provider. It discovers the provider
+// on first use and caches its keys. It is safe for concurrent use.
+type Client struct {
+	Config     Config
+	HTTPClient *http.Client
+	// Leeway is the clock skew tolerated on exp and iat.
+	Leeway time.Duration
+
+	mu          sync.Mutex
+	metadata    *Metadata
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+func NewClient(config Config) *Client {
+	return &Client{
+		Config:     config,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		Leeway:     auth.DefaultLeeway,
+	}
+}
+
+// Metadata fetches the provider configuration the first time it is called.
+// A failed discovery is retried on the next call.
+func (c *Client) Metadata(ctx context.Context) (*Metadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.metadata != nil {
+		return c.metadata, nil
+	}
+	issuer := strings.TrimSuffix(c.Config.Issuer, "/")
+	metadata := &Metadata{}
+	if err := c.getJSON(ctx, issuer+"/.well-known/openid-configuration", metadata); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	// OpenID Connect Discovery 4.3: the document must name the issuer we
+	// asked, or a compromised document could impersonate another provider
+	if metadata.Issuer != c.Config.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q does not match %q", ErrDiscovery, metadata.Issuer, c.Config.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: missing endpoints", ErrDiscovery)
+	}
+	c.metadata = metadata
+	return metadata, nil
+}
+
+// AuthCodeURL is where to send the browser to log in. The caller keeps
+// state, nonce and verifier for the callback.
+func (c *Client) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	metadata, err := c.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	target, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	query := target.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", c.Config.ClientID)
+	query.Set("redirect_uri", c.Config.RedirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid"}, c.Config.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", auth.PKCEChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	target.RawQuery = query.Encode()
+	return target.String(), nil
+}
+
+// Exchange redeems an authorization code at the token endpoint.
+func (c *Client) Exchange(ctx context.Context, code string, verifier string) (*Tokens, error) {
+	metadata, err := c.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.Config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.Config.ClientID), url.QueryEscape(c.Config.ClientSecret))
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var oauthErr struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		json.Unmarshal(body, &oauthErr)
+		return nil, fmt.Errorf("%w: %s %s %s", ErrExchange, resp.Status, oauthErr.Error, oauthErr.Description)
+	}
+	tokens := &Tokens{}
+	if err := json.Unmarshal(body, tokens); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: response has no id_token", ErrExchange)
+	}
+	return tokens, nil
+}
+
+// Verify validates an ID token's signature, issuer, audience, lifetime and
+// nonce (OpenID Connect Core 3.1.3.7).
+func (c *Client) Verify(ctx context.Context, rawIDToken string, nonce string) (*IDToken, error) {
+	metadata, err := c.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	algorithms := metadata.SigningAlgorithms
+	if len(algorithms) == 0 {
+		algorithms = []string{"RS256"}
+	}
+	claims := &idTokenClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims,
+		func(token *jwt.Token) (interface{}, error) {
+			return c.key(ctx, token)
+		},
+		jwt.WithValidMethods(supportedAlgorithms(algorithms)),
+		jwt.WithIssuer(metadata.Issuer),
+		jwt.WithAudience(c.Config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(c.Leeway),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIDToken, err)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != c.Config.ClientID {
+		return nil, fmt.Errorf("%w: azp %q is not our client", ErrIDToken, claims.AuthorizedParty)
+	}
+	if claims.Subject == "" || claims.IssuedAt == nil {
+		return nil, fmt.Errorf("%w: missing sub or iat", ErrIDToken)
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, ErrNonce
+	}
+	return &IDToken{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: isTrue(claims.EmailVerified),
+		Name:          claims.Name,
+		IssuedAt:      claims.IssuedAt.Time,
+	}, nil
+}
+
+// key finds the provider key for token, refetching the JWKS when the kid
+// is unknown because the provider may have rotated keys.
+func (c *Client) key(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, ok := c.lookupKey(kid)
+	if ok {
+		return key, nil
+	}
+	if time.Since(c.keysFetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	set := auth.JWKS{}
+	if err := c.getJSON(ctx, c.metadata.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	c.keysFetched = time.Now()
+	c.keys = map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			// skip keys we cannot use rather than failing every login
+			continue
+		}
+		c.keys[jwk.Kid] = pub
+	}
+	key, ok = c.lookupKey(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	return key, nil
+}
+
+// lookupKey finds a cached key. A token without a kid is accepted only
+// when the provider publishes a single key.
+func (c *Client) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+func (c *Client) getJSON(ctx context.Context, target string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", target, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// supportedAlgorithms keeps the provider's algorithms we can verify. HS256
+// is never accepted: it would be keyed with our client secret.
+func supportedAlgorithms(algorithms []string) []string {
+	supported := []string{}
+	for _, alg := range algorithms {
+		switch alg {
+		case "RS256", "ES256", "EdDSA":
+			supported = append(supported, alg)
+		}
+	}
+	return supported
+}
+
+// isTrue reads email_verified, which some providers send as a string.
+func isTrue(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/oidc/oidctest"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID    = "chirpy"
+	testSecret      = "s3cret&more"
+	testRedirectURL = "http://localhost:8080/app/oidc-callback"
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+func newTestProvider(t *testing.T) (*oidctest.Provider, *Client) {
+	t.Helper()
+	provider, err := oidctest.New(testClientID, testSecret)
+	if err != nil {
+		t.Fatalf("Error starting provider: %v", err)
+	}
+	t.Cleanup(provider.Close)
+	provider.User = oidctest.User{
+		Subject:       "user-123",
+		Email:         "ada@example.com",
+		EmailVerified: true,
+		Name:          "Ada",
+	}
+	client := NewClient(Config{
+		Issuer:       provider.Issuer(),
+		ClientID:     testClientID,
+		ClientSecret: testSecret,
+		RedirectURL:  testRedirectURL,
+		Scopes:       []string{"email", "profile"},
+	})
+	return provider, client
+}
+
+// authorize follows the browser to the provider and back, returning the
+// code and state from the callback.
+func authorize(t *testing.T, client *Client, state string, nonce string) (string, string) {
+	t.Helper()
+	target, err := client.AuthCodeURL(context.Background(), state, nonce, testVerifier)
+	if err != nil {
+		t.Fatalf("Error building auth URL: %v", err)
+	}
+	browser := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := browser.Get(target)
+	if err != nil {
+		t.Fatalf("Error visiting provider: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("Expected a redirect, got %s", resp.Status)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("Error parsing callback: %v", err)
+	}
+	return callback.Query().Get("code"), callback.Query().Get("state")
+}
+
+func TestLoginEndToEnd(t *testing.T) {
+	_, client := newTestProvider(t)
+	code, state := authorize(t, client, "state-1", "nonce-1")
+	if state != "state-1" {
+		t.Errorf("Expected state to round-trip, got %q", state)
+	}
+	tokens, err := client.Exchange(context.Background(), code, testVerifier)
+	if err != nil {
+		t.Fatalf("Error exchanging code: %v", err)
+	}
+	idToken, err := client.Verify(context.Background(), tokens.IDToken, "nonce-1")
+	if err != nil {
+		t.Fatalf("Error verifying ID token: %v", err)
+	}
+	if idToken.Subject != "user-123" || idToken.Email != "ada@example.com" || !idToken.EmailVerified {
+		t.Errorf("Unexpected ID token %+v", idToken)
+	}
+
+	if _, err := client.Exchange(context.Background(), code, testVerifier); !errors.Is(err, ErrExchange) {
+		t.Errorf("Expected a redeemed code to be rejected, got %v", err)
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	_, client := newTestProvider(t)
+	code, _ := authorize(t, client, "state", "nonce")
+	wrong := "A" + testVerifier[1:]
+	if _, err := client.Exchange(context.Background(), code, wrong); !errors.Is(err, ErrExchange) {
+		t.Errorf("Expected ErrExchange, got %v", err)
+	}
+}
+
+func TestVerifyRejectsBadTokens(t *testing.T) {
+	testCases := []struct {
+		name   string
+		edit   func(claims jwt.MapClaims)
+		nonce  string
+		target error
+	}{
+		{"wrong nonce", nil, "other-nonce", ErrNonce},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "someone-else" }, "nonce", ErrIDToken},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, "nonce", ErrIDToken},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, "nonce", ErrIDToken},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }, "nonce", ErrIDToken},
+		{"issued in the future", func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() }, "nonce", ErrIDToken},
+		{"foreign azp", func(c jwt.MapClaims) {
+			c["aud"] = []string{testClientID, "other"}
+			c["azp"] = "other"
+		}, "nonce", ErrIDToken},
+	}
+	for _, testCase := range testCases {
+		provider, client := newTestProvider(t)
+		provider.Claims = testCase.edit
+		code, _ := authorize(t, client, "state", "nonce")
+		tokens, err := client.Exchange(context.Background(), code, testVerifier)
+		if err != nil {
+			t.Fatalf("%s: error exchanging code: %v", testCase.name, err)
+		}
+		if _, err := client.Verify(context.Background(), tokens.IDToken, testCase.nonce); !errors.Is(err, testCase.target) {
+			t.Errorf("%s: expected %v, got %v", testCase.name, testCase.target, err)
+		}
+	}
+}
+
+func TestVerifyRejectsForeignSignature(t *testing.T) {
+	provider, client := newTestProvider(t)
+	// a key the provider never published
+	key, _ := auth.GenerateSigningKey(auth.AlgRS256)
+	forger := auth.NewKeyring("")
+	forger.Set([]auth.SigningKey{key})
+	token, err := forger.Sign(jwt.MapClaims{
+		"iss":   provider.Issuer(),
+		"sub":   "user-123",
+		"aud":   testClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": "nonce",
+	})
+	if err != nil {
+		t.Fatalf("Error signing: %v", err)
+	}
+	if _, err := client.Verify(context.Background(), token, "nonce"); !errors.Is(err, ErrIDToken) {
+		t.Errorf("Expected ErrIDToken, got %v", err)
+	}
+}
+
+func TestVerifyFollowsKeyRotation(t *testing.T) {
+	provider, client := newTestProvider(t)
+	code, _ := authorize(t, client, "state", "nonce")
+	tokens, _ := client.Exchange(context.Background(), code, testVerifier)
+	if _, err := client.Verify(context.Background(), tokens.IDToken, "nonce"); err != nil {
+		t.Fatalf("Error verifying: %v", err)
+	}
+
+	key, _ := auth.GenerateSigningKey(auth.AlgEdDSA)
+	provider.Keys.Set([]auth.SigningKey{key})
+	// pretend the cached keys are old enough to refetch
+	client.keysFetched = time.Time{}
+	code, _ = authorize(t, client, "state", "nonce")
+	tokens, _ = client.Exchange(context.Background(), code, testVerifier)
+	if _, err := client.Verify(context.Background(), tokens.IDToken, "nonce"); err != nil {
+		t.Errorf("Expected the rotated key to be fetched, got %v", err)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	provider, _ := newTestProvider(t)
+	client := NewClient(Config{Issuer: provider.Issuer() + "/", ClientID: testClientID})
+	if _, err := client.Metadata(context.Background()); !errors.Is(err, ErrDiscovery) {
+		t.Errorf("Expected ErrDiscovery, got %v", err)
+	}
+}
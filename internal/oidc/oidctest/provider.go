@@ -0,0 +1,186 @@
+// Package oidctest runs a fake OpenID Connect provider in httptest, for
+// testing the relying party end to end.
+package oidctest
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// User is who logs in at the provider.
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider logs User in without asking whenever a browser reaches its
+// authorization endpoint, then redeems the code once.
+type Provider struct {
+	Server       *httptest.Server
+	ClientID     string
+	ClientSecret string
+	User         User
+	// Keys signs ID tokens. Set new keys on it to simulate rotation.
+	Keys *auth.Keyring
+	// Claims, when set, edits ID token claims before signing, to produce
+	// bad tokens.
+	Claims func(claims jwt.MapClaims)
+
+	mu    sync.Mutex
+	codes map[string]grant
+}
+
+type grant struct {
+	redirectURI string
+	nonce       string
+	challenge   string
+	user        User
+}
+
+// New starts a provider that knows one client.
+func New(clientID string, clientSecret string) (*Provider, error) {
+	key, err := auth.GenerateSigningKey(auth.AlgRS256)
+	if err != nil {
+		return nil, err
+	}
+	keys := auth.NewKeyring("")
+	keys.Set([]auth.SigningKey{key})
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Keys:         keys,
+		codes:        map[string]grant{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	mux.HandleFunc("GET /authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+	p.Server = httptest.NewServer(mux)
+	return p, nil
+}
+
+// Issuer is the provider's issuer identifier.
+func (p *Provider) Issuer() string {
+	return p.Server.URL
+}
+
+func (p *Provider) Close() {
+	p.Server.Close()
+}
+
+// SignIDToken signs arbitrary claims with the provider's current key.
+func (p *Provider) SignIDToken(claims jwt.MapClaims) (string, error) {
+	return p.Keys.Sign(claims)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, map[string]interface{}{
+		"issuer":                                p.Issuer(),
+		"authorization_endpoint":                p.Issuer() + "/authorize",
+		"token_endpoint":                        p.Issuer() + "/token",
+		"jwks_uri":                              p.Issuer() + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256", "EdDSA"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, p.Keys.JWKS())
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != p.ClientID || query.Get("response_type") != "code" ||
+		query.Get("code_challenge_method") != "S256" || query.Get("redirect_uri") == "" {
+		http.Error(w, "bad authorization request", 400)
+		return
+	}
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	p.mu.Lock()
+	p.codes[code] = grant{
+		redirectURI: query.Get("redirect_uri"),
+		nonce:       query.Get("nonce"),
+		challenge:   query.Get("code_challenge"),
+		user:        p.User,
+	}
+	p.mu.Unlock()
+	target, _ := url.Parse(query.Get("redirect_uri"))
+	params := target.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	target.RawQuery = params.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	}
+	if !ok || clientID != p.ClientID || subtle.ConstantTimeCompare([]byte(secret), []byte(p.ClientSecret)) != 1 {
+		writeJSON(w, 401, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.FormValue("grant_type") != "authorization_code" {
+		writeJSON(w, 400, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	p.mu.Lock()
+	g, found := p.codes[r.FormValue("code")]
+	delete(p.codes, r.FormValue("code"))
+	p.mu.Unlock()
+	if !found || g.redirectURI != r.FormValue("redirect_uri") || !auth.VerifyPKCE(r.FormValue("code_verifier"), g.challenge) {
+		writeJSON(w, 400, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            p.Issuer(),
+		"sub":            g.user.Subject,
+		"aud":            p.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(10 * time.Minute).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.user.Email,
+		"email_verified": g.user.EmailVerified,
+		"name":           g.user.Name,
+	}
+	if p.Claims != nil {
+		p.Claims(claims)
+	}
+	idToken, err := p.SignIDToken(claims)
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, 200, map[string]interface{}{
+		"access_token": "opaque-" + g.user.Subject,
+		"token_type":   "Bearer",
+		"expires_in":   600,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+)
+
+// Queries is the subset of db.Queries PostgresStore needs.
+type Queries interface {
+	TakeRateLimit(ctx context.Context, arg db.TakeRateLimitParams) (time.Time, error)
+	GetRateLimit(ctx context.Context, key string) (time.Time, error)
+	DeleteExpiredRateLimits(ctx context.Context, tat time.Time) error
+}
+
+// PostgresStore keeps TATs in the rate_limits table, so every replica
+// shares one quota per key. Each request is a single upsert that only
+// moves the TAT when the request is allowed.
+type PostgresStore struct {
+	Queries Queries
+}
+
+func (s PostgresStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	next, err := s.Queries.TakeRateLimit(ctx, db.TakeRateLimitParams{
+		Key:             key,
+		Now:             now,
+		IntervalSeconds: limit.interval().Seconds(),
+		PeriodSeconds:   limit.Per.Seconds(),
+	})
+	if err == nil {
+		return allowed(next, now, limit), nil
+	}
+	if err != sql.ErrNoRows {
+		return Result{}, err
+	}
+	// the upsert matched no row, so the request is over the limit
+	tat, err := s.Queries.GetRateLimit(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	return denied(tat, now, limit), nil
+}
+
+func (s PostgresStore) Prune(ctx context.Context, before time.Time) error {
+	return s.Queries.DeleteExpiredRateLimits(ctx, before)
+}
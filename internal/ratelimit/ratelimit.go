@@ -0,0 +1,238 @@
+// Package ratelimit limits how often each client may call an endpoint. It
+// uses the generic cell rate algorithm (GCRA), which behaves like a token
+// bucket but only needs one timestamp per key: the theoretical arrival
+// time (TAT) of the next request.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit allows Requests per Per, all of which may come at once.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// interval is the time one request uses up.
+func (l Limit) interval() time.Duration {
+	return l.Per / time.Duration(l.Requests)
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Per)
+}
+
+// ParseLimit parses "requests/period", e.g. "30/1h".
+func ParseLimit(s string) (Limit, error) {
+	requests, period, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid limit %q, expected requests/period", s)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 1 {
+		return Limit{}, fmt.Errorf("invalid request count in limit %q", s)
+	}
+	per, err := time.ParseDuration(period)
+	if err != nil || per <= 0 {
+		return Limit{}, fmt.Errorf("invalid period in limit %q", s)
+	}
+	return Limit{Requests: n, Per: per}, nil
+}
+
+// Result describes one request against a limit.
+type Result struct {
+	Allowed   bool
+	Limit     Limit
+	Remaining int
+	// RetryAfter is how long a denied client must wait.
+	RetryAfter time.Duration
+	// ResetAfter is how long until the whole quota is available again.
+	ResetAfter time.Duration
+}
+
+// take applies GCRA to a key whose TAT is tat. It returns the key's new
+// TAT, which is tat itself when the request is denied.
+func take(tat time.Time, now time.Time, limit Limit) (time.Time, Result) {
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(limit.interval())
+	if allowAt := next.Add(-limit.Per); now.Before(allowAt) {
+		return tat, denied(tat, now, limit)
+	}
+	return next, allowed(next, now, limit)
+}
+
+// allowed is the Result of a request that moved the TAT to next.
+func allowed(next time.Time, now time.Time, limit Limit) Result {
+	allowAt := next.Add(-limit.Per)
+	return Result{
+		Allowed:    true,
+		Limit:      limit,
+		Remaining:  int(now.Sub(allowAt) / limit.interval()),
+		ResetAfter: next.Sub(now),
+	}
+}
+
+// denied is the Result of a request refused at TAT tat.
+func denied(tat time.Time, now time.Time, limit Limit) Result {
+	return Result{
+		Limit:      limit,
+		RetryAfter: tat.Add(limit.interval()).Add(-limit.Per).Sub(now),
+		ResetAfter: tat.Sub(now),
+	}
+}
+
+// Store keeps the TAT of each key. Use MemoryStore for a single replica
+// and PostgresStore to share limits between replicas.
+type Store interface {
+	// Take counts a request against key if limit allows it.
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+	// Prune forgets keys whose TAT is before before; their quota is full.
+	Prune(ctx context.Context, before time.Time) error
+}
+
+// Policy is the limit of one route. Chirpy Red members get Red instead,
+// when it is set.
+type Policy struct {
+	Limit Limit
+	Red   Limit
+}
+
+// For returns the limit for a member or non-member.
+func (p Policy) For(red bool) Limit {
+	if red && p.Red.Requests > 0 {
+		return p.Red
+	}
+	return p.Limit
+}
+
+// DefaultPolicies are the limits Chirpy ships with, by policy name.
+var DefaultPolicies = map[string]Policy{
+	// password guessing is also slowed down per account by lockout
+	"login":  {Limit: Limit{Requests: 20, Per: time.Minute}},
+	"signup": {Limit: Limit{Requests: 5, Per: time.Hour}},
+	// endpoints that send email
+	"email": {Limit: Limit{Requests: 5, Per: time.Hour}},
+	"token": {Limit: Limit{Requests: 60, Per: time.Minute}},
+	"chirps_create": {
+		Limit: Limit{Requests: 30, Per: time.Hour},
+		Red:   Limit{Requests: 300, Per: time.Hour},
+	},
+	"reports_create": {Limit: Limit{Requests: 20, Per: time.Hour}},
+	"tokens_create":  {Limit: Limit{Requests: 20, Per: time.Hour}},
+	"read":           {Limit: Limit{Requests: 600, Per: time.Minute}},
+	// resource servers introspect on every request they serve
+	"introspect": {Limit: Limit{Requests: 600, Per: time.Minute}},
+	// Polka retries on its own, so a 429 only delays a delivery
+	"polka": {Limit: Limit{Requests: 300, Per: time.Minute}},
+}
+
+// ParsePolicies overrides policies with a comma-separated list like
+// "login=30/1m,chirps_create=50/1h,chirps_create.red=500/1h". The ".red"
+// suffix sets a policy's Chirpy Red limit.
+func ParsePolicies(s string, policies map[string]Policy) (map[string]Policy, error) {
+	out := map[string]Policy{}
+	for name, policy := range policies {
+		out[name] = policy
+	}
+	for _, entry := range strings.Split(s, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q, expected name=requests/period", entry)
+		}
+		limit, err := ParseLimit(value)
+		if err != nil {
+			return nil, err
+		}
+		name = strings.TrimSpace(name)
+		base, red := strings.CutSuffix(name, ".red")
+		policy, ok := out[base]
+		if !ok {
+			return nil, fmt.Errorf("unknown rate limit policy %q", base)
+		}
+		if red {
+			policy.Red = limit
+		} else {
+			policy.Limit = limit
+		}
+		out[base] = policy
+	}
+	return out, nil
+}
+
+// Limiter applies named policies to keys.
+type Limiter struct {
+	Store    Store
+	Policies map[string]Policy
+}
+
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{Store: store, Policies: DefaultPolicies}
+}
+
+// Take counts a request by key against the named policy. Keys are scoped
+// to the policy, so each policy has its own quota.
+func (l *Limiter) Take(ctx context.Context, policy string, key string, red bool, now time.Time) (Result, error) {
+	p, ok := l.Policies[policy]
+	if !ok {
+		return Result{}, fmt.Errorf("unknown rate limit policy %q", policy)
+	}
+	return l.Store.Take(ctx, policy+":"+key, p.For(red), now)
+}
+
+// Run prunes full quotas every interval until ctx is done.
+func (l *Limiter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.Store.Prune(ctx, time.Now()); err != nil {
+				slog.Error("Error pruning rate limits", "error", err)
+			}
+		}
+	}
+}
+
+// MemoryStore keeps TATs in this process. Limits are lost on restart and
+// not shared between replicas.
+type MemoryStore struct {
+	mu   sync.Mutex
+	tats map[string]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tats: map[string]time.Time{}}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tat, result := take(s.tats[key], now, limit)
+	s.tats[key] = tat
+	return result, nil
+}
+
+func (s *MemoryStore) Prune(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, tat := range s.tats {
+		if tat.Before(before) {
+			delete(s.tats, key)
+		}
+	}
+	return nil
+}
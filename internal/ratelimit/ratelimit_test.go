@@ -0,0 +1,185 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+)
+
+func TestParseLimit(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Limit
+		wantErr  bool
+	}{
+		{"30/1h", Limit{Requests: 30, Per: time.Hour}, false},
+		{" 10/1m ", Limit{Requests: 10, Per: time.Minute}, false},
+		{"30", Limit{}, true},
+		{"0/1h", Limit{}, true},
+		{"x/1h", Limit{}, true},
+		{"30/hour", Limit{}, true},
+		{"30/-1h", Limit{}, true},
+	}
+	for _, testCase := range testCases {
+		limit, err := ParseLimit(testCase.input)
+		if (err != nil) != testCase.wantErr {
+			t.Errorf("ParseLimit(%q): unexpected error %v", testCase.input, err)
+			continue
+		}
+		if limit != testCase.expected {
+			t.Errorf("ParseLimit(%q) = %v, expected %v", testCase.input, limit, testCase.expected)
+		}
+	}
+}
+
+func TestParsePolicies(t *testing.T) {
+	policies, err := ParsePolicies("login=30/1m, chirps_create.red=500/1h", DefaultPolicies)
+	if err != nil {
+		t.Fatalf("Error parsing policies: %v", err)
+	}
+	if policies["login"].Limit != (Limit{Requests: 30, Per: time.Minute}) {
+		t.Errorf("Expected login to be overridden, got %v", policies["login"].Limit)
+	}
+	chirps := policies["chirps_create"]
+	if chirps.Limit != DefaultPolicies["chirps_create"].Limit || chirps.Red != (Limit{Requests: 500, Per: time.Hour}) {
+		t.Errorf("Expected only the Red limit of chirps_create to change, got %+v", chirps)
+	}
+	if DefaultPolicies["login"].Limit.Requests != 20 {
+		t.Error("Expected the defaults to be left alone")
+	}
+	for _, input := range []string{"nope=1/1m", "login", "login=1"} {
+		if _, err := ParsePolicies(input, DefaultPolicies); err == nil {
+			t.Errorf("Expected an error for %q", input)
+		}
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	policy := DefaultPolicies["chirps_create"]
+	if policy.For(true) != policy.Red || policy.For(false) != policy.Limit {
+		t.Error("Expected members to get the Red limit")
+	}
+	login := DefaultPolicies["login"]
+	if login.For(true) != login.Limit {
+		t.Error("Expected members to get the usual limit without a Red one")
+	}
+}
+
+// testStore runs the GCRA checks every store must pass.
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	limit := Limit{Requests: 3, Per: 3 * time.Minute}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// the whole burst is available at once
+	for i := 2; i >= 0; i-- {
+		result, err := store.Take(ctx, "k", limit, now)
+		if err != nil {
+			t.Fatalf("Error taking: %v", err)
+		}
+		if !result.Allowed || result.Remaining != i {
+			t.Errorf("Expected to be allowed with %d remaining, got %+v", i, result)
+		}
+	}
+	result, err := store.Take(ctx, "k", limit, now)
+	if err != nil {
+		t.Fatalf("Error taking: %v", err)
+	}
+	if result.Allowed || result.RetryAfter != time.Minute || result.ResetAfter != 3*time.Minute {
+		t.Errorf("Expected to be denied for a minute, got %+v", result)
+	}
+	// a denied request does not use up quota
+	result, _ = store.Take(ctx, "k", limit, now.Add(time.Minute))
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf("Expected one request after a minute, got %+v", result)
+	}
+	// other keys have their own quota
+	if result, _ := store.Take(ctx, "other", limit, now); !result.Allowed {
+		t.Errorf("Expected another key to be allowed, got %+v", result)
+	}
+	// an idle key refills, but never beyond the burst
+	result, _ = store.Take(ctx, "k", limit, now.Add(time.Hour))
+	if !result.Allowed || result.Remaining != 2 {
+		t.Errorf("Expected a full quota after an hour, got %+v", result)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	testStore(t, store)
+	if err := store.Prune(context.Background(), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("Error pruning: %v", err)
+	}
+	if len(store.tats) != 0 {
+		t.Errorf("Expected every key to be pruned, %d left", len(store.tats))
+	}
+}
+
+// fakeQueries does what the rate_limits queries do in Postgres.
+type fakeQueries struct {
+	tats map[string]time.Time
+}
+
+func (q *fakeQueries) TakeRateLimit(ctx context.Context, arg db.TakeRateLimitParams) (time.Time, error) {
+	interval := time.Duration(arg.IntervalSeconds * float64(time.Second))
+	period := time.Duration(arg.PeriodSeconds * float64(time.Second))
+	tat, ok := q.tats[arg.Key]
+	if !ok {
+		q.tats[arg.Key] = arg.Now.Add(interval)
+		return q.tats[arg.Key], nil
+	}
+	if tat.Before(arg.Now) {
+		tat = arg.Now
+	}
+	if tat.Add(interval).Add(-period).After(arg.Now) {
+		return time.Time{}, sql.ErrNoRows
+	}
+	q.tats[arg.Key] = tat.Add(interval)
+	return q.tats[arg.Key], nil
+}
+
+func (q *fakeQueries) GetRateLimit(ctx context.Context, key string) (time.Time, error) {
+	tat, ok := q.tats[key]
+	if !ok {
+		return time.Time{}, sql.ErrNoRows
+	}
+	return tat, nil
+}
+
+func (q *fakeQueries) DeleteExpiredRateLimits(ctx context.Context, before time.Time) error {
+	for key, tat := range q.tats {
+		if tat.Before(before) {
+			delete(q.tats, key)
+		}
+	}
+	return nil
+}
+
+func TestPostgresStore(t *testing.T) {
+	testStore(t, PostgresStore{Queries: &fakeQueries{tats: map[string]time.Time{}}})
+}
+
+func TestLimiterScopesKeysByPolicy(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLimiter(NewMemoryStore())
+	limiter.Policies = map[string]Policy{
+		"a": {Limit: Limit{Requests: 1, Per: time.Hour}},
+		"b": {Limit: Limit{Requests: 1, Per: time.Hour}, Red: Limit{Requests: 2, Per: time.Hour}},
+	}
+	now := time.Now()
+	if result, _ := limiter.Take(ctx, "a", "ip:203.0.113.1", false, now); !result.Allowed {
+		t.Error("Expected the first request to be allowed")
+	}
+	if result, _ := limiter.Take(ctx, "a", "ip:203.0.113.1", false, now); result.Allowed {
+		t.Error("Expected the second request to be denied")
+	}
+	if result, _ := limiter.Take(ctx, "b", "ip:203.0.113.1", true, now); !result.Allowed || result.Remaining != 1 {
+		t.Errorf("Expected another policy to have its own Red quota, got %+v", result)
+	}
+	if _, err := limiter.Take(ctx, "c", "ip:203.0.113.1", false, now); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
@@ -0,0 +1,113 @@
+// Package secretbox encrypts secrets kept in the database, such as JWT
+// signing keys and TOTP secrets, under a key encryption key (KEK) that is
+// kept out of it, so a leaked dump or backup does not leak them. Values
+// are sealed with AES-256-GCM. The caller passes a label naming where a
+// value is stored, e.g. "signing_key:<kid>", as associated data, so a
+// sealed value copied into another row does not open.
+package secretbox
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// KeySize is the length of a KEK in bytes.
+const KeySize = 32
+
+// prefix starts every sealed value. Plain PKCS8 keys and base32 TOTP
+// secrets never start with it, which lets values stored before encryption
+// be told apart and sealed on first use.
+var prefix = []byte("sbx1")
+
+var (
+	ErrNotSealed = errors.New("value is not sealed")
+	ErrOpen      = errors.New("sealed value does not open with this key")
+)
+
+// Box seals and opens values with one KEK.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// New returns a Box for a KeySize-byte key.
+func New(key []byte) (*Box, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// ParseKey returns a Box for a base64-encoded key, as generated by
+// "openssl rand -base64 32".
+func ParseKey(encoded string) (*Box, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("key is not base64: %w", err)
+	}
+	return New(key)
+}
+
+// Seal encrypts plaintext for storage under label.
+func (b *Box) Seal(plaintext []byte, label string) []byte {
+	nonce := make([]byte, b.aead.NonceSize())
+	// crypto/rand does not fail since Go 1.24
+	rand.Read(nonce)
+	sealed := append(append([]byte{}, prefix...), nonce...)
+	return b.aead.Seal(sealed, nonce, plaintext, []byte(label))
+}
+
+// Open decrypts a value sealed under label. It returns ErrNotSealed for
+// values stored before encryption, which the caller may still trust, and
+// ErrOpen when the key or label do not match.
+func (b *Box) Open(sealed []byte, label string) ([]byte, error) {
+	if !IsSealed(sealed) {
+		return nil, ErrNotSealed
+	}
+	sealed = sealed[len(prefix):]
+	if len(sealed) < b.aead.NonceSize() {
+		return nil, ErrOpen
+	}
+	nonce, ciphertext := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, []byte(label))
+	if err != nil {
+		return nil, ErrOpen
+	}
+	return plaintext, nil
+}
+
+// SealString is Seal for text columns: the sealed value is base64
+// encoded.
+func (b *Box) SealString(plaintext string, label string) string {
+	return base64.StdEncoding.EncodeToString(b.Seal([]byte(plaintext), label))
+}
+
+// OpenString opens a value made by SealString, with the errors of Open.
+func (b *Box) OpenString(sealed string, label string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", ErrNotSealed
+	}
+	plaintext, err := b.Open(decoded, label)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// IsSealed reports whether value was made by Seal.
+func IsSealed(value []byte) bool {
+	return bytes.HasPrefix(value, prefix)
+}
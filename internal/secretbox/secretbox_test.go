@@ -0,0 +1,88 @@
+package secretbox
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func newBox(t *testing.T) *Box {
+	key := make([]byte, KeySize)
+	rand.Read(key)
+	box, err := New(key)
+	if err != nil {
+		t.Fatalf("Error creating box: %v", err)
+	}
+	return box
+}
+
+func TestSealOpen(t *testing.T) {
+	box := newBox(t)
+	sealed := box.Seal([]byte("private key"), "signing_key:a")
+	if bytes.Contains(sealed, []byte("private key")) {
+		t.Fatalf("Expected the plaintext to be encrypted, got %q", sealed)
+	}
+	opened, err := box.Open(sealed, "signing_key:a")
+	if err != nil || string(opened) != "private key" {
+		t.Fatalf("Expected the plaintext back, got %q (%v)", opened, err)
+	}
+	if again := box.Seal([]byte("private key"), "signing_key:a"); bytes.Equal(again, sealed) {
+		t.Errorf("Expected a fresh nonce for every seal")
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	box := newBox(t)
+	sealed := box.Seal([]byte("private key"), "signing_key:a")
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 1
+
+	testCases := []struct {
+		name   string
+		box    *Box
+		sealed []byte
+		label  string
+		err    error
+	}{
+		{"other row", box, sealed, "signing_key:b", ErrOpen},
+		{"other key", newBox(t), sealed, "signing_key:a", ErrOpen},
+		{"tampered", box, tampered, "signing_key:a", ErrOpen},
+		{"truncated", box, sealed[:6], "signing_key:a", ErrOpen},
+		{"stored before encryption", box, []byte{0x30, 0x2e}, "signing_key:a", ErrNotSealed},
+	}
+	for _, testCase := range testCases {
+		if _, err := testCase.box.Open(testCase.sealed, testCase.label); !errors.Is(err, testCase.err) {
+			t.Errorf("%s: expected %v, got %v", testCase.name, testCase.err, err)
+		}
+	}
+}
+
+func TestSealString(t *testing.T) {
+	box := newBox(t)
+	sealed := box.SealString("JBSWY3DPEHPK3PXP", "totp:a")
+	if opened, err := box.OpenString(sealed, "totp:a"); err != nil || opened != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("Expected the secret back, got %q (%v)", opened, err)
+	}
+	if _, err := box.OpenString(sealed, "totp:b"); !errors.Is(err, ErrOpen) {
+		t.Errorf("Expected %v for another row, got %v", ErrOpen, err)
+	}
+	// base32 secrets stored before encryption
+	for _, plain := range []string{"JBSWY3DPEHPK3PXP", "JBSWY3DPEHPK3PX="} {
+		if _, err := box.OpenString(plain, "totp:a"); !errors.Is(err, ErrNotSealed) {
+			t.Errorf("%s: expected %v, got %v", plain, ErrNotSealed, err)
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	if _, err := ParseKey("c2hvcnQ="); err == nil {
+		t.Errorf("Expected a short key to be refused")
+	}
+	if _, err := ParseKey("not base64!"); err == nil {
+		t.Errorf("Expected invalid base64 to be refused")
+	}
+	if _, err := ParseKey("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="); err != nil {
+		t.Errorf("Expected a 32 byte key to parse, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package spam
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// DuplicateBody flags a chirp whose body repeats one the author posted
+// within Window.
+type DuplicateBody struct {
+	Window time.Duration
+}
+
+func (c DuplicateBody) Name() string { return "duplicate_body" }
+
+func (c DuplicateBody) Score(in Input) (float64, string) {
+	body := normalize(in.Body)
+	for _, recent := range in.Recent {
+		if within(in, recent, c.Window) && normalize(recent.Body) == body {
+			return 1.0, fmt.Sprintf("same body posted at %s", recent.CreatedAt.Format(time.RFC3339))
+		}
+	}
+	return 0, ""
+}
+
+var linkPattern = regexp.MustCompile(`(?i)(https?://|www\.)\S+`)
+
+// LinkDensity flags chirps with many links or that are mostly links.
+type LinkDensity struct {
+	MaxLinks int
+	MaxRatio float64
+}
+
+func (c LinkDensity) Name() string { return "link_density" }
+
+func (c LinkDensity) Score(in Input) (float64, string) {
+	if len(in.Body) == 0 {
+		return 0, ""
+	}
+	links := linkPattern.FindAllString(in.Body, -1)
+	linkLen := 0
+	for _, link := range links {
+		linkLen += len(link)
+	}
+	ratio := float64(linkLen) / float64(len(in.Body))
+	score := 0.0
+	if len(links) > c.MaxLinks {
+		score += 0.5
+	}
+	if len(links) > 0 && ratio > c.MaxRatio {
+		score += 0.5
+	}
+	if score == 0 {
+		return 0, ""
+	}
+	return score, fmt.Sprintf("%d links, %.0f%% of body", len(links), ratio*100)
+}
+
+// NewAccount throttles accounts younger than MinAge to MaxChirps per
+// Window.
+type NewAccount struct {
+	MinAge    time.Duration
+	Window    time.Duration
+	MaxChirps int
+}
+
+func (c NewAccount) Name() string { return "new_account" }
+
+func (c NewAccount) Score(in Input) (float64, string) {
+	if in.Now.Sub(in.AccountCreatedAt) >= c.MinAge {
+		return 0, ""
+	}
+	count := 0
+	for _, recent := range in.Recent {
+		if within(in, recent, c.Window) {
+			count++
+		}
+	}
+	if count < c.MaxChirps {
+		return 0, ""
+	}
+	return 1.0, fmt.Sprintf("%d chirps in %s from an account younger than %s", count, c.Window, c.MinAge)
+}
+
+// NearDuplicate flags chirps whose simhash is within MaxDistance bits of
+// one the author posted within Window.
+type NearDuplicate struct {
+	Window      time.Duration
+	MaxDistance int
+}
+
+func (c NearDuplicate) Name() string { return "near_duplicate" }
+
+func (c NearDuplicate) Score(in Input) (float64, string) {
+	hash := SimHash(in.Body)
+	for _, recent := range in.Recent {
+		if !within(in, recent, c.Window) {
+			continue
+		}
+		if d := Distance(hash, SimHash(recent.Body)); d <= c.MaxDistance {
+			return 0.6, fmt.Sprintf("simhash distance %d to chirp posted at %s", d, recent.CreatedAt.Format(time.RFC3339))
+		}
+	}
+	return 0, ""
+}
@@ -0,0 +1,49 @@
+package spam
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"unicode"
+)
+
+// SimHash returns a 64-bit locality sensitive hash of text built from its
+// words and word pairs. Similar texts have hashes a small Hamming
+// distance apart.
+func SimHash(text string) uint64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var features []string
+	for i, word := range words {
+		features = append(features, word)
+		if i > 0 {
+			features = append(features, words[i-1]+" "+word)
+		}
+	}
+	var weights [64]int
+	for _, feature := range features {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+	var hash uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			hash |= 1 << bit
+		}
+	}
+	return hash
+}
+
+// Distance is the Hamming distance between two simhashes.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,95 @@
+// Package spam scores new chirps for spam and flooding before they are
+// published.
+package spam
+
+import (
+	"strings"
+	"time"
+)
+
+// Chirp is a previously posted chirp by the same author.
+type Chirp struct {
+	Body      string
+	CreatedAt time.Time
+}
+
+// Input is everything a Check may look at when scoring a new chirp.
+type Input struct {
+	Body             string
+	Now              time.Time
+	AccountCreatedAt time.Time
+	// Recent holds the author's recent chirps, newest first.
+	Recent []Chirp
+}
+
+// Reason explains why a Check added to the score.
+type Reason struct {
+	Check  string  `json:"check"`
+	Score  float64 `json:"score"`
+	Detail string  `json:"detail"`
+}
+
+// Check is a single spam heuristic. Score returns 0 when the check has
+// nothing to say about the chirp.
+type Check interface {
+	Name() string
+	Score(in Input) (score float64, detail string)
+}
+
+// Verdict is the outcome of running a Pipeline.
+type Verdict struct {
+	Score   float64
+	Reasons []Reason
+	Held    bool
+}
+
+// Pipeline runs every Check and holds chirps whose total score reaches
+// Threshold.
+type Pipeline struct {
+	Checks    []Check
+	Threshold float64
+}
+
+// RecentWindow is how far back callers should look when filling
+// Input.Recent for the default pipeline.
+const RecentWindow = 24 * time.Hour
+
+// DefaultPipeline returns the checks we run in production.
+func DefaultPipeline() *Pipeline {
+	return &Pipeline{
+		Threshold: 1.0,
+		Checks: []Check{
+			DuplicateBody{Window: time.Hour},
+			LinkDensity{MaxLinks: 2, MaxRatio: 0.5},
+			NewAccount{MinAge: 24 * time.Hour, Window: 10 * time.Minute, MaxChirps: 5},
+			NearDuplicate{Window: RecentWindow, MaxDistance: 3},
+		},
+	}
+}
+
+// Evaluate scores in with every check of the pipeline.
+func (p *Pipeline) Evaluate(in Input) Verdict {
+	verdict := Verdict{}
+	for _, check := range p.Checks {
+		score, detail := check.Score(in)
+		if score <= 0 {
+			continue
+		}
+		verdict.Score += score
+		verdict.Reasons = append(verdict.Reasons, Reason{
+			Check:  check.Name(),
+			Score:  score,
+			Detail: detail,
+		})
+	}
+	verdict.Held = verdict.Score >= p.Threshold
+	return verdict
+}
+
+func normalize(body string) string {
+	return strings.Join(strings.Fields(strings.ToLower(body)), " ")
+}
+
+func within(in Input, c Chirp, window time.Duration) bool {
+	return in.Now.Sub(c.CreatedAt) <= window
+}
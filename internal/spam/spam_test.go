@@ -0,0 +1,82 @@
+package spam
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvaluate(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-30 * 24 * time.Hour)
+	p := DefaultPipeline()
+	testCases := []struct {
+		name   string
+		in     Input
+		held   bool
+		reason string
+	}{
+		{
+			name: "clean",
+			in:   Input{Body: "Lovely morning for a walk", Now: now, AccountCreatedAt: old},
+		},
+		{
+			name: "duplicate",
+			in: Input{Body: "Buy my course", Now: now, AccountCreatedAt: old,
+				Recent: []Chirp{{Body: "buy  my COURSE", CreatedAt: now.Add(-time.Minute)}}},
+			held:   true,
+			reason: "duplicate_body",
+		},
+		{
+			name:   "links",
+			in:     Input{Body: "http://a.example http://b.example http://c.example", Now: now, AccountCreatedAt: old},
+			held:   true,
+			reason: "link_density",
+		},
+		{
+			name: "new account flood",
+			in: Input{Body: "hello again", Now: now, AccountCreatedAt: now.Add(-time.Hour),
+				Recent: []Chirp{
+					{Body: "one", CreatedAt: now.Add(-time.Minute)},
+					{Body: "two", CreatedAt: now.Add(-2 * time.Minute)},
+					{Body: "three", CreatedAt: now.Add(-3 * time.Minute)},
+					{Body: "four", CreatedAt: now.Add(-4 * time.Minute)},
+					{Body: "five", CreatedAt: now.Add(-5 * time.Minute)},
+				}},
+			held:   true,
+			reason: "new_account",
+		},
+		{
+			name: "old duplicate",
+			in: Input{Body: "good morning everyone", Now: now, AccountCreatedAt: old,
+				Recent: []Chirp{{Body: "good morning everyone", CreatedAt: now.Add(-2 * RecentWindow)}}},
+		},
+	}
+	for _, testCase := range testCases {
+		verdict := p.Evaluate(testCase.in)
+		if verdict.Held != testCase.held {
+			t.Errorf("%s: expected held %v, got %v (%+v)", testCase.name, testCase.held, verdict.Held, verdict)
+		}
+		if testCase.reason == "" {
+			continue
+		}
+		found := false
+		for _, reason := range verdict.Reasons {
+			found = found || reason.Check == testCase.reason
+		}
+		if !found {
+			t.Errorf("%s: expected reason %v, got %+v", testCase.name, testCase.reason, verdict.Reasons)
+		}
+	}
+}
+
+func TestSimHash(t *testing.T) {
+	a := SimHash("check out my new mixtape it is fire, link in bio")
+	b := SimHash("check out my new mixtape it is fire! link in bio")
+	c := SimHash("the weather in lisbon is wonderful this time of year")
+	if d := Distance(a, b); d > 3 {
+		t.Errorf("Expected near duplicates, got distance %v", d)
+	}
+	if d := Distance(a, c); d <= 3 {
+		t.Errorf("Expected distinct texts, got distance %v", d)
+	}
+}
@@ -0,0 +1,134 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// decodeCBOR decodes the first CBOR item in data and returns it with the
+// number of bytes it used. It understands the subset WebAuthn uses:
+// integers, byte and text strings, arrays, maps and the simple values
+// false, true and null. Integers decode to int64, maps to
+// map[interface{}]interface{}.
+func decodeCBOR(data []byte) (interface{}, int, error) {
+	d := cborDecoder{data: data}
+	v, err := d.item(0)
+	return v, d.pos, err
+}
+
+// maxCBORDepth stops hostile input from nesting without bound.
+const maxCBORDepth = 16
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) header() (major byte, arg uint64, err error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, fmt.Errorf("cbor: unexpected end of data")
+	}
+	b := d.data[d.pos]
+	d.pos++
+	major, info := b>>5, b&0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info <= 27:
+		n := 1 << (info - 24)
+		if d.pos+n > len(d.data) {
+			return 0, 0, fmt.Errorf("cbor: unexpected end of data")
+		}
+		buf := d.data[d.pos : d.pos+n]
+		d.pos += n
+		switch n {
+		case 1:
+			return major, uint64(buf[0]), nil
+		case 2:
+			return major, uint64(binary.BigEndian.Uint16(buf)), nil
+		case 4:
+			return major, uint64(binary.BigEndian.Uint32(buf)), nil
+		default:
+			return major, binary.BigEndian.Uint64(buf), nil
+		}
+	default:
+		return 0, 0, fmt.Errorf("cbor: unsupported additional info %d", info)
+	}
+}
+
+func (d *cborDecoder) item(depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, fmt.Errorf("cbor: nested too deeply")
+	}
+	major, arg, err := d.header()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, fmt.Errorf("cbor: integer overflow")
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, fmt.Errorf("cbor: integer overflow")
+		}
+		return -1 - int64(arg), nil
+	case 2, 3:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, fmt.Errorf("cbor: string longer than data")
+		}
+		buf := d.data[d.pos : d.pos+int(arg)]
+		d.pos += int(arg)
+		if major == 3 {
+			return string(buf), nil
+		}
+		return append([]byte(nil), buf...), nil
+	case 4:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, fmt.Errorf("cbor: array longer than data")
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			v, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	case 5:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, fmt.Errorf("cbor: map longer than data")
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, fmt.Errorf("cbor: unsupported map key %T", k)
+			}
+			v, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case 7:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22:
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("cbor: unsupported major type %d", major)
+}
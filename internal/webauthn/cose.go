@@ -0,0 +1,109 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers we accept, in order of preference.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// COSE key parameters (RFC 9053).
+const (
+	coseKty = 1
+	coseAlg = 3
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+	coseN   = -1
+	coseE   = -2
+
+	coseKtyOKP = 1
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+
+	coseCrvP256    = 1
+	coseCrvEd25519 = 6
+)
+
+// parseCOSEKey decodes a COSE_Key into a public key and its algorithm.
+func parseCOSEKey(data []byte) (crypto.PublicKey, int64, error) {
+	v, _, err := decodeCBOR(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: public key is not a map", ErrMalformed)
+	}
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+	switch {
+	case kty == coseKtyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != coseCrvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, fmt.Errorf("%w: bad EC2 key", ErrMalformed)
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, 0, fmt.Errorf("%w: point is not on P-256", ErrMalformed)
+		}
+		return pub, alg, nil
+	case kty == coseKtyOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if crv != coseCrvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, fmt.Errorf("%w: bad OKP key", ErrMalformed)
+		}
+		return ed25519.PublicKey(x), alg, nil
+	case kty == coseKtyRSA && alg == AlgRS256:
+		n, _ := m[int64(coseN)].([]byte)
+		e, _ := m[int64(coseE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, fmt.Errorf("%w: bad RSA key", ErrMalformed)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, alg, nil
+	}
+	return nil, 0, fmt.Errorf("%w: kty %d alg %d", ErrUnsupportedAlgorithm, kty, alg)
+}
+
+// verifySignature checks sig over data with a COSE public key.
+func verifySignature(coseKey, data, sig []byte) error {
+	pub, _, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(data)
+	ok := false
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(key, digest[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	}
+	if !ok {
+		return ErrBadSignature
+	}
+	return nil
+}
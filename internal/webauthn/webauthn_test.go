@@ -0,0 +1,124 @@
+package webauthn
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/webauthn/webauthntest"
+)
+
+var testRP = &RelyingParty{
+	ID:      "localhost",
+	Name:    "Chirpy",
+	Origins: []string{"http://localhost:8080"},
+	Timeout: time.Minute,
+}
+
+func register(t *testing.T, authenticator *webauthntest.Authenticator) Credential {
+	t.Helper()
+	challenge, _ := NewChallenge()
+	raw, err := authenticator.Register(challenge, []byte("user-handle"))
+	if err != nil {
+		t.Fatalf("Error registering: %v", err)
+	}
+	var resp RegistrationResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		t.Fatalf("Error decoding registration: %v", err)
+	}
+	cred, err := testRP.FinishRegistration(challenge, resp)
+	if err != nil {
+		t.Fatalf("Error finishing registration: %v", err)
+	}
+	return cred
+}
+
+func login(t *testing.T, authenticator *webauthntest.Authenticator, cred Credential, challenge, expected []byte) (Assertion, error) {
+	t.Helper()
+	raw, err := authenticator.Login(challenge)
+	if err != nil {
+		t.Fatalf("Error logging in: %v", err)
+	}
+	var resp AssertionResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		t.Fatalf("Error decoding assertion: %v", err)
+	}
+	return testRP.FinishLogin(expected, cred, resp)
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	authenticator := webauthntest.New("localhost", "http://localhost:8080")
+	cred := register(t, authenticator)
+	if cred.Algorithm != AlgES256 || !cred.UserVerified {
+		t.Errorf("Unexpected credential %+v", cred)
+	}
+	challenge, _ := NewChallenge()
+	assertion, err := login(t, authenticator, cred, challenge, challenge)
+	if err != nil {
+		t.Fatalf("Error finishing login: %v", err)
+	}
+	if assertion.SignCount != 1 || !assertion.UserVerified {
+		t.Errorf("Unexpected assertion %+v", assertion)
+	}
+}
+
+func TestFinishLogin_Rejects(t *testing.T) {
+	testCases := []struct {
+		name  string
+		setup func(a *webauthntest.Authenticator, cred *Credential, expected *[]byte)
+		err   error
+	}{
+		{"wrong challenge", func(a *webauthntest.Authenticator, cred *Credential, expected *[]byte) {
+			*expected, _ = NewChallenge()
+		}, ErrChallenge},
+		{"wrong origin", func(a *webauthntest.Authenticator, cred *Credential, expected *[]byte) {
+			a.Origin = "https://evil.example"
+		}, ErrOrigin},
+		{"wrong rp id", func(a *webauthntest.Authenticator, cred *Credential, expected *[]byte) {
+			a.RPID = "evil.example"
+		}, ErrRPID},
+		{"cloned authenticator", func(a *webauthntest.Authenticator, cred *Credential, expected *[]byte) {
+			cred.SignCount = 5
+		}, ErrSignCount},
+		{"other credential's key", func(a *webauthntest.Authenticator, cred *Credential, expected *[]byte) {
+			other := register(t, webauthntest.New("localhost", "http://localhost:8080"))
+			cred.PublicKey = other.PublicKey
+		}, ErrBadSignature},
+	}
+	for _, testCase := range testCases {
+		authenticator := webauthntest.New("localhost", "http://localhost:8080")
+		cred := register(t, authenticator)
+		challenge, _ := NewChallenge()
+		expected := challenge
+		testCase.setup(authenticator, &cred, &expected)
+		_, err := login(t, authenticator, cred, challenge, expected)
+		if !errors.Is(err, testCase.err) {
+			t.Errorf("%s: expected %v, got %v", testCase.name, testCase.err, err)
+		}
+	}
+}
+
+func TestFinishLogin_NonCountingAuthenticator(t *testing.T) {
+	authenticator := webauthntest.New("localhost", "http://localhost:8080")
+	authenticator.FixedSignCount = true
+	cred := register(t, authenticator)
+	for i := 0; i < 2; i++ {
+		challenge, _ := NewChallenge()
+		if _, err := login(t, authenticator, cred, challenge, challenge); err != nil {
+			t.Errorf("Expected authenticators that always report zero to work: %v", err)
+		}
+	}
+}
+
+func TestDecodeCBOR_Truncated(t *testing.T) {
+	// a map claiming two entries, with one
+	data := []byte{0xa2, 0x01, 0x02}
+	if _, _, err := decodeCBOR(data); err == nil {
+		t.Errorf("Expected truncated map to fail")
+	}
+	// a byte string claiming more bytes than there are
+	if _, _, err := decodeCBOR([]byte{0x58, 0xff, 0x00}); err == nil {
+		t.Errorf("Expected truncated byte string to fail")
+	}
+}
@@ -0,0 +1,179 @@
+// Package webauthntest provides a software authenticator that answers
+// WebAuthn ceremonies the way a browser and a passkey would, for tests.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+// Authenticator holds one ES256 passkey. Copying it after Register
+// simulates a cloned authenticator.
+type Authenticator struct {
+	RPID   string
+	Origin string
+	// UserVerified sets the UV flag, as if the user unlocked the passkey
+	// with a PIN or biometric.
+	UserVerified bool
+	// SignCount is incremented before every assertion unless
+	// FixedSignCount is set, like authenticators that do not count.
+	SignCount      uint32
+	FixedSignCount bool
+
+	CredentialID []byte
+	UserHandle   []byte
+	key          *ecdsa.PrivateKey
+}
+
+// New returns an authenticator for rpID that reports origin as the page
+// that called it.
+func New(rpID, origin string) *Authenticator {
+	return &Authenticator{RPID: rpID, Origin: origin, UserVerified: true}
+}
+
+// Register answers navigator.credentials.create and returns the JSON
+// PublicKeyCredential.
+func (a *Authenticator) Register(challenge, userHandle []byte) ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	credID := make([]byte, 16)
+	if _, err := rand.Read(credID); err != nil {
+		return nil, err
+	}
+	a.key, a.CredentialID, a.UserHandle = key, credID, userHandle
+
+	attested := make([]byte, 16, 18+len(credID)) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(credID)))
+	attested = append(attested, credID...)
+	attested = append(attested, a.coseKey()...)
+	authData := a.authData(0x40, attested)
+
+	var attestation []byte
+	attestation = appendHead(attestation, 5, 3)
+	attestation = appendText(attestation, "fmt")
+	attestation = appendText(attestation, "none")
+	attestation = appendText(attestation, "attStmt")
+	attestation = appendHead(attestation, 5, 0)
+	attestation = appendText(attestation, "authData")
+	attestation = appendBytes(attestation, authData)
+
+	return json.Marshal(map[string]interface{}{
+		"id":    b64(credID),
+		"rawId": b64(credID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(a.clientData("webauthn.create", challenge)),
+			"attestationObject": b64(attestation),
+		},
+	})
+}
+
+// Login answers navigator.credentials.get and returns the JSON
+// PublicKeyCredential.
+func (a *Authenticator) Login(challenge []byte) ([]byte, error) {
+	if a.key == nil {
+		return nil, fmt.Errorf("webauthntest: authenticator has no credential")
+	}
+	if !a.FixedSignCount {
+		a.SignCount++
+	}
+	authData := a.authData(0, nil)
+	clientData := a.clientData("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"id":    b64(a.CredentialID),
+		"rawId": b64(a.CredentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData),
+			"authenticatorData": b64(authData),
+			"signature":         b64(sig),
+			"userHandle":        b64(a.UserHandle),
+		},
+	})
+}
+
+func (a *Authenticator) clientData(ceremony string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":        ceremony,
+		"challenge":   b64(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	return data
+}
+
+func (a *Authenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+	flags |= 0x01
+	if a.UserVerified {
+		flags |= 0x04
+	}
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.SignCount)
+	return append(data, attested...)
+}
+
+// coseKey encodes the public key as an EC2 COSE_Key for ES256.
+func (a *Authenticator) coseKey() []byte {
+	x := a.key.PublicKey.X.FillBytes(make([]byte, 32))
+	y := a.key.PublicKey.Y.FillBytes(make([]byte, 32))
+	var key []byte
+	key = appendHead(key, 5, 5)
+	key = appendInt(key, 1) // kty
+	key = appendInt(key, 2) // EC2
+	key = appendInt(key, 3) // alg
+	key = appendInt(key, -7)
+	key = appendInt(key, -1) // crv
+	key = appendInt(key, 1)  // P-256
+	key = appendInt(key, -2)
+	key = appendBytes(key, x)
+	key = appendInt(key, -3)
+	key = appendBytes(key, y)
+	return key
+}
+
+func appendHead(buf []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(buf, major<<5|byte(n))
+	case n <= 0xff:
+		return append(buf, major<<5|24, byte(n))
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(buf, major<<5|25), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(buf, major<<5|26), uint32(n))
+	}
+}
+
+func appendInt(buf []byte, n int64) []byte {
+	if n < 0 {
+		return appendHead(buf, 1, uint64(-1-n))
+	}
+	return appendHead(buf, 0, uint64(n))
+}
+
+func appendBytes(buf, b []byte) []byte {
+	return append(appendHead(buf, 2, uint64(len(b))), b...)
+}
+
+func appendText(buf []byte, s string) []byte {
+	return append(appendHead(buf, 3, uint64(len(s))), s...)
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
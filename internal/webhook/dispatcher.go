@@ -0,0 +1,248 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/google/uuid"
+)
+
+// Headers sent with every delivery. Receivers should drop deliveries
+// whose EventIDHeader they have already seen, since an event can be
+// delivered more than once.
+const (
+	SignatureHeader  = "Chirpy-Signature"
+	EventIDHeader    = "Chirpy-Event-Id"
+	EventTypeHeader  = "Chirpy-Event-Type"
+	DeliveryIDHeader = "Chirpy-Delivery-Id"
+)
+
+// EndpointPaused is the status of an endpoint that failed too often.
+const EndpointPaused = "paused"
+
+// maxErrorLength bounds the error text kept for a failed attempt.
+const maxErrorLength = 500
+
+// Store is the subset of db.Queries the dispatcher needs.
+type Store interface {
+	ClaimPendingWebhookDeliveries(ctx context.Context, arg db.ClaimPendingWebhookDeliveriesParams) ([]db.WebhookDelivery, error)
+	GetWebhookEndpoint(ctx context.Context, id uuid.UUID) (db.WebhookEndpoint, error)
+	MarkWebhookDelivered(ctx context.Context, arg db.MarkWebhookDeliveredParams) error
+	MarkWebhookDeliveryFailed(ctx context.Context, arg db.MarkWebhookDeliveryFailedParams) error
+	RecordWebhookEndpointFailure(ctx context.Context, arg db.RecordWebhookEndpointFailureParams) (string, error)
+	ResetWebhookEndpointFailures(ctx context.Context, id uuid.UUID) error
+}
+
+// Dispatcher posts queued deliveries to their endpoints, retrying
+// failures with exponential backoff until MaxAttempts. An endpoint that
+// fails PauseAfter times in a row is paused: its deliveries, including
+// those for events after the pause, wait until the owner resumes it.
+type Dispatcher struct {
+	Store       Store
+	Client      *http.Client
+	BatchSize   int32
+	MaxAttempts int32
+	PauseAfter  int32
+	// Lease is how long a claimed batch is hidden from other
+	// dispatchers. Sends are cut off when it runs out, so another
+	// dispatcher never posts a delivery that is still being sent.
+	Lease time.Duration
+}
+
+// Flush sends one batch of due deliveries and returns how many succeeded.
+func (d *Dispatcher) Flush(ctx context.Context) (int, error) {
+	now := time.Now()
+	leaseUntil := now.Add(d.Lease)
+	pending, err := d.Store.ClaimPendingWebhookDeliveries(ctx, db.ClaimPendingWebhookDeliveriesParams{
+		LeaseUntil: leaseUntil,
+		BatchSize:  d.BatchSize,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("claiming webhook deliveries: %w", err)
+	}
+	delivered := 0
+	for _, delivery := range pending {
+		// what is left once the lease runs out is claimed again later
+		if !time.Now().Before(leaseUntil) {
+			break
+		}
+		endpoint, err := d.Store.GetWebhookEndpoint(ctx, delivery.EndpointID)
+		if err != nil {
+			return delivered, fmt.Errorf("getting webhook endpoint %s: %w", delivery.EndpointID, err)
+		}
+		status, err := d.send(ctx, leaseUntil, endpoint, delivery)
+		if err != nil {
+			slog.Info("Webhook delivery failed", "error", err, "deliveryID", delivery.ID, "endpointID", endpoint.ID, "attempt", delivery.Attempts)
+			if err := d.fail(ctx, endpoint, delivery, status, err, now); err != nil {
+				return delivered, err
+			}
+			continue
+		}
+		err = d.Store.MarkWebhookDelivered(ctx, db.MarkWebhookDeliveredParams{ID: delivery.ID, ResponseStatus: int32(status)})
+		if err != nil {
+			return delivered, fmt.Errorf("marking webhook delivery %s delivered: %w", delivery.ID, err)
+		}
+		if endpoint.ConsecutiveFailures > 0 {
+			if err := d.Store.ResetWebhookEndpointFailures(ctx, endpoint.ID); err != nil {
+				return delivered, fmt.Errorf("resetting webhook endpoint %s: %w", endpoint.ID, err)
+			}
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+// send posts one delivery and returns the response status, or 0 when
+// there was no response. Any status other than 2xx is an error. It gives
+// up at leaseUntil, or after Client's timeout if that comes first.
+func (d *Dispatcher) send(ctx context.Context, leaseUntil time.Time, endpoint db.WebhookEndpoint, delivery db.WebhookDelivery) (int, error) {
+	deadline := leaseUntil
+	if timeout := d.Client.Timeout; timeout > 0 && time.Now().Add(timeout).Before(deadline) {
+		deadline = time.Now().Add(timeout)
+	}
+	sendCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	req, err := http.NewRequestWithContext(sendCtx, "POST", endpoint.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set(SignatureHeader, Sign(delivery.Payload, time.Now(), endpoint.Secret))
+	req.Header.Set(EventIDHeader, delivery.EventID.String())
+	req.Header.Set(EventTypeHeader, delivery.EventType)
+	req.Header.Set(DeliveryIDHeader, delivery.ID.String())
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// drain a little so the connection can be reused, but never wait on a
+	// huge body
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) fail(ctx context.Context, endpoint db.WebhookEndpoint, delivery db.WebhookDelivery, status int, sendErr error, now time.Time) error {
+	lastError := sendErr.Error()
+	if len(lastError) > maxErrorLength {
+		lastError = lastError[:maxErrorLength]
+	}
+	err := d.Store.MarkWebhookDeliveryFailed(ctx, db.MarkWebhookDeliveryFailedParams{
+		MaxAttempts:    d.MaxAttempts,
+		ResponseStatus: int32(status),
+		LastError:      lastError,
+		NextAttemptAt:  now.Add(backoff(delivery.Attempts)),
+		ID:             delivery.ID,
+	})
+	if err != nil {
+		return fmt.Errorf("marking webhook delivery %s failed: %w", delivery.ID, err)
+	}
+	endpointStatus, err := d.Store.RecordWebhookEndpointFailure(ctx, db.RecordWebhookEndpointFailureParams{
+		PauseAfter: d.PauseAfter,
+		ID:         endpoint.ID,
+	})
+	if err != nil {
+		return fmt.Errorf("recording webhook endpoint %s failure: %w", endpoint.ID, err)
+	}
+	if endpointStatus == EndpointPaused && endpoint.Status != EndpointPaused {
+		slog.Warn("Paused failing webhook endpoint", "endpointID", endpoint.ID, "userID", endpoint.UserID)
+	}
+	return nil
+}
+
+// Run flushes due deliveries every tick until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := d.Flush(ctx); err != nil {
+				slog.Error("Error flushing webhook deliveries", "error", err)
+			}
+		}
+	}
+}
+
+// backoff is the wait before retrying after the given attempt: 30s, 1m,
+// 2m, 4m... up to 12 hours.
+func backoff(attempt int32) time.Duration {
+	wait := 30 * time.Second
+	for i := int32(1); i < attempt && wait < 12*time.Hour; i++ {
+		wait *= 2
+	}
+	if wait > 12*time.Hour {
+		return 12 * time.Hour
+	}
+	return wait
+}
+
+var errPrivateAddress = errors.New("webhook address is not public")
+
+// PublicClient returns an HTTP client that refuses to connect to loopback,
+// private and link-local addresses, so a webhook URL cannot be used to
+// reach Chirpy's own network. The check runs on the address actually
+// dialed, after DNS. Redirects are not followed.
+func PublicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !publicIP(ip) {
+				return fmt.Errorf("%w: %s", errPrivateAddress, host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() || ip.IsInterfaceLocalMulticast())
+}
+
+// ValidURL reports why raw cannot be a webhook URL, or nil. Only https
+// URLs are accepted unless allowHTTP is set, for development.
+func ValidURL(raw string, allowHTTP bool) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return errors.New("invalid webhook URL")
+	}
+	if u.User != nil {
+		return errors.New("webhook URL must not contain credentials")
+	}
+	if u.Scheme != "https" && !(allowHTTP && u.Scheme == "http") {
+		return errors.New("webhook URL must use https")
+	}
+	return nil
+}
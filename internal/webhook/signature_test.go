@@ -0,0 +1,56 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":"evt_1","event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+	now := time.Unix(1700000000, 0)
+	secrets := []string{"new-secret", "old-secret"}
+
+	testCases := []struct {
+		name     string
+		header   string
+		body     []byte
+		expected error
+	}{
+		{"current secret", Sign(body, now, "new-secret"), body, nil},
+		{"secret being rotated out", Sign(body, now, "old-secret"), body, nil},
+		{"sender signing with two secrets", Sign(body, now, "unknown", "new-secret"), body, nil},
+		{"clock skew within tolerance", Sign(body, now.Add(-4*time.Minute), "new-secret"), body, nil},
+		{"missing", "", body, ErrNoSignature},
+		{"unknown secret", Sign(body, now, "unknown"), body, ErrInvalidSignature},
+		{"tampered body", Sign(body, now, "new-secret"), []byte(`{"id":"evt_2"}`), ErrInvalidSignature},
+		{"replayed", Sign(body, now.Add(-10*time.Minute), "new-secret"), body, ErrTimestampRange},
+		{"from the future", Sign(body, now.Add(10*time.Minute), "new-secret"), body, ErrTimestampRange},
+		{"no timestamp", "v1=00", body, ErrInvalidSignature},
+		{"no signature", "t=1700000000", body, ErrInvalidSignature},
+		{"not hex", "t=1700000000,v1=zz", body, ErrInvalidSignature},
+	}
+	for _, testCase := range testCases {
+		err := Verify(testCase.header, testCase.body, secrets, now, DefaultTolerance)
+		if err != testCase.expected {
+			t.Errorf("%s: expected %v, got %v", testCase.name, testCase.expected, err)
+		}
+	}
+}
+
+func TestVerifyIgnoresEmptySecrets(t *testing.T) {
+	body := []byte(`{}`)
+	now := time.Now()
+	if err := Verify(Sign(body, now, ""), body, []string{""}, now, DefaultTolerance); err != ErrInvalidSignature {
+		t.Errorf("Expected an empty secret never to match, got %v", err)
+	}
+}
+
+func TestParseSecrets(t *testing.T) {
+	secrets := ParseSecrets(" a, ,b,")
+	if len(secrets) != 2 || secrets[0] != "a" || secrets[1] != "b" {
+		t.Errorf("Expected [a b], got %q", secrets)
+	}
+	if secrets := ParseSecrets(""); len(secrets) != 0 {
+		t.Errorf("Expected no secrets, got %q", secrets)
+	}
+}
@@ -1,14 +1,30 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/eliza-guseva/chirpy-server/handlers"
+	"github.com/eliza-guseva/chirpy-server/internal/auth"
+	"github.com/eliza-guseva/chirpy-server/internal/billing"
 	"github.com/eliza-guseva/chirpy-server/internal/db"
+	"github.com/eliza-guseva/chirpy-server/internal/keystore"
+	"github.com/eliza-guseva/chirpy-server/internal/langdetect"
+	"github.com/eliza-guseva/chirpy-server/internal/lockout"
+	"github.com/eliza-guseva/chirpy-server/internal/mail"
+	"github.com/eliza-guseva/chirpy-server/internal/oidc"
+	"github.com/eliza-guseva/chirpy-server/internal/ratelimit"
+	"github.com/eliza-guseva/chirpy-server/internal/secretbox"
+	"github.com/eliza-guseva/chirpy-server/internal/spam"
+	"github.com/eliza-guseva/chirpy-server/internal/webauthn"
+	"github.com/eliza-guseva/chirpy-server/internal/webhook"
+	"golang.org/x/crypto/bcrypt"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
@@ -24,33 +40,182 @@ func main() {
 	dbQueries := db.New(dbPool)
 	defer dbPool.Close()
 
+	refreshTokenPepper := os.Getenv("REFRESH_TOKEN_PEPPER")
+	if refreshTokenPepper == "" {
+		log.Fatal("REFRESH_TOKEN_PEPPER must be set")
+	}
+
+	if len(os.Args) > 1 {
+		if err := runCommand(dbQueries, refreshTokenPepper, os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	mux := http.NewServeMux()
 	addr := "localhost:8080"
+	langDetector, err := langdetect.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	secrets, err := newSecretBox()
+	if err != nil {
+		log.Fatal(err)
+	}
+	rotator, err := newRotator(dbPool, dbQueries, secrets)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := rotator.RotateIfDue(context.Background(), time.Now().UTC()); err != nil {
+		log.Fatal(err)
+	}
+	go rotator.Run(context.Background(), time.Minute)
+
+	validator, err := newValidator(rotator.Keys, dbQueries)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	passwords, err := newPasswordHasher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	auth.Passwords = passwords
+	passwordPolicy, err := newPasswordPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mailer, err := newMailer()
+	if err != nil {
+		log.Fatal(err)
+	}
+	outbox := &mail.Outbox{
+		Store:       dbQueries,
+		Mailer:      mailer,
+		From:        envOr("MAIL_FROM", "Chirpy <no-reply@localhost>"),
+		BatchSize:   20,
+		MaxAttempts: 5,
+		Lease:       time.Minute,
+	}
+	go outbox.Run(context.Background(), 10*time.Second)
+
+	baseURL := strings.TrimSuffix(envOr("APP_BASE_URL", "http://localhost:8080"), "/")
+	guard, err := newLockoutGuard(dbQueries)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go guard.Run(context.Background(), 10*time.Minute)
+	limiter, err := newRateLimiter(dbQueries)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go limiter.Run(context.Background(), 10*time.Minute)
+	go billing.Expirer{Queries: dbQueries}.Run(context.Background(), 5*time.Minute)
+	dispatcher := &webhook.Dispatcher{
+		Store:       dbQueries,
+		Client:      newWebhookClient(),
+		BatchSize:   20,
+		MaxAttempts: 10,
+		PauseAfter:  20,
+		Lease:       time.Minute,
+	}
+	go dispatcher.Run(context.Background(), 10*time.Second)
+
 	cfg := &handlers.APIConfig{
 		DBQueries: dbQueries,
-		JWTSecret: os.Getenv("JWT_SECRET"),
-		PolkaKey: os.Getenv("POLKA_KEY"),
+		DB: dbPool,
+		Keys: rotator.Keys,
+		Validator: validator,
+		RefreshTokenPepper: refreshTokenPepper,
+		Secrets: secrets,
+		PolkaSecrets: webhook.ParseSecrets(os.Getenv("POLKA_WEBHOOK_SECRETS")),
+		LangDetector: langDetector,
+		SpamPipeline: spam.DefaultPipeline(),
+		WebAuthn: newRelyingParty(),
+		BaseURL: baseURL,
+		PasswordPolicy: passwordPolicy,
+		Lockout: guard,
+		OIDC: newOIDCProviders(baseURL),
+		RateLimiter: limiter,
 	}
 
 	fileServer := cfg.MiddlewareMetricsInc(http.FileServer(http.Dir("./static")))
 
 	mux.Handle("/app/", http.StripPrefix("/app", fileServer))
 	mux.HandleFunc("GET /api/healthz", handlers.Health)
-	mux.HandleFunc("GET /admin/metrics", cfg.FSHits)
-	mux.HandleFunc("POST /admin/reset", cfg.ResetUsers)
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.JWKS)
+	mux.HandleFunc("GET /.well-known/oauth-authorization-server", cfg.OAuthMetadata)
+	mux.HandleFunc("GET /admin/metrics", cfg.RequireAuth(cfg.RequireRole(auth.RoleAdmin, cfg.FSHits)))
+	mux.HandleFunc("POST /admin/reset", cfg.RequireAuth(cfg.RequireRole(auth.RoleAdmin, cfg.ResetUsers)))
+	mux.HandleFunc("GET /admin/reports", cfg.RequireAuth(cfg.RequireRole(auth.RoleModerator, cfg.ListReports)))
+	mux.HandleFunc("POST /admin/reports/{id}/claim", cfg.RequireAuth(cfg.RequireRole(auth.RoleModerator, cfg.ClaimReport)))
+	mux.HandleFunc("POST /admin/reports/{id}/resolve", cfg.RequireAuth(cfg.RequireRole(auth.RoleModerator, cfg.ResolveReport)))
+	mux.HandleFunc("GET /admin/chirps/held", cfg.RequireAuth(cfg.RequireRole(auth.RoleModerator, cfg.ListHeldChirps)))
+	mux.HandleFunc("POST /admin/chirps/{id}/release", cfg.RequireAuth(cfg.RequireRole(auth.RoleModerator, cfg.ReleaseChirp)))
+	mux.HandleFunc("POST /admin/chirps/{id}/reject", cfg.RequireAuth(cfg.RequireRole(auth.RoleModerator, cfg.RejectChirp)))
+	mux.HandleFunc("GET /admin/moderation-log", cfg.RequireAuth(cfg.RequireRole(auth.RoleModerator, cfg.GetModerationLog)))
+	mux.HandleFunc("POST /admin/users/{id}/status", cfg.RequireAuth(cfg.RequireRole(auth.RoleModerator, cfg.SetUserStatus)))
+	mux.HandleFunc("PUT /admin/users/{id}/role", cfg.RequireAuth(cfg.RequireRole(auth.RoleAdmin, cfg.SetUserRole)))
+	mux.HandleFunc("POST /admin/users/{id}/unlock", cfg.RequireAuth(cfg.RequireRole(auth.RoleAdmin, cfg.UnlockUser)))
 
-	mux.HandleFunc("POST /api/users", cfg.CreateUser)
+	mux.HandleFunc("POST /api/users", cfg.RateLimit("signup", cfg.CreateUser))
 	mux.HandleFunc("PUT /api/users", cfg.RequireAuth(cfg.UpdateUser))
+	mux.HandleFunc("GET /api/users/me", cfg.RequireAuth(cfg.GetMe, auth.ScopeProfile))
+	mux.HandleFunc("POST /api/email/verify", cfg.RateLimit("login", cfg.VerifyEmail))
+	mux.HandleFunc("POST /api/email/verify/resend", cfg.RequireAuth(cfg.RateLimit("email", cfg.ResendVerification)))
+	mux.HandleFunc("POST /api/password/forgot", cfg.RateLimit("email", cfg.ForgotPassword))
+	mux.HandleFunc("POST /api/password/reset", cfg.RateLimit("login", cfg.ResetPassword))
+
+	mux.HandleFunc("POST /api/login", cfg.RateLimit("login", cfg.Login))
+	mux.HandleFunc("POST /api/login/mfa", cfg.RateLimit("login", cfg.LoginMFA))
+	mux.HandleFunc("POST /api/login/magic", cfg.RequestMagicLink)
+	mux.HandleFunc("POST /api/login/magic/verify", cfg.RateLimit("login", cfg.FinishMagicLink))
+	mux.HandleFunc("GET /api/login/oidc/{provider}", cfg.RateLimit("login", cfg.BeginOIDCLogin))
+	mux.HandleFunc("POST /api/login/oidc/callback", cfg.RateLimit("login", cfg.FinishOIDCLogin))
+	mux.HandleFunc("POST /api/mfa/totp", cfg.RequireAuth(cfg.EnrollTOTP))
+	mux.HandleFunc("POST /api/mfa/totp/confirm", cfg.RequireAuth(cfg.RateLimit("login", cfg.ConfirmTOTP)))
+	mux.HandleFunc("DELETE /api/mfa/totp", cfg.RequireAuth(cfg.DisableTOTP))
+	mux.HandleFunc("POST /api/mfa/verify", cfg.RequireAuth(cfg.RateLimit("login", cfg.VerifyMFA)))
+	mux.HandleFunc("POST /api/mfa/passkey", cfg.RequireAuth(cfg.BeginPasskeyMFA))
+	mux.HandleFunc("POST /api/login/mfa/passkey", cfg.RateLimit("login", cfg.BeginPasskeyLoginMFA))
+	mux.HandleFunc("POST /api/login/passkey/begin", cfg.RateLimit("login", cfg.BeginPasskeyLogin))
+	mux.HandleFunc("POST /api/login/passkey/finish", cfg.RateLimit("login", cfg.FinishPasskeyLogin))
+	mux.HandleFunc("GET /api/passkeys", cfg.RequireAuth(cfg.ListPasskeys))
+	mux.HandleFunc("POST /api/passkeys/register/begin", cfg.RequireAuth(cfg.BeginPasskeyRegistration))
+	mux.HandleFunc("POST /api/passkeys/register/finish", cfg.RequireAuth(cfg.FinishPasskeyRegistration))
+	mux.HandleFunc("DELETE /api/passkeys/{id}", cfg.RequireAuth(cfg.DeletePasskey))
+	mux.HandleFunc("POST /api/refresh", cfg.RateLimit("token", cfg.RefreshJWT))
+	mux.HandleFunc("POST /api/revoke", cfg.RateLimit("token", cfg.RevokeRT))
+	mux.HandleFunc("POST /api/logout", cfg.RequireAuth(cfg.Logout))
+	mux.HandleFunc("POST /api/tokens", cfg.RequireAuth(cfg.RateLimit("tokens_create", cfg.CreatePersonalToken)))
+	mux.HandleFunc("GET /api/tokens", cfg.RequireAuth(cfg.ListPersonalTokens))
+	mux.HandleFunc("DELETE /api/tokens/{id}", cfg.RequireAuth(cfg.DeletePersonalToken))
+	mux.HandleFunc("GET /api/sessions", cfg.RequireAuth(cfg.GetSessions))
+	mux.HandleFunc("DELETE /api/sessions", cfg.RequireAuth(cfg.DeleteOtherSessions))
+	mux.HandleFunc("DELETE /api/sessions/{id}", cfg.RequireAuth(cfg.DeleteSession))
+	mux.HandleFunc("GET /api/billing", cfg.RequireAuth(cfg.GetBilling))
+	mux.HandleFunc("POST /api/polka/webhooks", cfg.RateLimit("polka", cfg.PolkaWebhook))
+	mux.HandleFunc("GET /api/webhooks", cfg.RequireAuth(cfg.ListWebhookEndpoints, auth.ScopeWebhooks))
+	mux.HandleFunc("POST /api/webhooks", cfg.RequireAuth(cfg.CreateWebhookEndpoint, auth.ScopeWebhooks))
+	mux.HandleFunc("DELETE /api/webhooks/{id}", cfg.RequireAuth(cfg.DeleteWebhookEndpoint, auth.ScopeWebhooks))
+	mux.HandleFunc("POST /api/webhooks/{id}/resume", cfg.RequireAuth(cfg.ResumeWebhookEndpoint, auth.ScopeWebhooks))
+	mux.HandleFunc("GET /api/webhooks/{id}/deliveries", cfg.RequireAuth(cfg.ListWebhookDeliveries, auth.ScopeWebhooks))
 
-	mux.HandleFunc("POST /api/login", cfg.Login)
-	mux.HandleFunc("POST /api/refresh", cfg.RefreshJWT)
-	mux.HandleFunc("POST /api/revoke", cfg.RevokeRT)
-	mux.HandleFunc("POST /api/polka/webhooks", cfg.UpradeUserPolka)
+	mux.HandleFunc("GET /oauth/authorize", cfg.Authorize)
+	mux.HandleFunc("POST /oauth/authorize", cfg.RateLimit("login", cfg.AuthorizeDecision))
+	mux.HandleFunc("POST /oauth/token", cfg.RateLimit("token", cfg.OAuthToken))
+	mux.HandleFunc("POST /oauth/introspect", cfg.RateLimit("introspect", cfg.IntrospectOAuthToken))
+	mux.HandleFunc("POST /oauth/revoke", cfg.RateLimit("token", cfg.RevokeOAuthToken))
+	mux.HandleFunc("GET /api/oauth/clients", cfg.RequireAuth(cfg.ListOAuthClients))
+	mux.HandleFunc("POST /api/oauth/clients", cfg.RequireAuth(cfg.CreateOAuthClient))
+	mux.HandleFunc("DELETE /api/oauth/clients/{id}", cfg.RequireAuth(cfg.DeleteOAuthClient))
 
-	mux.HandleFunc("GET /api/chirps", cfg.GetChirps)
-	mux.HandleFunc("POST /api/chirps", cfg.RequireAuth(cfg.CreateChirp))
-	mux.HandleFunc("GET /api/chirps/{id}", cfg.GetChirp)
-	mux.HandleFunc("DELETE /api/chirps/{id}", cfg.RequireAuth(cfg.DeleteChirp))
+	mux.HandleFunc("GET /api/chirps", cfg.RateLimit("read", cfg.GetChirps))
+	mux.HandleFunc("POST /api/chirps", cfg.RequireAuth(cfg.RateLimit("chirps_create", cfg.CreateChirp), auth.ScopeChirpsWrite))
+	mux.HandleFunc("GET /api/chirps/{id}", cfg.RateLimit("read", cfg.GetChirp))
+	mux.HandleFunc("DELETE /api/chirps/{id}", cfg.RequireAuth(cfg.DeleteChirp, auth.ScopeChirpsWrite))
+	mux.HandleFunc("POST /api/reports", cfg.RequireAuth(cfg.RateLimit("reports_create", cfg.CreateReport)))
 
 
 
@@ -63,3 +228,251 @@ func main() {
 	server.ListenAndServe()
 
 }
+
+// newSecretBox reads KEY_ENCRYPTION_KEY, the base64 AES-256 key that
+// seals secrets stored in the database.
+func newSecretBox() (*secretbox.Box, error) {
+	encoded := os.Getenv("KEY_ENCRYPTION_KEY")
+	if encoded == "" {
+		return nil, fmt.Errorf("KEY_ENCRYPTION_KEY must be set, e.g. to the output of: openssl rand -base64 32")
+	}
+	box, err := secretbox.ParseKey(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid KEY_ENCRYPTION_KEY: %w", err)
+	}
+	return box, nil
+}
+
+// newRotator builds the signing key rotator from JWT_SIGNING_ALG (EdDSA or
+// RS256) and JWT_KEY_ROTATION. JWT_SECRET, when set, still verifies tokens
+// issued before asymmetric signing.
+func newRotator(dbPool *sql.DB, dbQueries *db.Queries, secrets *secretbox.Box) (*keystore.Rotator, error) {
+	alg := os.Getenv("JWT_SIGNING_ALG")
+	if alg == "" {
+		alg = auth.AlgEdDSA
+	}
+	if alg != auth.AlgEdDSA && alg != auth.AlgRS256 {
+		return nil, fmt.Errorf("unsupported JWT_SIGNING_ALG %q", alg)
+	}
+	interval := 30 * 24 * time.Hour
+	if v := os.Getenv("JWT_KEY_ROTATION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid JWT_KEY_ROTATION %q", v)
+		}
+		interval = d
+	}
+	return &keystore.Rotator{
+		Store: dbQueries,
+		InTx: func(ctx context.Context, fn func(keystore.Store) error) error {
+			tx, err := dbPool.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+			if err := fn(dbQueries.WithTx(tx)); err != nil {
+				return err
+			}
+			return tx.Commit()
+		},
+		Box:       secrets,
+		Keys:      auth.NewKeyring(os.Getenv("JWT_SECRET")),
+		Algorithm: alg,
+		Interval:  interval,
+		// access tokens live for an hour, so a retired key must verify
+		// for at least that long
+		Grace: 2 * time.Hour,
+	}, nil
+}
+
+// newValidator builds the access token validator. JWT_LEEWAY overrides the
+// tolerated clock skew.
+func newValidator(keys *auth.Keyring, dbQueries *db.Queries) (*auth.Validator, error) {
+	validator := auth.NewValidator(keys)
+	validator.Revoked = dbQueries
+	if v := os.Getenv("JWT_LEEWAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid JWT_LEEWAY %q", v)
+		}
+		validator.Leeway = d
+	}
+	return validator, nil
+}
+
+// newRelyingParty configures passkeys. WEBAUTHN_RP_ID is the domain the
+// passkeys are bound to and WEBAUTHN_ORIGINS the comma-separated origins
+// the browser may report.
+func newRelyingParty() *webauthn.RelyingParty {
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = "localhost"
+	}
+	origins := []string{"http://localhost:8080"}
+	if v := os.Getenv("WEBAUTHN_ORIGINS"); v != "" {
+		origins = strings.Split(v, ",")
+	}
+	return &webauthn.RelyingParty{
+		ID:      rpID,
+		Name:    "Chirpy",
+		Origins: origins,
+		Timeout: 5 * time.Minute,
+	}
+}
+
+// newPasswordHasher tunes Argon2id from ARGON2_MEMORY_KIB,
+// ARGON2_ITERATIONS and ARGON2_PARALLELISM. Changing them upgrades each
+// user's hash the next time they log in.
+func newPasswordHasher() (auth.PasswordHasher, error) {
+	params := auth.DefaultArgon2idParams
+	settings := []struct {
+		key string
+		dst interface{}
+	}{
+		{"ARGON2_MEMORY_KIB", &params.Memory},
+		{"ARGON2_ITERATIONS", &params.Iterations},
+		{"ARGON2_PARALLELISM", &params.Parallelism},
+	}
+	for _, setting := range settings {
+		v := os.Getenv(setting.key)
+		if v == "" {
+			continue
+		}
+		if _, err := fmt.Sscan(v, setting.dst); err != nil {
+			return nil, fmt.Errorf("invalid %s %q", setting.key, v)
+		}
+	}
+	hasher := auth.MigratingHasher{
+		Current: auth.Argon2idHasher{Params: params},
+		Legacy:  []auth.PasswordHasher{auth.BcryptHasher{Cost: bcrypt.DefaultCost}},
+	}
+	// fail now rather than on the first signup
+	if _, err := hasher.Hash("check"); err != nil {
+		return nil, err
+	}
+	return hasher, nil
+}
+
+// newLockoutGuard counts failed logins in Postgres, shared by every
+// replica, unless LOGIN_FAILURE_STORE is memory.
+func newLockoutGuard(dbQueries *db.Queries) (*lockout.Guard, error) {
+	switch store := envOr("LOGIN_FAILURE_STORE", "postgres"); store {
+	case "postgres":
+		return lockout.NewGuard(lockout.PostgresStore{Queries: dbQueries}), nil
+	case "memory":
+		return lockout.NewGuard(lockout.NewMemoryStore()), nil
+	default:
+		return nil, fmt.Errorf("unsupported LOGIN_FAILURE_STORE %q", store)
+	}
+}
+
+// newRateLimiter keeps rate limits in Postgres, shared by every replica,
+// unless RATE_LIMIT_STORE is memory. RATE_LIMITS overrides policies, e.g.
+// "login=30/1m,chirps_create.red=500/1h".
+func newRateLimiter(dbQueries *db.Queries) (*ratelimit.Limiter, error) {
+	var limiter *ratelimit.Limiter
+	switch store := envOr("RATE_LIMIT_STORE", "postgres"); store {
+	case "postgres":
+		limiter = ratelimit.NewLimiter(ratelimit.PostgresStore{Queries: dbQueries})
+	case "memory":
+		limiter = ratelimit.NewLimiter(ratelimit.NewMemoryStore())
+	default:
+		return nil, fmt.Errorf("unsupported RATE_LIMIT_STORE %q", store)
+	}
+	policies, err := ratelimit.ParsePolicies(os.Getenv("RATE_LIMITS"), limiter.Policies)
+	if err != nil {
+		return nil, err
+	}
+	limiter.Policies = policies
+	return limiter, nil
+}
+
+// newWebhookClient refuses to deliver webhooks to private addresses,
+// except with PLATFORM=dev so endpoints on localhost can be tested.
+func newWebhookClient() *http.Client {
+	if os.Getenv("PLATFORM") == "dev" {
+		return &http.Client{Timeout: 10 * time.Second}
+	}
+	return webhook.PublicClient(10 * time.Second)
+}
+
+// newPasswordPolicy reads PASSWORD_MIN_LENGTH and PASSWORD_MAX_LENGTH, and
+// BREACHED_PASSWORDS_FILE, a sorted Pwned Passwords SHA-1 file to reject
+// leaked passwords with.
+func newPasswordPolicy() (*auth.PasswordPolicy, error) {
+	policy := auth.DefaultPasswordPolicy
+	for key, dst := range map[string]*int{
+		"PASSWORD_MIN_LENGTH": &policy.MinLength,
+		"PASSWORD_MAX_LENGTH": &policy.MaxLength,
+	} {
+		if v := os.Getenv(key); v != "" {
+			if _, err := fmt.Sscan(v, dst); err != nil || *dst < 1 {
+				return nil, fmt.Errorf("invalid %s %q", key, v)
+			}
+		}
+	}
+	if policy.MinLength > policy.MaxLength {
+		return nil, fmt.Errorf("PASSWORD_MIN_LENGTH is more than PASSWORD_MAX_LENGTH")
+	}
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		list, err := auth.OpenBreachedPasswordFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("opening BREACHED_PASSWORDS_FILE: %w", err)
+		}
+		policy.Breached = list
+	}
+	return &policy, nil
+}
+
+// newOIDCProviders configures single sign-on when OIDC_ISSUER is set.
+// OIDC_PROVIDER names the provider in the login URL, and
+// OIDC_REDIRECT_URL must be registered with the provider.
+func newOIDCProviders(baseURL string) map[string]*oidc.Client {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return map[string]*oidc.Client{}
+	}
+	return map[string]*oidc.Client{
+		envOr("OIDC_PROVIDER", "sso"): oidc.NewClient(oidc.Config{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  envOr("OIDC_REDIRECT_URL", baseURL+"/app/oidc-callback"),
+			Scopes:       []string{"email", "profile"},
+		}),
+	}
+}
+
+// newMailer picks the mail transport from MAIL_TRANSPORT: smtp (SMTP_ADDR,
+// SMTP_USERNAME, SMTP_PASSWORD), file (MAIL_FILE) or log, the default.
+func newMailer() (mail.Mailer, error) {
+	switch transport := envOr("MAIL_TRANSPORT", "log"); transport {
+	case "smtp":
+		addr := os.Getenv("SMTP_ADDR")
+		if addr == "" {
+			return nil, fmt.Errorf("SMTP_ADDR must be set for MAIL_TRANSPORT=smtp")
+		}
+		return &mail.SMTPMailer{
+			Addr:     addr,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}, nil
+	case "file":
+		path := os.Getenv("MAIL_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("MAIL_FILE must be set for MAIL_TRANSPORT=file")
+		}
+		return &mail.FileMailer{Path: path}, nil
+	case "log":
+		return &mail.FileMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported MAIL_TRANSPORT %q", transport)
+	}
+}
+
+func envOr(key string, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
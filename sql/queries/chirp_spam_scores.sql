@@ -0,0 +1,5 @@
+-- name: CreateChirpSpamScore :one
+INSERT INTO chirp_spam_scores (chirp_id, score, reasons) VALUES ($1, $2, $3) RETURNING *;
+
+-- name: GetChirpSpamScore :one
+SELECT * FROM chirp_spam_scores WHERE chirp_id = $1;
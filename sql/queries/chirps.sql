@@ -1,27 +1,35 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (user_id, body) VALUES ($1, $2) RETURNING *;
+INSERT INTO chirps (user_id, body, lang, status) VALUES ($1, $2, $3, $4) RETURNING *;
 
--- name: GetChirpsASC :many
-SELECT 
-    *
-FROM chirps
-ORDER BY chirps.created_at ASC;
-
--- name: GetChirpsDESC :many
-SELECT 
-    *
-FROM chirps
-ORDER BY chirps.created_at DESC;
+-- name: ListChirps :many
+SELECT * FROM chirps
+WHERE chirps.status = 'published'
+  AND NOT EXISTS (
+    SELECT 1 FROM users
+    WHERE users.id = chirps.user_id
+      AND (users.status = 'banned' OR (users.status = 'suspended' AND users.suspended_until > NOW()))
+  )
+  AND (sqlc.narg('user_id')::uuid IS NULL OR chirps.user_id = sqlc.narg('user_id'))
+  AND (sqlc.narg('lang')::text IS NULL OR chirps.lang = sqlc.narg('lang'))
+ORDER BY
+    CASE WHEN @sort_desc::bool THEN chirps.created_at END DESC,
+    chirps.created_at ASC;
 
--- name: GetChirpsByUserIDASC :many
+-- name: GetPublishedChirp :one
 SELECT * FROM chirps
-WHERE chirps.user_id = $1
-ORDER BY chirps.created_at ASC;
+WHERE chirps.id = $1
+  AND chirps.status = 'published'
+  AND NOT EXISTS (
+    SELECT 1 FROM users
+    WHERE users.id = chirps.user_id
+      AND (users.status = 'banned' OR (users.status = 'suspended' AND users.suspended_until > NOW()))
+  );
 
--- name: GetChirpsByUserIDDESC :many
+-- name: GetRecentChirpsByUser :many
 SELECT * FROM chirps
-WHERE chirps.user_id = $1                
-ORDER BY chirps.created_at DESC;
+WHERE chirps.user_id = $1 AND chirps.created_at > $2
+ORDER BY chirps.created_at DESC
+LIMIT 50;
 
 -- name: GetChirp :one
 SELECT * FROM chirps
@@ -32,3 +40,23 @@ DELETE FROM chirps;
 
 -- name: DeleteChirp :exec
 DELETE FROM chirps WHERE id = $1;
+
+-- name: ListHeldChirps :many
+SELECT chirps.*,
+    COALESCE(chirp_spam_scores.score, 0)::float8 AS spam_score,
+    COALESCE(chirp_spam_scores.reasons, '[]')::jsonb AS spam_reasons
+FROM chirps
+LEFT JOIN chirp_spam_scores ON chirp_spam_scores.chirp_id = chirps.id
+WHERE chirps.status = 'held'
+ORDER BY chirps.created_at ASC
+LIMIT $1;
+
+-- name: ReleaseHeldChirp :one
+UPDATE chirps SET status = 'published', updated_at = NOW()
+WHERE id = $1 AND status = 'held'
+RETURNING *;
+
+-- name: DeleteHeldChirp :one
+DELETE FROM chirps
+WHERE id = $1 AND status = 'held'
+RETURNING *;
@@ -0,0 +1,18 @@
+-- name: CreateEmailToken :exec
+INSERT INTO email_tokens (token_hash, user_id, purpose, expires_at, nonce_hash)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: ConsumeEmailToken :one
+UPDATE email_tokens SET used_at = NOW()
+WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+RETURNING *;
+
+-- name: ConsumeMagicLinkToken :one
+UPDATE email_tokens SET used_at = NOW()
+WHERE token_hash = $1 AND nonce_hash = $2 AND purpose = 'magic_link'
+    AND used_at IS NULL AND expires_at > NOW()
+RETURNING *;
+
+-- name: InvalidateEmailTokens :exec
+UPDATE email_tokens SET used_at = NOW()
+WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL;
@@ -0,0 +1,21 @@
+-- name: GetLoginFailures :one
+SELECT * FROM login_failures WHERE key = $1;
+
+-- name: IncrementLoginFailures :one
+INSERT INTO login_failures (key, failures, updated_at)
+VALUES ($1, 1, NOW())
+ON CONFLICT (key) DO UPDATE SET
+    failures = CASE WHEN login_failures.updated_at < $2 THEN 1 ELSE login_failures.failures + 1 END,
+    updated_at = NOW()
+RETURNING failures;
+
+-- name: BlockLoginFailures :exec
+UPDATE login_failures SET blocked_until = GREATEST(COALESCE(blocked_until, $2), $2)
+WHERE key = $1;
+
+-- name: ResetLoginFailures :exec
+DELETE FROM login_failures WHERE key = $1;
+
+-- name: DeleteStaleLoginFailures :exec
+DELETE FROM login_failures
+WHERE updated_at < $1 AND (blocked_until IS NULL OR blocked_until < NOW());
@@ -0,0 +1,15 @@
+-- name: RecordMagicLinkRequest :exec
+INSERT INTO magic_link_requests (email, ip)
+VALUES ($1, $2);
+
+-- name: CountMagicLinkRequestsByEmail :one
+SELECT COUNT(*) FROM magic_link_requests
+WHERE email = @email AND created_at > @since;
+
+-- name: CountMagicLinkRequestsByIP :one
+SELECT COUNT(*) FROM magic_link_requests
+WHERE ip = @ip AND created_at > @since;
+
+-- name: DeleteMagicLinkRequestsBefore :exec
+DELETE FROM magic_link_requests
+WHERE created_at < $1;
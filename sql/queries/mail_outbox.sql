@@ -0,0 +1,27 @@
+-- name: EnqueueMail :one
+INSERT INTO mail_outbox (to_address, subject, body)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: ClaimPendingMail :many
+UPDATE mail_outbox SET
+    attempts = attempts + 1,
+    next_attempt_at = @lease_until
+WHERE id IN (
+    SELECT pending.id FROM mail_outbox AS pending
+    WHERE pending.sent_at IS NULL AND pending.next_attempt_at <= NOW() AND pending.attempts < @max_attempts
+    ORDER BY pending.id
+    LIMIT @batch_size
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: MarkMailSent :exec
+UPDATE mail_outbox SET sent_at = NOW(), last_error = ''
+WHERE id = $1;
+
+-- name: MarkMailFailed :exec
+UPDATE mail_outbox SET
+    last_error = $2,
+    next_attempt_at = $3
+WHERE id = $1;
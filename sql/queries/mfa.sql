@@ -0,0 +1,62 @@
+-- name: UpsertUserTOTP :one
+INSERT INTO user_totp (user_id, secret)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE SET
+    secret = EXCLUDED.secret,
+    created_at = NOW(),
+    confirmed_at = NULL,
+    last_used_step = 0
+WHERE user_totp.confirmed_at IS NULL
+RETURNING *;
+
+-- name: GetUserTOTP :one
+SELECT * FROM user_totp WHERE user_id = $1;
+
+-- name: ConfirmUserTOTP :exec
+UPDATE user_totp SET confirmed_at = NOW()
+WHERE user_id = $1;
+
+-- name: UseTOTPStep :execrows
+UPDATE user_totp SET last_used_step = $2
+WHERE user_id = $1 AND last_used_step < $2;
+
+-- name: DeleteUserTOTP :exec
+DELETE FROM user_totp WHERE user_id = $1;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2);
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes WHERE user_id = $1;
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes SET used_at = NOW()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+
+-- name: CreateMFAChallenge :exec
+INSERT INTO mfa_challenges (token_hash, user_id, expires_at) VALUES ($1, $2, $3);
+
+-- name: AttemptMFAChallenge :one
+UPDATE mfa_challenges SET attempts = attempts + 1
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING *;
+
+-- name: ConsumeMFAChallenge :execrows
+UPDATE mfa_challenges SET used_at = NOW()
+WHERE token_hash = $1 AND used_at IS NULL;
+
+-- name: SealUserTOTPSecret :exec
+UPDATE user_totp SET secret = $2 WHERE user_id = $1;
+
+-- name: AttemptMFAVerify :one
+INSERT INTO mfa_verify_attempts (user_id, attempts, window_start)
+VALUES ($1, 1, NOW())
+ON CONFLICT (user_id) DO UPDATE SET
+    attempts = CASE WHEN mfa_verify_attempts.window_start > NOW() - sqlc.arg(window_seconds)::float8 * INTERVAL '1 second'
+        THEN mfa_verify_attempts.attempts + 1 ELSE 1 END,
+    window_start = CASE WHEN mfa_verify_attempts.window_start > NOW() - sqlc.arg(window_seconds)::float8 * INTERVAL '1 second'
+        THEN mfa_verify_attempts.window_start ELSE NOW() END
+RETURNING attempts;
+
+-- name: ResetMFAVerifyAttempts :exec
+DELETE FROM mfa_verify_attempts WHERE user_id = $1;
@@ -0,0 +1,9 @@
+-- name: CreateModerationLogEntry :one
+INSERT INTO moderation_log (actor_id, action, report_id, target_user_id, chirp_id, note)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: ListModerationLog :many
+SELECT * FROM moderation_log
+ORDER BY id DESC
+LIMIT $1;
@@ -0,0 +1,24 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, owner_id, name, secret_hash, redirect_uris, scopes)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients WHERE id = $1;
+
+-- name: ListOAuthClientsByOwner :many
+SELECT * FROM oauth_clients WHERE owner_id = $1 ORDER BY created_at;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients WHERE id = $1 AND owner_id = $2;
+
+-- name: CreateOAuthCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scope, code_challenge, family_id, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: GetOAuthCode :one
+SELECT * FROM oauth_authorization_codes WHERE code_hash = $1;
+
+-- name: ConsumeOAuthCode :execrows
+UPDATE oauth_authorization_codes SET used_at = NOW()
+WHERE code_hash = $1 AND used_at IS NULL;
@@ -0,0 +1,22 @@
+-- name: CreateOIDCIdentity :exec
+INSERT INTO oidc_identities (provider, subject, user_id, email)
+VALUES ($1, $2, $3, $4);
+
+-- name: GetOIDCIdentity :one
+SELECT * FROM oidc_identities WHERE provider = $1 AND subject = $2;
+
+-- name: TouchOIDCIdentity :exec
+UPDATE oidc_identities SET last_login_at = NOW(), email = $3
+WHERE provider = $1 AND subject = $2;
+
+-- name: CreateOIDCLoginState :exec
+INSERT INTO oidc_login_states (state_hash, provider, nonce, code_verifier, expires_at)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: ConsumeOIDCLoginState :one
+DELETE FROM oidc_login_states
+WHERE state_hash = $1 AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteExpiredOIDCLoginStates :exec
+DELETE FROM oidc_login_states WHERE expires_at <= NOW();
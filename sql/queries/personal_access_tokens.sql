@@ -0,0 +1,23 @@
+-- name: CreatePersonalAccessToken :one
+INSERT INTO personal_access_tokens (user_id, name, token_hash, scopes, allowed_ips, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: GetPersonalAccessTokenByHash :one
+SELECT * FROM personal_access_tokens WHERE token_hash = $1;
+
+-- name: ListPersonalAccessTokens :many
+SELECT * FROM personal_access_tokens WHERE user_id = $1 ORDER BY created_at;
+
+-- name: CountPersonalAccessTokens :one
+SELECT COUNT(*) FROM personal_access_tokens WHERE user_id = $1;
+
+-- name: DeletePersonalAccessToken :execrows
+DELETE FROM personal_access_tokens WHERE id = $1 AND user_id = $2;
+
+-- name: TouchPersonalAccessToken :exec
+UPDATE personal_access_tokens SET last_used_at = NOW(), last_used_ip = $2
+WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute' OR last_used_ip <> $2);
+
+-- name: DeleteUserPersonalAccessTokens :exec
+DELETE FROM personal_access_tokens WHERE user_id = $1;
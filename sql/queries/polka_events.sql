@@ -0,0 +1,8 @@
+-- name: CreatePolkaEvent :exec
+INSERT INTO polka_events (event_id, event, payload, remote_ip, outcome, error)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: MarkPolkaEventProcessed :execrows
+INSERT INTO polka_processed_events (event_id)
+VALUES ($1)
+ON CONFLICT (event_id) DO NOTHING;
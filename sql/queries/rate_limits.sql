@@ -0,0 +1,14 @@
+-- name: TakeRateLimit :one
+INSERT INTO rate_limits (key, tat)
+VALUES (sqlc.arg(key), sqlc.arg(now)::timestamptz + sqlc.arg(interval_seconds)::float8 * INTERVAL '1 second')
+ON CONFLICT (key) DO UPDATE
+SET tat = GREATEST(rate_limits.tat, sqlc.arg(now)::timestamptz) + sqlc.arg(interval_seconds)::float8 * INTERVAL '1 second'
+WHERE GREATEST(rate_limits.tat, sqlc.arg(now)::timestamptz) + sqlc.arg(interval_seconds)::float8 * INTERVAL '1 second'
+    - sqlc.arg(period_seconds)::float8 * INTERVAL '1 second' <= sqlc.arg(now)::timestamptz
+RETURNING tat;
+
+-- name: GetRateLimit :one
+SELECT tat FROM rate_limits WHERE key = $1;
+
+-- name: DeleteExpiredRateLimits :exec
+DELETE FROM rate_limits WHERE tat < $1;
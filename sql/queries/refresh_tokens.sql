@@ -1,12 +1,55 @@
-
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, user_id, expires_at) VALUES ($1, $2, $3) RETURNING *;
+INSERT INTO refresh_tokens (token_hash, user_id, expires_at, family_id, parent_token_hash, user_agent, ip, client_id, scope)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING *;
 
 -- name: GetRefreshToken :one
-SELECT * FROM refresh_tokens WHERE token = $1 AND revoked_at is NULL;
+SELECT * FROM refresh_tokens WHERE token_hash = $1 AND hashed;
+
+-- name: GetLegacyRefreshToken :one
+SELECT * FROM refresh_tokens WHERE token_hash = $1 AND NOT hashed;
+
+-- name: ListLegacyRefreshTokens :many
+SELECT token_hash FROM refresh_tokens WHERE NOT hashed;
+
+-- name: HashLegacyRefreshToken :exec
+UPDATE refresh_tokens SET token_hash = sqlc.arg('new_hash'), hashed = true
+WHERE token_hash = sqlc.arg('raw_token') AND NOT hashed;
 
 -- name: ExpireRefreshToken :exec
-UPDATE refresh_tokens SET revoked_at = NOW() WHERE token = $1;
+UPDATE refresh_tokens SET revoked_at = NOW(), revoked_reason = 'logout'
+WHERE token_hash = $1 AND revoked_at IS NULL;
+
+-- name: RotateRefreshToken :execrows
+UPDATE refresh_tokens SET revoked_at = NOW(), revoked_reason = 'rotated', updated_at = NOW(), last_used_at = NOW()
+WHERE token_hash = $1 AND revoked_at IS NULL;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens SET revoked_at = NOW(), revoked_reason = 'revoked', updated_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL;
 
 -- name: GetUserByRefreshToken :one
-SELECT * FROM users WHERE id = (SELECT user_id FROM refresh_tokens WHERE token = $1);
+SELECT * FROM users WHERE id = (SELECT user_id FROM refresh_tokens WHERE token_hash = $1);
+
+-- name: RevokeUserRefreshTokens :exec
+UPDATE refresh_tokens SET revoked_at = NOW(), revoked_reason = 'revoked'
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: ListActiveRefreshTokensByUser :many
+SELECT * FROM refresh_tokens
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY last_used_at DESC;
+
+-- name: RevokeUserRefreshTokenFamily :execrows
+UPDATE refresh_tokens SET revoked_at = NOW(), revoked_reason = 'revoked', updated_at = NOW()
+WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeOtherRefreshTokenFamilies :exec
+UPDATE refresh_tokens SET revoked_at = NOW(), revoked_reason = 'revoked', updated_at = NOW()
+WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL;
+
+-- name: RefreshTokenFamilyActive :one
+SELECT EXISTS (
+    SELECT 1 FROM refresh_tokens
+    WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+);
@@ -0,0 +1,30 @@
+-- name: CreateReport :one
+INSERT INTO reports (reporter_id, chirp_id, target_user_id, reason, details)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetReport :one
+SELECT * FROM reports WHERE id = $1;
+
+-- name: ListReports :many
+SELECT * FROM reports
+WHERE status = $1
+ORDER BY created_at ASC;
+
+-- name: ClaimReport :one
+UPDATE reports SET
+    status = 'claimed',
+    claimed_by = $2,
+    claimed_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1 AND status = 'open'
+RETURNING *;
+
+-- name: ResolveReport :one
+UPDATE reports SET
+    status = 'resolved',
+    resolution = $3,
+    resolved_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1 AND status = 'claimed' AND claimed_by = $2
+RETURNING *;
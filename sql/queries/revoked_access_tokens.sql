@@ -0,0 +1,13 @@
+-- name: RevokeAccessToken :exec
+INSERT INTO revoked_access_tokens (jti, expires_at)
+VALUES ($1, $2)
+ON CONFLICT (jti) DO NOTHING;
+
+-- name: IsAccessTokenRevoked :one
+SELECT EXISTS (
+    SELECT 1 FROM revoked_access_tokens WHERE jti = $1
+);
+
+-- name: DeleteExpiredRevokedAccessTokens :exec
+DELETE FROM revoked_access_tokens
+WHERE expires_at < NOW();
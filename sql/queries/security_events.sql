@@ -0,0 +1,8 @@
+-- name: CreateSecurityEvent :one
+INSERT INTO security_events (user_id, kind, details) VALUES ($1, $2, $3) RETURNING *;
+
+-- name: ListSecurityEventsByUser :many
+SELECT * FROM security_events
+WHERE user_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
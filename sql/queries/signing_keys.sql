@@ -0,0 +1,18 @@
+-- name: CreateSigningKey :one
+INSERT INTO signing_keys (kid, created_at, algorithm, private_key) VALUES ($1, $2, $3, $4) RETURNING *;
+
+-- name: ListActiveSigningKeys :many
+SELECT * FROM signing_keys
+WHERE retires_at IS NULL OR retires_at > NOW()
+ORDER BY created_at DESC;
+
+-- name: RetireSigningKeys :exec
+UPDATE signing_keys SET retires_at = $1
+WHERE retires_at IS NULL AND created_at <= $2;
+
+-- name: SealSigningKey :exec
+UPDATE signing_keys SET private_key = $2 WHERE kid = $1;
+
+-- name: LockSigningKeys :exec
+-- held until the transaction ends, so replicas rotate one at a time
+SELECT pg_advisory_xact_lock(hashtext('signing_keys'));
@@ -0,0 +1,30 @@
+-- name: GetSubscriptionByUser :one
+SELECT * FROM subscriptions WHERE user_id = $1;
+
+-- name: GetSubscriptionByUserForUpdate :one
+SELECT * FROM subscriptions WHERE user_id = $1 FOR UPDATE;
+
+-- name: UpsertSubscription :one
+INSERT INTO subscriptions (user_id, plan, status, current_period_start, current_period_end, grace_until, canceled_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (user_id) DO UPDATE SET
+    plan = EXCLUDED.plan,
+    status = EXCLUDED.status,
+    current_period_start = EXCLUDED.current_period_start,
+    current_period_end = EXCLUDED.current_period_end,
+    grace_until = EXCLUDED.grace_until,
+    canceled_at = EXCLUDED.canceled_at,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: ExpireLapsedSubscriptions :many
+WITH expired AS (
+    UPDATE subscriptions SET status = 'expired', updated_at = NOW()
+    WHERE (status = 'active' AND current_period_end < @active_before)
+        OR (status = 'past_due' AND grace_until < @grace_before::timestamptz)
+    RETURNING user_id
+)
+UPDATE users SET is_chirpy_red = false, updated_at = NOW()
+FROM expired
+WHERE users.id = expired.user_id
+RETURNING users.id;
@@ -17,12 +17,49 @@ SELECT hashed_password FROM users WHERE email = $1;
 UPDATE users SET 
     email = $1,
     hashed_password = $2,
+    verified_at = CASE WHEN email = $1 THEN verified_at END,
     updated_at = NOW()
 WHERE id = $3
 RETURNING *;
 
--- name: UpgradeUser :one
+-- name: SetChirpyRed :exec
+UPDATE users SET is_chirpy_red = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: SetUserStatus :one
 UPDATE users SET
-    is_chirpy_red = true
+    status = $2,
+    suspended_until = $3,
+    updated_at = NOW()
 WHERE id = $1
 RETURNING *;
+
+-- name: SetUserRole :one
+UPDATE users SET
+    role = $2,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: CountUsersWithRole :one
+SELECT COUNT(*) FROM users WHERE role = $1;
+
+-- name: LockUsersWithRole :many
+SELECT id FROM users WHERE role = $1 FOR UPDATE;
+
+-- name: VerifyUserEmail :exec
+UPDATE users SET verified_at = NOW()
+WHERE id = $1 AND verified_at IS NULL;
+
+-- name: SetUserPassword :execrows
+UPDATE users SET
+    hashed_password = sqlc.arg(hashed_password),
+    updated_at = NOW()
+WHERE id = sqlc.arg(id) AND hashed_password = sqlc.arg(old_hashed_password);
+
+-- name: ResetUserPassword :exec
+UPDATE users SET
+    hashed_password = $2,
+    password_changed_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1;
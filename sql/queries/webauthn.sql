@@ -0,0 +1,35 @@
+-- name: CreateWebAuthnCredential :one
+INSERT INTO webauthn_credentials (credential_id, user_id, name, public_key, algorithm, sign_count, aaguid)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING *;
+
+-- name: GetWebAuthnCredential :one
+SELECT * FROM webauthn_credentials WHERE credential_id = $1;
+
+-- name: ListWebAuthnCredentialsByUser :many
+SELECT * FROM webauthn_credentials
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: UpdateWebAuthnSignCount :execrows
+UPDATE webauthn_credentials SET
+    sign_count = @sign_count,
+    last_used_at = NOW()
+WHERE credential_id = @credential_id AND (sign_count < @sign_count OR @sign_count = 0);
+
+-- name: DeleteWebAuthnCredential :execrows
+DELETE FROM webauthn_credentials
+WHERE credential_id = $1 AND user_id = $2;
+
+-- name: CountWebAuthnCredentialsByUser :one
+SELECT COUNT(*) FROM webauthn_credentials WHERE user_id = $1;
+
+-- name: CreateWebAuthnChallenge :one
+INSERT INTO webauthn_challenges (expires_at, user_id, ceremony, challenge)
+VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: ConsumeWebAuthnChallenge :one
+DELETE FROM webauthn_challenges
+WHERE id = $1 AND ceremony = $2 AND expires_at > NOW()
+RETURNING *;
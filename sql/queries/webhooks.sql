@@ -0,0 +1,102 @@
+-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (user_id, client_id, url, secret, events, description)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: GetWebhookEndpoint :one
+SELECT * FROM webhook_endpoints WHERE id = $1;
+
+-- name: ListWebhookEndpoints :many
+SELECT * FROM webhook_endpoints WHERE user_id = $1 ORDER BY created_at;
+
+-- name: CountWebhookEndpoints :one
+SELECT COUNT(*) FROM webhook_endpoints WHERE user_id = $1;
+
+-- name: DeleteWebhookEndpoint :execrows
+DELETE FROM webhook_endpoints WHERE id = $1 AND user_id = $2;
+
+-- name: DeleteEndedGrantWebhookEndpoints :exec
+DELETE FROM webhook_endpoints
+WHERE webhook_endpoints.user_id = @user_id
+    AND client_id = (SELECT grant_token.client_id FROM refresh_tokens grant_token WHERE grant_token.family_id = @family_id LIMIT 1)
+    AND NOT EXISTS (
+        SELECT 1 FROM refresh_tokens
+        WHERE refresh_tokens.user_id = webhook_endpoints.user_id
+            AND refresh_tokens.client_id = webhook_endpoints.client_id
+            AND refresh_tokens.revoked_at IS NULL AND refresh_tokens.expires_at > NOW()
+    );
+
+-- name: ResumeWebhookEndpoint :one
+UPDATE webhook_endpoints SET
+    status = 'active',
+    consecutive_failures = 0,
+    paused_at = NULL,
+    updated_at = NOW()
+WHERE id = $1 AND user_id = $2
+RETURNING *;
+
+-- name: RecordWebhookEndpointFailure :one
+UPDATE webhook_endpoints SET
+    consecutive_failures = consecutive_failures + 1,
+    status = CASE WHEN consecutive_failures + 1 >= @pause_after THEN 'paused' ELSE status END,
+    paused_at = CASE WHEN consecutive_failures + 1 >= @pause_after AND status = 'active' THEN NOW() ELSE paused_at END,
+    updated_at = NOW()
+WHERE id = @id
+RETURNING status;
+
+-- name: ResetWebhookEndpointFailures :exec
+UPDATE webhook_endpoints SET consecutive_failures = 0
+WHERE id = $1 AND consecutive_failures > 0;
+
+-- name: EnqueueWebhookEvent :execrows
+INSERT INTO webhook_deliveries (endpoint_id, event_id, event_type, payload)
+SELECT id, @event_id::uuid, @event_type::text, @payload::jsonb
+FROM webhook_endpoints
+WHERE webhook_endpoints.user_id = @user_id
+    -- paused ones too: their deliveries wait until it is resumed
+    AND @event_type::text = ANY(string_to_array(events, ' '))
+    -- an app hears about the user only while it holds a live grant
+    AND (client_id IS NULL OR EXISTS (
+        SELECT 1 FROM refresh_tokens
+        WHERE refresh_tokens.user_id = webhook_endpoints.user_id
+            AND refresh_tokens.client_id = webhook_endpoints.client_id
+            AND refresh_tokens.revoked_at IS NULL AND refresh_tokens.expires_at > NOW()
+    ));
+
+-- name: ClaimPendingWebhookDeliveries :many
+UPDATE webhook_deliveries SET
+    attempts = attempts + 1,
+    next_attempt_at = @lease_until,
+    last_attempt_at = NOW()
+WHERE id IN (
+    SELECT webhook_deliveries.id FROM webhook_deliveries
+    JOIN webhook_endpoints ON webhook_endpoints.id = webhook_deliveries.endpoint_id
+    WHERE webhook_deliveries.status = 'pending' AND webhook_deliveries.next_attempt_at <= NOW()
+        AND webhook_endpoints.status = 'active'
+    ORDER BY webhook_deliveries.created_at
+    LIMIT @batch_size
+    FOR UPDATE OF webhook_deliveries SKIP LOCKED
+)
+RETURNING *;
+
+-- name: MarkWebhookDelivered :exec
+UPDATE webhook_deliveries SET
+    status = 'delivered',
+    delivered_at = NOW(),
+    response_status = $2,
+    last_error = ''
+WHERE id = $1;
+
+-- name: MarkWebhookDeliveryFailed :exec
+UPDATE webhook_deliveries SET
+    status = CASE WHEN attempts >= @max_attempts THEN 'failed' ELSE 'pending' END,
+    response_status = @response_status,
+    last_error = @last_error,
+    next_attempt_at = @next_attempt_at
+WHERE id = @id;
+
+-- name: ListWebhookDeliveries :many
+SELECT * FROM webhook_deliveries
+WHERE endpoint_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE chirps ADD COLUMN lang TEXT NOT NULL DEFAULT 'und';
+CREATE INDEX chirps_lang_idx ON chirps (lang);
+
+-- +goose Down
+DROP INDEX chirps_lang_idx;
+ALTER TABLE chirps DROP COLUMN lang;
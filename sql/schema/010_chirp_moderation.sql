@@ -0,0 +1,14 @@
+-- +goose Up
+ALTER TABLE chirps ADD COLUMN status TEXT NOT NULL DEFAULT 'published'
+    CHECK (status IN ('published', 'held'));
+
+CREATE TABLE chirp_spam_scores (
+    chirp_id UUID PRIMARY KEY REFERENCES chirps(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    score DOUBLE PRECISION NOT NULL,
+    reasons JSONB NOT NULL DEFAULT '[]'
+);
+
+-- +goose Down
+DROP TABLE chirp_spam_scores;
+ALTER TABLE chirps DROP COLUMN status;
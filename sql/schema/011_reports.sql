@@ -0,0 +1,48 @@
+-- +goose Up
+CREATE TABLE reports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    reporter_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    chirp_id UUID REFERENCES chirps(id) ON DELETE SET NULL,
+    target_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL
+        CHECK (reason IN ('spam', 'harassment', 'hate', 'violence', 'impersonation', 'other')),
+    details TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'claimed', 'resolved')),
+    claimed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    claimed_at TIMESTAMPTZ,
+    resolved_at TIMESTAMPTZ,
+    resolution TEXT
+);
+CREATE INDEX reports_status_idx ON reports (status, created_at);
+
+-- No foreign keys: log entries must outlive the rows they talk about.
+CREATE TABLE moderation_log (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    actor_id UUID,
+    action TEXT NOT NULL,
+    report_id UUID,
+    target_user_id UUID,
+    chirp_id UUID,
+    note TEXT NOT NULL DEFAULT ''
+);
+
+-- +goose StatementBegin
+CREATE FUNCTION moderation_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'moderation_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER moderation_log_append_only
+    BEFORE UPDATE OR DELETE ON moderation_log
+    FOR EACH ROW EXECUTE FUNCTION moderation_log_append_only();
+
+-- +goose Down
+DROP TRIGGER moderation_log_append_only ON moderation_log;
+DROP FUNCTION moderation_log_append_only();
+DROP TABLE moderation_log;
+DROP TABLE reports;
@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN status TEXT NOT NULL DEFAULT 'active'
+    CHECK (status IN ('active', 'suspended', 'banned'));
+ALTER TABLE users ADD COLUMN suspended_until TIMESTAMPTZ;
+ALTER TABLE users ADD CONSTRAINT users_suspended_until_set
+    CHECK (status <> 'suspended' OR suspended_until IS NOT NULL);
+
+-- +goose Down
+ALTER TABLE users DROP CONSTRAINT users_suspended_until_set;
+ALTER TABLE users DROP COLUMN suspended_until;
+ALTER TABLE users DROP COLUMN status;
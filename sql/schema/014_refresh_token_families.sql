@@ -0,0 +1,27 @@
+-- +goose Up
+ALTER TABLE refresh_tokens ADD COLUMN family_id UUID NOT NULL DEFAULT gen_random_uuid();
+ALTER TABLE refresh_tokens ADD COLUMN parent_token VARCHAR(256)
+    REFERENCES refresh_tokens(token) ON DELETE SET NULL;
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens (family_id);
+
+CREATE TABLE security_events (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL,
+    details JSONB NOT NULL DEFAULT '{}'
+);
+CREATE INDEX security_events_user_idx ON security_events (user_id, created_at);
+
+-- why a refresh token was revoked: rotated when it was exchanged for a
+-- new one, which is the only case where seeing it again means it leaked;
+-- logout or revoked otherwise
+ALTER TABLE refresh_tokens ADD COLUMN revoked_reason TEXT NOT NULL DEFAULT '';
+UPDATE refresh_tokens SET revoked_reason = 'revoked' WHERE revoked_at IS NOT NULL;
+
+-- +goose Down
+ALTER TABLE refresh_tokens DROP COLUMN revoked_reason;
+DROP TABLE security_events;
+DROP INDEX refresh_tokens_family_idx;
+ALTER TABLE refresh_tokens DROP COLUMN parent_token;
+ALTER TABLE refresh_tokens DROP COLUMN family_id;
@@ -0,0 +1,22 @@
+-- +goose Up
+ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash;
+ALTER TABLE refresh_tokens RENAME COLUMN parent_token TO parent_token_hash;
+ALTER TABLE refresh_tokens DROP CONSTRAINT refresh_tokens_parent_token_fkey;
+ALTER TABLE refresh_tokens ADD CONSTRAINT refresh_tokens_parent_token_hash_fkey
+    FOREIGN KEY (parent_token_hash) REFERENCES refresh_tokens(token_hash)
+    ON DELETE SET NULL ON UPDATE CASCADE;
+-- Rows written before this migration still hold the raw token. The server
+-- hashes them on first use, or all at once with
+-- `chirpy-server hash-refresh-tokens`.
+ALTER TABLE refresh_tokens ADD COLUMN hashed BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE refresh_tokens ALTER COLUMN hashed SET DEFAULT true;
+
+-- +goose Down
+-- Hashed tokens cannot be turned back into raw ones, so they are revoked.
+UPDATE refresh_tokens SET revoked_at = NOW() WHERE hashed AND revoked_at IS NULL;
+ALTER TABLE refresh_tokens DROP COLUMN hashed;
+ALTER TABLE refresh_tokens DROP CONSTRAINT refresh_tokens_parent_token_hash_fkey;
+ALTER TABLE refresh_tokens RENAME COLUMN parent_token_hash TO parent_token;
+ALTER TABLE refresh_tokens RENAME COLUMN token_hash TO token;
+ALTER TABLE refresh_tokens ADD CONSTRAINT refresh_tokens_parent_token_fkey
+    FOREIGN KEY (parent_token) REFERENCES refresh_tokens(token) ON DELETE SET NULL;
@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE signing_keys (
+    kid TEXT PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    algorithm TEXT NOT NULL CHECK (algorithm IN ('EdDSA', 'RS256')),
+    private_key BYTEA NOT NULL,
+    retires_at TIMESTAMPTZ
+);
+
+-- +goose Down
+DROP TABLE signing_keys;
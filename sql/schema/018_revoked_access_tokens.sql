@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE revoked_access_tokens (
+    jti UUID PRIMARY KEY,
+    revoked_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX revoked_access_tokens_expires_at_idx ON revoked_access_tokens (expires_at);
+
+-- +goose Down
+DROP TABLE revoked_access_tokens;
@@ -0,0 +1,41 @@
+-- +goose Up
+CREATE TABLE user_totp (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    secret TEXT NOT NULL,
+    confirmed_at TIMESTAMPTZ,
+    -- the last accepted time step; codes at or before it are replays
+    last_used_step BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE recovery_codes (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    used_at TIMESTAMPTZ,
+    PRIMARY KEY (user_id, code_hash)
+);
+
+CREATE TABLE mfa_challenges (
+    token_hash TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    used_at TIMESTAMPTZ
+);
+
+-- step-up attempts by a logged-in user, capped like the attempts on a
+-- login's mfa_challenges row
+CREATE TABLE mfa_verify_attempts (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    -- attempts count from here; a later window starts over
+    window_start TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE mfa_verify_attempts;
+DROP TABLE mfa_challenges;
+DROP TABLE recovery_codes;
+DROP TABLE user_totp;
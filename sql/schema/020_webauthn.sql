@@ -0,0 +1,27 @@
+-- +goose Up
+CREATE TABLE webauthn_credentials (
+    credential_id BYTEA PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    name TEXT NOT NULL,
+    public_key BYTEA NOT NULL,
+    algorithm INTEGER NOT NULL,
+    sign_count BIGINT NOT NULL,
+    aaguid BYTEA NOT NULL,
+    last_used_at TIMESTAMPTZ
+);
+CREATE INDEX webauthn_credentials_user_idx ON webauthn_credentials (user_id);
+
+-- a ceremony's challenge lives here between its begin and finish calls
+CREATE TABLE webauthn_challenges (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    ceremony TEXT NOT NULL CHECK (ceremony IN ('register', 'login', 'mfa')),
+    challenge BYTEA NOT NULL
+);
+
+-- +goose Down
+DROP TABLE webauthn_challenges;
+DROP TABLE webauthn_credentials;
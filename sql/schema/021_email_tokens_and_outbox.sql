@@ -0,0 +1,37 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN verified_at TIMESTAMPTZ;
+-- accounts created before verification existed keep working as verified
+UPDATE users SET verified_at = created_at;
+
+CREATE TABLE email_tokens (
+    token_hash TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose TEXT NOT NULL CHECK (purpose IN ('verify_email', 'reset_password')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
+CREATE INDEX email_tokens_user_idx ON email_tokens (user_id, purpose);
+
+CREATE TABLE mail_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    to_address TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    body TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMPTZ,
+    last_error TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX mail_outbox_pending_idx ON mail_outbox (next_attempt_at) WHERE sent_at IS NULL;
+
+-- when the password was last reset; access tokens issued before it are
+-- rejected
+ALTER TABLE users ADD COLUMN password_changed_at TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN password_changed_at;
+DROP TABLE mail_outbox;
+DROP TABLE email_tokens;
+ALTER TABLE users DROP COLUMN verified_at;
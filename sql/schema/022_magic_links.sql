@@ -0,0 +1,23 @@
+-- +goose Up
+ALTER TABLE email_tokens DROP CONSTRAINT email_tokens_purpose_check;
+ALTER TABLE email_tokens ADD CONSTRAINT email_tokens_purpose_check
+    CHECK (purpose IN ('verify_email', 'reset_password', 'magic_link'));
+-- hash of the nonce cookie set on the browser that asked for a magic link
+ALTER TABLE email_tokens ADD COLUMN nonce_hash TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE magic_link_requests (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    email TEXT NOT NULL,
+    ip TEXT NOT NULL
+);
+CREATE INDEX magic_link_requests_email_idx ON magic_link_requests (email, created_at);
+CREATE INDEX magic_link_requests_ip_idx ON magic_link_requests (ip, created_at);
+
+-- +goose Down
+DROP TABLE magic_link_requests;
+DELETE FROM email_tokens WHERE purpose = 'magic_link';
+ALTER TABLE email_tokens DROP COLUMN nonce_hash;
+ALTER TABLE email_tokens DROP CONSTRAINT email_tokens_purpose_check;
+ALTER TABLE email_tokens ADD CONSTRAINT email_tokens_purpose_check
+    CHECK (purpose IN ('verify_email', 'reset_password'));
@@ -0,0 +1,37 @@
+-- +goose Up
+CREATE TABLE oauth_clients (
+    id TEXT PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    -- empty for public clients, which authenticate with PKCE alone
+    secret_hash TEXT NOT NULL DEFAULT '',
+    redirect_uris JSONB NOT NULL,
+    -- space-separated scopes the client may request
+    scopes TEXT NOT NULL
+);
+CREATE INDEX oauth_clients_owner_idx ON oauth_clients (owner_id);
+
+CREATE TABLE oauth_authorization_codes (
+    code_hash TEXT PRIMARY KEY,
+    client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    code_challenge TEXT NOT NULL,
+    -- refresh token family the code is exchanged into, revoked if the
+    -- code is replayed
+    family_id UUID NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+ALTER TABLE refresh_tokens
+    ADD COLUMN client_id TEXT REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    ADD COLUMN scope TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE refresh_tokens DROP COLUMN scope, DROP COLUMN client_id;
+DROP TABLE oauth_authorization_codes;
+DROP TABLE oauth_clients;
@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE personal_access_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    -- space-separated scopes, the same ones OAuth clients get
+    scopes TEXT NOT NULL,
+    -- addresses and CIDR ranges the token may be used from; empty allows any
+    allowed_ips JSONB NOT NULL DEFAULT '[]',
+    -- NULL for tokens that never expire
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    last_used_ip TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX personal_access_tokens_user_idx ON personal_access_tokens (user_id);
+
+-- +goose Down
+DROP TABLE personal_access_tokens;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- failed login counters, keyed by account email or client IP, shared by
+-- every replica
+CREATE TABLE login_failures (
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    blocked_until TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX login_failures_updated_at_idx ON login_failures (updated_at);
+
+-- +goose Down
+DROP TABLE login_failures;
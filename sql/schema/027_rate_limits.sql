@@ -0,0 +1,11 @@
+-- +goose Up
+-- GCRA state for rate limits: tat is the theoretical arrival time of the
+-- next request for each key
+CREATE TABLE rate_limits (
+    key TEXT PRIMARY KEY,
+    tat TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX rate_limits_tat_idx ON rate_limits (tat);
+
+-- +goose Down
+DROP TABLE rate_limits;
@@ -0,0 +1,27 @@
+-- +goose Up
+-- every Polka webhook delivery we receive, kept for auditing whether or
+-- not it was accepted
+CREATE TABLE polka_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    received_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- Polka's event ID; empty when the payload had none
+    event_id TEXT NOT NULL DEFAULT '',
+    event TEXT NOT NULL DEFAULT '',
+    payload TEXT NOT NULL,
+    remote_ip TEXT NOT NULL,
+    -- processed, duplicate, ignored, rejected, invalid, user_not_found or failed
+    outcome TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX polka_events_event_id_idx ON polka_events (event_id);
+CREATE INDEX polka_events_received_at_idx ON polka_events (received_at);
+
+-- Polka retries deliveries, so each event ID is acted on once
+CREATE TABLE polka_processed_events (
+    event_id TEXT PRIMARY KEY,
+    processed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE polka_processed_events;
+DROP TABLE polka_events;
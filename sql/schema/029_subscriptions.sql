@@ -0,0 +1,27 @@
+-- +goose Up
+-- a user's Chirpy Red subscription, kept in step with Polka's billing
+-- events; users.is_chirpy_red is derived from its status
+CREATE TABLE subscriptions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    user_id UUID NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+    plan TEXT NOT NULL,
+    -- active, past_due, canceled or expired
+    status TEXT NOT NULL,
+    current_period_start TIMESTAMPTZ NOT NULL,
+    current_period_end TIMESTAMPTZ NOT NULL,
+    -- set while past_due: Red is kept until then
+    grace_until TIMESTAMPTZ,
+    canceled_at TIMESTAMPTZ
+);
+CREATE INDEX subscriptions_status_idx ON subscriptions (status);
+
+-- upgrades before subscriptions were tracked carried no period, so give
+-- them a month for Polka's next renewal to arrive
+INSERT INTO subscriptions (user_id, plan, status, current_period_start, current_period_end)
+SELECT id, 'red_monthly', 'active', NOW(), NOW() + INTERVAL '1 month'
+FROM users WHERE is_chirpy_red;
+
+-- +goose Down
+DROP TABLE subscriptions;
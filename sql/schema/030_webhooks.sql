@@ -0,0 +1,50 @@
+-- +goose Up
+-- URLs that users and their apps registered to be told about events
+CREATE TABLE webhook_endpoints (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- the OAuth client that registered it, so the endpoint goes away with
+    -- the app; NULL for the user's own
+    client_id TEXT REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    -- signs deliveries, so it has to be kept as is
+    secret TEXT NOT NULL,
+    -- space-separated event types
+    events TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    -- active or paused
+    status TEXT NOT NULL DEFAULT 'active',
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    paused_at TIMESTAMPTZ
+);
+CREATE INDEX webhook_endpoints_user_idx ON webhook_endpoints (user_id);
+
+-- the outbox of events to send, which doubles as each endpoint's
+-- delivery log
+CREATE TABLE webhook_deliveries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    endpoint_id UUID NOT NULL REFERENCES webhook_endpoints(id) ON DELETE CASCADE,
+    -- the same for every endpoint told about one event, so receivers can
+    -- drop repeats
+    event_id UUID NOT NULL,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    -- pending, delivered or failed, once attempts run out
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_attempt_at TIMESTAMPTZ,
+    delivered_at TIMESTAMPTZ,
+    -- HTTP status of the last attempt; 0 when there was no response
+    response_status INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX webhook_deliveries_endpoint_idx ON webhook_deliveries (endpoint_id, created_at);
+
+-- +goose Down
+DROP TABLE webhook_deliveries;
+DROP TABLE webhook_endpoints;